@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -9,12 +10,17 @@ import (
 	"math/rand"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/0xrawsec/gene/v2/engine"
 	"github.com/0xrawsec/golang-evtx/evtx"
+	"github.com/0xrawsec/golang-utils/crypto/data"
+	"github.com/0xrawsec/sod"
 	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/hids/sysinfo"
 	"github.com/0xrawsec/whids/utils"
 	"github.com/gorilla/websocket"
 )
@@ -186,6 +192,199 @@ func TestAdminAPIGetEndpoints(t *testing.T) {
 	t.Logf("received: %s", prettyJSON(r))
 }
 
+func TestAdminAPIReadOnlyUser(t *testing.T) {
+	m, _ := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	ro := &AdminAPIUser{
+		Uuid:       UUIDGen().String(),
+		Identifier: "read-only",
+		Key:        KeyGen(DefaultKeySize),
+		Role:       RoleReadOnly,
+	}
+
+	if err := m.CreateNewAdminAPIUser(ro); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if err := m.CreateNewAdminAPIUser(&AdminAPIUser{Identifier: "unknown-role", Key: KeyGen(DefaultKeySize), Role: "root"}); err == nil {
+		t.Error("User with unknown role must not be created")
+	}
+
+	cl := http.Client{Transport: cconf.Transport()}
+	for method, status := range map[string]int{"GET": 200, "POST": 403, "DELETE": 403} {
+		req := prepare(method, AdmAPIRulesPath, nil, nil)
+		req.Header.Set(AuthKeyHeader, ro.Key)
+		resp, err := cl.Do(req)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("Unexpected status for read-only user method=%s: %d", method, resp.StatusCode)
+		}
+	}
+}
+
+func getAdminAPIUser(t *testing.T, m *Manager, uuid string) *AdminAPIUser {
+	o, err := m.db.Search(&AdminAPIUser{}, "Uuid", "=", uuid).One()
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	return o.(*AdminAPIUser)
+}
+
+func TestAdminAPIUserLastLogin(t *testing.T) {
+	m, _ := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	u := &AdminAPIUser{
+		Uuid:       UUIDGen().String(),
+		Identifier: "last-login",
+		Key:        KeyGen(DefaultKeySize),
+	}
+
+	if err := m.CreateNewAdminAPIUser(u); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	req := prepare("GET", AdmAPIUsers, nil, nil)
+	req.Header.Set(AuthKeyHeader, u.Key)
+	do(req)
+
+	stale := getAdminAPIUser(t, m, u.Uuid)
+	if stale.LastLogin.IsZero() {
+		t.Error("Last login must be set")
+	}
+
+	// user modified while its last login is updated
+	r := post(AdmAPIUsers+"/"+u.Uuid, JSON(AdminAPIUser{Description: "modified"}))
+	failOnAdminAPIError(t, r)
+
+	login := stale.LastLogin.Add(lastLoginUpdateInterval * 2)
+	if err := m.updateLastLogin(stale.Uuid, login); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if user := getAdminAPIUser(t, m, u.Uuid); user.Description != "modified" || !user.LastLogin.Equal(login) {
+		t.Errorf("Unexpected user after last login update: %+v", user)
+	}
+
+	// deleted user must not be created again
+	r = do(prepare("DELETE", AdmAPIUsers+"/"+u.Uuid, nil, nil))
+	failOnAdminAPIError(t, r)
+
+	if err := m.updateLastLogin(u.Uuid, time.Now()); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := m.db.Search(&AdminAPIUser{}, "Uuid", "=", u.Uuid).One(); !sod.IsNoObjectFound(err) {
+		t.Errorf("Deleted user must not exist: %v", err)
+	}
+}
+
+func TestAdminAPIGetEndpointsPaginated(t *testing.T) {
+	m, _ := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	// creating new endpoints
+	for i := 0; i < 3; i++ {
+		failOnAdminAPIError(t, put(AdmAPIEndpointsPath))
+	}
+
+	v := url.Values{}
+	v.Set(qpLimit, "2")
+	r := get(AdmAPIEndpointsPath + "?" + v.Encode())
+	failOnAdminAPIError(t, r)
+
+	page := Page{}
+	r.UnmarshalData(&page)
+	if page.Count != 2 || page.Total < 3 || page.NextOffset != 2 {
+		t.Errorf("Unexpected page: %+v", page)
+		t.FailNow()
+	}
+
+	v.Set(qpOffset, format("%d", page.Total))
+	r = get(AdmAPIEndpointsPath + "?" + v.Encode())
+	failOnAdminAPIError(t, r)
+
+	page = Page{}
+	r.UnmarshalData(&page)
+	if page.Count != 0 || page.NextOffset != 0 {
+		t.Errorf("Unexpected page: %+v", page)
+		t.FailNow()
+	}
+}
+
+func TestAdminAPIReportArchivePaginated(t *testing.T) {
+	m, _ := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	endpt := Endpoint{}
+	r := put(AdmAPIEndpointsPath)
+	failOnAdminAPIError(t, r)
+	r.UnmarshalData(&endpt)
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		ar := &ArchivedReport{ArchivedTimestamp: now.Add(-time.Duration(i) * time.Minute)}
+		ar.Identifier = endpt.Uuid
+		if err := m.db.InsertOrUpdate(ar); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+	}
+
+	path := format("%s/%s%s%s", AdmAPIEndpointsPath, endpt.Uuid, AdmAPIReportSuffix, AdmAPIArchiveSuffix)
+
+	// reports are returned as a list when not paginating
+	r = get(path)
+	failOnAdminAPIError(t, r)
+	reports := make([]ArchivedReport, 0)
+	if err := r.UnmarshalData(&reports); err != nil || len(reports) != 3 {
+		t.Errorf("Unexpected reports: %d %v", len(reports), err)
+	}
+
+	v := url.Values{}
+	v.Set(qpLimit, "2")
+	r = get(path + "?" + v.Encode())
+	failOnAdminAPIError(t, r)
+
+	page := Page{}
+	r.UnmarshalData(&page)
+	if page.Count != 2 || page.Total != 3 || page.NextOffset != 2 {
+		t.Errorf("Unexpected page: %+v", page)
+		t.FailNow()
+	}
+
+	v.Set(qpOffset, "2")
+	r = get(path + "?" + v.Encode())
+	failOnAdminAPIError(t, r)
+
+	page = Page{}
+	r.UnmarshalData(&page)
+	if page.Count != 1 || page.Total != 3 || page.NextOffset != 0 {
+		t.Errorf("Unexpected page: %+v", page)
+	}
+}
+
 func TestAdminAPIGetCommand(t *testing.T) {
 	m, _ := prepareTest()
 	defer func() {
@@ -237,6 +436,423 @@ func TestAdminAPIPostCommand(t *testing.T) {
 	t.Logf("received: %s", prettyJSON(r))
 }
 
+func TestCommandLineRawArgs(t *testing.T) {
+	for cl, args := range map[string][]string{
+		`ls -l "/tmp/some dir"`:                       {"-l", "/tmp/some dir"},
+		`unblacklist cmd.exe /c "echo  foo" 'bar'`:    {`cmd.exe /c "echo  foo" 'bar'`},
+		`  unblacklist   C:\Windows\System32\a.exe  `: {`C:\Windows\System32\a.exe`},
+	} {
+		cmd := NewCommand()
+		if err := cmd.SetCommandLine(cl); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if len(cmd.Args) != len(args) {
+			t.Errorf("Unexpected arguments for %s: %q", cl, cmd.Args)
+			continue
+		}
+		for i := range args {
+			if cmd.Args[i] != args[i] {
+				t.Errorf("Unexpected arguments for %s: %q", cl, cmd.Args)
+			}
+		}
+	}
+}
+
+func TestCommandCancel(t *testing.T) {
+	// command not sent yet is cancelled right away
+	cmd := NewCommand()
+	cmd.SetCommandLine("sleep 30")
+	if err := cmd.Cancel(); err != nil {
+		t.Error(err)
+	}
+	if !cmd.Cancelled || !cmd.Completed || cmd.CancelRequested || cmd.Status != CmdStatusCancelled {
+		t.Errorf("Unexpected state of command cancelled before being sent: %+v", cmd)
+	}
+	if err := cmd.Cancel(); err != ErrCommandCompleted {
+		t.Errorf("Cancelling a completed command must fail: %v", err)
+	}
+
+	// cancellation of a command line sent is requested to the endpoint
+	cmd = NewCommand()
+	cmd.SetCommandLine("sleep 30")
+	cmd.Sent = true
+	if err := cmd.Cancel(); err != nil {
+		t.Error(err)
+	}
+	if !cmd.CancelRequested || cmd.Cancelled || cmd.Completed {
+		t.Errorf("Unexpected state of command cancelled once sent: %+v", cmd)
+	}
+
+	// structured command sent runs to completion
+	cmd = NewCommand()
+	cmd.Type = CmdTypeSysinfo
+	cmd.Sent = true
+	if err := cmd.Cancel(); err != ErrCommandNotCancellable {
+		t.Errorf("Cancelling a structured command sent must fail: %v", err)
+	}
+	if cmd.CancelRequested {
+		t.Error("Cancellation must not be requested for a structured command")
+	}
+
+	// structured command not sent yet can be cancelled
+	cmd = NewCommand()
+	cmd.Type = CmdTypeIsolate
+	if err := cmd.Cancel(); err != nil || !cmd.Cancelled {
+		t.Errorf("Structured command not sent must be cancelled: %v", err)
+	}
+
+	// command line cancelled by the manager while running
+	cmd = NewCommand()
+	cmd.SetCommandLine("sleep 30")
+	cancel := make(chan struct{})
+	close(cancel)
+	cmd.RunContext(context.Background(), cancel)
+	if !cmd.Cancelled || cmd.Interrupted {
+		t.Errorf("Command line must be cancelled: %+v", cmd)
+	}
+
+	// command line stopped by the endpoint shutting down is not cancelled
+	cmd = NewCommand()
+	cmd.SetCommandLine("sleep 30")
+	ctx, stop := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, stop)
+	cmd.RunContext(ctx, nil)
+	if cmd.Cancelled || !cmd.Interrupted {
+		t.Errorf("Command line must be interrupted: %+v", cmd)
+	}
+}
+
+func TestAdminAPIPostStructuredCommand(t *testing.T) {
+	m, c := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+	euuid := c.config.UUID
+
+	// unknown command types must be rejected
+	r := post(format("%s/%s/command", AdmAPIEndpointsPath, euuid), JSON(CommandAPI{Type: "unknown"}))
+	if r.Error == "" {
+		t.Error("Unknown command type must fail")
+		t.FailNow()
+	}
+
+	r = post(format("%s/%s/command", AdmAPIEndpointsPath, euuid), JSON(CommandAPI{Type: CmdTypeIsolate}))
+	failOnAdminAPIError(t, r)
+
+	if cmd, err := c.FetchCommand(); err != nil {
+		t.Errorf("Failed to Fetch command: %s", err)
+		t.FailNow()
+	} else if cmd.Type != CmdTypeIsolate || cmd.Name != string(CmdTypeIsolate) {
+		t.Errorf("Unexpected command received: %s", cmd)
+	}
+}
+
+func TestAdminAPIContainers(t *testing.T) {
+	m, c := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	entries := []string{"some.random.domain", "other.random.domain"}
+	path := AdmAPIContainersPath + "/blacklist"
+
+	// reserved container cannot be managed through this API
+	r := post(AdmAPIContainersPath+"/"+IoCContainerName, JSON(entries))
+	if r.Error == "" {
+		t.Error("Reserved container must not be managed")
+	}
+
+	r = post(path, JSON(entries))
+	failOnAdminAPIError(t, r)
+
+	hashes, err := c.GetContainersSha256()
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	ctn, err := c.GetContainer("blacklist")
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if len(ctn) != len(entries) || utils.Sha256StringArray(ctn) != hashes["blacklist"] {
+		t.Errorf("Unexpected container received: %v", ctn)
+	}
+}
+
+func TestRuleCheckChannels(t *testing.T) {
+	channels := NewRuleChannels("Custom/Operational")
+
+	for _, tc := range []struct {
+		events   map[string][]int64
+		problems int
+	}{
+		{map[string][]int64{"Microsoft-Windows-Sysmon/Operational": {1, 255}}, 0},
+		// any event of the channel
+		{map[string][]int64{"Security": {}}, 0},
+		// any event id is valid in configured channels
+		{map[string][]int64{"Custom/Operational": {4242}}, 0},
+		{map[string][]int64{"": {1}}, 1},
+		{map[string][]int64{"Unknown/Operational": {1}}, 1},
+		{map[string][]int64{"Microsoft-Windows-Sysmon/Operational": {1, 42, 4242}}, 2},
+		{map[string][]int64{"Security": {-1, 1 << 20}}, 2},
+	} {
+		rule := EdrRule{Rule: engine.NewRule()}
+		rule.Name = "TestRule"
+		rule.Meta.Events = tc.events
+
+		if problems := rule.Check(channels); len(problems) != tc.problems {
+			t.Errorf("Unexpected problems for events=%v: %+v", tc.events, problems)
+		}
+	}
+}
+
+func TestAdminAPIRulesValidation(t *testing.T) {
+	m, _ := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	valid := engine.NewRule()
+	valid.Name = "ValidRule"
+	valid.Meta.Events = map[string][]int64{"Microsoft-Windows-Sysmon/Operational": {1}}
+	valid.Matches = []string{"$a: Image ~= 'malware.exe'"}
+	valid.Condition = "$a"
+
+	invalid := engine.NewRule()
+	invalid.Name = "InvalidRule"
+	invalid.Meta.Criticality = 42
+	invalid.Meta.Events = map[string][]int64{"Microsoft-Windows-Sysmon/Operational": {1 << 20}}
+
+	r := post(AdmAPIRulesPath, JSON([]engine.Rule{valid, invalid}))
+	if r.Error == "" {
+		t.Error("Invalid rules must be rejected")
+		t.FailNow()
+	}
+
+	problems := make([]RuleValidationError, 0)
+	if err := r.UnmarshalData(&problems); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if len(problems) != 2 {
+		t.Errorf("Unexpected validation problems: %+v", problems)
+	}
+
+	for _, p := range problems {
+		if p.Rule != invalid.Name || p.Index != 1 {
+			t.Errorf("Unexpected validation problem: %+v", p)
+		}
+	}
+
+	// loading is all or nothing
+	if _, err := m.db.Search(&EdrRule{}, "Name", "=", valid.Name).One(); !sod.IsNoObjectFound(err) {
+		t.Errorf("Valid rule should not have been loaded: %v", err)
+	}
+}
+
+func suppressionEvent(computer, image, hashes string) *event.EdrEvent {
+	e := event.EdrEvent{}
+	raw := fmt.Sprintf(`{"Event":{"EventData":{"Image":%q,"ImageHashes":%q},"System":{"Channel":"Microsoft-Windows-Sysmon/Operational","EventID":1,"Computer":%q}}}`,
+		image, hashes, computer)
+	if err := json.Unmarshal([]byte(raw), &e); err != nil {
+		panic(err)
+	}
+	return &e
+}
+
+func TestSuppressionFilterRule(t *testing.T) {
+	image := `C:\Windows\System32\benign.exe`
+	hash := "SHA256=0123456789ABCDEF"
+
+	for _, tc := range []struct {
+		s     DetectionSuppression
+		event *event.EdrEvent
+		match bool
+	}{
+		{DetectionSuppression{Rule: "R", Image: image}, suppressionEvent("HOST", image, ""), true},
+		{DetectionSuppression{Rule: "R", Image: image}, suppressionEvent("HOST", strings.ToUpper(image), ""), true},
+		{DetectionSuppression{Rule: "R", Image: image}, suppressionEvent("HOST", image+".exe", ""), false},
+		{DetectionSuppression{Rule: "R", Hash: hash}, suppressionEvent("HOST", image, "MD5=AA,"+hash), true},
+		{DetectionSuppression{Rule: "R", Image: image, Hash: hash}, suppressionEvent("HOST", image, "MD5=AA"), false},
+		{DetectionSuppression{Rule: "R", Image: image, Hostname: "HOST"}, suppressionEvent("HOST", image, ""), true},
+		{DetectionSuppression{Rule: "R", Image: image, Hostname: "HOST"}, suppressionEvent("OTHER", image, ""), false},
+	} {
+		tc.s.Uuid = UUIDGen().String()
+		e := engine.NewEngine()
+		rule := tc.s.FilterRule()
+		if err := e.LoadRule(&rule); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if cr := e.GetCRuleByName(tc.s.RuleName()); cr.Match(tc.event) != tc.match {
+			t.Errorf("Unexpected match result for suppression %+v", tc.s)
+		}
+	}
+}
+
+func autoAssignEndpoint(hostname, product, ip string) *Endpoint {
+	endpt := NewEndpoint(UUIDGen().String(), KeyGen(DefaultKeySize))
+	endpt.Hostname = hostname
+	endpt.IP = ip
+	if product != "" {
+		endpt.SystemInfo = &sysinfo.SystemInfo{}
+		endpt.SystemInfo.OS.Product = product
+	}
+	return endpt
+}
+
+func TestAutoAssignRuleMatch(t *testing.T) {
+	for _, tc := range []struct {
+		r     AutoAssignRule
+		endpt *Endpoint
+		match bool
+	}{
+		{AutoAssignRule{Hostname: "srv-*"}, autoAssignEndpoint("SRV-DC01", "", ""), true},
+		{AutoAssignRule{Hostname: "srv-*"}, autoAssignEndpoint("wks-01", "", ""), false},
+		{AutoAssignRule{OS: "*server*"}, autoAssignEndpoint("host", "Windows Server 2019 Standard", ""), true},
+		{AutoAssignRule{OS: "*server*"}, autoAssignEndpoint("host", "Windows 10 Pro", ""), false},
+		// no system information reported yet
+		{AutoAssignRule{OS: "*"}, autoAssignEndpoint("host", "", ""), false},
+		{AutoAssignRule{Network: "10.0.0.0/8"}, autoAssignEndpoint("host", "", "10.1.2.3"), true},
+		{AutoAssignRule{Network: "10.0.0.0/8"}, autoAssignEndpoint("host", "", "10.1.2.3:49152"), true},
+		{AutoAssignRule{Network: "10.0.0.0/8"}, autoAssignEndpoint("host", "", "192.168.1.1"), false},
+		{AutoAssignRule{Network: "10.0.0.0/8"}, autoAssignEndpoint("host", "", ""), false},
+		// all the criteria must match
+		{AutoAssignRule{Hostname: "srv-*", Network: "10.0.0.0/8"}, autoAssignEndpoint("srv-01", "", "192.168.1.1"), false},
+		{AutoAssignRule{Hostname: "srv-*", Network: "10.0.0.0/8"}, autoAssignEndpoint("srv-01", "", "10.0.0.1"), true},
+	} {
+		if err := tc.r.compile(); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if tc.r.Match(tc.endpt) != tc.match {
+			t.Errorf("Unexpected match result for rule %+v and endpoint hostname=%s ip=%s", tc.r, tc.endpt.Hostname, tc.endpt.IP)
+		}
+	}
+
+	for _, r := range []AutoAssignRule{
+		{Group: "nocriteria"},
+		{Hostname: "[srv"},
+		{Network: "10.0.0.0"},
+	} {
+		if err := r.compile(); err == nil {
+			t.Errorf("Rule %+v must be rejected", r)
+		}
+	}
+}
+
+func TestAutoAssign(t *testing.T) {
+	a, err := newAutoAssigner([]AutoAssignRule{
+		{Hostname: "srv-*", Group: "servers", Labels: []string{"prod"}},
+		{Network: "10.0.0.0/8", Group: "lan"},
+	})
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	// first matching rule wins
+	endpt := autoAssignEndpoint("srv-01", "", "10.0.0.1")
+	if !a.Assign(endpt) || endpt.Group != "servers" || !equalStrings(endpt.Labels, []string{"prod"}) {
+		t.Errorf("Unexpected assignment: group=%s labels=%v", endpt.Group, endpt.Labels)
+	}
+	// labels of the rule must not be shared with endpoint
+	endpt.Labels[0] = "modified"
+	if a.rules[0].Labels[0] != "prod" {
+		t.Error("Rule labels must not be modified through endpoint")
+	}
+	endpt.Labels[0] = "prod"
+
+	// nothing to change
+	if a.Assign(endpt) {
+		t.Error("Endpoint already assigned must not be reported as modified")
+	}
+
+	endpt = autoAssignEndpoint("wks-01", "", "10.0.0.2")
+	if !a.Assign(endpt) || endpt.Group != "lan" || len(endpt.Labels) != 0 {
+		t.Errorf("Unexpected assignment: group=%s labels=%v", endpt.Group, endpt.Labels)
+	}
+
+	// no rule matching
+	endpt = autoAssignEndpoint("wks-01", "", "192.168.1.1")
+	if a.Assign(endpt) || endpt.Group != "" {
+		t.Errorf("Unexpected assignment: group=%s", endpt.Group)
+	}
+
+	// group set manually is never overwritten
+	endpt = autoAssignEndpoint("srv-01", "", "")
+	endpt.Group = "manual"
+	endpt.ManualGroup = true
+	if a.Assign(endpt) || endpt.Group != "manual" {
+		t.Errorf("Manual group must not be overwritten: group=%s", endpt.Group)
+	}
+}
+
+func TestAdminAPISuppressions(t *testing.T) {
+	m, c := prepareTest()
+	defer func() {
+		m.Shutdown()
+		m.Wait()
+	}()
+
+	rule := engine.NewRule()
+	rule.Name = "SuppressedRule"
+	rule.Meta.Events = map[string][]int64{"Microsoft-Windows-Sysmon/Operational": {1}}
+	rule.Matches = []string{"$a: Image ~= 'benign.exe'"}
+	rule.Condition = "$a"
+	failOnAdminAPIError(t, post(AdmAPIRulesPath, JSON([]engine.Rule{rule})))
+
+	r := do(prepare("PUT", AdmAPISuppressionsPath, JSON(DetectionSuppression{Rule: rule.Name, Image: `C:\benign.exe`}), nil))
+	failOnAdminAPIError(t, r)
+
+	s := DetectionSuppression{}
+	if err := r.UnmarshalData(&s); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	// suppressions must not be loaded with the rules deployed on endpoints
+	rules, err := c.GetRules()
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if strings.Contains(rules, s.RuleName()) {
+		t.Error("Suppression must not be served with the rules")
+	}
+
+	sups, err := c.GetSuppressions()
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	sha256, err := c.GetSuppressionsSha256()
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if !strings.Contains(sups, s.RuleName()) || data.Sha256([]byte(sups)) != sha256 {
+		t.Errorf("Unexpected suppressions served: %s", sups)
+	}
+
+	r = do(prepare("DELETE", AdmAPISuppressionsPath+"/"+s.Uuid, nil, nil))
+	failOnAdminAPIError(t, r)
+
+	if sups, err = c.GetSuppressions(); err != nil {
+		t.Error(err)
+	} else if strings.Contains(sups, s.RuleName()) {
+		t.Error("Deleted suppression must not be served anymore")
+	}
+}
+
 func TestAdminAPIGetCommandField(t *testing.T) {
 	var stdout []byte
 	var files map[string]*EndpointFile
@@ -350,6 +966,17 @@ func TestAdminAPIGetEndpointReport(t *testing.T) {
 	failOnAdminAPIError(t, r)
 	t.Logf("received: %s", prettyJSON(r))
 
+	fleet := FleetReport{}
+	r = get(format("%s?%s=1", AdmAPIEndpointsFleetReportPath, qpLimit))
+	failOnAdminAPIError(t, r)
+	if err := r.UnmarshalData(&fleet); err != nil {
+		t.Errorf("Failed to unmarshal fleet report: %s", err)
+		t.FailNow()
+	}
+	if len(fleet.TopEndpoints) != 1 || fleet.TopEndpoints[0].Uuid != euuid {
+		t.Errorf("Unexpected top endpoints: %s", prettyJSON(fleet.TopEndpoints))
+	}
+
 	r = do(prepare("DELETE", AdmAPIEndpointsPath+"/"+euuid+"/report", nil, nil))
 	failOnAdminAPIError(t, r)
 	t.Logf("received: %s", prettyJSON(r))
@@ -360,6 +987,28 @@ func TestAdminAPIGetEndpointReport(t *testing.T) {
 	t.Logf("received: %s", prettyJSON(r))
 }
 
+func TestArtifactWrapped(t *testing.T) {
+	for query, wrapped := range map[string]bool{
+		"":                     false,
+		"json=true":            true,
+		"json=false":           false,
+		"raw=true":             false,
+		"raw=false":            true,
+		"json=true&raw=true":   true,
+		"json=false&raw=false": false,
+		"raw=bad":              false,
+	} {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if artifactWrapped(values) != wrapped {
+			t.Errorf("Unexpected artifact wrapping for query %q", query)
+		}
+	}
+}
+
 func TestAdminAPIGetEndpointLogs(t *testing.T) {
 
 	// cleanup previous data
@@ -521,6 +1170,53 @@ func TestAdminAPIGetEndpointAlerts(t *testing.T) {
 		t.Errorf("Wrong number of events %d instead of %d", len(data), len(events))
 		t.FailNow()
 	}
+
+	// detections must come with a summary
+	entries := make([]DetectionEntry, 0)
+	r.UnmarshalData(&entries)
+	for _, entry := range entries {
+		if len(entry.DetectionSummary.Rules) == 0 {
+			t.Errorf("Missing detection summary: %+v", entry.DetectionSummary)
+			t.FailNow()
+		}
+	}
+}
+
+func TestStreamFilter(t *testing.T) {
+	euuid := "5a92baeb-9384-47d3-92b4-a0db6f9b8c6d"
+
+	e := event.EdrEvent{}
+	e.Event.EdrData = &event.EdrData{}
+	e.Event.EdrData.Endpoint.UUID = euuid
+	e.Event.Detection = engine.NewDetection(false, false)
+	e.Event.Detection.Signature.Add("SuspiciousRule")
+	e.Event.Detection.Criticality = 8
+
+	for query, match := range map[string]bool{
+		"":                                      true,
+		"euuid=" + euuid:                        true,
+		"euuid=other-uuid":                      false,
+		"rule=OtherRule,SuspiciousRule":         true,
+		"rule=OtherRule":                        false,
+		"min-criticality=8":                     true,
+		"min-criticality=9":                     false,
+		"euuid=" + euuid + "&min-criticality=5": true,
+	} {
+		v, _ := url.ParseQuery(query)
+		f, err := ParseStreamFilter(v)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if f.Match(&e) != match {
+			t.Errorf("Unexpected filter result for query=%s", query)
+		}
+	}
+
+	v, _ := url.ParseQuery("min-criticality=foo")
+	if _, err := ParseStreamFilter(v); err == nil {
+		t.Error("Invalid filter must return an error")
+	}
 }
 
 func TestEventStream(t *testing.T) {
@@ -598,7 +1294,7 @@ func TestEventStream(t *testing.T) {
 		}()
 	}
 
-	mc.PostLogs(readerFromEvents(int(expctd)))
+	mc.PostLogs(newBatchID(), readerFromEvents(int(expctd)))
 	tick := time.NewTicker(60 * time.Second)
 loop:
 	for {
@@ -622,3 +1318,42 @@ loop:
 	t.Logf("Average %.1f EPS/client", sumEps/(nclients-slowClients))
 
 }
+
+func TestScoreHistory(t *testing.T) {
+	now := time.Now().Truncate(time.Hour)
+	reports := make([]*ArchivedReport, 0)
+	for i := 0; i < 6; i++ {
+		r := &ArchivedReport{ArchivedTimestamp: now.Add(time.Duration(i) * 30 * time.Minute)}
+		r.Score = i
+		r.CntBySig = map[string]int{"A": i, "B": 2, "C": 1}
+		// history must not rely on reports ordering
+		reports = append([]*ArchivedReport{r}, reports...)
+	}
+
+	h := NewScoreHistory(reports, 0, 2)
+	if h.Count != 6 {
+		t.Errorf("Expected 6 samples, got %d", h.Count)
+	}
+	for i, s := range h.Samples {
+		if s.Score != i {
+			t.Errorf("Samples not sorted by time")
+		}
+		if len(s.TopRules) != 2 {
+			t.Errorf("Expected 2 top rules, got %d", len(s.TopRules))
+		}
+	}
+	if last := h.Samples[len(h.Samples)-1]; last.TopRules[0].Name != "A" {
+		t.Errorf("Unexpected top rule: %s", last.TopRules[0].Name)
+	}
+
+	// keeping the most recent sample of every hour
+	h = NewScoreHistory(reports, time.Hour, DefaultScoreHistoryTop)
+	if h.Count != 3 {
+		t.Errorf("Expected 3 samples, got %d", h.Count)
+	}
+	for _, s := range h.Samples {
+		if s.Score%2 != 1 {
+			t.Errorf("Most recent sample of interval must be kept")
+		}
+	}
+}
@@ -13,7 +13,9 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/0xrawsec/golang-utils/crypto/data"
@@ -25,15 +27,20 @@ import (
 
 // ClientConfig structure definition
 type ClientConfig struct {
-	Proto             string `toml:"proto" comment:"Protocol to use to connect to manager (http or https)"`
-	Host              string `toml:"host" comment:"Hostname or IP of the manager"`
-	Port              int    `toml:"port" comment:"Port at which endpoint API is running on manager server"`
-	UUID              string `toml:"endpoint-uuid" comment:"Endpoint UUID configured on manager used to authenticate this endpoint"`
-	Key               string `toml:"endpoint-key" comment:"Endpoint key configured on manager used to authenticate this endpoint"`
-	ServerKey         string `toml:"server-key" comment:"Key configured on manager, used to authenticate server on this endpoint\n This settings does not protect from MITM, so configuring server\n certificate pinning is recommended."`
-	ServerFingerprint string `toml:"server-fingerprint" comment:"Configure manager certificate pinning\n Put here the manager's certificate fingerprint"`
-	Unsafe            bool   `toml:"unsafe" comment:"Allow unsafe HTTPS connection"`
-	MaxUploadSize     int64  `toml:"max-upload-size" comment:"Maximum allowed upload size"`
+	Proto             string        `toml:"proto" comment:"Protocol to use to connect to manager (http or https)"`
+	Host              string        `toml:"host" comment:"Hostname or IP of the manager"`
+	Port              int           `toml:"port" comment:"Port at which endpoint API is running on manager server"`
+	UUID              string        `toml:"endpoint-uuid" comment:"Endpoint UUID configured on manager used to authenticate this endpoint"`
+	Key               string        `toml:"endpoint-key" comment:"Endpoint key configured on manager used to authenticate this endpoint"`
+	ServerKey         string        `toml:"server-key" comment:"Key configured on manager, used to authenticate server on this endpoint\n This settings does not protect from MITM, so configuring server\n certificate pinning is recommended."`
+	ServerFingerprint string        `toml:"server-fingerprint" comment:"Configure manager certificate pinning\n Put here the manager's certificate fingerprint"`
+	Unsafe            bool          `toml:"unsafe" comment:"Allow unsafe HTTPS connection"`
+	MaxUploadSize     int64         `toml:"max-upload-size" comment:"Maximum allowed upload size"`
+	UploadRate        int64         `toml:"upload-rate" comment:"Maximum upload rate (in bytes per second) used to send events and dumps\n to the manager, events are sent in priority over dumps (0: unlimited)"`
+	MaxRetryBackoff   time.Duration `toml:"max-retry-backoff" comment:"Maximum delay between two attempts to reach the manager after failures,\n delays grow exponentially (with random jitter) up to this value"`
+	BatchSize         uint64        `toml:"batch-size" comment:"Maximum number of events sent to the manager in a single upload"`
+	BatchBytes        int           `toml:"batch-bytes" comment:"Maximum size (in bytes) of the events sent in a single upload (0: unlimited)"`
+	FlushInterval     time.Duration `toml:"flush-interval" comment:"Maximum delay before events are sent to the manager, an upload\n is triggered as soon as any batch limit is reached"`
 
 	localAddr string
 }
@@ -121,7 +128,14 @@ func (cc *ClientConfig) Transport() http.RoundTripper {
 
 // ManagerClient structure definition
 type ManagerClient struct {
-	config *ClientConfig
+	sync.RWMutex
+	config      *ClientConfig
+	suppression ActionsSuppression
+	limiter     *bandwidthLimiter
+	// manager asked us to slow down until this time
+	backpressure time.Time
+	// manager rotated endpoint key, new key has to be fetched
+	keyRotated bool
 
 	ManagerIP  net.IP
 	HTTPClient http.Client
@@ -132,6 +146,12 @@ const (
 	UserAgent = "Whids-API-Client/1.0"
 	// Mega byte size
 	Mega = 1 << 20
+	// DefaultMaxRetryBackoff default maximum delay between two attempts to reach the manager
+	DefaultMaxRetryBackoff = 5 * time.Minute
+	// DefaultBatchSize default maximum number of events sent in a single upload
+	DefaultBatchSize = 500
+	// DefaultFlushInterval default maximum delay before events are sent
+	DefaultFlushInterval = 10 * time.Second
 )
 
 var (
@@ -157,6 +177,7 @@ func NewManagerClient(c *ClientConfig) (*ManagerClient, error) {
 		config:     c,
 		ManagerIP:  c.ManagerIP(),
 		HTTPClient: http.Client{Transport: tpt},
+		limiter:    newBandwidthLimiter(c.UploadRate),
 	}
 
 	// host
@@ -192,11 +213,100 @@ func (m *ManagerClient) Prepare(method, url string, body io.Reader) (*http.Reque
 		// the address used by the client to connect to the manager
 		r.Header.Add(EndpointIPHeader, m.config.localAddr)
 		r.Header.Add(EndpointUUIDHeader, m.config.UUID)
+
+		m.RLock()
 		r.Header.Add(AuthKeyHeader, m.config.Key)
+
+		// reporting actions suppression state
+		if m.suppression.Active() {
+			r.Header.Add(EndpointActionsSuppressedHeader, strconv.FormatBool(true))
+			if !m.suppression.Until.IsZero() {
+				r.Header.Add(EndpointActionsSuppressedUntilHeader, m.suppression.Until.UTC().Format(time.RFC3339))
+			}
+		}
+		m.RUnlock()
 	}
 	return r, err
 }
 
+// throttle limits the upload rate of the body of req, priority uploads
+// (i.e. events) pausing the other ones (i.e. dumps)
+func (m *ManagerClient) throttle(req *http.Request, priority bool) {
+	if m.limiter != nil && req.Body != nil && req.Body != http.NoBody {
+		req.Body = m.limiter.reader(req.Body, priority)
+	}
+}
+
+// Key returns the key used by the endpoint to authenticate on the manager
+func (m *ManagerClient) Key() string {
+	m.RLock()
+	defer m.RUnlock()
+	return m.config.Key
+}
+
+// checkKeyRotation records whether the manager indicated the endpoint
+// authenticated with a key which has been rotated
+func (m *ManagerClient) checkKeyRotation(resp *http.Response) {
+	if rotated, _ := strconv.ParseBool(resp.Header.Get(EndpointKeyRotatedHeader)); rotated {
+		m.Lock()
+		defer m.Unlock()
+		m.keyRotated = true
+	}
+}
+
+// IsKeyRotated returns true if endpoint key has been rotated on the manager
+// and the new key needs to be fetched
+func (m *ManagerClient) IsKeyRotated() bool {
+	m.RLock()
+	defer m.RUnlock()
+	return m.keyRotated
+}
+
+// FetchKey fetches the new key of the endpoint, after a key rotation, and
+// uses it for subsequent requests
+func (m *ManagerClient) FetchKey() (string, error) {
+	if auth, _ := m.IsServerAuthenticated(); auth {
+		req, err := m.Prepare("GET", EptAPIEndpointKeyPath, nil)
+		if err != nil {
+			return "", fmt.Errorf("FetchKey failed to prepare request: %s", err)
+		}
+
+		resp, err := m.HTTPClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("FetchKey failed to issue HTTP request: %s", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("FetchKey unexpected HTTP status %d", resp.StatusCode)
+		}
+
+		key, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return "", fmt.Errorf("FetchKey failed to read HTTP response body: %s", err)
+		}
+
+		if len(key) == 0 {
+			return "", fmt.Errorf("FetchKey received an empty key")
+		}
+
+		m.Lock()
+		defer m.Unlock()
+		m.config.Key = string(key)
+		m.keyRotated = false
+
+		return m.config.Key, nil
+	}
+	return "", fmt.Errorf("FetchKey failed, server cannot be authenticated")
+}
+
+// SetActionsSuppression sets the actions suppression state reported to the manager
+func (m *ManagerClient) SetActionsSuppression(s ActionsSuppression) {
+	m.Lock()
+	defer m.Unlock()
+	m.suppression = s
+}
+
 // PrepareGzip prepares a http.Request gzip encoded to be sent to the manager
 func (m *ManagerClient) PrepareGzip(method, url string, body io.Reader) (*http.Request, error) {
 	// Prepare gzip content
@@ -236,6 +346,7 @@ func (m *ManagerClient) IsServerUp() bool {
 
 	if resp != nil {
 		defer resp.Body.Close()
+		m.checkKeyRotation(resp)
 	}
 
 	return resp.StatusCode == 200
@@ -256,6 +367,7 @@ func (m *ManagerClient) IsServerAuthenticated() (auth bool, up bool) {
 		}
 		if resp != nil {
 			defer resp.Body.Close()
+			m.checkKeyRotation(resp)
 			if resp.StatusCode == 200 {
 				key, _ := ioutil.ReadAll(resp.Body)
 				if m.config.ServerKey == string(key) {
@@ -364,6 +476,64 @@ func (m *ManagerClient) GetIoCsSha256() (string, error) {
 	return "", nil
 }
 
+// GetContainersSha256 retrieves the sha256 of the containers available in the manager
+func (m *ManagerClient) GetContainersSha256() (map[string]string, error) {
+	hashes := make(map[string]string)
+
+	if auth, _ := m.IsServerAuthenticated(); auth {
+		req, err := m.Prepare("GET", EptAPIContainersPath, nil)
+		if err != nil {
+			return hashes, fmt.Errorf("GetContainersSha256 failed to prepare request: %s", err)
+		}
+
+		resp, err := m.HTTPClient.Do(req)
+		if err != nil {
+			return hashes, fmt.Errorf("GetContainersSha256 failed to issue HTTP request: %s", err)
+		}
+
+		if resp != nil {
+			defer resp.Body.Close()
+			if resp.StatusCode != 200 {
+				return hashes, fmt.Errorf("failed to retrieve containers sha256, unexpected HTTP status code %d", resp.StatusCode)
+			}
+			dec := json.NewDecoder(resp.Body)
+			if err = dec.Decode(&hashes); err != nil {
+				return hashes, fmt.Errorf("GetContainersSha256 failed to decode response")
+			}
+		}
+	}
+	return hashes, nil
+}
+
+// GetContainer get a container from manager
+func (m *ManagerClient) GetContainer(name string) ([]string, error) {
+	ctn := make([]string, 0)
+
+	if auth, _ := m.IsServerAuthenticated(); auth {
+		req, err := m.Prepare("GET", EptAPIContainersPath+"/"+name, nil)
+		if err != nil {
+			return ctn, fmt.Errorf("GetContainer failed to prepare request: %s", err)
+		}
+
+		resp, err := m.HTTPClient.Do(req)
+		if err != nil {
+			return ctn, fmt.Errorf("GetContainer failed to issue HTTP request: %s", err)
+		}
+
+		if resp != nil {
+			defer resp.Body.Close()
+			if resp.StatusCode != 200 {
+				return ctn, fmt.Errorf("failed to retrieve container, unexpected HTTP status code %d", resp.StatusCode)
+			}
+			dec := json.NewDecoder(resp.Body)
+			if err = dec.Decode(&ctn); err != nil {
+				return ctn, fmt.Errorf("GetContainer failed to decode container")
+			}
+		}
+	}
+	return ctn, nil
+}
+
 // GetRules retrieve the latest batch of Gene rules available on the server
 func (m *ManagerClient) GetRules() (string, error) {
 	if auth, _ := m.IsServerAuthenticated(); auth {
@@ -392,6 +562,118 @@ func (m *ManagerClient) GetRules() (string, error) {
 	return "", nil
 }
 
+// GetShadowRulesSha256 returns the sha256 string of the shadow rules available on the server
+func (m *ManagerClient) GetShadowRulesSha256() (string, error) {
+	if auth, _ := m.IsServerAuthenticated(); auth {
+		req, err := m.Prepare("GET", EptAPIShadowRulesSha256Path, nil)
+		if err != nil {
+			return "", fmt.Errorf("GetShadowRulesSha256 failed to prepare request: %s", err)
+		}
+
+		resp, err := m.HTTPClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("GetShadowRulesSha256 failed to issue HTTP request: %s", err)
+		}
+
+		if resp != nil {
+			defer resp.Body.Close()
+			if resp.StatusCode != 200 {
+				return "", fmt.Errorf("failed to retrieve shadow rules sha256, unexpected HTTP status code %d", resp.StatusCode)
+			}
+			sha256, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				return "", fmt.Errorf("GetShadowRulesSha256 failed to read HTTP response body: %s", err)
+			}
+			return string(sha256), nil
+		}
+	}
+	return "", nil
+}
+
+// GetShadowRules retrieve the shadow Gene rules available on the server
+func (m *ManagerClient) GetShadowRules() (string, error) {
+	if auth, _ := m.IsServerAuthenticated(); auth {
+		req, err := m.Prepare("GET", EptAPIShadowRulesPath, nil)
+		if err != nil {
+			return "", fmt.Errorf("GetShadowRules failed to prepare request: %s", err)
+		}
+
+		resp, err := m.HTTPClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("GetShadowRules failed to issue HTTP request: %s", err)
+		}
+
+		if resp != nil {
+			defer resp.Body.Close()
+			if resp.StatusCode != 200 {
+				return "", fmt.Errorf("GetShadowRules failed to retrieve shadow rules, unexpected HTTP status code %d", resp.StatusCode)
+			}
+			rules, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				return "", fmt.Errorf("GetShadowRules failed to read HTTP response body: %s", err)
+			}
+			return string(rules), nil
+		}
+	}
+	return "", nil
+}
+
+// GetSuppressionsSha256 returns the sha256 string of the suppression rules available on the server
+func (m *ManagerClient) GetSuppressionsSha256() (string, error) {
+	if auth, _ := m.IsServerAuthenticated(); auth {
+		req, err := m.Prepare("GET", EptAPISuppressionsSha256Path, nil)
+		if err != nil {
+			return "", fmt.Errorf("GetSuppressionsSha256 failed to prepare request: %s", err)
+		}
+
+		resp, err := m.HTTPClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("GetSuppressionsSha256 failed to issue HTTP request: %s", err)
+		}
+
+		if resp != nil {
+			defer resp.Body.Close()
+			if resp.StatusCode != 200 {
+				return "", fmt.Errorf("failed to retrieve suppressions sha256, unexpected HTTP status code %d", resp.StatusCode)
+			}
+			sha256, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				return "", fmt.Errorf("GetSuppressionsSha256 failed to read HTTP response body: %s", err)
+			}
+			return string(sha256), nil
+		}
+	}
+	return "", nil
+}
+
+// GetSuppressions retrieve the suppression rules available on the server
+func (m *ManagerClient) GetSuppressions() (string, error) {
+	if auth, _ := m.IsServerAuthenticated(); auth {
+		req, err := m.Prepare("GET", EptAPISuppressionsPath, nil)
+		if err != nil {
+			return "", fmt.Errorf("GetSuppressions failed to prepare request: %s", err)
+		}
+
+		resp, err := m.HTTPClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("GetSuppressions failed to issue HTTP request: %s", err)
+		}
+
+		if resp != nil {
+			defer resp.Body.Close()
+			if resp.StatusCode != 200 {
+				return "", fmt.Errorf("GetSuppressions failed to retrieve suppressions, unexpected HTTP status code %d", resp.StatusCode)
+			}
+			rules, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				return "", fmt.Errorf("GetSuppressions failed to read HTTP response body: %s", err)
+			}
+			return string(rules), nil
+		}
+	}
+	return "", nil
+}
+
 func (m *ManagerClient) IsFileAboveUploadLimit(path string) bool {
 	if fsutil.IsFile(path) {
 		stats, err := os.Stat(path)
@@ -419,6 +701,9 @@ func (m *ManagerClient) PostDump(f *FileUpload) error {
 				return fmt.Errorf("PostDump failed to prepare request: %s", err)
 			}
 
+			// dumps are uploaded after events
+			m.throttle(req, false)
+
 			resp, err := m.HTTPClient.Do(req)
 			if err != nil {
 				return fmt.Errorf("PostDump failed to issue HTTP request: %s", err)
@@ -438,16 +723,30 @@ func (m *ManagerClient) PostDump(f *FileUpload) error {
 	return fmt.Errorf("PostDump failed, server cannot be authenticated")
 }
 
-// PostLogs posts logs to be collected
-func (m *ManagerClient) PostLogs(r io.Reader) error {
+// PostLogs posts a batch of logs to be collected, the batch identifier
+// allowing the manager not to ingest twice a replayed batch (empty: no
+// deduplication)
+func (m *ManagerClient) PostLogs(id string, r io.Reader) error {
 	if auth, up := m.IsServerAuthenticated(); auth {
 		if up {
-			req, err := m.PrepareGzip("POST", EptAPIPostLogsPath, r)
+			b, err := ioutil.ReadAll(r)
+			if err != nil {
+				return fmt.Errorf("PostLogs failed to read logs: %s", err)
+			}
+
+			req, err := m.PrepareGzip("POST", EptAPIPostLogsPath, bytes.NewReader(b))
 
 			if err != nil {
 				return fmt.Errorf("PostLogs failed to prepare request: %s", err)
 			}
 
+			m.throttle(req, true)
+
+			// allows the manager not to ingest twice replayed batches
+			if id != "" {
+				req.Header.Set(EventsBatchIDHeader, id)
+			}
+
 			resp, err := m.HTTPClient.Do(req)
 			if err != nil {
 				return fmt.Errorf("PostLogs failed to issue HTTP request: %s", err)
@@ -455,7 +754,12 @@ func (m *ManagerClient) PostLogs(r io.Reader) error {
 
 			if resp != nil {
 				defer resp.Body.Close()
-				if resp.StatusCode != 200 {
+				switch resp.StatusCode {
+				case 200:
+				case http.StatusServiceUnavailable, http.StatusTooManyRequests:
+					delay := m.setBackpressure(resp)
+					return fmt.Errorf("PostLogs failed to send logs, manager asked to retry in %s: %w", delay, ErrBackpressure)
+				default:
 					return fmt.Errorf("PostLogs failed to send logs, unexpected HTTP status code %d", resp.StatusCode)
 				}
 				return nil
@@ -469,8 +773,33 @@ func (m *ManagerClient) PostLogs(r io.Reader) error {
 
 var (
 	ErrNothingToDo = fmt.Errorf("nothing to do")
+	// ErrBackpressure returned when the manager cannot keep up with ingestion
+	ErrBackpressure = fmt.Errorf("manager backpressure")
 )
 
+// setBackpressure records the delay, taken from Retry-After header, the
+// manager asked us to wait before sending logs again
+func (m *ManagerClient) setBackpressure(resp *http.Response) time.Duration {
+	delay := time.Duration(DefaultBackpressureRetryAfter) * time.Second
+	if sec, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && sec > 0 {
+		delay = time.Duration(sec) * time.Second
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	m.backpressure = time.Now().Add(delay)
+
+	return delay
+}
+
+// IsBackpressured returns true if the manager asked us to slow down
+// and the delay it asked for is not elapsed yet
+func (m *ManagerClient) IsBackpressured() bool {
+	m.RLock()
+	defer m.RUnlock()
+	return time.Now().Before(m.backpressure)
+}
+
 func (m *ManagerClient) PostCommand(command *Command) error {
 	if auth, _ := m.IsServerAuthenticated(); auth {
 		// stripping unecessary content to send back the command
@@ -505,6 +834,70 @@ func (m *ManagerClient) PostCommand(command *Command) error {
 
 }
 
+// AckCommand notifies the manager that a command is about to run
+func (m *ManagerClient) AckCommand(command *Command) error {
+	if auth, _ := m.IsServerAuthenticated(); auth {
+		// we only need the UUID to acknowledge command
+		jsonAck, err := json.Marshal(Command{UUID: command.UUID})
+		if err != nil {
+			return fmt.Errorf("AckCommand failed to marshal command")
+		}
+
+		req, err := m.Prepare("POST", EptAPICommandAckPath, bytes.NewBuffer(jsonAck))
+		if err != nil {
+			return fmt.Errorf("AckCommand failed to prepare POST request")
+		}
+
+		resp, err := m.HTTPClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("AckCommand failed to issue HTTP request: %s", err)
+		}
+
+		if resp != nil {
+			defer resp.Body.Close()
+			if resp.StatusCode != 200 {
+				return fmt.Errorf("AckCommand failed, unexpected HTTP status code %d", resp.StatusCode)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("AckCommand failed, server cannot be authenticated")
+}
+
+// IsCommandCancelled returns true if the manager requests the command to be cancelled
+func (m *ManagerClient) IsCommandCancelled(command *Command) (bool, error) {
+	if auth, _ := m.IsServerAuthenticated(); auth {
+		// we only need the UUID to check for cancellation
+		jsonCmd, err := json.Marshal(Command{UUID: command.UUID})
+		if err != nil {
+			return false, fmt.Errorf("IsCommandCancelled failed to marshal command")
+		}
+
+		req, err := m.Prepare("POST", EptAPICommandCancelPath, bytes.NewBuffer(jsonCmd))
+		if err != nil {
+			return false, fmt.Errorf("IsCommandCancelled failed to prepare POST request")
+		}
+
+		resp, err := m.HTTPClient.Do(req)
+		if err != nil {
+			return false, fmt.Errorf("IsCommandCancelled failed to issue HTTP request: %s", err)
+		}
+
+		if resp != nil {
+			defer resp.Body.Close()
+			switch resp.StatusCode {
+			case http.StatusOK:
+				return true, nil
+			case http.StatusNoContent:
+				return false, nil
+			}
+			return false, fmt.Errorf("IsCommandCancelled failed, unexpected HTTP status code %d", resp.StatusCode)
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("IsCommandCancelled failed, server cannot be authenticated")
+}
+
 func (m *ManagerClient) FetchCommand() (*Command, error) {
 	command := NewCommand()
 	if auth, _ := m.IsServerAuthenticated(); auth {
@@ -519,6 +912,9 @@ func (m *ManagerClient) FetchCommand() (*Command, error) {
 			return command, fmt.Errorf("FetchCommand failed to issue HTTP request: %s", err)
 		}
 
+		// endpoints beacon so this is where they learn about key rotation
+		m.checkKeyRotation(resp)
+
 		// if there is no command to execute, the server replies with this status code
 		if resp.StatusCode == http.StatusNoContent {
 			// nothing else to do
@@ -1,10 +1,18 @@
 package api
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -59,6 +67,49 @@ func TestClientGetRules(t *testing.T) {
 
 }
 
+func TestClientActionsSuppression(t *testing.T) {
+	key := KeyGen(DefaultKeySize)
+
+	r, err := NewManager(&mconf)
+	if err != nil {
+		panic(err)
+	}
+	r.AddEndpoint(cconf.UUID, key)
+	r.Run()
+	defer r.Shutdown()
+
+	cconf.Key = key
+	c, err := NewManagerClient(&cconf)
+	if err != nil {
+		panic(err)
+	}
+
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	c.SetActionsSuppression(ActionsSuppression{Enabled: true, Until: until})
+	if _, err := c.GetRulesSha256(); err != nil {
+		t.Errorf("%s", err)
+	}
+
+	endpt, ok := r.MutEndpoint(cconf.UUID)
+	if !ok {
+		t.Errorf("Endpoint not found")
+		t.FailNow()
+	}
+
+	if !endpt.Suppression.Active() || !endpt.Suppression.Until.Equal(until) {
+		t.Errorf("Unexpected actions suppression state: %+v", endpt.Suppression)
+	}
+
+	c.SetActionsSuppression(ActionsSuppression{})
+	if _, err := c.GetRulesSha256(); err != nil {
+		t.Errorf("%s", err)
+	}
+
+	if endpt, _ = r.MutEndpoint(cconf.UUID); endpt.Suppression.Active() {
+		t.Errorf("Actions must not be suppressed anymore")
+	}
+}
+
 func TestClientPostDump(t *testing.T) {
 	key := KeyGen(DefaultKeySize)
 
@@ -173,6 +224,71 @@ func TestClientContainer(t *testing.T) {
 
 }
 
+func TestClientKeyRotation(t *testing.T) {
+	key := KeyGen(DefaultKeySize)
+
+	r, err := NewManager(&mconf)
+	if err != nil {
+		panic(err)
+	}
+	r.AddEndpoint(cconf.UUID, key)
+	r.Run()
+	defer r.Shutdown()
+
+	cconf.Key = key
+	c, err := NewManagerClient(&cconf)
+	if err != nil {
+		panic(err)
+	}
+
+	rotate := func(overlap time.Duration) (newKey string) {
+		endpt, ok := r.MutEndpoint(cconf.UUID)
+		if !ok {
+			t.Fatal("Endpoint not found")
+		}
+		newKey = KeyGen(DefaultKeySize)
+		endpt.RotateKey(newKey, overlap)
+		if err := r.db.InsertOrUpdate(endpt); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	// previous key accepted during overlap, endpoint being told to fetch its new key
+	newKey := rotate(time.Hour)
+	if _, err := c.FetchCommand(); err != ErrNothingToDo {
+		t.Errorf("Previous key must be accepted during overlap: %v", err)
+	}
+	if !c.IsKeyRotated() {
+		t.Error("Endpoint must be told its key has been rotated")
+	}
+	if k, err := c.FetchKey(); err != nil || k != newKey {
+		t.Errorf("Failed to fetch rotated key: %v", err)
+	}
+	if c.IsKeyRotated() || c.Key() != newKey {
+		t.Error("Endpoint must use its new key")
+	}
+
+	// previous key expired
+	rotate(time.Hour)
+	endpt, _ := r.MutEndpoint(cconf.UUID)
+	endpt.PreviousKeyExpires = time.Now().Add(-time.Second)
+	r.db.InsertOrUpdate(endpt)
+	if _, err := c.FetchCommand(); err == nil || err == ErrNothingToDo {
+		t.Error("Expired previous key must be rejected")
+	}
+
+	// rotation without overlap
+	cconf.Key = rotate(0)
+	if c, err = NewManagerClient(&cconf); err != nil {
+		panic(err)
+	}
+	rotate(0)
+	if _, err := c.FetchCommand(); err == nil || err == ErrNothingToDo {
+		t.Error("Previous key must be rejected without overlap")
+	}
+}
+
 func TestClientExecuteCommand(t *testing.T) {
 	var cmd *Command
 	var err error
@@ -209,6 +325,10 @@ func TestClientExecuteCommand(t *testing.T) {
 		t.Errorf("Client failed to fetch command: %s", err)
 		t.FailNow()
 	} else {
+		if err := c.AckCommand(cmd); err != nil {
+			t.Errorf("Failed to acknowledge command: %s", err)
+			t.FailNow()
+		}
 		if err := cmd.Run(); err != nil {
 			t.Errorf("Failed to run command: %s", err)
 			t.FailNow()
@@ -225,6 +345,12 @@ func TestClientExecuteCommand(t *testing.T) {
 		t.Fail()
 	}
 
+	// pending -> sent -> running -> completed
+	if cmd.Status != CmdStatusCompleted || len(cmd.Transitions) != 4 {
+		t.Errorf("Unexpected command status %s transitions: %v", cmd.Status, cmd.Transitions)
+		t.Fail()
+	}
+
 	if cmd.Stdout == nil {
 		t.Errorf("Expected output on stdout")
 		t.Fail()
@@ -271,6 +397,7 @@ func TestClientExecuteDroppedCommand(t *testing.T) {
 
 	cmd.AddFetchFile("/usr/bin/ls")
 	cmd.AddFetchFile("/nonexistingfile")
+	cmd.AddFetchFileWithHash("/usr/bin/env", "0000000000000000000000000000000000000000000000000000000000000000")
 
 	if err = cmd.SetCommandLine("./droppedls -hail ./"); err != nil {
 		t.Errorf("Failed at setting command line: %s", err)
@@ -320,9 +447,130 @@ func TestClientExecuteDroppedCommand(t *testing.T) {
 		t.Fail()
 	}
 
+	if cmd.Fetch["/usr/bin/ls"].Status != FetchStatusVerified {
+		t.Logf("Fetched file should have been verified: %s", cmd.Fetch["/usr/bin/ls"].Status)
+		t.Fail()
+	}
+
+	if ef := cmd.Fetch["/usr/bin/env"]; ef.Status != FetchStatusSkipped || ef.Data != nil {
+		t.Logf("File not matching expected hash should have been skipped: %s", ef.Status)
+		t.Fail()
+	}
+
 	if cmd.Fetch["/nonexistingfile"].Error == "" {
 		t.Logf("Failed to retrieve error")
 		t.Fail()
 	}
 	t.Logf(cmd.Fetch["/nonexistingfile"].Error)
 }
+
+func TestBandwidthLimiter(t *testing.T) {
+	rate := int64(64 * 1024)
+	data := make([]byte, 2*rate)
+	l := newBandwidthLimiter(rate)
+
+	start := time.Now()
+	r := l.reader(ioutil.NopCloser(bytes.NewReader(data)), true)
+	if n, err := io.Copy(ioutil.Discard, r); err != nil || n != int64(len(data)) {
+		t.Errorf("Unexpected throttled copy n=%d err=%v", n, err)
+	}
+	r.Close()
+
+	// first second of transfer is allowed to burst
+	if d := time.Since(start); d < 900*time.Millisecond {
+		t.Errorf("Transfer not throttled, took %s", d)
+	}
+
+	if l.priority != 0 {
+		t.Errorf("Priority transfers count should be zero")
+	}
+
+	if newBandwidthLimiter(0) != nil {
+		t.Errorf("Zero rate must mean unlimited")
+	}
+}
+
+func TestClientUploadRate(t *testing.T) {
+	rate := int64(64 * 1024)
+	received := int64(0)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(wt http.ResponseWriter, rq *http.Request) {
+		n, _ := io.Copy(ioutil.Discard, rq.Body)
+		atomic.AddInt64(&received, n)
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+	c, err := NewManagerClient(&ClientConfig{
+		Proto:      "http",
+		Host:       u.Hostname(),
+		Port:       port,
+		Key:        "key",
+		UploadRate: rate,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// random data not to be compressed
+	content := make([]byte, 2*rate)
+	rand.Read(content)
+
+	start := time.Now()
+	if err := c.PostLogs(newBatchID(), bytes.NewReader(content)); err != nil {
+		t.Error(err)
+	}
+	// first second of transfer is allowed to burst
+	if d := time.Since(start); d < 900*time.Millisecond {
+		t.Errorf("Logs upload not throttled, took %s", d)
+	}
+
+	start = time.Now()
+	if err := c.PostDump(&FileUpload{Name: "test.bin", Content: content, Total: 1}); err != nil {
+		t.Error(err)
+	}
+	if d := time.Since(start); d < 900*time.Millisecond {
+		t.Errorf("Dump upload not throttled, took %s", d)
+	}
+
+	if atomic.LoadInt64(&received) < 4*rate {
+		t.Errorf("Unexpected amount of data received: %d", received)
+	}
+
+	if c.limiter.priority != 0 {
+		t.Errorf("Priority transfers count should be zero")
+	}
+}
+
+func TestIngestionLimiter(t *testing.T) {
+	l := newIngestionLimiter(1, 1)
+
+	if !l.Acquire() {
+		t.Errorf("First request must be accepted")
+	}
+
+	// second request waits for the worker
+	acquired := make(chan bool)
+	go func() { acquired <- l.Acquire() }()
+
+	for l.Stats().Queued != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// queue is full
+	if l.Acquire() {
+		t.Errorf("Request must be rejected when intake queue is full")
+	}
+
+	l.Release()
+	if !<-acquired {
+		t.Errorf("Queued request must be accepted once a worker is released")
+	}
+	l.Release()
+
+	s := l.Stats()
+	if s.Active != 0 || s.Queued != 0 || s.Rejected != 1 {
+		t.Errorf("Unexpected ingestion stats: %+v", s)
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+)
+
+// AutoAssignRule assigns a group and labels to the endpoints matching all
+// the criteria set in the rule
+type AutoAssignRule struct {
+	Hostname string   `toml:"hostname" comment:"Hostname glob pattern, case insensitive (ex: srv-*)"`
+	OS       string   `toml:"os" comment:"OS product name glob pattern, case insensitive (ex: *Server*)"`
+	Network  string   `toml:"network" comment:"Network endpoint IP must be in, CIDR notation (ex: 10.0.0.0/8)"`
+	Group    string   `toml:"group" comment:"Group assigned to matching endpoints"`
+	Labels   []string `toml:"labels" comment:"Labels assigned to matching endpoints"`
+
+	network *net.IPNet
+}
+
+func (r *AutoAssignRule) compile() (err error) {
+	if r.Hostname == "" && r.OS == "" && r.Network == "" {
+		return fmt.Errorf("auto-assign rule must have a hostname, an os or a network")
+	}
+
+	for _, p := range []string{r.Hostname, r.OS} {
+		if _, err = filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("bad auto-assign pattern %s: %w", p, err)
+		}
+	}
+
+	if r.Network != "" {
+		if _, r.network, err = net.ParseCIDR(r.Network); err != nil {
+			return fmt.Errorf("bad auto-assign network %s: %w", r.Network, err)
+		}
+	}
+
+	return
+}
+
+func globMatch(pattern, s string) bool {
+	ok, _ := filepath.Match(strings.ToLower(pattern), strings.ToLower(s))
+	return ok
+}
+
+// Match returns true if endpoint matches the rule
+func (r *AutoAssignRule) Match(endpt *Endpoint) bool {
+	if r.Hostname != "" && !globMatch(r.Hostname, endpt.Hostname) {
+		return false
+	}
+
+	if r.OS != "" && (endpt.SystemInfo == nil || !globMatch(r.OS, endpt.SystemInfo.OS.Product)) {
+		return false
+	}
+
+	if r.network != nil {
+		// IP may be suffixed with a port
+		host := endpt.IP
+		if h, _, err := net.SplitHostPort(endpt.IP); err == nil {
+			host = h
+		}
+		if ip := net.ParseIP(host); ip == nil || !r.network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// autoAssigner applies the first auto-assign rule matching an endpoint
+type autoAssigner struct {
+	rules []AutoAssignRule
+}
+
+func newAutoAssigner(rules []AutoAssignRule) (a *autoAssigner, err error) {
+	a = &autoAssigner{rules: make([]AutoAssignRule, len(rules))}
+	copy(a.rules, rules)
+
+	for i := range a.rules {
+		if err = a.rules[i].compile(); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Assign sets the group and labels of endpoint according to the first rule
+// matching it. It returns true if endpoint has been modified. Endpoints with
+// a group set manually are left untouched.
+func (a *autoAssigner) Assign(endpt *Endpoint) bool {
+	if endpt.ManualGroup {
+		return false
+	}
+
+	for _, r := range a.rules {
+		if r.Match(endpt) {
+			if endpt.Group == r.Group && equalStrings(endpt.Labels, r.Labels) {
+				return false
+			}
+			endpt.Group = r.Group
+			endpt.Labels = append([]string{}, r.Labels...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBatchDedupWindow default period during which the manager
+	// remembers the batches of events it collected
+	DefaultBatchDedupWindow = time.Hour
+
+	// batchHeaderPrefix prefixes the line written before the events of a
+	// batch in queue files, so that replayed batches keep their identifier
+	batchHeaderPrefix = "#batch:"
+)
+
+// newBatchID generates a random batch identifier
+func newBatchID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// batchHeader returns the line preceding the events of a batch in queue files
+func batchHeader(id string) []byte {
+	return []byte(batchHeaderPrefix + id + "\n")
+}
+
+// queuedBatch is a batch of events read from a queue file
+type queuedBatch struct {
+	id     string
+	events []byte
+}
+
+// splitBatches splits the content of a queue file into the batches it
+// holds. Events queued without batch header (i.e. by older agents) are
+// returned in a batch without identifier.
+func splitBatches(b []byte) (batches []queuedBatch) {
+	var cur *queuedBatch
+
+	batches = make([]queuedBatch, 0)
+	prefix := []byte(batchHeaderPrefix)
+
+	for len(b) > 0 {
+		line := b
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			line, b = b[:i+1], b[i+1:]
+		} else {
+			b = nil
+		}
+
+		if bytes.HasPrefix(line, prefix) {
+			id := string(bytes.TrimSpace(line[len(prefix):]))
+			batches = append(batches, queuedBatch{id: id, events: make([]byte, 0)})
+			cur = &batches[len(batches)-1]
+			continue
+		}
+
+		if cur == nil {
+			batches = append(batches, queuedBatch{events: make([]byte, 0)})
+			cur = &batches[len(batches)-1]
+		}
+		cur.events = append(cur.events, line...)
+	}
+
+	// headers may be followed by no event if a save was interrupted
+	filtered := batches[:0]
+	for _, qb := range batches {
+		if len(bytes.TrimSpace(qb.events)) > 0 {
+			filtered = append(filtered, qb)
+		}
+	}
+
+	return filtered
+}
+
+// collectedBatches remembers the batches of events collected recently so that
+// batches replayed by endpoints (i.e. if an endpoint did not get the response
+// of the manager) are not ingested twice
+type collectedBatches struct {
+	sync.Mutex
+	window    time.Duration
+	seen      map[uint64]time.Time
+	lastClean time.Time
+}
+
+func newCollectedBatches(window time.Duration) *collectedBatches {
+	return &collectedBatches{
+		window:    window,
+		seen:      make(map[uint64]time.Time),
+		lastClean: time.Now(),
+	}
+}
+
+// SetWindow changes the period during which collected batches are remembered
+func (c *collectedBatches) SetWindow(window time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.window = window
+}
+
+func batchKey(uuid, id string) uint64 {
+	sum := sha256.Sum256([]byte(uuid + id))
+	return binary.LittleEndian.Uint64(sum[:8])
+}
+
+// Collected returns true if the batch has already been collected from the
+// endpoint within the window
+func (c *collectedBatches) Collected(uuid, id string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.window <= 0 || id == "" {
+		return false
+	}
+
+	t, ok := c.seen[batchKey(uuid, id)]
+	return ok && time.Since(t) <= c.window
+}
+
+// MarkCollected records a batch as collected from the endpoint, it must be
+// called only once the batch is fully ingested so that a batch failing to
+// be ingested is ingested when replayed
+func (c *collectedBatches) MarkCollected(uuid, id string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.window <= 0 || id == "" {
+		return
+	}
+
+	now := time.Now()
+	// cleaning up expired batches from time to time
+	if now.Sub(c.lastClean) > c.window/10 {
+		for k, t := range c.seen {
+			if now.Sub(t) > c.window {
+				delete(c.seen, k)
+			}
+		}
+		c.lastClean = now
+	}
+
+	c.seen[batchKey(uuid, id)] = now
+}
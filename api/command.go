@@ -8,8 +8,10 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/0xrawsec/golang-utils/crypto/data"
 	"github.com/0xrawsec/whids/utils/command"
 	"github.com/google/shlex"
 	"github.com/google/uuid"
@@ -21,13 +23,144 @@ type EndpointFile struct {
 	Name  string `json:"name"`
 	Data  []byte `json:"data"`
 	Error string `json:"error"`
+
+	// fetched files only
+	Sha256         string      `json:"sha256,omitempty"`
+	ExpectedSha256 string      `json:"expected-sha256,omitempty"`
+	Status         FetchStatus `json:"status,omitempty"`
+}
+
+// FetchStatus status of a file fetched from an endpoint
+type FetchStatus string
+
+const (
+	// FetchStatusFetched file has been fetched, its hash has not been verified yet
+	FetchStatusFetched = FetchStatus("fetched")
+	// FetchStatusVerified file has been received by the manager and its
+	// content matches the hash computed by the endpoint
+	FetchStatusVerified = FetchStatus("verified")
+	// FetchStatusCorrupted file received by the manager does not match
+	// the hash computed by the endpoint
+	FetchStatusCorrupted = FetchStatus("corrupted")
+	// FetchStatusSkipped file has not been fetched as it does not match
+	// the expected hash
+	FetchStatusSkipped = FetchStatus("skipped")
+	// FetchStatusError file could not be fetched
+	FetchStatusError = FetchStatus("error")
+)
+
+// fetch reads a file on the endpoint, the file is fetched only if it
+// matches the expected hash (if any)
+func (ef *EndpointFile) fetch(path string) {
+	var err error
+
+	if ef.Data, err = ioutil.ReadFile(path); err != nil {
+		ef.Error = fmt.Sprintf("%s", err)
+		ef.Status = FetchStatusError
+		return
+	}
+
+	ef.Sha256 = data.Sha256(ef.Data)
+	ef.Status = FetchStatusFetched
+
+	if ef.ExpectedSha256 != "" && !strings.EqualFold(ef.Sha256, ef.ExpectedSha256) {
+		ef.Data = nil
+		ef.Status = FetchStatusSkipped
+		ef.Error = fmt.Sprintf("file hash mismatch sha256=%s expected=%s", ef.Sha256, ef.ExpectedSha256)
+	}
+}
+
+// verify checks the integrity of a fetched file received by the manager
+func (ef *EndpointFile) verify() {
+	if ef.Status != FetchStatusFetched {
+		return
+	}
+
+	if data.Sha256(ef.Data) == ef.Sha256 {
+		ef.Status = FetchStatusVerified
+	} else {
+		ef.Status = FetchStatusCorrupted
+		ef.Error = fmt.Sprintf("file content does not match endpoint hash sha256=%s", ef.Sha256)
+	}
+}
+
+// CommandStatus status of a command sent to an endpoint
+type CommandStatus string
+
+const (
+	// CmdStatusPending command is waiting to be fetched by the endpoint
+	CmdStatusPending = CommandStatus("pending")
+	// CmdStatusSent command has been sent to the endpoint
+	CmdStatusSent = CommandStatus("sent")
+	// CmdStatusRunning endpoint acknowledged the command and runs it
+	CmdStatusRunning = CommandStatus("running")
+	// CmdStatusCompleted command completed successfully
+	CmdStatusCompleted = CommandStatus("completed")
+	// CmdStatusFailed command completed with an error
+	CmdStatusFailed = CommandStatus("failed")
+	// CmdStatusTimedOut command has been stopped because it timed out
+	CmdStatusTimedOut = CommandStatus("timed-out")
+	// CmdStatusCancelled command has been cancelled by an operator
+	CmdStatusCancelled = CommandStatus("cancelled")
+	// CmdStatusInterrupted command has been stopped because the endpoint
+	// shut down while running it
+	CmdStatusInterrupted = CommandStatus("interrupted")
+)
+
+// CommandType type of a command sent to an endpoint
+type CommandType string
+
+const (
+	// CmdTypeExec command line to execute on the endpoint
+	CmdTypeExec = CommandType("")
+	// CmdTypeIsolate isolates the endpoint from the network, except
+	// from the manager and the addresses allowed in agent configuration
+	CmdTypeIsolate = CommandType("isolate")
+	// CmdTypeUnisolate restores network connectivity of an isolated endpoint
+	CmdTypeUnisolate = CommandType("unisolate")
+	// CmdTypeMemdump dumps the memory of a process running on the endpoint,
+	// the target process is identified by PID, process GUID or image
+	CmdTypeMemdump = CommandType("memdump")
+	// CmdTypeSysinfo returns a structured profile of the endpoint (OS, network,
+	// Sysmon and agent versions, ETW and audit configuration)
+	CmdTypeSysinfo = CommandType("sysinfo")
+)
+
+// IsStructured returns true if the command type is handled by the
+// endpoint itself and does not carry a command line
+func (t CommandType) IsStructured() bool {
+	switch t {
+	case CmdTypeIsolate, CmdTypeUnisolate, CmdTypeMemdump, CmdTypeSysinfo:
+		return true
+	}
+	return false
+}
+
+// NeedsTarget returns true if the command type requires a target
+func (t CommandType) NeedsTarget() bool {
+	return t == CmdTypeMemdump
+}
+
+// Validate returns an error if the command type is unknown
+func (t CommandType) Validate() error {
+	if t == CmdTypeExec || t.IsStructured() {
+		return nil
+	}
+	return fmt.Errorf("unknown command type: %s", t)
+}
+
+// CommandTransition records a status change of a command
+type CommandTransition struct {
+	Status CommandStatus `json:"status"`
+	Time   time.Time     `json:"time"`
 }
 
 // Command structure representing a command sent to an endpoint
 type Command struct {
-	UUID string   `json:"uuid"`
-	Name string   `json:"name"`
-	Args []string `json:"args"`
+	UUID string      `json:"uuid"`
+	Type CommandType `json:"type"`
+	Name string      `json:"name"`
+	Args []string    `json:"args"`
 	// used to drop files on the endpoint
 	Drop []*EndpointFile `json:"drop"`
 	// used to fetch files from the endpoint
@@ -41,8 +174,16 @@ type Command struct {
 	Completed  bool                     `json:"completed"`
 	ExpectJSON bool                     `json:"expect-json"`
 	Timeout    time.Duration            `json:"timeout"`
+	TimedOut   bool                     `json:"timed-out"`
 	SentTime   time.Time                `json:"sent-time"`
-	runnable   bool
+	// cancellation
+	CancelRequested bool `json:"cancel-requested"`
+	Cancelled       bool `json:"cancelled"`
+	Interrupted     bool `json:"interrupted"`
+	// command lifecycle
+	Status      CommandStatus       `json:"status"`
+	Transitions []CommandTransition `json:"transitions"`
+	runnable    bool
 }
 
 // NewCommand creates a new Command to run on an endpoint
@@ -53,9 +194,30 @@ func NewCommand() *Command {
 		Drop:     make([]*EndpointFile, 0),
 		Fetch:    make(map[string]*EndpointFile),
 		runnable: true}
+	cmd.SetStatus(CmdStatusPending)
 	return cmd
 }
 
+// SetStatus sets the status of the command and records the transition
+func (c *Command) SetStatus(s CommandStatus) {
+	c.Status = s
+	c.Transitions = append(c.Transitions, CommandTransition{s, time.Now()})
+}
+
+var (
+	// ErrCommandCompleted is returned when cancelling a completed command
+	ErrCommandCompleted = fmt.Errorf("command is already completed")
+	// ErrCommandNotCancellable is returned when cancelling a structured
+	// command already sent, the endpoint running it to completion
+	ErrCommandNotCancellable = fmt.Errorf("structured command cannot be cancelled once sent")
+
+	// rawArgsCommands are the commands taking the rest of the command line,
+	// unchanged, as a single argument
+	rawArgsCommands = map[string]bool{
+		"unblacklist": true,
+	}
+)
+
 // SetCommandLine sets the command line to execute on the endpoint
 func (c *Command) SetCommandLine(cl string) error {
 	args, err := shlex.Split(cl)
@@ -68,6 +230,10 @@ func (c *Command) SetCommandLine(cl string) error {
 	}
 	if len(args) > 1 {
 		c.Args = args[1:]
+		// a command line argument must be kept as is, quotes included
+		if trimmed := strings.TrimSpace(cl); rawArgsCommands[c.Name] && strings.HasPrefix(trimmed, c.Name) {
+			c.Args = []string{strings.TrimSpace(strings.TrimPrefix(trimmed, c.Name))}
+		}
 	}
 	return nil
 }
@@ -100,6 +266,12 @@ func (c *Command) AddFetchFile(filepath string) {
 	c.Fetch[filepath] = &EndpointFile{UUID: UUIDGen().String()}
 }
 
+// AddFetchFileWithHash adds a file to fetch from the endpoint only if its
+// SHA256 matches sha256.
+func (c *Command) AddFetchFileWithHash(filepath, sha256 string) {
+	c.Fetch[filepath] = &EndpointFile{UUID: UUIDGen().String(), ExpectedSha256: strings.ToLower(sha256)}
+}
+
 func (c *Command) FromExecCmd(cmd *exec.Cmd) {
 	if cmd.Args != nil {
 		if len(cmd.Args) > 0 {
@@ -130,9 +302,22 @@ func (c *Command) Unrunnable() {
 	c.runnable = false
 }
 
+// IsRunnable returns true if the command line has to be run
+func (c *Command) IsRunnable() bool {
+	return c.Name != "" && c.runnable
+}
+
 // Run runs the command according to the specified settings
 // it aims at being used on the endpoint
 func (c *Command) Run() (err error) {
+	return c.RunContext(context.Background(), nil)
+}
+
+// RunContext runs the command as Run does, the command line being stopped
+// (with its whole process tree) when cancel is closed or when ctx is done.
+// The command is reported as cancelled in the first case and as interrupted
+// in the second.
+func (c *Command) RunContext(ctx context.Context, cancel <-chan struct{}) (err error) {
 	// current working directory for command
 	var cwd string
 	var cmd *command.Cmd
@@ -169,15 +354,21 @@ func (c *Command) Run() (err error) {
 	}
 
 	// we have something to run
-	if c.Name != "" && c.runnable {
+	if c.IsRunnable() {
 
-		if c.Timeout > 0 {
-			cmd = command.CommandTimeout(c.Timeout, c.Name, c.Args...)
-		} else {
-			cmd = command.Command(c.Name, c.Args...)
-		}
+		cmd = command.CommandContext(ctx, c.Timeout, c.Name, c.Args...)
 		defer cmd.Terminate()
 
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-cancel:
+				cmd.Cancel()
+			case <-done:
+			}
+		}()
+
 		// ToDo consider removing that !
 		c.Name = cmd.Path
 		c.Args = cmd.Args
@@ -194,6 +385,9 @@ func (c *Command) Run() (err error) {
 				c.Stderr = ee.Stderr
 			}
 			c.Error = fmt.Sprintf("%s", err)
+			c.TimedOut = cmd.TimedOut()
+			c.Cancelled = cmd.Cancelled()
+			c.Interrupted = cmd.Interrupted()
 		}
 
 		// if we expect JSON output
@@ -207,12 +401,8 @@ func (c *Command) Run() (err error) {
 	}
 
 	// fetching files after the command has been ran
-	for fn := range c.Fetch {
-		data, err := ioutil.ReadFile(fn)
-		if err != nil {
-			c.Fetch[fn].Error = fmt.Sprintf("%s", err)
-		}
-		c.Fetch[fn].Data = data
+	for fn, ef := range c.Fetch {
+		ef.fetch(fn)
 	}
 
 	return
@@ -243,9 +433,51 @@ func (c *Command) Complete(other *Command) error {
 		c.Error = other.Error
 		c.Drop = other.Drop
 		c.Fetch = other.Fetch
+		for _, ef := range c.Fetch {
+			ef.verify()
+		}
 		c.ExpectJSON = other.ExpectJSON
+		c.TimedOut = other.TimedOut
+		c.Cancelled = other.Cancelled
+		c.Interrupted = other.Interrupted
 		c.Completed = true
+		switch {
+		case c.Cancelled:
+			c.SetStatus(CmdStatusCancelled)
+		case c.Interrupted:
+			c.SetStatus(CmdStatusInterrupted)
+		case c.TimedOut:
+			c.SetStatus(CmdStatusTimedOut)
+		case c.Error != "":
+			c.SetStatus(CmdStatusFailed)
+		default:
+			c.SetStatus(CmdStatusCompleted)
+		}
 		return nil
 	}
 	return fmt.Errorf("Command does not have the same ID")
 }
+
+// Cancel cancels a command not sent yet to the endpoint, or requests
+// the endpoint to cancel it if it has already been sent. Only command lines
+// can be cancelled once sent.
+func (c *Command) Cancel() error {
+	switch {
+	case c.Completed:
+		return ErrCommandCompleted
+	case c.Sent && c.Type.IsStructured():
+		return ErrCommandNotCancellable
+	case !c.Sent:
+		c.Cancelled = true
+		c.Completed = true
+		c.SetStatus(CmdStatusCancelled)
+	default:
+		c.CancelRequested = true
+	}
+	return nil
+}
+
+// InFlight returns true if the command is not completed yet
+func (c *Command) InFlight() bool {
+	return !c.Completed
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/0xrawsec/sod"
+	"github.com/0xrawsec/whids/utils"
+)
+
+var (
+	containerNameRe = regexp.MustCompile(`^\w+$`)
+)
+
+// EdrContainer structure holding a Gene container (list of IoCs, whitelist ...)
+// managed from the manager and deployed on endpoints
+type EdrContainer struct {
+	sod.Item
+	Name    string   `json:"name"`
+	Entries []string `json:"entries"`
+}
+
+// NewEdrContainer creates a new container
+func NewEdrContainer(name string) *EdrContainer {
+	return &EdrContainer{Name: name, Entries: make([]string, 0)}
+}
+
+// Validate validates the container
+func (c *EdrContainer) Validate() error {
+	if !containerNameRe.MatchString(c.Name) {
+		return fmt.Errorf("invalid container name %q, it must match %s", c.Name, containerNameRe)
+	}
+	if c.Name == IoCContainerName {
+		return fmt.Errorf("container %s is reserved, manage it through IoCs API", IoCContainerName)
+	}
+	return nil
+}
+
+// Add adds entries to the container, entries already existing are ignored
+func (c *EdrContainer) Add(entries ...string) {
+	set := c.set()
+	for _, e := range entries {
+		if !set[e] {
+			c.Entries = append(c.Entries, e)
+			set[e] = true
+		}
+	}
+	sort.Strings(c.Entries)
+}
+
+// Del deletes entries from the container
+func (c *EdrContainer) Del(entries ...string) {
+	del := make(map[string]bool)
+	for _, e := range entries {
+		del[e] = true
+	}
+
+	new := make([]string, 0, len(c.Entries))
+	for _, e := range c.Entries {
+		if !del[e] {
+			new = append(new, e)
+		}
+	}
+	c.Entries = new
+}
+
+// Sha256 returns the sha256 of the container entries
+func (c *EdrContainer) Sha256() string {
+	return utils.Sha256StringArray(c.Entries)
+}
+
+func (c *EdrContainer) set() map[string]bool {
+	set := make(map[string]bool)
+	for _, e := range c.Entries {
+		set[e] = true
+	}
+	return set
+}
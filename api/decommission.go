@@ -0,0 +1,132 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/whids/logger"
+)
+
+// DecommissionSummary summarizes the data purged when an endpoint is
+// decommissioned
+type DecommissionSummary struct {
+	Uuid            string   `json:"uuid"`
+	Hostname        string   `json:"hostname"`
+	EventsBytes     int64    `json:"events-bytes"`
+	DetectionsBytes int64    `json:"detections-bytes"`
+	ArchivedReports int      `json:"archived-reports"`
+	Suppressions    int      `json:"suppressions"`
+	DumpsBytes      int64    `json:"dumps-bytes"`
+	Errors          []string `json:"errors,omitempty"`
+}
+
+func (s *DecommissionSummary) errorf(f string, args ...interface{}) {
+	s.Errors = append(s.Errors, format(f, args...))
+}
+
+// decommission deletes an endpoint and purges all the data the manager
+// stores about it. Purging goes on when an error is met so that a partial
+// decommission can be completed by calling it again.
+func (m *Manager) decommission(endpt *Endpoint) (s DecommissionSummary) {
+	var err error
+
+	uuid := endpt.Uuid
+	s.Uuid = uuid
+	s.Hostname = endpt.Hostname
+
+	// endpoint is deleted first so that it cannot send more data
+	if err = m.db.Delete(endpt); err != nil {
+		s.errorf("failed to delete endpoint: %s", err)
+	}
+
+	stores := []struct {
+		name   string
+		logger *logger.EventLogger
+		size   *int64
+	}{
+		{"events", m.eventLogger, &s.EventsBytes},
+		{"detections", m.detectionLogger, &s.DetectionsBytes},
+	}
+
+	for _, store := range stores {
+		if *store.size, err = store.logger.Remove(uuid); err != nil {
+			s.errorf("failed to remove %s: %s", store.name, err)
+		}
+	}
+
+	search := m.db.Search(&ArchivedReport{}, "Identifier", "=", uuid)
+	if err = search.Err(); err != nil {
+		s.errorf("failed to search archived reports: %s", err)
+	} else if err = search.Delete(); err != nil {
+		s.errorf("failed to delete archived reports: %s", err)
+	} else {
+		s.ArchivedReports = search.Len()
+	}
+	m.gene.reducer.Delete(uuid)
+
+	// suppressions scoped to the endpoint would never apply again
+	if objs, err := m.db.All(&DetectionSuppression{}); err != nil {
+		s.errorf("failed to list suppressions: %s", err)
+	} else {
+		for _, o := range objs {
+			if o.(*DetectionSuppression).Endpoint != uuid {
+				continue
+			}
+			if err := m.db.Delete(o); err != nil {
+				s.errorf("failed to delete suppression: %s", err)
+				continue
+			}
+			s.Suppressions++
+		}
+
+		if s.Suppressions > 0 {
+			if err := m.initializeGeneFromDB(); err != nil {
+				s.errorf("failed to reload rules: %s", err)
+			}
+		}
+	}
+
+	if m.Config.DumpDir != "" {
+		dir := filepath.Join(m.Config.DumpDir, uuid)
+		s.DumpsBytes = logger.DirSize(dir)
+		if err = os.RemoveAll(dir); err != nil {
+			s.DumpsBytes -= logger.DirSize(dir)
+			s.errorf("failed to remove dumps: %s", err)
+		}
+	}
+
+	return
+}
+
+func (m *Manager) admAPIEndpointDecommission(wt http.ResponseWriter, rq *http.Request) {
+	var euuid string
+	var err error
+
+	if euuid, err = muxGetVar(rq, "euuid"); err != nil {
+		wt.Write(admErr(format("Failed to parse URL: %s", err)))
+		return
+	}
+
+	// confirmation prevents purging data of an endpoint by mistake
+	if rq.URL.Query().Get(qpConfirm) != euuid {
+		wt.Write(admErr(format("Decommission must be confirmed with %s=%s", qpConfirm, euuid)))
+		return
+	}
+
+	endpt, ok := m.MutEndpoint(euuid)
+	if !ok {
+		wt.Write(admErr(format("Unknown endpoint: %s", euuid)))
+		return
+	}
+
+	s := m.decommission(endpt)
+	log.Infof("Decommissioned endpoint UUID=%s hostname=%s", euuid, s.Hostname)
+
+	apiResp := NewAdminAPIResponse(s)
+	if len(s.Errors) > 0 {
+		apiResp.Error = format("decommission not complete: %d errors", len(s.Errors))
+	}
+	wt.Write(apiResp.ToJSON())
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"github.com/0xrawsec/golang-utils/datastructs"
+	"github.com/0xrawsec/whids/event"
+)
+
+func setToStrings(s *datastructs.Set) (out []string) {
+	out = make([]string, 0)
+	if s != nil {
+		for _, i := range s.SortSlice() {
+			if str, ok := i.(string); ok {
+				out = append(out, str)
+			}
+		}
+	}
+	return
+}
+
+// DetectionSummary flattened view of the detection information embedded in an event
+type DetectionSummary struct {
+	Rules       []string `json:"rules"`
+	Criticality int      `json:"criticality"`
+	Actions     []string `json:"actions"`
+}
+
+// NewDetectionSummary creates a DetectionSummary out of an event
+func NewDetectionSummary(e *event.EdrEvent) (s DetectionSummary) {
+	s.Rules = make([]string, 0)
+	s.Actions = make([]string, 0)
+	if d := e.GetDetection(); d != nil {
+		s.Rules = setToStrings(d.Signature)
+		s.Criticality = d.Criticality
+		s.Actions = setToStrings(d.Actions)
+	}
+	return
+}
+
+// DetectionEntry structure returned by the detections API, it is
+// the event itself, encoded as any other event, along with a summary
+// of the detection
+type DetectionEntry struct {
+	Event            *event.InnerEvent `json:"Event"`
+	DetectionSummary DetectionSummary  `json:"detection-summary"`
+}
+
+// NewDetectionEntry creates a DetectionEntry out of an event
+func NewDetectionEntry(e *event.EdrEvent) DetectionEntry {
+	return DetectionEntry{&e.Event, NewDetectionSummary(e)}
+}
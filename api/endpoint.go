@@ -8,6 +8,18 @@ import (
 	"github.com/0xrawsec/whids/hids/sysinfo"
 )
 
+// ActionsSuppression describes the actions suppression state of an endpoint
+type ActionsSuppression struct {
+	Enabled bool      `json:"enabled"`
+	Until   time.Time `json:"until"`
+}
+
+// Active returns true if actions are currently suppressed. A zero Until
+// means actions are suppressed until explicitly resumed.
+func (s ActionsSuppression) Active() bool {
+	return s.Enabled && (s.Until.IsZero() || time.Now().Before(s.Until))
+}
+
 // Endpoint structure used to track and interact with endpoints
 type Endpoint struct {
 	sod.Item
@@ -23,6 +35,14 @@ type Endpoint struct {
 	SystemInfo     *sysinfo.SystemInfo `json:"system-info,omitempty"`
 	LastDetection  time.Time           `json:"last-detection"`
 	LastConnection time.Time           `json:"last-connection"`
+	// actions suppression state reported by the endpoint
+	Suppression ActionsSuppression `json:"actions-suppression"`
+	// previous key remains valid until it expires after a key rotation
+	PreviousKey        string    `json:"previous-key,omitempty"`
+	PreviousKeyExpires time.Time `json:"previous-key-expires"`
+	// labels and group set by auto-assign rules, unless group was set manually
+	Labels      []string `json:"labels,omitempty"`
+	ManualGroup bool     `json:"manual-group"`
 }
 
 // NewEndpoint returns a new Endpoint structure
@@ -40,6 +60,35 @@ func (e *Endpoint) Validate() error {
 	return nil
 }
 
+// RotateKey replaces endpoint key, the previous key is still accepted
+// during overlap to give endpoint time to fetch the new key
+func (e *Endpoint) RotateKey(key string, overlap time.Duration) {
+	e.PreviousKey, e.PreviousKeyExpires = "", time.Time{}
+	if overlap > 0 && e.Key != "" {
+		e.PreviousKey = e.Key
+		e.PreviousKeyExpires = time.Now().UTC().Add(overlap)
+	}
+	e.Key = key
+}
+
+// HasPreviousKey returns true if endpoint has a previous key which did
+// not expire yet
+func (e *Endpoint) HasPreviousKey() bool {
+	return e.PreviousKey != "" && time.Now().Before(e.PreviousKeyExpires)
+}
+
+// IsPreviousKey returns true if key is the previous key of the endpoint
+// and it did not expire yet
+func (e *Endpoint) IsPreviousKey(key string) bool {
+	return e.HasPreviousKey() && key == e.PreviousKey
+}
+
+// HideKeys removes keys from endpoint structure
+func (e *Endpoint) HideKeys() {
+	e.Key = ""
+	e.PreviousKey = ""
+}
+
 // Copy returns a pointer to a new copy of the Endpoint
 func (e *Endpoint) Copy() *Endpoint {
 	new := *e
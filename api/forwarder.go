@@ -3,7 +3,10 @@ package api
 import (
 	"bytes"
 	"compress/gzip"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,6 +18,7 @@ import (
 	"github.com/0xrawsec/golang-utils/fsutil/fswalker"
 	"github.com/0xrawsec/golang-utils/fsutil/logfile"
 	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/whids/event"
 	"github.com/0xrawsec/whids/utils"
 )
 
@@ -23,21 +27,56 @@ const (
 	DefaultLogfileSize = logfile.MB * 5
 	// DiskSpaceThreshold allow 1GB of queued events
 	DiskSpaceThreshold = logfile.GB
+	// queued detections are stored in distinct files than other events
+	// so that they are deleted last when disk space is constrained
+	eventsQueueBasename     = "alerts.log"
+	detectionsQueueBasename = "detections.log"
 	// MinRotationInterval is the minimum rotation interval allowed
 	MinRotationInterval = time.Minute
 )
 
+// Reasons why a batch of events has been sent
+const (
+	FlushReasonCount    = "count"
+	FlushReasonSize     = "size"
+	FlushReasonInterval = "interval"
+	FlushReasonForced   = "forced"
+)
+
 // LoggingConfig structure to encode Logging configuration of the forwarder
 type LoggingConfig struct {
 	Dir              string        `toml:"dir" comment:"Directory used to store logs"`
 	RotationInterval time.Duration `toml:"rotation-interval" comment:"Logfile rotation interval"`
+	MaxQueueSize     int64         `toml:"max-queue-size" comment:"Maximum disk space (in bytes) taken by events queued while the manager is\n unreachable, oldest events being deleted first and detections last (0: 1GB)"`
 }
 
 // ForwarderConfig structure definition
 type ForwarderConfig struct {
 	Local   bool          `toml:"local" comment:"If forwarder is local (this setting equals true)\n neither alerts nor dumps will be forwarded to manager"`
+	Sign    bool          `toml:"sign-events" comment:"Sign forwarded events with an HMAC computed with endpoint key,\n allowing the manager to verify their integrity"`
 	Client  ClientConfig  `toml:"manager" comment:"Configure connection to the manager"`
 	Logging LoggingConfig `toml:"logging" comment:"Forwarder's logging configuration"`
+	Syslog  *SyslogConfig `toml:"syslog" comment:"Forwarding of detections to a syslog destination (RFC5424 or CEF),\n independently of the forwarding to the manager"`
+}
+
+// BatchStats holds statistics about the batches of events sent by a
+// forwarder, used to tune batching configuration
+type BatchStats struct {
+	Batches   uint64            `json:"batches"`
+	Events    uint64            `json:"events"`
+	Bytes     uint64            `json:"bytes"`
+	AvgEvents float64           `json:"avg-events"`
+	AvgBytes  float64           `json:"avg-bytes"`
+	Reasons   map[string]uint64 `json:"flush-reasons"`
+}
+
+func (s *BatchStats) update(events uint64, bytes int, reason string) {
+	s.Batches++
+	s.Events += events
+	s.Bytes += uint64(bytes)
+	s.AvgEvents = float64(s.Events) / float64(s.Batches)
+	s.AvgBytes = float64(s.Bytes) / float64(s.Batches)
+	s.Reasons[reason]++
 }
 
 // Forwarder structure definition
@@ -46,12 +85,26 @@ type Forwarder struct {
 	fwdConfig *ForwarderConfig
 	stop      chan bool
 	done      chan bool
+	flush     chan bool
 	logfile   logfile.LogFile
+	// logfile where detections are queued
+	detLogfile logfile.LogFile
+	// identifiers of the batches of piped events and detections, kept
+	// when the batches are queued
+	batchID    string
+	detBatchID string
+	// sends detections to a syslog destination
+	syslog *syslogWriter
+
+	statsMutex sync.Mutex
+	stats      BatchStats
 
 	Client      *ManagerClient
 	TimeTresh   time.Duration
 	EventTresh  uint64
+	ByteTresh   int
 	Pipe        *bytes.Buffer
+	DetPipe     *bytes.Buffer
 	EventsPiped uint64
 	Local       bool
 }
@@ -65,15 +118,34 @@ func NewForwarder(c *ForwarderConfig) (*Forwarder, error) {
 	// TODO: better organize forwarder configuration
 	co := Forwarder{
 		fwdConfig: c,
-		TimeTresh: time.Second * 10,
+		TimeTresh: c.Client.FlushInterval,
 		// Writing events too quickly has a perf impact
-		EventTresh: 500,
+		EventTresh: c.Client.BatchSize,
+		ByteTresh:  c.Client.BatchBytes,
 		Pipe:       new(bytes.Buffer),
+		DetPipe:    new(bytes.Buffer),
 		stop:       make(chan bool),
 		done:       make(chan bool),
+		flush:      make(chan bool, 1),
+		stats:      BatchStats{Reasons: make(map[string]uint64)},
 		Local:      c.Local,
 	}
 
+	if c.Syslog.IsEnabled() {
+		if err = c.Syslog.Verify(); err != nil {
+			return nil, err
+		}
+		co.syslog = newSyslogWriter(c.Syslog)
+	}
+
+	if co.TimeTresh <= 0 {
+		co.TimeTresh = DefaultFlushInterval
+	}
+
+	if co.EventTresh == 0 {
+		co.EventTresh = DefaultBatchSize
+	}
+
 	if !co.Local {
 		if co.Client, err = NewManagerClient(&c.Client); err != nil {
 			return nil, fmt.Errorf("field to initialize manager client: %s", err)
@@ -122,12 +194,81 @@ func (f *Forwarder) ArchiveLogs() {
 }
 
 // PipeEvent pipes an event to be sent through the forwarder
-func (f *Forwarder) PipeEvent(event interface{}) {
+func (f *Forwarder) PipeEvent(e interface{}) {
 	f.Lock()
 	defer f.Unlock()
-	f.Pipe.Write(utils.Json(event))
-	f.Pipe.WriteByte('\n')
+	key := f.fwdConfig.Client.Key
+	if f.Client != nil {
+		// key might have been rotated
+		key = f.Client.Key()
+	}
+
+	pipe := f.Pipe
+	ee, ok := e.(*event.EdrEvent)
+	// detections are sent and queued apart from other events, shadow
+	// detections do not take precedence over events
+	if ok && ee.IsDetection() && !ee.IsShadow() {
+		pipe = f.DetPipe
+	}
+
+	if ok && ee.IsDetection() && !ee.IsShadow() && f.syslog != nil {
+		f.syslog.Send(ee)
+	}
+
+	if ok && f.fwdConfig.Sign && key != "" {
+		pipe.Write(ee.SignedJson(key))
+	} else {
+		pipe.Write(utils.Json(e))
+	}
+	pipe.WriteByte('\n')
 	f.EventsPiped++
+
+	// wake up forwarding routine as soon as batch is full
+	if f.flushReason() != "" {
+		select {
+		case f.flush <- true:
+		default:
+		}
+	}
+}
+
+// flushReason returns the batch limit reached by piped events if any,
+// it must be called with forwarder locked
+func (f *Forwarder) flushReason() string {
+	switch {
+	case f.EventsPiped >= f.EventTresh:
+		return FlushReasonCount
+	case f.ByteTresh > 0 && f.pipedBytes() >= f.ByteTresh:
+		return FlushReasonSize
+	}
+	return ""
+}
+
+// pipedBytes returns the size of piped events, it must be called with
+// forwarder locked
+func (f *Forwarder) pipedBytes() int {
+	return f.Pipe.Len() + f.DetPipe.Len()
+}
+
+// maxQueueSize returns the maximum disk space taken by queued events
+func (f *Forwarder) maxQueueSize() int64 {
+	if f.fwdConfig.Logging.MaxQueueSize > 0 {
+		return f.fwdConfig.Logging.MaxQueueSize
+	}
+	return DiskSpaceThreshold
+}
+
+// BatchStats returns statistics about the batches of events sent
+func (f *Forwarder) BatchStats() (s BatchStats) {
+	f.statsMutex.Lock()
+	defer f.statsMutex.Unlock()
+
+	s = f.stats
+	s.Reasons = make(map[string]uint64)
+	for r, c := range f.stats.Reasons {
+		s.Reasons[r] = c
+	}
+	return
 }
 
 // Save save the piped events to the disks
@@ -135,28 +276,75 @@ func (f *Forwarder) Save() (err error) {
 	log.Debugf("Collector saved logs to be sent later on")
 
 	// Clean queued files if needed
-	if f.DiskSpaceQueue() > DiskSpaceThreshold {
+	if max := f.maxQueueSize(); f.DiskSpaceQueue() > max {
 		log.Infof("Disk space taken by queued events reached %dMB threshold, need cleanup",
-			DiskSpaceThreshold/logfile.MB)
+			max/logfile.MB)
 		if err := f.CleanOlderQueued(); err != nil {
 			log.Errorf("Error attempting to remove older queue file: %s", err)
 		}
 	}
 
-	if f.logfile == nil {
+	if f.DetPipe.Len() > 0 {
+		if err = f.saveTo(&f.detLogfile, detectionsQueueBasename, f.pipeBatchID(f.DetPipe), f.DetPipe.Bytes()); err != nil {
+			return
+		}
+	}
+
+	if f.Pipe.Len() > 0 {
+		err = f.saveTo(&f.logfile, eventsQueueBasename, f.pipeBatchID(f.Pipe), f.Pipe.Bytes())
+	}
+
+	return
+}
+
+// pipeBatchID returns the identifier of the batch of events held by pipe,
+// it must be called with forwarder locked
+func (f *Forwarder) pipeBatchID(pipe *bytes.Buffer) string {
+	id := &f.batchID
+	if pipe == f.DetPipe {
+		id = &f.detBatchID
+	}
+	if *id == "" {
+		*id = newBatchID()
+	}
+	return *id
+}
+
+func (f *Forwarder) saveTo(lf *logfile.LogFile, basename, id string, data []byte) (err error) {
+	if *lf == nil {
 		// This will reopen the first available alerts.gz.X file if several
-		//lf := filepath.Join(f.fwdConfig.LogConf.Dir, "alerts.gz")
-		lf := filepath.Join(f.fwdConfig.Logging.Dir, "alerts.log")
+		path := filepath.Join(f.fwdConfig.Logging.Dir, basename)
 		ri := f.fwdConfig.Logging.RotationInterval
 		log.Infof("Rotating logfile every %s", ri)
-		if f.logfile, err = logfile.OpenTimeRotateLogFile(lf, utils.DefaultPerms, ri); err != nil {
+		if *lf, err = logfile.OpenTimeRotateLogFile(path, utils.DefaultPerms, ri); err != nil {
 			return
 		}
 	}
-	_, err = f.logfile.Write(f.Pipe.Bytes())
+	// batch identifier is written along with the events so that the
+	// batch is identified the same way when replayed
+	if _, err = (*lf).Write(append(batchHeader(id), data...)); err != nil {
+		return
+	}
 	return
 }
 
+func isDetectionsQueueFile(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), detectionsQueueBasename)
+}
+
+// isCurrentQueueFile returns true if path is one of the queue files currently written
+func (f *Forwarder) isCurrentQueueFile(path string) bool {
+	abs, _ := filepath.Abs(path)
+	for _, lf := range []logfile.LogFile{f.logfile, f.detLogfile} {
+		if lf != nil {
+			if absLogfile, _ := filepath.Abs(lf.Path()); absLogfile == abs {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // HasQueuedEvents checks whether some events are waiting to be sent
 func (f *Forwarder) HasQueuedEvents() bool {
 	for wi := range fswalker.Walk(f.fwdConfig.Logging.Dir) {
@@ -167,30 +355,40 @@ func (f *Forwarder) HasQueuedEvents() bool {
 	return false
 }
 
-// CleanOlderQueued cleans up the older queue file
+// CleanOlderQueued cleans up the older queue file. Queued detections are
+// deleted only if there are no other queued events.
 func (f *Forwarder) CleanOlderQueued() error {
-	var older string
-	var olderTime time.Time
+	var older, olderDet string
+	var olderTime, olderDetTime time.Time
 	empty := time.Time{}
 	for wi := range fswalker.Walk(f.fwdConfig.Logging.Dir) {
 		for _, fi := range wi.Files {
-			// initialization
-			if olderTime == empty {
-				olderTime = fi.ModTime()
-				older = filepath.Join(f.fwdConfig.Logging.Dir, fi.Name())
+			fp := filepath.Join(f.fwdConfig.Logging.Dir, fi.Name())
+			// prevent from deleting the current files we are working on
+			if f.isCurrentQueueFile(fp) {
+				continue
+			}
+			if isDetectionsQueueFile(fp) {
+				if olderDetTime == empty || fi.ModTime().Before(olderDetTime) {
+					olderDetTime = fi.ModTime()
+					olderDet = fp
+				}
 				continue
 			}
 			// check if we have an older file
-			if fi.ModTime().Before(olderTime) {
+			if olderTime == empty || fi.ModTime().Before(olderTime) {
 				olderTime = fi.ModTime()
-				older = filepath.Join(f.fwdConfig.Logging.Dir, fi.Name())
+				older = fp
 			}
 		}
 	}
-	absOlder, _ := filepath.Abs(older)
-	absLogfile, _ := filepath.Abs(f.LogfilePath())
-	// prevent from deleting the current file we are working on
-	if absLogfile != absOlder {
+
+	if older == "" {
+		older = olderDet
+	}
+
+	if older != "" {
+		absOlder, _ := filepath.Abs(older)
 		log.Infof("Attempt to delete older queue file to make more space: %s", absOlder)
 		return os.Remove(absOlder)
 	}
@@ -210,16 +408,46 @@ func (f *Forwarder) DiskSpaceQueue() int64 {
 
 // Here we rely on the fact that the layout of the directory is known
 // and should be alert.log, alert.log.1, alert.log.2.gz, alert.log.3.gz ...
-// if we append in reverse order, older files appears first in the list
+// if we append in reverse order, older files appears first in the list.
+// Queued detections are listed first so that they are replayed first.
 func (f *Forwarder) listLogfiles() (files []string) {
 	files = make([]string, 0)
+	events := make([]string, 0)
 	for wi := range fswalker.Walk(f.fwdConfig.Logging.Dir) {
 		for _, fi := range wi.Files {
 			fp := filepath.Join(f.fwdConfig.Logging.Dir, fi.Name())
-			files = append([]string{fp}, files...)
+			if isDetectionsQueueFile(fp) {
+				files = append([]string{fp}, files...)
+			} else {
+				events = append([]string{fp}, events...)
+			}
 		}
 	}
-	return
+	return append(files, events...)
+}
+
+// readQueueFile reads the content of a queue file, decompressing it if needed
+func readQueueFile(path string) (data []byte, err error) {
+	var fd *os.File
+	var r io.Reader
+
+	if fd, err = os.Open(path); err != nil {
+		return
+	}
+	defer fd.Close()
+
+	r = fd
+	if strings.HasSuffix(path, ".gz") {
+		var gzr *gzip.Reader
+
+		if gzr, err = gzip.NewReader(fd); err != nil {
+			return
+		}
+		defer gzr.Close()
+		r = gzr
+	}
+
+	return ioutil.ReadAll(r)
 }
 
 // ProcessQueue processes the events queued
@@ -233,20 +461,23 @@ func (f *Forwarder) ProcessQueue() {
 		return
 	}
 
-	// returns if Manager is not up, this prevents closing logfile
-	// for nothing
-	if !f.Client.IsServerUp() {
+	// returns if Manager is not up or asked us to slow down, this
+	// prevents closing logfile for nothing
+	if f.Client.IsBackpressured() || !f.Client.IsServerUp() {
 		return
 	}
 
 	log.Info("Processing queued files")
 
-	if f.logfile != nil {
-		f.logfile.Close()
+	for _, lf := range []logfile.LogFile{f.logfile, f.detLogfile} {
+		if lf != nil {
+			lf.Close()
+		}
 	}
 
-	// Reset logfile for latter Save function use
+	// Reset logfiles for latter Save function use
 	f.logfile = nil
+	f.detLogfile = nil
 	//for wi := range fswalker.Walk(f.fwdConfig.Logging.Dir) {
 	//for _, fi := range wi.Files {
 	for _, fp := range f.listLogfiles() {
@@ -254,34 +485,27 @@ func (f *Forwarder) ProcessQueue() {
 		//fp := filepath.Join(f.fwdConfig.Logging.Dir, fi.Name())
 		//log.Debug("Processing queued file: %s", fp)
 		log.Infof("Processing queued file: %s", fp)
-		fd, err := os.Open(fp)
+		data, err := readQueueFile(fp)
 		if err != nil {
-			log.Errorf("Failed to open queued file (%s): %s", fp, err)
+			log.Errorf("Failed to read queued file (%s): %s", fp, err)
 			continue
 		}
-		switch {
-		case strings.HasSuffix(fp, ".gz"):
-			var gzr *gzip.Reader
-			// the file is gzip so we have to pass a gzip reader to prepCollectReq
-			gzr, err = gzip.NewReader(fd)
-			if err != nil {
-				log.Errorf("Failed to create gzip reader for queued file (%s): %s", fp, err)
-				// close file
-				fd.Close()
-				continue
+
+		// batches are replayed with the identifier they were first sent
+		// with, the manager skipping those already collected
+		for _, b := range splitBatches(data) {
+			if err = f.Client.PostLogs(b.id, bytes.NewReader(b.events)); err != nil {
+				break
 			}
-			err = f.Client.PostLogs(gzr)
-			// we can close the reader since we don't need those anymore
-			gzr.Close()
-			fd.Close()
-		case strings.HasSuffix(fp, ".log.1"), strings.HasSuffix(fp, ".log"):
-			err = f.Client.PostLogs(fd)
-			fd.Close()
 		}
 
 		// We do not remove the logs if we failed to send
 		if err != nil {
 			log.Errorf("%s", err)
+			// manager cannot keep up, no need to try other files
+			if errors.Is(err, ErrBackpressure) {
+				return
+			}
 			continue
 		}
 
@@ -296,12 +520,20 @@ func (f *Forwarder) ProcessQueue() {
 // Reset resets the forwarder
 func (f *Forwarder) Reset() {
 	f.Pipe.Reset()
+	f.DetPipe.Reset()
 	f.EventsPiped = 0
+	f.batchID = ""
+	f.detBatchID = ""
 }
 
-// Collect sends the piped event to the remote server
+// Collect sends the piped event to the remote server, if events cannot be
+// sent they are saved to be sent later on and the error is returned
 // Todo: needs update with client
-func (f *Forwarder) Collect() {
+func (f *Forwarder) Collect() (err error) {
+	return f.collect(FlushReasonForced)
+}
+
+func (f *Forwarder) collect(reason string) (err error) {
 	// Locking collector for sending data
 	f.Lock()
 	// Unlocking collector after sending data
@@ -309,9 +541,24 @@ func (f *Forwarder) Collect() {
 	// Reset the collector
 	defer f.Reset()
 
-	// if not a local forwarder
-	if !f.Local {
-		err := f.Client.PostLogs(bytes.NewBuffer(f.Pipe.Bytes()))
+	f.statsMutex.Lock()
+	f.stats.update(f.EventsPiped, f.pipedBytes(), reason)
+	f.statsMutex.Unlock()
+
+	// if not a local forwarder and manager did not ask us to slow down
+	if !f.Local && !f.Client.IsBackpressured() {
+		// detections are sent first, in their own batch as they are
+		// queued apart from other events
+		for _, pipe := range []*bytes.Buffer{f.DetPipe, f.Pipe} {
+			if pipe.Len() == 0 {
+				continue
+			}
+			if err = f.Client.PostLogs(f.pipeBatchID(pipe), bytes.NewReader(pipe.Bytes())); err != nil {
+				break
+			}
+			// not to queue a batch already sent
+			pipe.Reset()
+		}
 
 		if err != nil {
 			log.Errorf("%s", err)
@@ -321,42 +568,97 @@ func (f *Forwarder) Collect() {
 			}
 		}
 	} else {
+		if !f.Local {
+			err = ErrBackpressure
+		}
 		// Save the events in queue directory
 		if err := f.Save(); err != nil {
 			log.Errorf("Failed to save events: %s", err)
 		}
 	}
+	return
+}
+
+// FlushStats reports what has been sent by a forwarder flush
+type FlushStats struct {
+	Events      uint64 `json:"events"`
+	QueuedFiles int    `json:"queued-files"`
+	Artifacts   int    `json:"artifacts"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Flush immediately sends queued files and piped events to the manager
+// instead of waiting for the next forwarding schedule
+func (f *Forwarder) Flush() (s FlushStats) {
+	if f.Local {
+		s.Error = "local forwarder, nothing to flush"
+		return
+	}
+
+	if f.HasQueuedEvents() {
+		before := len(f.listLogfiles())
+		f.ProcessQueue()
+		s.QueuedFiles = before - len(f.listLogfiles())
+	}
+
+	f.Lock()
+	piped := f.EventsPiped
+	f.Unlock()
+
+	if piped > 0 {
+		if err := f.Collect(); err != nil {
+			s.Error = err.Error()
+		} else {
+			s.Events = piped
+		}
+	}
+
+	return
 }
 
 // Run starts the Forwarder worker function
 func (f *Forwarder) Run() {
+	if f.syslog != nil {
+		f.syslog.Run()
+	}
+
 	// Process Piped Events
 	go func() {
 		// defer signal that we are done
 		defer func() { f.done <- true }()
 		timer := time.Now()
 		for {
-			select {
-			case <-f.stop:
-				return
-			default:
-			}
 			// We have queued events so we try to send them before sending pending events
 			// We check if server is up not to close the current logfile if not needed
 			if f.HasQueuedEvents() {
 				f.ProcessQueue()
 			}
 
+			f.Lock()
+			piped := f.EventsPiped
+			reason := f.flushReason()
+			f.Unlock()
+
+			if reason == "" && (time.Now().After(timer.Add(f.TimeTresh)) || f.Local) {
+				reason = FlushReasonInterval
+			}
+
 			// Sending piped events
-			if f.EventsPiped >= f.EventTresh || time.Now().After(timer.Add(f.TimeTresh)) || f.Local {
+			if reason != "" {
 				// Send out events if there are pending events
-				if f.EventsPiped > 0 {
-					f.Collect()
+				if piped > 0 {
+					f.collect(reason)
 				}
 				// reset timer
 				timer = time.Now()
 			}
-			time.Sleep(time.Second)
+
+			select {
+			case <-f.stop:
+				return
+			case <-f.flush:
+			case <-time.After(time.Second):
+			}
 		}
 	}()
 }
@@ -376,4 +678,10 @@ func (f *Forwarder) Close() {
 	if f.logfile != nil {
 		f.logfile.Close()
 	}
+	if f.detLogfile != nil {
+		f.detLogfile.Close()
+	}
+	if f.syslog != nil {
+		f.syslog.Close()
+	}
 }
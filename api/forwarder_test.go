@@ -1,6 +1,7 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
@@ -8,6 +9,9 @@ import (
 	"io/ioutil"
 	"math"
 	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,6 +19,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/golang-utils/datastructs"
+	"github.com/0xrawsec/golang-utils/fileutils"
 	"github.com/0xrawsec/golang-utils/log"
 	"github.com/0xrawsec/golang-utils/readers"
 	"github.com/0xrawsec/golang-utils/scanner"
@@ -306,13 +313,21 @@ func TestForwarderParallel(t *testing.T) {
 	clean(&mconf, &fconf)
 	defer clean(&mconf, &fconf)
 
-	jobs := semaphore.New(100)
+	parallel := 100
+	jobs := semaphore.New(uint64(parallel))
 	nclients, nevents := 1000, 1000
 	wg := sync.WaitGroup{}
 	testfile := "TestCollectorParallel.log.gz"
 	key := KeyGen(DefaultKeySize)
 	mconf.Logging.LogBasename = testfile
 
+	// clients collecting concurrently must not be asked to retry later
+	mconfBak := mconf
+	mconf.EndpointAPI.IngestionQueue = parallel
+	defer func() {
+		mconf = mconfBak
+	}()
+
 	r, err := NewManager(&mconf)
 	if err != nil {
 		panic(err)
@@ -472,3 +487,293 @@ func TestForwarderCleanup(t *testing.T) {
 	}
 
 }
+
+func TestForwarderSyslog(t *testing.T) {
+	clean(&mconf, &fconf)
+	defer clean(&mconf, &fconf)
+
+	ndet := 10
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fc := fconf
+	fc.Local = true
+	fc.Syslog = &SyslogConfig{
+		Enable:    true,
+		Format:    SyslogFormatCEF,
+		Transport: SyslogTransportUDP,
+		Host:      "127.0.0.1",
+		Port:      conn.LocalAddr().(*net.UDPAddr).Port,
+	}
+
+	f, err := NewForwarder(&fc)
+	if err != nil {
+		t.Fatalf("Failed to create forwarder: %s", err)
+	}
+	f.Run()
+
+	for e := range emitMixedEvents(ndet, ndet) {
+		f.PipeEvent(e)
+	}
+	f.Close()
+
+	buf := make([]byte, 65536)
+	for i := 0; i < ndet; i++ {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Only %d detections received out of %d: %s", i, ndet, err)
+		}
+		if msg := string(buf[:n]); !strings.Contains(msg, " - CEF:0|0xrawsec|whids|") {
+			t.Errorf("Unexpected syslog message: %s", msg)
+		}
+	}
+}
+
+func syslogDetection(image string) *event.EdrEvent {
+	e := event.EdrEvent{}
+	raw := fmt.Sprintf(`{"Event":{"EventData":{"Image":%q},"System":{"Channel":"Microsoft-Windows-Sysmon/Operational","EventID":1,"Computer":"HOST"}}}`, image)
+	if err := json.Unmarshal([]byte(raw), &e); err != nil {
+		panic(err)
+	}
+	e.Event.System.TimeCreated.SystemTime = time.Date(2022, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	e.SetDetection(&engine.Detection{Signature: datastructs.NewInitSet("Rule"), Criticality: 9})
+	return &e
+}
+
+func TestSyslogFormatRFC5424(t *testing.T) {
+	kern := 0
+	c := &SyslogConfig{
+		Enable:    true,
+		Format:    SyslogFormatRFC5424,
+		Transport: SyslogTransportUDP,
+		Host:      "127.0.0.1",
+		Port:      514,
+		Facility:  &kern,
+		Fields:    []SyslogField{{Name: "sproc", Path: "/Event/EventData/Image"}},
+	}
+
+	if err := c.Verify(); err != nil {
+		t.Fatalf("Kern facility must be valid: %s", err)
+	}
+
+	msg := string(newSyslogWriter(c).format(syslogDetection(`C:\a"b]c.exe`)))
+
+	// kern facility and critical severity, timestamp in microseconds
+	if prefix := "<2>1 2022-01-02T03:04:05.123456Z HOST whids - detection "; !strings.HasPrefix(msg, prefix) {
+		t.Errorf("Unexpected syslog header: %s", msg)
+	}
+
+	// SD-PARAM values must have \, " and ] escaped
+	if sd := `[whids@32473 rules="Rule" criticality="9" sproc="C:\\a\"b\]c.exe"]`; !strings.Contains(msg, sd) {
+		t.Errorf("Unexpected syslog structured data: %s", msg)
+	}
+
+	// default facility is local0
+	c.Facility = nil
+	if msg := string(newSyslogWriter(c).format(syslogDetection("cmd.exe"))); !strings.HasPrefix(msg, "<130>1 ") {
+		t.Errorf("Unexpected syslog priority: %s", msg)
+	}
+}
+
+// readOctetCounted reads a message framed with octet counting
+func readOctetCounted(r *bufio.Reader) (msg string, err error) {
+	var n int
+
+	if _, err = fmt.Fscanf(r, "%d ", &n); err != nil {
+		return
+	}
+
+	b := make([]byte, n)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return
+	}
+
+	return string(b), nil
+}
+
+func TestSyslogTCPReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	w := newSyslogWriter(&SyslogConfig{
+		Enable:    true,
+		Format:    SyslogFormatRFC5424,
+		Transport: SyslogTransportTCP,
+		Host:      "127.0.0.1",
+		Port:      l.Addr().(*net.TCPAddr).Port,
+	})
+	// writer never run must not block when closed
+	defer w.Close()
+
+	msg := w.format(syslogDetection("cmd.exe"))
+
+	accept := func() (net.Conn, *bufio.Reader) {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return conn, bufio.NewReader(conn)
+	}
+
+	if err := w.write(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, r := accept()
+	// two messages must be read back from the stream
+	if err := w.write(msg); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if got, err := readOctetCounted(r); err != nil || got != string(msg) {
+			t.Fatalf("Unexpected framed message %q: %v", got, err)
+		}
+	}
+
+	// destination going away, writes end up failing
+	conn.Close()
+	for i := 0; w.conn != nil; i++ {
+		if i == 100 {
+			t.Fatal("Write to a closed destination never failed")
+		}
+		w.write(msg)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// next write reconnects
+	if err := w.write(msg); err != nil {
+		t.Fatal(err)
+	}
+	conn, r = accept()
+	defer conn.Close()
+	if got, err := readOctetCounted(r); err != nil || got != string(msg) {
+		t.Errorf("Unexpected framed message after reconnection %q: %v", got, err)
+	}
+}
+
+func TestForwarderQueuedBatchIDs(t *testing.T) {
+	clean(&mconf, &fconf)
+	defer clean(&mconf, &fconf)
+
+	fc := fconf
+	fc.Local = true
+	f, err := NewForwarder(&fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two batches saved to the same queue file, as when the manager is down
+	ids := make([]string, 0)
+	for i := 0; i < 2; i++ {
+		for e := range emitEvents(10, false) {
+			f.PipeEvent(e)
+		}
+		if err := f.Save(); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, f.batchID)
+		f.Reset()
+	}
+	path := f.LogfilePath()
+	f.logfile.Close()
+
+	// queue file rotated and compressed
+	if err := fileutils.GzipFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := readQueueFile(path + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batches := splitBatches(data)
+	if len(batches) != len(ids) {
+		t.Fatalf("Expecting %d batches, got %d", len(ids), len(batches))
+	}
+	for i, b := range batches {
+		if b.id != ids[i] {
+			t.Errorf("Batch identifier not kept: %s instead of %s", b.id, ids[i])
+		}
+		if n := bytes.Count(b.events, []byte("\n")); n != 10 {
+			t.Errorf("Expecting 10 events in batch, got %d", n)
+		}
+	}
+
+	// events queued without batch header are replayed without identifier
+	legacy := splitBatches([]byte("{}\n{}\n"))
+	if len(legacy) != 1 || legacy[0].id != "" {
+		t.Errorf("Unexpected batches for events queued without header: %v", legacy)
+	}
+}
+
+type failingReader struct {
+	r io.Reader
+}
+
+func (f *failingReader) Read(p []byte) (n int, err error) {
+	if n, err = f.r.Read(p); err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+func TestManagerBatchDedup(t *testing.T) {
+	clean(&mconf, &fconf)
+	defer clean(&mconf, &fconf)
+
+	r, err := NewManager(&mconf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Shutdown()
+	r.batches.SetWindow(time.Hour)
+
+	body := new(bytes.Buffer)
+	for e := range emitEvents(10, false) {
+		body.Write(utils.Json(e))
+		body.WriteByte('\n')
+	}
+
+	collect := func(body io.Reader) int {
+		rq := httptest.NewRequest("POST", EptAPIPostLogsPath, body)
+		rq.Header.Set(EndpointUUIDHeader, cconf.UUID)
+		rq.Header.Set(EventsBatchIDHeader, "batch")
+		wt := httptest.NewRecorder()
+		r.eptAPICollect(wt, rq)
+		return wt.Code
+	}
+
+	// batch failing to be read must be replayed
+	if code := collect(&failingReader{bytes.NewReader(body.Bytes())}); code != http.StatusInternalServerError {
+		t.Errorf("Unexpected status for a batch failing to be read: %d", code)
+	}
+	if r.batches.Collected(cconf.UUID, "batch") {
+		t.Errorf("Batch failing to be read must not be marked as collected")
+	}
+
+	if code := collect(bytes.NewReader(body.Bytes())); code != http.StatusOK {
+		t.Errorf("Unexpected status for a valid batch: %d", code)
+	}
+	if !r.batches.Collected(cconf.UUID, "batch") {
+		t.Errorf("Batch ingested must be marked as collected")
+	}
+
+	n := countEvents(r.eventSearcher)
+	// replayed batch is skipped
+	if code := collect(bytes.NewReader(body.Bytes())); code != http.StatusOK {
+		t.Errorf("Unexpected status for a replayed batch: %d", code)
+	}
+	if m := countEvents(r.eventSearcher); m != n {
+		t.Errorf("Replayed batch ingested twice: %d events instead of %d", m, n)
+	}
+}
@@ -7,4 +7,12 @@ const (
 	EndpointUUIDHeader     = "X-Endpoint-Uuid"
 	EndpointIPHeader       = "X-Endpoint-IP"
 	EndpointHostnameHeader = "X-Endpoint-Hostname"
+	// actions suppression state
+	EndpointActionsSuppressedHeader      = "X-Endpoint-Actions-Suppressed"
+	EndpointActionsSuppressedUntilHeader = "X-Endpoint-Actions-Suppressed-Until"
+	// set by the manager when endpoint authenticated with a rotated key
+	EndpointKeyRotatedHeader = "X-Endpoint-Key-Rotated"
+
+	// identifier of a batch of events, used by the manager to detect replays
+	EventsBatchIDHeader = "X-Events-Batch-Id"
 )
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"sync/atomic"
+)
+
+const (
+	// DefaultIngestionWorkers default number of log collection requests processed concurrently
+	DefaultIngestionWorkers = 8
+	// DefaultIngestionQueue default number of log collection requests allowed to wait for a worker
+	DefaultIngestionQueue = 64
+	// DefaultBackpressureRetryAfter delay (in seconds) sent to endpoints, via
+	// Retry-After header, when the manager cannot keep up with ingestion
+	DefaultBackpressureRetryAfter = 30
+)
+
+// IngestionStats holds events ingestion statistics
+type IngestionStats struct {
+	Workers  int    `json:"workers"`
+	Active   int64  `json:"active"`
+	Queued   int64  `json:"queued"`
+	MaxQueue int    `json:"max-queue"`
+	Rejected uint64 `json:"rejected"`
+}
+
+// ingestionLimiter bounds the number of log collection requests processed
+// concurrently and the number of requests waiting to be processed. Requests
+// above those limits are rejected so that endpoints back off instead of
+// having the manager buffering an unbounded amount of data.
+type ingestionLimiter struct {
+	workers  chan struct{}
+	maxQueue int64
+	active   int64
+	queued   int64
+	rejected uint64
+}
+
+func newIngestionLimiter(workers, queue int) *ingestionLimiter {
+	if workers <= 0 {
+		workers = DefaultIngestionWorkers
+	}
+	if queue <= 0 {
+		queue = DefaultIngestionQueue
+	}
+	return &ingestionLimiter{
+		workers:  make(chan struct{}, workers),
+		maxQueue: int64(queue),
+	}
+}
+
+// Acquire waits for a worker to be available. It returns false, without
+// waiting, if the intake queue is full.
+func (l *ingestionLimiter) Acquire() bool {
+	// fast path a worker is available
+	select {
+	case l.workers <- struct{}{}:
+		atomic.AddInt64(&l.active, 1)
+		return true
+	default:
+	}
+
+	if atomic.AddInt64(&l.queued, 1) > l.maxQueue {
+		atomic.AddInt64(&l.queued, -1)
+		atomic.AddUint64(&l.rejected, 1)
+		return false
+	}
+
+	l.workers <- struct{}{}
+	atomic.AddInt64(&l.queued, -1)
+	atomic.AddInt64(&l.active, 1)
+	return true
+}
+
+// Release releases a worker previously acquired
+func (l *ingestionLimiter) Release() {
+	atomic.AddInt64(&l.active, -1)
+	<-l.workers
+}
+
+// Stats returns ingestion statistics
+func (l *ingestionLimiter) Stats() IngestionStats {
+	return IngestionStats{
+		Workers:  cap(l.workers),
+		Active:   atomic.LoadInt64(&l.active),
+		Queued:   atomic.LoadInt64(&l.queued),
+		MaxQueue: int(l.maxQueue),
+		Rejected: atomic.LoadUint64(&l.rejected),
+	}
+}
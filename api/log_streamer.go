@@ -1,7 +1,11 @@
 package api
 
 import (
+	"fmt"
 	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -9,9 +13,88 @@ import (
 	"github.com/0xrawsec/whids/event"
 )
 
+// StreamFilter filters events sent to a LogStream, an empty filter
+// matches any event
+type StreamFilter struct {
+	Endpoints      []string
+	Rules          []string
+	MinCriticality int
+}
+
+func splitFilterValues(value string) (values []string) {
+	values = make([]string, 0)
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return
+}
+
+// ParseStreamFilter parses a StreamFilter from URL query parameters
+func ParseStreamFilter(query url.Values) (f *StreamFilter, err error) {
+	f = &StreamFilter{
+		Endpoints: splitFilterValues(query.Get(qpEndpointUuid)),
+		Rules:     splitFilterValues(query.Get(qpRule)),
+	}
+
+	if s := query.Get(qpMinCriticality); s != "" {
+		if f.MinCriticality, err = strconv.Atoi(s); err != nil || f.MinCriticality < 0 || f.MinCriticality > MaxRuleCriticality {
+			return nil, fmt.Errorf("%s parameter must be an integer in [0; %d]", qpMinCriticality, MaxRuleCriticality)
+		}
+	}
+
+	return
+}
+
+// Match returns true if the event passes the filter
+func (f *StreamFilter) Match(e *event.EdrEvent) bool {
+	if f == nil {
+		return true
+	}
+
+	if len(f.Endpoints) > 0 {
+		if e.Event.EdrData == nil || !containsString(f.Endpoints, e.Event.EdrData.Endpoint.UUID) {
+			return false
+		}
+	}
+
+	if len(f.Rules) > 0 || f.MinCriticality > 0 {
+		det := e.GetDetection()
+		if det == nil {
+			return false
+		}
+
+		if det.Criticality < f.MinCriticality {
+			return false
+		}
+
+		if len(f.Rules) > 0 {
+			for _, r := range f.Rules {
+				if det.Signature.Contains(r) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsString(s []string, value string) bool {
+	for _, v := range s {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 type LogStream struct {
 	closed bool
 	queue  datastructs.Fifo
+	filter *StreamFilter
 	S      chan *event.EdrEvent
 }
 
@@ -19,7 +102,10 @@ func (s *LogStream) Queue(e *event.EdrEvent) bool {
 	if s.closed {
 		return false
 	}
-	s.queue.Push(e)
+	// events not matching the filter are not even queued
+	if s.filter.Match(e) {
+		s.queue.Push(e)
+	}
 	return true
 }
 
@@ -53,9 +139,14 @@ func NewEventStreamer() *EventStreamer {
 }
 
 func (s *EventStreamer) NewStream() *LogStream {
+	return s.NewFilteredStream(nil)
+}
+
+// NewFilteredStream creates a new stream receiving only events matching f
+func (s *EventStreamer) NewFilteredStream(f *StreamFilter) *LogStream {
 	s.Lock()
 	defer s.Unlock()
-	ls := &LogStream{S: make(chan *event.EdrEvent), queue: datastructs.Fifo{}}
+	ls := &LogStream{S: make(chan *event.EdrEvent), queue: datastructs.Fifo{}, filter: f}
 	s.streams[s.newId()] = ls
 	return ls
 }
@@ -47,12 +47,16 @@ const (
 	DefaultManagerLogSize = utils.Mega * 100
 	// DefaultKeySize default size for API key generation
 	DefaultKeySize = 64
+	// DefaultKeyRotationOverlap default period the previous key of an endpoint is accepted after rotation
+	DefaultKeyRotationOverlap = 24 * time.Hour
 	// EptAPIDefaultPort default port used by manager's endpoint API
 	EptAPIDefaultPort = 1519
 	// AdmAPIDefaultPort default port used by manager's admin API
 	AdmAPIDefaultPort = 1520
 	// DefaultMaxUploadSize default maximum upload size
 	DefaultMaxUploadSize = 100 * utils.Mega
+	// MaxCollectedEventSize maximum size of a single event sent by an endpoint
+	MaxCollectedEventSize = 16 * utils.Mega
 	// IoCContainerName default container name to store manager's IoCs
 	IoCContainerName = "edr_iocs"
 )
@@ -104,30 +108,6 @@ func gunzipMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-//////////////////// TLSConfig
-
-// TLSConfig structure definition
-type TLSConfig struct {
-	Cert string `toml:"cert" comment:"Path to the certificate file to use for TLS connections"`
-	Key  string `toml:"key" comment:"Path to the key to use for TLS connection"`
-}
-
-// Empty returns true if current TLSConfig is empty else false
-func (t *TLSConfig) Empty() bool {
-	return *t == TLSConfig{}
-}
-
-// Verify checks whether the files holding cert and key exist
-func (t *TLSConfig) Verify() error {
-	switch {
-	case !fsutil.IsFile(t.Cert):
-		return fmt.Errorf("certificate file (%s) not found", t.Cert)
-	case !fsutil.IsFile(t.Key):
-		return fmt.Errorf("key file (%s) not found", t.Key)
-	}
-	return nil
-}
-
 /////////////////////// Manager
 
 // UUIDGen generates a random UUID
@@ -164,6 +144,15 @@ type EndpointAPIConfig struct {
 	Host      string `toml:"host" comment:"Hostname or IP where the API should listen to"`
 	Port      int    `toml:"port" comment:"Port used by the API"`
 	ServerKey string `toml:"server-key" comment:"Server key used to do basic authentication of the server on clients.\n Configure certificate pinning on client offers better security."`
+	// events signature settings
+	RejectUnsigned bool `toml:"reject-unsigned-events" comment:"Reject events not signed by endpoints"`
+	RejectInvalid  bool `toml:"reject-invalid-signatures" comment:"Reject events failing signature verification,\n when disabled such events are kept and flagged"`
+	// events ingestion settings
+	IngestionWorkers int `toml:"ingestion-workers" comment:"Number of log collection requests processed concurrently (0: default)"`
+	IngestionQueue   int `toml:"ingestion-queue" comment:"Number of log collection requests allowed to wait for a worker,\n above endpoints are asked to retry later (HTTP 503) (0: default)"`
+	// endpoint keys settings
+	KeyRotationOverlap time.Duration `toml:"key-rotation-overlap" comment:"Period during which the previous key of an endpoint is still accepted\n after its key has been regenerated (0: previous key is revoked immediately)"`
+	BatchDedupWindow   time.Duration `toml:"batch-dedup-window" comment:"Period during which batches of events collected are remembered not to\n ingest twice batches replayed by endpoints (0: disabled)"`
 }
 
 // ManagerLogConfig structure to hold manager's logging configuration
@@ -176,13 +165,16 @@ type ManagerLogConfig struct {
 // ManagerConfig defines manager's configuration structure
 type ManagerConfig struct {
 	// TOML strings need to be first otherwise issue parsing back config
-	Database    string            `toml:"db" comment:"Path to store database"`
-	DumpDir     string            `toml:"dump-dir" comment:"Directory where to dump artifacts collected on hosts"`
-	AdminAPI    AdminAPIConfig    `toml:"admin-api" comment:"Settings to configure administrative API (not supposed to be reachable by endpoints)"`
-	EndpointAPI EndpointAPIConfig `toml:"endpoint-api" comment:"Settings to configure API used by endpoints"`
-	Logging     ManagerLogConfig  `toml:"logging" comment:"Logging settings"`
-	TLS         TLSConfig         `toml:"tls" comment:"TLS settings. Leave empty, not to use TLS"`
-	path        string
+	Database     string            `toml:"db" comment:"Path to store database"`
+	DumpDir      string            `toml:"dump-dir" comment:"Directory where to dump artifacts collected on hosts"`
+	RuleChannels []string          `toml:"rule-channels" comment:"Channels rules can apply to, on top of the built-in ones.\n Rules applying to any other channel are rejected"`
+	AdminAPI     AdminAPIConfig    `toml:"admin-api" comment:"Settings to configure administrative API (not supposed to be reachable by endpoints)"`
+	EndpointAPI  EndpointAPIConfig `toml:"endpoint-api" comment:"Settings to configure API used by endpoints"`
+	Logging      ManagerLogConfig  `toml:"logging" comment:"Logging settings"`
+	TLS          TLSConfig         `toml:"tls" comment:"TLS settings. Leave empty, not to use TLS"`
+	Retention    RetentionConfig   `toml:"retention" comment:"Retention of events, detections and archived reports"`
+	AutoAssign   []AutoAssignRule  `toml:"auto-assign" comment:"Rules assigning a group and labels to endpoints, the first rule matching applies"`
+	path         string
 }
 
 // LoadManagerConfig loads the manager configuration from a file
@@ -246,6 +238,8 @@ type Manager struct {
 	adminAPI *http.Server
 	stop     chan bool
 	done     bool
+	// serializes updates of admin API users
+	usersLock sync.Mutex
 
 	// Gene related members
 	gene struct {
@@ -253,9 +247,26 @@ type Manager struct {
 		reducer *reducer.Reducer
 		rules   string // to cache the rules concatenated
 		sha256  string // rules integrity check and update
-	}
-
-	iocs *ioc.IoCs
+		// shadow rule set deployed along with the active one
+		shadow       *engine.Engine
+		shadowRules  string
+		shadowSha256 string
+		// suppressions are served apart from the rules so that they
+		// never filter events on endpoints
+		suppressions       *engine.Engine
+		suppressionsRules  string
+		suppressionsSha256 string
+	}
+
+	iocs       *ioc.IoCs
+	ingestion  *ingestionLimiter
+	batches    *collectedBatches
+	autoAssign *autoAssigner
+	// channels rules are allowed to apply to
+	ruleChannels RuleChannels
+	storage      storageUsage
+	// protects settings changed at runtime
+	runtime sync.RWMutex
 
 	/* Public */
 	Config *ManagerConfig
@@ -265,7 +276,7 @@ type Manager struct {
 func NewManager(c *ManagerConfig) (*Manager, error) {
 	var err error
 
-	m := Manager{Config: c, iocs: ioc.NewIocs()}
+	m := Manager{Config: c, iocs: ioc.NewIocs(), ruleChannels: NewRuleChannels(c.RuleChannels...)}
 	//logPath := filepath.Join(c.Logging.Root, c.Logging.LogBasename)
 	eventDir := filepath.Join(c.Logging.Root, "events")
 	m.eventLogger = logger.NewEventLogger(eventDir, c.Logging.LogBasename, utils.Giga)
@@ -280,6 +291,10 @@ func NewManager(c *ManagerConfig) (*Manager, error) {
 	// Create a new streamer
 	m.eventStreamer = NewEventStreamer()
 
+	// events ingestion limits
+	m.ingestion = newIngestionLimiter(c.EndpointAPI.IngestionWorkers, c.EndpointAPI.IngestionQueue)
+	m.batches = newCollectedBatches(c.EndpointAPI.BatchDedupWindow)
+
 	if c.EndpointAPI.Port <= 0 || c.EndpointAPI.Port > 65535 {
 		return nil, fmt.Errorf("manager Endpoint API Error: invalid port to listen to %d", c.EndpointAPI.Port)
 	}
@@ -288,6 +303,14 @@ func NewManager(c *ManagerConfig) (*Manager, error) {
 		return nil, fmt.Errorf("manager Admin API Error: invalid port to listen to %d", c.EndpointAPI.Port)
 	}
 
+	if m.autoAssign, err = newAutoAssigner(c.AutoAssign); err != nil {
+		return nil, fmt.Errorf("manager auto-assign rules error: %w", err)
+	}
+
+	if err = c.Retention.Verify(); err != nil {
+		return nil, fmt.Errorf("manager retention error: %w", err)
+	}
+
 	if err := os.MkdirAll(c.Logging.Root, utils.DefaultPerms); err != nil {
 		return nil, fmt.Errorf("failed at creating log directory: %s", err)
 	}
@@ -303,6 +326,15 @@ func NewManager(c *ManagerConfig) (*Manager, error) {
 		return nil, err
 	}
 
+	if c.AdminAPI.MutualTLS.Enabled() {
+		if c.TLS.Empty() {
+			return nil, fmt.Errorf("manager Admin API Error: mutual TLS requires TLS to be configured")
+		}
+		if !fsutil.IsFile(c.AdminAPI.MutualTLS.ClientCA) {
+			return nil, fmt.Errorf("manager Admin API Error: client CA file (%s) not found", c.AdminAPI.MutualTLS.ClientCA)
+		}
+	}
+
 	// Gene components initialization
 	if err := m.initializeGeneFromDB(); err != nil {
 		return &m, fmt.Errorf("manager cannot initialize gene components: %s", err)
@@ -361,6 +393,26 @@ func (m *Manager) initializeDB() (err error) {
 		return
 	}
 
+	// shadow rules must not share the objects index of the active rules
+	shadowSchema := rulesSchema
+	shadowSchema.ObjectsIndex = sod.NewIndex(rulesDesc...)
+	if err = m.db.Create(&EdrShadowRule{}, shadowSchema); err != nil {
+		return
+	}
+
+	containersSchema := sod.DefaultSchema
+	containersDesc := []sod.FieldDescriptor{
+		{Name: "Name", Index: true, Constraint: sod.Constraints{Unique: true}},
+	}
+	containersSchema.ObjectsIndex = sod.NewIndex(containersDesc...)
+	if err = m.db.Create(&EdrContainer{}, containersSchema); err != nil {
+		return
+	}
+
+	if err = m.db.Create(&DetectionSuppression{}, sod.DefaultSchema); err != nil {
+		return
+	}
+
 	return
 }
 
@@ -370,6 +422,18 @@ func (m *Manager) initializeGeneFromDB() error {
 
 	reducer := reducer.NewReducer(engine)
 
+	// containers must be loaded before the rules
+	if objs, err := m.db.All(&EdrContainer{}); err != nil {
+		return err
+	} else {
+		for _, o := range objs {
+			cont := o.(*EdrContainer)
+			for _, e := range cont.Entries {
+				engine.AddToContainer(cont.Name, e)
+			}
+		}
+	}
+
 	if objs, err := m.db.All(&EdrRule{}); err != nil {
 		return err
 	} else {
@@ -381,25 +445,43 @@ func (m *Manager) initializeGeneFromDB() error {
 		}
 	}
 
+	shadow, err := m.shadowEngineFromDB()
+	if err != nil {
+		return err
+	}
+
+	suppressions, err := m.suppressionEngineFromDB()
+	if err != nil {
+		return err
+	}
+
 	// we update gene components only if no error is met
 	m.gene.engine = engine
 	m.gene.reducer = reducer
+	m.gene.shadow = shadow
+	m.gene.suppressions = suppressions
 	m.updateRulesCache()
 
 	return nil
 
 }
 
-func (m *Manager) updateRulesCache() {
+// rawRules returns the rules of an engine concatenated and their sha256
+func rawRules(e *engine.Engine) (rules, sum string) {
 	sha256 := sha256.New()
 	buf := new(bytes.Buffer)
-	for rr := range m.gene.engine.GetRawRule(".*") {
+	for rr := range e.GetRawRule(".*") {
 		chunk := []byte(rr + "\n")
 		buf.Write(chunk)
 		sha256.Write(chunk)
 	}
-	m.gene.rules = buf.String()
-	m.gene.sha256 = hex.EncodeToString(sha256.Sum(nil))
+	return buf.String(), hex.EncodeToString(sha256.Sum(nil))
+}
+
+func (m *Manager) updateRulesCache() {
+	m.gene.rules, m.gene.sha256 = rawRules(m.gene.engine)
+	m.gene.shadowRules, m.gene.shadowSha256 = rawRules(m.gene.shadow)
+	m.gene.suppressionsRules, m.gene.suppressionsSha256 = rawRules(m.gene.suppressions)
 }
 
 // AddCommand sets a command to be executed on endpoint specified by UUID
@@ -479,6 +561,17 @@ func (m *Manager) ImportRules(directory string) (err error) {
 
 // CreateNewAdminAPIUser creates a new user in the user able to access admin API in database.
 func (m *Manager) CreateNewAdminAPIUser(user *AdminAPIUser) (err error) {
+	if err = user.Validate(); err != nil {
+		return
+	}
+
+	if user.Role == "" {
+		user.Role = RoleAdmin
+	}
+
+	user.Created = time.Now()
+	user.LastLogin = time.Time{}
+
 	if err = m.db.InsertOrUpdate(user); err != nil && !sod.IsUnique(err) {
 		return err
 	} else if sod.IsUnique(err) {
@@ -566,4 +659,5 @@ func (m *Manager) Shutdown() (lastErr error) {
 func (m *Manager) Run() {
 	m.runEndpointAPI()
 	m.runAdminAPI()
+	m.runRetention()
 }
@@ -1,14 +1,18 @@
 package api
 
 import (
-	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"io/ioutil"
+	"math"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -18,9 +22,10 @@ import (
 	"github.com/0xrawsec/gene/v2/engine"
 	"github.com/0xrawsec/gene/v2/reducer"
 	"github.com/0xrawsec/sod"
-	"github.com/0xrawsec/whids/event"
 	"github.com/0xrawsec/whids/ioc"
+	"github.com/0xrawsec/whids/logger"
 	"github.com/0xrawsec/whids/sysmon"
+	"github.com/0xrawsec/whids/utils"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 
@@ -29,6 +34,10 @@ import (
 
 const (
 	MaxLimitLogAPI = 10000
+	// DefaultArchiveLimit number of archived reports returned when no limit is specified
+	DefaultArchiveLimit = 1000
+	// DefaultArchiveMaxLimit default maximum number of archived reports returned by a query
+	DefaultArchiveMaxLimit = 10000
 )
 
 func admApiParseDuration(pLast string) (d time.Duration, err error) {
@@ -53,8 +62,55 @@ func admApiParseTime(stimestamp string) (t time.Time, err error) {
 
 // AdminAPIConfig configuration for Administrative API
 type AdminAPIConfig struct {
-	Host string `toml:"host" comment:"Hostname or IP address where the API should listen to"`
-	Port int    `toml:"port" comment:"Port used by the API"`
+	Host            string          `toml:"host" comment:"Hostname or IP address where the API should listen to"`
+	Port            int             `toml:"port" comment:"Port used by the API"`
+	ArchiveMaxLimit uint64          `toml:"archive-max-limit" comment:"Maximum number of archived reports returned by a single query\n (default: 10000)"`
+	MutualTLS       MutualTLSConfig `toml:"mutual-tls" comment:"Client certificate authentication (requires TLS to be configured)"`
+	// event streams settings
+	StreamCompression bool `toml:"stream-compression" comment:"Compress events streamed over WebSocket (permessage-deflate) with\n clients supporting it. Disable if a client has issues with compressed frames"`
+}
+
+func (c *AdminAPIConfig) archiveMaxLimit() uint64 {
+	if c.ArchiveMaxLimit == 0 {
+		return DefaultArchiveMaxLimit
+	}
+	return c.ArchiveMaxLimit
+}
+
+// MutualTLSConfig holds client certificate authentication settings
+type MutualTLSConfig struct {
+	ClientCA string `toml:"client-ca" comment:"Path to the CA certificate(s) (PEM) used to verify client certificates\n Clients presenting a valid certificate whose CN matches a user identifier\n are authenticated without API key"`
+	Require  bool   `toml:"require" comment:"Reject connections not presenting a valid client certificate"`
+}
+
+// Enabled returns true if mutual TLS is configured
+func (c *MutualTLSConfig) Enabled() bool {
+	return c.ClientCA != ""
+}
+
+// TLSConfig returns the tls.Config to use by the server
+func (c *MutualTLSConfig) TLSConfig() (conf *tls.Config, err error) {
+	var pem []byte
+
+	if pem, err = ioutil.ReadFile(c.ClientCA); err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no valid certificate found in client CA: %s", c.ClientCA)
+	}
+
+	conf = &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+	}
+
+	if c.Require {
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return
 }
 
 //////////////// AdminAPIResponse
@@ -125,26 +181,92 @@ func admMsgStr(s string) []byte {
 
 /////////////////// Manager functions
 
-var (
-	upgrader = websocket.Upgrader{} // use default options
+const (
+	// interval at which last login time of admin API users is updated
+	lastLoginUpdateInterval = time.Minute
 )
 
+// wsUpgrade upgrades an HTTP connection to a websocket, write compression
+// is used only if enabled in config and negotiated with client
+func (m *Manager) wsUpgrade(w http.ResponseWriter, r *http.Request) (c *websocket.Conn, err error) {
+	conf := m.config()
+	upgrader := websocket.Upgrader{EnableCompression: conf.AdminAPI.StreamCompression}
+
+	if c, err = upgrader.Upgrade(w, r, nil); err != nil {
+		return
+	}
+
+	c.EnableWriteCompression(conf.AdminAPI.StreamCompression)
+	return
+}
+
 func (m *Manager) adminAuthorizationMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(wt http.ResponseWriter, rq *http.Request) {
+		var user *AdminAPIUser
 
 		auth := rq.Header.Get(AuthKeyHeader)
 
 		// Key is unique and thus indexed, doing this way we only query
 		// index in memory for authorization
-		if m.db.Search(&AdminAPIUser{}, "Key", "=", auth).Len() == 1 {
-			next.ServeHTTP(wt, rq)
-		} else {
+		if o, err := m.db.Search(&AdminAPIUser{}, "Key", "=", auth).One(); err == nil {
+			user = o.(*AdminAPIUser)
+		} else if rq.TLS != nil && len(rq.TLS.VerifiedChains) > 0 && len(rq.TLS.VerifiedChains[0]) > 0 {
+			// user authenticated with a client certificate verified by TLS layer
+			cn := rq.TLS.VerifiedChains[0][0].Subject.CommonName
+			if o, err := m.db.Search(&AdminAPIUser{}, "Identifier", "=", cn).One(); cn != "" && err == nil {
+				user = o.(*AdminAPIUser)
+			}
+		}
+
+		if user == nil {
 			http.Error(wt, "Not Authorized", http.StatusForbidden)
 			return
 		}
+
+		if user.IsDisabled() {
+			http.Error(wt, "Disabled user", http.StatusForbidden)
+			return
+		}
+
+		// limit database writes to one per user and interval
+		if now := time.Now(); now.Sub(user.LastLogin) > lastLoginUpdateInterval {
+			if err := m.updateLastLogin(user.Uuid, now); err != nil {
+				log.Errorf("Failed to update last login of user %s: %s", user.Identifier, err)
+			}
+		}
+
+		// read-only users can only issue GET requests and cannot manage users
+		if user.IsReadOnly() {
+			if (rq.Method != "GET" && rq.Method != "HEAD") || strings.HasPrefix(rq.URL.Path, AdmAPIUsers) {
+				http.Error(wt, "Forbidden for read-only users", http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(wt, withAdminAPIUser(rq, user))
 	})
 }
 
+// updateLastLogin updates only the last login time of a user, the user being
+// read again so that a concurrent update of the user is not overwritten
+func (m *Manager) updateLastLogin(uuid string, t time.Time) error {
+	m.usersLock.Lock()
+	defer m.usersLock.Unlock()
+
+	o, err := m.db.Search(&AdminAPIUser{}, "Uuid", "=", uuid).One()
+	if err != nil {
+		// user deleted in the meantime
+		if sod.IsNoObjectFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	user := o.(*AdminAPIUser)
+	user.LastLogin = t
+	return m.db.InsertOrUpdate(user)
+}
+
 func admLogHTTPMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// src-ip:src-port http-method http-proto url user-agent UUID content-length
@@ -167,12 +289,34 @@ func (m *Manager) admAPIUsers(wt http.ResponseWriter, rq *http.Request) {
 	var err error
 
 	identifier := rq.URL.Query().Get(qpIdentifier)
+	group := rq.URL.Query().Get(qpGroup)
 
 	switch rq.Method {
 	case "GET":
-		if users, err := m.db.All(&AdminAPIUser{}); err != nil {
+		var pg pagination
+		var users []sod.Object
+
+		if pg, err = parsePagination(rq); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		if group != "" {
+			users, err = m.db.Search(&AdminAPIUser{}, "Group", "=", group).Collect()
+		} else {
+			users, err = m.db.All(&AdminAPIUser{})
+		}
+
+		if err != nil && !sod.IsNoObjectFound(err) {
 			wt.Write(admErr(err))
 			return
+		} else if users == nil {
+			users = make([]sod.Object, 0)
+		}
+
+		if pg.enabled {
+			start, stop := pg.bounds(len(users))
+			wt.Write(admJSONResp(pg.page(users[start:stop], len(users), start, stop)))
 		} else {
 			wt.Write(admJSONResp(users))
 		}
@@ -187,6 +331,7 @@ func (m *Manager) admAPIUsers(wt http.ResponseWriter, rq *http.Request) {
 			Identifier: identifier,
 			Uuid:       UUIDGen().String(),
 			Key:        KeyGen(DefaultKeySize),
+			Role:       rq.URL.Query().Get(qpRole),
 		}
 
 		if err = m.CreateNewAdminAPIUser(&user); err != nil {
@@ -243,6 +388,9 @@ func (m *Manager) admAPIUser(wt http.ResponseWriter, rq *http.Request) {
 			user := o.(*AdminAPIUser)
 			switch rq.Method {
 			case "DELETE":
+				m.usersLock.Lock()
+				defer m.usersLock.Unlock()
+
 				if err := m.db.Delete(user); err != nil {
 					wt.Write(admErr(err))
 					return
@@ -255,6 +403,17 @@ func (m *Manager) admAPIUser(wt http.ResponseWriter, rq *http.Request) {
 					return
 				}
 
+				m.usersLock.Lock()
+				defer m.usersLock.Unlock()
+
+				// user is read again not to overwrite a concurrent update
+				if o, err := m.db.Search(&AdminAPIUser{}, "Uuid", "=", uuid).One(); err != nil {
+					wt.Write(admErr(err))
+					return
+				} else {
+					user = o.(*AdminAPIUser)
+				}
+
 				// updating only some allowed fields of existing user
 				if newKey {
 					user.Key = KeyGen(DefaultKeySize)
@@ -272,6 +431,21 @@ func (m *Manager) admAPIUser(wt http.ResponseWriter, rq *http.Request) {
 					user.Description = new.Description
 				}
 
+				if new.Role != "" {
+					if err := new.Validate(); err != nil {
+						wt.Write(admErr(err))
+						return
+					}
+					user.Role = new.Role
+				}
+
+				if disabled := rq.URL.Query().Get(qpDisabled); disabled != "" {
+					if user.Disabled, err = strconv.ParseBool(disabled); err != nil {
+						wt.Write(admErr(err))
+						return
+					}
+				}
+
 				// save new user to database
 				if err := m.db.InsertOrUpdate(user); err != nil {
 					wt.Write(admErr(err))
@@ -295,12 +469,20 @@ func (m *Manager) admAPIUser(wt http.ResponseWriter, rq *http.Request) {
 func (m *Manager) admAPIEndpoints(wt http.ResponseWriter, rq *http.Request) {
 
 	showKey, _ := strconv.ParseBool(rq.URL.Query().Get(qpShowKey))
+	// read-only users are not allowed to see endpoint keys
+	showKey = showKey && !adminAPIUserFromRequest(rq).IsReadOnly()
 	group := rq.URL.Query().Get(qpGroup)
 	status := rq.URL.Query().Get(qpStatus)
 	criticality, _ := strconv.ParseInt(rq.URL.Query().Get(qpCriticality), 10, 8)
 
 	switch {
 	case rq.Method == "GET":
+		pg, err := parsePagination(rq)
+		if err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
 		// we return the list of all endpoints
 		if endpoints, err := m.MutEndpoints(); err != nil {
 			wt.Write(admErr(err))
@@ -323,18 +505,25 @@ func (m *Manager) admAPIEndpoints(wt http.ResponseWriter, rq *http.Request) {
 				if !showKey {
 					// to prevent modifying data in the db cache
 					endpt = endpt.Copy()
-					endpt.Key = ""
+					endpt.HideKeys()
 				}
 				// score is updated at every call as it depends on all the other endpoints
 				endpt.Score = m.gene.reducer.BoundedScore(endpt.Uuid)
 				out = append(out, endpt)
 			}
+
+			if pg.enabled {
+				start, stop := pg.bounds(len(out))
+				wt.Write(admJSONResp(pg.page(out[start:stop], len(out), start, stop)))
+				return
+			}
+
 			wt.Write(admJSONResp(out))
 		}
 
 	case rq.Method == "PUT":
 		endpt := NewEndpoint(UUIDGen().String(), KeyGen(DefaultKeySize))
-		m.db.InsertOrUpdate(endpt)
+		m.autoAssign.Assign(endpt)
 		// save endpoint to database
 		if err := m.db.InsertOrUpdate(endpt); err != nil {
 			m.logAPIErrorf("failed to save new endpoint")
@@ -348,7 +537,10 @@ func (m *Manager) admAPIEndpoint(wt http.ResponseWriter, rq *http.Request) {
 	var err error
 
 	showKey, _ := strconv.ParseBool(rq.URL.Query().Get(qpShowKey))
+	// read-only users are not allowed to see endpoint keys
+	showKey = showKey && !adminAPIUserFromRequest(rq).IsReadOnly()
 	newKey, _ := strconv.ParseBool(rq.URL.Query().Get(qpNewKey))
+	autoGroup, _ := strconv.ParseBool(rq.URL.Query().Get(qpAutoGroup))
 
 	if euuid, err = muxGetVar(rq, "euuid"); err == nil {
 		if endpt, ok := m.MutEndpoint(euuid); ok {
@@ -367,17 +559,27 @@ func (m *Manager) admAPIEndpoint(wt http.ResponseWriter, rq *http.Request) {
 					endpt.Status = new.Status
 				}
 
+				// a group set manually is not overwritten by auto-assign rules
 				if new.Group != "" {
 					endpt.Group = new.Group
+					endpt.ManualGroup = true
+				}
+
+				// give endpoint back to auto-assign rules
+				if autoGroup {
+					endpt.ManualGroup = false
+					m.autoAssign.Assign(endpt)
 				}
 
 				if new.Criticality != -1 {
 					endpt.Criticality = new.Criticality
 				}
 
-				// if we want to generate a new random key
+				// if we want to generate a new random key, previous one
+				// is still accepted during the configured overlap
 				if newKey {
-					endpt.Key = KeyGen(DefaultKeySize)
+					conf := m.config()
+					endpt.RotateKey(KeyGen(DefaultKeySize), conf.EndpointAPI.KeyRotationOverlap)
 				}
 
 				// save endpoint to database
@@ -399,7 +601,7 @@ func (m *Manager) admAPIEndpoint(wt http.ResponseWriter, rq *http.Request) {
 			if !showKey {
 				// to prevent modifying struct in db cache
 				endpt = endpt.Copy()
-				endpt.Key = ""
+				endpt.HideKeys()
 			}
 
 			apiResp := NewAdminAPIResponse(endpt)
@@ -417,17 +619,35 @@ func (m *Manager) admAPIEndpoint(wt http.ResponseWriter, rq *http.Request) {
 
 // CommandAPI structure used by Admin API clients to POST commands
 type CommandAPI struct {
-	CommandLine string        `json:"command-line"`
-	FetchFiles  []string      `json:"fetch-files"`
-	DropFiles   []string      `json:"drop-files"`
-	Timeout     time.Duration `json:"timeout"`
+	Type        CommandType       `json:"type"`
+	CommandLine string            `json:"command-line"`
+	Target      string            `json:"target,omitempty"`
+	FetchFiles  []string          `json:"fetch-files"`
+	FetchHashes map[string]string `json:"fetch-hashes,omitempty"`
+	DropFiles   []string          `json:"drop-files"`
+	Timeout     time.Duration     `json:"timeout"`
 }
 
 // ToCommand converts a CommandAPI to a Command
 func (c *CommandAPI) ToCommand() (*Command, error) {
 	cmd := NewCommand()
-	// adding command line
-	if err := cmd.SetCommandLine(c.CommandLine); err != nil {
+
+	if err := c.Type.Validate(); err != nil {
+		return cmd, err
+	}
+	cmd.Type = c.Type
+
+	if c.Type.IsStructured() {
+		// structured commands are implemented by the endpoint
+		cmd.Name = string(c.Type)
+		if c.Type.NeedsTarget() {
+			if c.Target == "" {
+				return cmd, fmt.Errorf("command type %s requires a target", c.Type)
+			}
+			cmd.Args = []string{c.Target}
+		}
+	} else if err := cmd.SetCommandLine(c.CommandLine); err != nil {
+		// adding command line
 		return cmd, err
 	}
 
@@ -436,6 +656,11 @@ func (c *CommandAPI) ToCommand() (*Command, error) {
 		cmd.AddFetchFile(ff)
 	}
 
+	// adding files to fetch only if they match the expected hash
+	for ff, sha256 := range c.FetchHashes {
+		cmd.AddFetchFileWithHash(ff, sha256)
+	}
+
 	// adding files to drop on the endpoint
 	for _, df := range c.DropFiles {
 		cmd.AddDropFileFromPath(df)
@@ -492,7 +717,57 @@ func (m *Manager) admAPIEndpointCommand(wt http.ResponseWriter, rq *http.Request
 				wt.Write(admErr(format("Unknown endpoint: %s", euuid)))
 			}
 		}
+	case "DELETE":
+		if euuid, err = muxGetVar(rq, "euuid"); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			if endpt, ok := m.MutEndpoint(euuid); ok {
+				switch {
+				case endpt.Command == nil:
+					wt.Write(admErr(format("Command is not set for endpoint: %s", euuid)))
+				default:
+					if err := endpt.Command.Cancel(); err != nil {
+						wt.WriteHeader(http.StatusConflict)
+						wt.Write(admErr(err))
+					} else if err := m.db.InsertOrUpdate(endpt); err != nil {
+						wt.Write(admErr(err))
+					} else {
+						wt.Write(admJSONResp(endpt.Command))
+					}
+				}
+			} else {
+				wt.Write(admErr(format("Unknown endpoint: %s", euuid)))
+			}
+		}
+	}
+}
+
+// EndpointCommand is a command of an endpoint
+type EndpointCommand struct {
+	Uuid     string   `json:"uuid"`
+	Hostname string   `json:"hostname"`
+	Command  *Command `json:"command"`
+}
+
+func (m *Manager) admAPIEndpointsCommands(wt http.ResponseWriter, rq *http.Request) {
+	// only in flight commands are listed by default
+	all, _ := strconv.ParseBool(rq.URL.Query().Get(qpAll))
+
+	endpts, err := m.MutEndpoints()
+	if err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	cmds := make([]EndpointCommand, 0)
+	for _, endpt := range endpts {
+		if endpt.Command == nil || (!all && !endpt.Command.InFlight()) {
+			continue
+		}
+		cmds = append(cmds, EndpointCommand{endpt.Uuid, endpt.Hostname, endpt.Command})
 	}
+
+	wt.Write(admJSONResp(cmds))
 }
 
 func (m *Manager) admAPIEndpointCommandField(wt http.ResponseWriter, rq *http.Request) {
@@ -517,6 +792,10 @@ func (m *Manager) admAPIEndpointCommandField(wt http.ResponseWriter, rq *http.Re
 						wt.Write(admJSONResp(endpt.Command.Error))
 					case "completed":
 						wt.Write(admJSONResp(endpt.Command.Completed))
+					case "status":
+						wt.Write(admJSONResp(endpt.Command.Status))
+					case "transitions":
+						wt.Write(admJSONResp(endpt.Command.Transitions))
 					case "files", "fetch":
 						wt.Write(admJSONResp(endpt.Command.Fetch))
 					default:
@@ -542,7 +821,7 @@ func (m *Manager) admAPIEndpointLogs(wt http.ResponseWriter, rq *http.Request) {
 	// default limit
 	limit := 1000
 
-	logs := make([]*event.EdrEvent, 0)
+	logs := make([]interface{}, 0)
 	pStart := rq.URL.Query().Get(qpSince)
 	pStop := rq.URL.Query().Get(qpUntil)
 	pLast := rq.URL.Query().Get(qpLast)
@@ -553,6 +832,16 @@ func (m *Manager) admAPIEndpointLogs(wt http.ResponseWriter, rq *http.Request) {
 	pLimit := rq.URL.Query().Get(qpLimit)
 	pSkip := rq.URL.Query().Get(qpSkip)
 
+	// events are searched by event time by default
+	basis := logger.TimeBasisEvent
+	if pBasis := rq.URL.Query().Get(qpTimeBasis); pBasis != "" {
+		basis = logger.TimeBasis(pBasis)
+		if err = basis.Validate(); err != nil {
+			wt.Write(admErr(format("Failed to parse %s parameter: %s", qpTimeBasis, err)))
+			return
+		}
+	}
+
 	now := time.Now()
 
 	// Parsing parameters
@@ -571,12 +860,9 @@ func (m *Manager) admAPIEndpointLogs(wt http.ResponseWriter, rq *http.Request) {
 	}
 
 	if pLast != "" {
-		if last, err = time.ParseDuration(pLast); err != nil {
-			if n, err := fmt.Sscanf(pLast, "%dd", &last); n != 1 || err != nil {
-				wt.Write(admErr("Failed to parse last parameter, it must be a valid Go time.Duration format"))
-				return
-			}
-			last *= 24 * time.Hour
+		if last, err = admApiParseDuration(pLast); err != nil {
+			wt.Write(admErr("Failed to parse last parameter, it must be a valid Go time.Duration format"))
+			return
 		}
 	}
 
@@ -649,14 +935,17 @@ searchLogs:
 		wt.Write(admErr(err))
 	} else {
 		searcher := m.eventSearcher
+		detections := strings.HasSuffix(rq.URL.Path, AdmAPIDetectionSuffix)
 
-		if strings.HasSuffix(rq.URL.Path, AdmAPIDetectionSuffix) {
+		if detections {
 			searcher = m.detectionSearcher
 		}
 
-		for rawEvent := range searcher.Events(start, stop, euuid, int(limit), int(skip)) {
+		for rawEvent := range searcher.EventsBy(basis, start, stop, euuid, int(limit), int(skip)) {
 			if e, err := rawEvent.Event(); err != nil {
 				m.logAPIErrorf("failed to encode event to JSON: %s", err)
+			} else if detections {
+				logs = append(logs, NewDetectionEntry(e))
 			} else {
 				logs = append(logs, e)
 			}
@@ -672,6 +961,29 @@ searchLogs:
 	}
 }
 
+// archiveReport archives the report of an endpoint in database and resets
+// its reducer state. It returns the report archived, nil if the endpoint
+// has no report.
+func (m *Manager) archiveReport(uuid string) (rs *reducer.ReducedStats, err error) {
+	if rs = m.gene.reducer.ReduceCopy(uuid); rs == nil {
+		return
+	}
+
+	ar := ArchivedReport{}
+	ar.ReducedStats = *rs
+	ar.ArchivedTimestamp = time.Now()
+
+	// we archive the report in database
+	if err = m.db.InsertOrUpdate(&ar); err != nil {
+		err = fmt.Errorf("failed to save archive: %s", err)
+	}
+
+	// we reset reducer
+	m.gene.reducer.Delete(uuid)
+
+	return
+}
+
 func (m *Manager) admAPIEndpointReport(wt http.ResponseWriter, rq *http.Request) {
 	var euuid string
 	var err error
@@ -680,27 +992,16 @@ func (m *Manager) admAPIEndpointReport(wt http.ResponseWriter, rq *http.Request)
 		wt.Write(admErr(err))
 	} else {
 		if endpt, ok := m.MutEndpoint(euuid); ok {
-			// we return the report anyway
-			rs := m.gene.reducer.ReduceCopy(endpt.Uuid)
 			switch rq.Method {
 			case "GET":
-				wt.Write(admJSONResp(rs))
+				wt.Write(admJSONResp(m.gene.reducer.ReduceCopy(endpt.Uuid)))
 			case "DELETE":
-				if rs != nil {
-					ar := ArchivedReport{}
-					ar.ReducedStats = *rs
-					ar.ArchivedTimestamp = time.Now()
-
+				// we return the report anyway
+				if rs, err := m.archiveReport(endpt.Uuid); rs != nil {
 					resp := NewAdminAPIResponse(rs)
-
-					// we archive the report in database
-					if err := m.db.InsertOrUpdate(&ar); err != nil {
-						resp.Error = fmt.Sprintf("failed to save archive: %s", err)
+					if err != nil {
+						resp.Error = err.Error()
 					}
-
-					// we reset reducer
-					m.gene.reducer.Delete(endpt.Uuid)
-
 					wt.Write(resp.ToJSON())
 				} else {
 					wt.Write(admErr("No report to delete"))
@@ -712,42 +1013,32 @@ func (m *Manager) admAPIEndpointReport(wt http.ResponseWriter, rq *http.Request)
 	}
 }
 
-func (m *Manager) admAPIEndpointReportArchive(wt http.ResponseWriter, rq *http.Request) {
-	var euuid string
-	var err error
-	var since, until time.Time
+// admAPITimeWindow parses since, until and last query parameters. If
+// none is specified the window defaults to the last 24 hours.
+func admAPITimeWindow(rq *http.Request) (since, until time.Time, err error) {
 	var last time.Duration
-	var limit uint64
 
 	pSince := rq.URL.Query().Get(qpSince)
 	pUntil := rq.URL.Query().Get(qpUntil)
 	pLast := rq.URL.Query().Get(qpLast)
-	pLimit := rq.URL.Query().Get(qpLimit)
 
 	if pSince != "" {
 		if since, err = admApiParseTime(pSince); err != nil {
-			wt.Write(admErr(format("Failed to parse since parameter: %s", err)))
+			err = fmt.Errorf("Failed to parse since parameter: %s", err)
 			return
 		}
 	}
 
 	if pUntil != "" {
 		if until, err = admApiParseTime(pUntil); err != nil {
-			wt.Write(admErr(format("Failed to parse until parameter: %s", err)))
+			err = fmt.Errorf("Failed to parse until parameter: %s", err)
 			return
 		}
 	}
 
 	if pLast != "" {
 		if last, err = admApiParseDuration(pLast); err != nil {
-			wt.Write(admErr(format("Failed to parse last parameter: %s", err)))
-			return
-		}
-	}
-
-	if pLimit != "" {
-		if limit, err = strconv.ParseUint(pLimit, 0, 64); err != nil {
-			wt.Write(admErr(format("Failed to parse limit parameter: %s", err)))
+			err = fmt.Errorf("Failed to parse last parameter: %s", err)
 			return
 		}
 	}
@@ -766,6 +1057,39 @@ func (m *Manager) admAPIEndpointReportArchive(wt http.ResponseWriter, rq *http.R
 		since = until.Add(-last)
 	}
 
+	if since.After(until) {
+		err = fmt.Errorf("Parameter %s must be before %s", qpSince, qpUntil)
+	}
+
+	return
+}
+
+func (m *Manager) admAPIEndpointReportArchive(wt http.ResponseWriter, rq *http.Request) {
+	var euuid string
+	var err error
+	var since, until time.Time
+	var pg pagination
+
+	if since, until, err = admAPITimeWindow(rq); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	if pg, err = parsePagination(rq); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	// bounding the number of results
+	conf := m.config()
+	limit := uint64(pg.limit)
+	if limit == 0 {
+		limit = DefaultArchiveLimit
+	}
+	if maxLimit := conf.AdminAPI.archiveMaxLimit(); limit > maxLimit {
+		limit = maxLimit
+	}
+
 	if euuid, err = muxGetVar(rq, "euuid"); err != nil {
 		wt.Write(admErr(err))
 	} else {
@@ -773,13 +1097,77 @@ func (m *Manager) admAPIEndpointReportArchive(wt http.ResponseWriter, rq *http.R
 			search := m.db.Search(&ArchivedReport{}, "Identifier", "=", endpt.Uuid).
 				And("ArchivedTimestamp", ">=", since).
 				And("ArchivedTimestamp", "<=", until)
-			if limit > 0 {
-				search.Limit(limit)
+			total := search.Len()
+			start := pg.offset
+			if start > total {
+				start = total
+			}
+
+			if res, err := search.Limit(uint64(start) + limit).Reverse().Collect(); err != nil && !sod.IsNoObjectFound(err) {
+				wt.Write(admErr(err))
+			} else {
+				if res == nil {
+					res = make([]sod.Object, 0)
+				}
+				if start > len(res) {
+					start = len(res)
+				}
+				res = res[start:]
+
+				if pg.enabled {
+					wt.Write(admJSONResp(pg.page(res, total, start, start+len(res))))
+				} else {
+					wt.Write(admJSONResp(res))
+				}
 			}
-			if res, err := search.Reverse().Collect(); err != nil {
+		} else {
+			wt.Write(admErr(format("Unknown endpoint: %s", euuid)))
+		}
+	}
+}
+
+func (m *Manager) admAPIEndpointReportHistory(wt http.ResponseWriter, rq *http.Request) {
+	var euuid string
+	var err error
+	var since, until time.Time
+	var step time.Duration
+
+	top := DefaultScoreHistoryTop
+
+	if since, until, err = admAPITimeWindow(rq); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	if s := rq.URL.Query().Get(qpStep); s != "" {
+		if step, err = admApiParseDuration(s); err != nil {
+			wt.Write(admErr(format("Failed to parse %s parameter: %s", qpStep, err)))
+			return
+		}
+	}
+
+	if s := rq.URL.Query().Get(qpTop); s != "" {
+		if top, err = strconv.Atoi(s); err != nil {
+			wt.Write(admErr(format("Failed to parse %s parameter: %s", qpTop, err)))
+			return
+		}
+	}
+
+	if euuid, err = muxGetVar(rq, "euuid"); err != nil {
+		wt.Write(admErr(err))
+	} else {
+		if endpt, ok := m.MutEndpoint(euuid); ok {
+			if res, err := m.db.Search(&ArchivedReport{}, "Identifier", "=", endpt.Uuid).
+				And("ArchivedTimestamp", ">=", since).
+				And("ArchivedTimestamp", "<=", until).
+				Collect(); err != nil {
 				wt.Write(admErr(err))
 			} else {
-				wt.Write(admJSONResp(res))
+				reports := make([]*ArchivedReport, 0, len(res))
+				for _, o := range res {
+					reports = append(reports, o.(*ArchivedReport))
+				}
+				wt.Write(admJSONResp(NewScoreHistory(reports, step, top)))
 			}
 		} else {
 			wt.Write(admErr(format("Unknown endpoint: %s", euuid)))
@@ -787,15 +1175,76 @@ func (m *Manager) admAPIEndpointReportArchive(wt http.ResponseWriter, rq *http.R
 	}
 }
 
+// ReportsReset summary of a fleet wide reports archiving
+type ReportsReset struct {
+	Reset     int               `json:"reset"`
+	Endpoints []string          `json:"endpoints"`
+	Errors    map[string]string `json:"errors,omitempty"`
+}
+
 func (m *Manager) admAPIEndpointsReports(wt http.ResponseWriter, rq *http.Request) {
-	out := make(map[string]*reducer.ReducedStats)
+	group := rq.URL.Query().Get(qpGroup)
+
+	if endpoints, err := m.MutEndpoints(); err != nil {
+		wt.Write(admErr(err))
+	} else {
+		switch rq.Method {
+		case "GET":
+			out := make(map[string]*reducer.ReducedStats)
+			for _, e := range endpoints {
+				out[e.Uuid] = m.gene.reducer.ReduceCopy(e.Uuid)
+			}
+			wt.Write(admJSONResp(out))
+		case "DELETE":
+			// archives and resets reports of all the endpoints or of a group
+			reset := ReportsReset{Endpoints: make([]string, 0)}
+			for _, e := range endpoints {
+				if group != "" && e.Group != group {
+					continue
+				}
+
+				rs, err := m.archiveReport(e.Uuid)
+				if err != nil {
+					if reset.Errors == nil {
+						reset.Errors = make(map[string]string)
+					}
+					reset.Errors[e.Uuid] = err.Error()
+				}
+				if rs != nil {
+					reset.Reset++
+					reset.Endpoints = append(reset.Endpoints, e.Uuid)
+				}
+			}
+			wt.Write(admJSONResp(reset))
+		}
+	}
+}
+
+func (m *Manager) admAPIEndpointsFleetReport(wt http.ResponseWriter, rq *http.Request) {
+	var err error
+
+	top := DefaultFleetReportTop
+	group := rq.URL.Query().Get(qpGroup)
+
+	if s := rq.URL.Query().Get(qpLimit); s != "" {
+		if top, err = strconv.Atoi(s); err != nil {
+			wt.Write(admErr(format("Failed to parse %s: %s", qpLimit, err)))
+			return
+		}
+	}
+
 	if endpoints, err := m.MutEndpoints(); err != nil {
 		wt.Write(admErr(err))
 	} else {
+		report := NewFleetReport()
 		for _, e := range endpoints {
-			out[e.Uuid] = m.gene.reducer.ReduceCopy(e.Uuid)
+			if group != "" && e.Group != group {
+				continue
+			}
+			report.Update(e, m.gene.reducer.ReduceCopy(e.Uuid), m.gene.engine)
 		}
-		wt.Write(admJSONResp(out))
+		report.Top(top)
+		wt.Write(admJSONResp(report))
 	}
 }
 
@@ -814,8 +1263,28 @@ type EndpointDumps struct {
 	Files        []DumpFile `json:"files"`
 }
 
-func listEndpointDumps(root, uuid string, since time.Time) (dumps []EndpointDumps, err error) {
-	var procGUIDs, eventHashes, eventDumps []fs.DirEntry
+// ArtifactsSync wraps artifacts listings when a sync cursor is requested
+type ArtifactsSync struct {
+	// Cursor is the latest modification time of the artifacts returned, it
+	// has to be passed as since parameter to retrieve only newer artifacts
+	Cursor    time.Time   `json:"cursor"`
+	Artifacts interface{} `json:"artifacts"`
+}
+
+// dumpsCursor returns the latest modification time of dumps, since is
+// returned if no dump is more recent
+func dumpsCursor(since time.Time, dumps []EndpointDumps) time.Time {
+	cursor := since.UTC()
+	for _, d := range dumps {
+		if d.Modification.After(cursor) {
+			cursor = d.Modification
+		}
+	}
+	return cursor
+}
+
+func listEndpointDumps(root, uuid string, since time.Time) (dumps []EndpointDumps, err error) {
+	var procGUIDs, eventHashes, eventDumps []fs.DirEntry
 
 	dumps = make([]EndpointDumps, 0)
 	urlPath := fmt.Sprintf("%s/%s%s", AdmAPIEndpointsPath, uuid, AdmAPIArticfactsSuffix)
@@ -886,7 +1355,10 @@ func (m *Manager) admAPIArtifacts(wt http.ResponseWriter, rq *http.Request) {
 	var since time.Time
 	var uuids []fs.DirEntry
 
+	var pg pagination
+
 	pSince := rq.URL.Query().Get("since")
+	withCursor, _ := strconv.ParseBool(rq.URL.Query().Get(qpCursor))
 	resp := make(map[string][]EndpointDumps)
 
 	if pSince != "" {
@@ -896,20 +1368,46 @@ func (m *Manager) admAPIArtifacts(wt http.ResponseWriter, rq *http.Request) {
 		}
 	}
 
+	if pg, err = parsePagination(rq); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
 	if uuids, err = os.ReadDir(m.Config.DumpDir); err != nil {
 		wt.Write(admErr(format("Failed to read dump directory: %s", err)))
 		return
 	}
 
+	// we paginate over endpoints directories (sorted by name)
+	dirs := make([]string, 0, len(uuids))
 	for _, uuid := range uuids {
 		if uuid.IsDir() {
-			if resp[uuid.Name()], err = listEndpointDumps(m.Config.DumpDir, uuid.Name(), since); err != nil {
-				wt.Write(admErr(format("Failed list dumps for uuid=%s , %s", uuid.Name(), err)))
-				return
-			}
+			dirs = append(dirs, uuid.Name())
 		}
 	}
-	wt.Write(admJSONResp(resp))
+
+	cursor := since.UTC()
+	start, stop := pg.bounds(len(dirs))
+	for _, uuid := range dirs[start:stop] {
+		if resp[uuid], err = listEndpointDumps(m.Config.DumpDir, uuid, since); err != nil {
+			wt.Write(admErr(format("Failed list dumps for uuid=%s , %s", uuid, err)))
+			return
+		}
+		if c := dumpsCursor(since, resp[uuid]); c.After(cursor) {
+			cursor = c
+		}
+	}
+
+	var out interface{} = resp
+	if pg.enabled {
+		out = pg.page(resp, len(dirs), start, stop)
+	}
+
+	if withCursor {
+		out = ArtifactsSync{Cursor: cursor, Artifacts: out}
+	}
+
+	wt.Write(admJSONResp(out))
 }
 
 func (m *Manager) admAPIEndpointArtifacts(wt http.ResponseWriter, rq *http.Request) {
@@ -917,8 +1415,10 @@ func (m *Manager) admAPIEndpointArtifacts(wt http.ResponseWriter, rq *http.Reque
 	var err error
 	var since time.Time
 	var dumps []EndpointDumps
+	var pg pagination
 
 	pSince := rq.URL.Query().Get("since")
+	withCursor, _ := strconv.ParseBool(rq.URL.Query().Get(qpCursor))
 
 	if pSince != "" {
 		if since, err = admApiParseTime(pSince); err != nil {
@@ -927,6 +1427,11 @@ func (m *Manager) admAPIEndpointArtifacts(wt http.ResponseWriter, rq *http.Reque
 		}
 	}
 
+	if pg, err = parsePagination(rq); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
 	if euuid, err = muxGetVar(rq, "euuid"); err != nil {
 		wt.Write(admErr(err))
 	} else {
@@ -935,7 +1440,20 @@ func (m *Manager) admAPIEndpointArtifacts(wt http.ResponseWriter, rq *http.Reque
 				wt.Write(admErr(format("Failed to list dumps, %s", err)))
 				return
 			}
-			wt.Write(admJSONResp(dumps))
+
+			var out interface{} = dumps
+			if pg.enabled {
+				total := len(dumps)
+				start, stop := pg.bounds(total)
+				dumps = dumps[start:stop]
+				out = pg.page(dumps, total, start, stop)
+			}
+
+			if withCursor {
+				out = ArtifactsSync{Cursor: dumpsCursor(since, dumps), Artifacts: out}
+			}
+
+			wt.Write(admJSONResp(out))
 			return
 		} else {
 			wt.Write(admErr(format("Unknown endpoint: %s", euuid)))
@@ -943,9 +1461,30 @@ func (m *Manager) admAPIEndpointArtifacts(wt http.ResponseWriter, rq *http.Reque
 	}
 }
 
+// artifactContentType returns the content type of an artifact, guessed from
+// its extension or sniffed from its content if extension is not known
+func artifactContentType(name string, data []byte) string {
+	if ct := mime.TypeByExtension(filepath.Ext(name)); ct != "" {
+		return ct
+	}
+	return http.DetectContentType(data)
+}
+
+// artifactWrapped returns true if an artifact must be wrapped into a JSON
+// response. Before json parameter existed, artifacts were wrapped unless raw
+// was true, so raw=false is still honoured if json is not set.
+func artifactWrapped(query url.Values) bool {
+	if query.Get(qpJSON) == "" {
+		raw, err := strconv.ParseBool(query.Get(qpRaw))
+		return err == nil && !raw
+	}
+	wrap, _ := strconv.ParseBool(query.Get(qpJSON))
+	return wrap
+}
+
 func (m *Manager) admAPIEndpointArtifact(wt http.ResponseWriter, rq *http.Request) {
 
-	raw, _ := strconv.ParseBool(rq.URL.Query().Get(qpRaw))
+	wrap := artifactWrapped(rq.URL.Query())
 	gunzip, _ := strconv.ParseBool(rq.URL.Query().Get(qpGunzip))
 
 	if euuid, err := muxGetVar(rq, "euuid"); err == nil {
@@ -963,9 +1502,11 @@ func (m *Manager) admAPIEndpointArtifact(wt http.ResponseWriter, rq *http.Reques
 								var r io.ReadCloser
 								if fd, err := os.Open(fetch); err == nil {
 									r = fd
-									if gunzip {
-										if r, err = gzip.NewReader(fd); err != nil {
-											wt.Write(admErr(format("Failed to gunzip file: %s", err)))
+									// decompressor depends on file extension (gzip or zstd)
+									if gunzip && utils.IsCompressedPath(fetch) {
+										if r, err = utils.NewDecompressReader(fetch, fd); err != nil {
+											fd.Close()
+											wt.Write(admErr(format("Failed to decompress file: %s", err)))
 											return
 										}
 									}
@@ -974,12 +1515,19 @@ func (m *Manager) admAPIEndpointArtifact(wt http.ResponseWriter, rq *http.Reques
 									defer r.Close()
 
 									if data, err := ioutil.ReadAll(r); err == nil {
-										// if we want the raw file
-										if raw {
-											wt.Header().Set("Content-Type", "application/octet-stream")
-											wt.Write(data)
-										} else {
+										// if we want the file wrapped into a JSON response
+										if wrap {
 											wt.Write(admJSONResp(data))
+										} else {
+											name := fname
+											if gunzip {
+												name = utils.TrimCompressionExt(name)
+											}
+											wt.Header().Set("Content-Type", artifactContentType(name, data))
+											wt.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(name)}))
+											// artifacts might be malicious, browsers must not guess content type
+											wt.Header().Set("X-Content-Type-Options", "nosniff")
+											wt.Write(data)
 										}
 									} else {
 										wt.Write(admErr(format("Cannot read file: %s", err)))
@@ -1084,8 +1632,10 @@ func (m *Manager) admAPIEndpointSysmonConfig(wt http.ResponseWriter, rq *http.Re
 }
 
 type stats struct {
-	EndpointCount int `json:"endpoint-count"`
-	RuleCount     int `json:"rule-count"`
+	EndpointCount int            `json:"endpoint-count"`
+	RuleCount     int            `json:"rule-count"`
+	Ingestion     IngestionStats `json:"ingestion"`
+	Storage       StorageStats   `json:"storage"`
 }
 
 func (m *Manager) admAPIStats(wt http.ResponseWriter, rq *http.Request) {
@@ -1095,11 +1645,34 @@ func (m *Manager) admAPIStats(wt http.ResponseWriter, rq *http.Request) {
 		s := stats{
 			EndpointCount: count,
 			RuleCount:     m.gene.engine.Count(),
+			Ingestion:     m.ingestionLimiter().Stats(),
+			Storage:       m.storage.Stats(),
 		}
 		wt.Write(admJSONResp(s))
 	}
 }
 
+func (m *Manager) admAPIConfig(wt http.ResponseWriter, rq *http.Request) {
+	if rq.Method == "POST" {
+		var r ManagerRuntimeConfig
+
+		dec := json.NewDecoder(rq.Body)
+		// settings requiring a restart cannot be changed
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&r); err != nil {
+			wt.Write(admErr(fmt.Errorf("bad runtime configuration: %w", err)))
+			return
+		}
+
+		if err := m.UpdateRuntimeConfig(r); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+	}
+
+	wt.Write(admJSONResp(m.ConfigView()))
+}
+
 func (m *Manager) admAPIIocs(wt http.ResponseWriter, rq *http.Request) {
 
 	source := rq.URL.Query().Get(qpSource)
@@ -1251,69 +1824,189 @@ func (m *Manager) admAPIRules(wt http.ResponseWriter, rq *http.Request) {
 		}
 
 	case "DELETE":
+		var search *sod.Search
+		var names []string
+
+		regex := rq.URL.Query().Get(qpRegex)
 
-		search := m.db.Search(&EdrRule{}, "Name", "=", name)
-		if objs, err := search.Collect(); err != nil {
+		switch {
+		case regex != "":
+			search = m.db.Search(&EdrRule{}, "Name", "~=", regex)
+		case name != "":
+			for _, n := range strings.Split(name, ",") {
+				if n = strings.TrimSpace(n); n == "" {
+					continue
+				}
+				names = append(names, n)
+				if search == nil {
+					search = m.db.Search(&EdrRule{}, "Name", "=", n)
+				} else {
+					search = search.Or("Name", "=", n)
+				}
+			}
+		}
+
+		if search == nil {
+			wt.Write(admErr(fmt.Sprintf("%s or %s parameter is required", qpName, qpRegex)))
+			return
+		}
+
+		objs, err := search.Collect()
+		if err != nil && !sod.IsNoObjectFound(err) {
 			wt.Write(admErr(err))
-		} else {
+			return
+		}
+
+		del := RulesDeletion{
+			Removed: make([]string, 0, len(objs)),
+			Missing: make([]string, 0),
+			Message: "Rules updated succesfully, engine needs to be reloaded"}
+
+		removed := make(map[string]bool)
+		for _, o := range objs {
+			rule := o.(*EdrRule)
+			del.Removed = append(del.Removed, rule.Name)
+			removed[rule.Name] = true
+		}
+
+		for _, n := range names {
+			if !removed[n] {
+				del.Missing = append(del.Missing, n)
+			}
+		}
+
+		if len(objs) > 0 {
+			// deleting all the rules at once
 			if err := search.Delete(); err != nil {
 				wt.Write(admErr(err))
-			} else if err := m.initializeGeneFromDB(); err != nil {
-				// we need to re-init gene engine in case of update
+				return
+			}
+			// we need to re-init gene engine only once
+			if err := m.initializeGeneFromDB(); err != nil {
 				wt.Write(admErr(err))
-			} else {
-				wt.Write(admJSONResp(objs))
+				return
 			}
 		}
 
+		if del.Remaining, err = m.db.Count(&EdrRule{}); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		wt.Write(admJSONResp(del))
+
 	case "POST":
 		defer rq.Body.Close()
 
 		var rules []*EdrRule
 
+		// summary is returned only when conflict mode is explicitly set
+		// not to break clients expecting the rules loaded
+		conflict := rq.URL.Query().Get(qpConflict)
+		summary := conflict != ""
+		switch {
+		case conflict == "" && update:
+			conflict = RuleConflictUpdate
+		case conflict == "":
+			conflict = RuleConflictAbort
+		case !isValidRuleConflict(conflict):
+			wt.Write(admErr(fmt.Sprintf("unknown %s value: %s", qpConflict, conflict)))
+			return
+		}
+
 		dec := json.NewDecoder(rq.Body)
 		if err := dec.Decode(&rules); err != nil {
 			wt.Write(admErr(err))
 		} else {
-			// we verify that we can compile rules
-			for _, rule := range rules {
-				eng := engine.NewEngine()
-				if _, err := rule.Compile(eng); err != nil {
-					// we abort API call
-					wt.Write(admErr(err))
-					return
+			up := RulesUpload{
+				Added:    make([]string, 0),
+				Updated:  make([]string, 0),
+				Rejected: make([]RuleValidationError, 0),
+			}
+
+			// we validate all the rules before loading any of them
+			problems := make([]RuleValidationError, 0)
+			names := make(map[string]bool)
+			rejected := make(map[int]bool)
+			for i, rule := range rules {
+				for _, p := range rule.Check(m.ruleChannels) {
+					p.Index = i
+					problems = append(problems, p)
+				}
+				if names[rule.Name] {
+					p := RuleValidationError{i, rule.Name, "Name", "duplicate rule name"}
+					if conflict == RuleConflictReject {
+						up.Rejected = append(up.Rejected, p)
+						rejected[i] = true
+					} else {
+						problems = append(problems, p)
+					}
 				}
+				names[rule.Name] = true
+			}
+
+			if len(problems) > 0 {
+				// we abort API call
+				resp := NewAdminAPIRespErrorString(format("%d validation error(s) found in rules, nothing loaded", len(problems)))
+				resp.Data = problems
+				wt.Write(resp.ToJSON())
+				return
 			}
 
 			// we add rules
-			for _, rule := range rules {
+			accepted := make([]*EdrRule, 0, len(rules))
+			for i, rule := range rules {
+				if rejected[i] {
+					continue
+				}
+
 				o, err := m.db.Search(&EdrRule{}, "Name", "=", rule.Name).One()
 				switch {
 				case err == nil:
-					if update {
+					switch conflict {
+					case RuleConflictUpdate:
 						// to be able to replace rule
 						rule.Initialize(o.UUID())
-					} else {
+						up.Updated = append(up.Updated, rule.Name)
+					case RuleConflictReject:
+						up.Rejected = append(up.Rejected, RuleValidationError{i, rule.Name, "Name", "rule already exists"})
+						continue
+					default:
 						wt.Write(admErr(fmt.Sprintf(`rule %s already exist, use update URL parameter to force update`, rule.Name)))
 						return
 					}
 				case sod.IsNoObjectFound(err):
-					// we don't do anything
+					up.Added = append(up.Added, rule.Name)
 				default:
-					// we abort API call
+					// we abort API call
 					wt.Write(admErr(err))
 					return
 				}
+
+				accepted = append(accepted, rule)
 			}
 
-			if err := m.db.InsertOrUpdateMany(sod.ToObjectSlice(rules)...); err != nil {
-				err := fmt.Errorf("partial insert/update due to error: %s", err)
-				wt.Write(admErr(err))
-			} else if err := m.initializeGeneFromDB(); err != nil {
+			up.Message = format("%d rule(s) added, %d updated, %d rejected", len(up.Added), len(up.Updated), len(up.Rejected))
+
+			// all accepted rules are written in a single call whatever
+			// the conflict mode, so that the engine is reloaded only once
+			if len(accepted) > 0 {
+				if err := m.db.InsertOrUpdateMany(sod.ToObjectSlice(accepted)...); err != nil {
+					err := fmt.Errorf("partial insert/update due to error: %s", err)
+					wt.Write(admErr(err))
+					return
+				}
 				// we need to re-init gene engine in case of update
-				wt.Write(admErr(err))
+				if err := m.initializeGeneFromDB(); err != nil {
+					wt.Write(admErr(err))
+					return
+				}
+			}
+
+			if summary {
+				wt.Write(admJSONResp(up))
 			} else {
-				wt.Write(admJSONResp(rules))
+				wt.Write(admJSONResp(accepted))
 			}
 		}
 
@@ -1321,6 +2014,348 @@ func (m *Manager) admAPIRules(wt http.ResponseWriter, rq *http.Request) {
 
 }
 
+// RuleTestResult is the result of a rule tested against stored events
+type RuleTestResult struct {
+	Rule      string           `json:"rule"`
+	Start     time.Time        `json:"start"`
+	Stop      time.Time        `json:"stop"`
+	Endpoints int              `json:"endpoints"`
+	Scanned   int              `json:"scanned"`
+	Truncated bool             `json:"truncated"`
+	Matches   []DetectionEntry `json:"matches"`
+}
+
+// testEngine returns a new engine, independent from the live one, with
+// the containers in database and rule loaded
+func (m *Manager) testEngine(rule *EdrRule) (*engine.Engine, error) {
+	eng := engine.NewEngine()
+
+	objs, err := m.db.All(&EdrContainer{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, o := range objs {
+		cont := o.(*EdrContainer)
+		for _, e := range cont.Entries {
+			eng.AddToContainer(cont.Name, e)
+		}
+	}
+
+	if err := eng.LoadRule(&rule.Rule); err != nil {
+		return nil, fmt.Errorf("fail to load rule %s: %s", rule.Name, err)
+	}
+
+	return eng, nil
+}
+
+func (m *Manager) admAPIRulesTest(wt http.ResponseWriter, rq *http.Request) {
+	var err error
+	var rule EdrRule
+	var eng *engine.Engine
+
+	defer rq.Body.Close()
+
+	// default settings last hour
+	last := time.Hour
+	limit := 1000
+
+	if err = json.NewDecoder(rq.Body).Decode(&rule); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	if problems := rule.Check(m.ruleChannels); len(problems) > 0 {
+		resp := NewAdminAPIRespErrorString(format("%d validation error(s) found in rule", len(problems)))
+		resp.Data = problems
+		wt.Write(resp.ToJSON())
+		return
+	}
+
+	if pLast := rq.URL.Query().Get(qpLast); pLast != "" {
+		if last, err = admApiParseDuration(pLast); err != nil {
+			wt.Write(admErr("Failed to parse last parameter, it must be a valid Go time.Duration format"))
+			return
+		}
+	}
+
+	if pLimit := rq.URL.Query().Get(qpLimit); pLimit != "" {
+		// we don't raise error here on bad conversion
+		if l, err := strconv.Atoi(pLimit); err == nil && l > 0 {
+			limit = l
+		}
+	}
+	if limit > MaxLimitLogAPI {
+		limit = MaxLimitLogAPI
+	}
+
+	res := RuleTestResult{Rule: rule.Name, Stop: time.Now(), Matches: make([]DetectionEntry, 0)}
+	res.Start = res.Stop.Add(-last)
+
+	// since and until take precedence over last
+	if pStart := rq.URL.Query().Get(qpSince); pStart != "" {
+		if res.Start, err = admApiParseTime(pStart); err != nil {
+			wt.Write(admErr("Failed to parse start parameter, it must be RFC3339 formated"))
+			return
+		}
+	}
+
+	if pStop := rq.URL.Query().Get(qpUntil); pStop != "" {
+		if res.Stop, err = admApiParseTime(pStop); err != nil {
+			wt.Write(admErr("Failed to parse stop parameter, it must be RFC3339 formated"))
+			return
+		}
+	}
+
+	if res.Start.After(res.Stop) {
+		wt.Write(admErr("Start date must be before stop date"))
+		return
+	}
+
+	// endpoints to test the rule against, whole fleet by default
+	uuids := make([]string, 0)
+	if euuid := rq.URL.Query().Get(qpEndpointUuid); euuid != "" {
+		if _, ok := m.MutEndpoint(euuid); !ok {
+			wt.Write(admErr(format("Unknown endpoint: %s", euuid)))
+			return
+		}
+		uuids = append(uuids, euuid)
+	} else if endpts, err := m.MutEndpoints(); err != nil {
+		wt.Write(admErr(err))
+		return
+	} else {
+		for _, endpt := range endpts {
+			uuids = append(uuids, endpt.Uuid)
+		}
+	}
+
+	if eng, err = m.testEngine(&rule); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	for _, euuid := range uuids {
+		res.Endpoints++
+		// channel must be consumed entirely
+		for rawEvent := range m.eventSearcher.EventsBy(logger.TimeBasisEvent, res.Start, res.Stop, euuid, math.MaxInt32, 0) {
+			if res.Truncated {
+				continue
+			}
+
+			e, err := rawEvent.Event()
+			if err != nil {
+				m.logAPIErrorf("failed to decode event: %s", err)
+				continue
+			}
+
+			res.Scanned++
+			// detection set by the endpoint must not be mistaken for a match
+			e.Event.Detection = nil
+			if names, _, _ := eng.MatchOrFilter(e); len(names) > 0 {
+				if len(res.Matches) == limit {
+					res.Truncated = true
+					continue
+				}
+				res.Matches = append(res.Matches, NewDetectionEntry(e))
+			}
+		}
+
+		if err := m.eventSearcher.Err(); err != nil {
+			wt.Write(admErr(format("failed to search events: %s", err)))
+			return
+		}
+
+		if res.Truncated {
+			break
+		}
+	}
+
+	wt.Write(admJSONResp(res))
+}
+
+func (m *Manager) admAPIContainers(wt http.ResponseWriter, rq *http.Request) {
+	if objs, err := m.db.All(&EdrContainer{}); err != nil {
+		wt.Write(admErr(err))
+	} else {
+		wt.Write(admJSONResp(objs))
+	}
+}
+
+func (m *Manager) admAPIContainer(wt http.ResponseWriter, rq *http.Request) {
+	var entries []string
+
+	name, _ := muxGetVar(rq, "name")
+	cont := NewEdrContainer(name)
+
+	if err := cont.Validate(); err != nil {
+		wt.Write(admErr(err))
+		return
+	}
+
+	err := m.db.Search(&EdrContainer{}, "Name", "=", name).AssignOne(&cont)
+	if err != nil && !sod.IsNoObjectFound(err) {
+		wt.Write(admErr(err))
+		return
+	}
+
+	switch rq.Method {
+	case "GET":
+		if err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(cont))
+		}
+
+	case "POST":
+		if err := readPostAsJSON(rq, &entries); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		cont.Add(entries...)
+		if err := m.db.InsertOrUpdate(cont); err != nil {
+			wt.Write(admErr(err))
+		} else if err := m.initializeGeneFromDB(); err != nil {
+			// we need to re-init gene engine as rules may use container
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(cont))
+		}
+
+	case "DELETE":
+		if err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		// no body means we delete the whole container
+		if err := readPostAsJSON(rq, &entries); err != nil && rq.ContentLength > 0 {
+			wt.Write(admErr(err))
+			return
+		}
+
+		if len(entries) > 0 {
+			cont.Del(entries...)
+			err = m.db.InsertOrUpdate(cont)
+		} else {
+			err = m.db.Delete(cont)
+		}
+
+		if err != nil {
+			wt.Write(admErr(err))
+		} else if err := m.initializeGeneFromDB(); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(cont))
+		}
+	}
+}
+
+func (m *Manager) admAPISuppressions(wt http.ResponseWriter, rq *http.Request) {
+	switch rq.Method {
+	case "GET":
+		if objs, err := m.db.All(&DetectionSuppression{}); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(objs))
+		}
+
+	case "PUT":
+		new := NewDetectionSuppression()
+
+		if err := readPostAsJSON(rq, new); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		// fields which cannot be set by user
+		new.Uuid = new.UUID()
+		new.Hostname = ""
+		new.Created = time.Now().UTC()
+		new.Updated = new.Created
+
+		if err := new.Validate(); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		m.RLock()
+		known := m.gene.engine.GetCRuleByName(new.Rule) != nil
+		m.RUnlock()
+		if !known {
+			wt.Write(admErr(format("unknown rule: %s", new.Rule)))
+			return
+		}
+
+		if new.Endpoint != "" {
+			endpt, ok := m.MutEndpoint(new.Endpoint)
+			switch {
+			case !ok:
+				wt.Write(admErr(format("unknown endpoint: %s", new.Endpoint)))
+				return
+			case endpt.Hostname == "":
+				// filter rule matches events on hostname
+				wt.Write(admErr(format("hostname of endpoint %s is not known yet", new.Endpoint)))
+				return
+			}
+			new.Hostname = endpt.Hostname
+		}
+
+		s := new
+		// an existing suppression applying to the same detections is updated
+		if objs, err := m.db.All(&DetectionSuppression{}); err != nil {
+			wt.Write(admErr(err))
+			return
+		} else {
+			for _, o := range objs {
+				if old := o.(*DetectionSuppression); old.Same(new) {
+					old.Comment = new.Comment
+					old.Hostname = new.Hostname
+					old.Updated = new.Updated
+					s = old
+					break
+				}
+			}
+		}
+
+		if err := m.db.InsertOrUpdate(s); err != nil {
+			wt.Write(admErr(err))
+		} else if err := m.initializeGeneFromDB(); err != nil {
+			// suppression rules served to endpoints are rebuilt
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(s))
+		}
+	}
+}
+
+func (m *Manager) admAPISuppression(wt http.ResponseWriter, rq *http.Request) {
+	suuid, err := muxGetVar(rq, "suuid")
+	if err != nil {
+		wt.Write(admErr(format("Failed to parse URL: %s", err)))
+		return
+	}
+
+	o, err := m.db.GetByUUID(&DetectionSuppression{}, suuid)
+	if err != nil {
+		wt.Write(admErr(format("unknown suppression: %s", suuid)))
+		return
+	}
+
+	switch rq.Method {
+	case "GET":
+		wt.Write(admJSONResp(o))
+	case "DELETE":
+		if err := m.db.Delete(o); err != nil {
+			wt.Write(admErr(err))
+		} else if err := m.initializeGeneFromDB(); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(o))
+		}
+	}
+}
+
 func (m *Manager) wsHandleControlMessage(c *websocket.Conn) {
 	for {
 		if _, _, err := c.NextReader(); err != nil {
@@ -1331,15 +2366,44 @@ func (m *Manager) wsHandleControlMessage(c *websocket.Conn) {
 	}
 }
 
+// parseStreamFilter parses stream filter from request and checks that
+// endpoints filtered on exist
+func (m *Manager) parseStreamFilter(rq *http.Request) (f *StreamFilter, err error) {
+	if f, err = ParseStreamFilter(rq.URL.Query()); err != nil {
+		return
+	}
+
+	for _, euuid := range f.Endpoints {
+		if _, ok := m.MutEndpoint(euuid); !ok {
+			return nil, fmt.Errorf("unknown endpoint: %s", euuid)
+		}
+	}
+
+	return
+}
+
+// wsCloseInvalidFilter closes a websocket connection with a close message
+// carrying the error
+func wsCloseInvalidFilter(c *websocket.Conn, err error) {
+	msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, format("invalid stream filter: %s", err))
+	c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+}
+
 func (m *Manager) admAPIStreamEvents(w http.ResponseWriter, r *http.Request) {
-	c, err := upgrader.Upgrade(w, r, nil)
+	c, err := m.wsUpgrade(w, r)
 	if err != nil {
 		m.logAPIErrorf("failed to upgrade to websocket: %s", err)
 		return
 	}
 	defer c.Close()
 
-	stream := m.eventStreamer.NewStream()
+	filter, err := m.parseStreamFilter(r)
+	if err != nil {
+		wsCloseInvalidFilter(c, err)
+		return
+	}
+
+	stream := m.eventStreamer.NewFilteredStream(filter)
 	stream.Stream()
 	defer stream.Close()
 
@@ -1355,22 +2419,29 @@ func (m *Manager) admAPIStreamEvents(w http.ResponseWriter, r *http.Request) {
 }
 
 func (m *Manager) admAPIStreamDetections(w http.ResponseWriter, r *http.Request) {
-	c, err := upgrader.Upgrade(w, r, nil)
+	c, err := m.wsUpgrade(w, r)
 	if err != nil {
 		m.logAPIErrorf("failed to upgrade to websocket: %s", err)
 		return
 	}
 	defer c.Close()
 
-	stream := m.eventStreamer.NewStream()
+	filter, err := m.parseStreamFilter(r)
+	if err != nil {
+		wsCloseInvalidFilter(c, err)
+		return
+	}
+
+	stream := m.eventStreamer.NewFilteredStream(filter)
 	stream.Stream()
 	defer stream.Close()
 
 	go m.wsHandleControlMessage(c)
 
 	for e := range stream.S {
-		// check if event is associated to a detection
-		if e.IsDetection() {
+		// check if event is associated to a detection, shadow
+		// detections are not streamed
+		if e.IsDetection() && !e.IsShadow() {
 			err = c.WriteJSON(e)
 			if err != nil {
 				break
@@ -1406,11 +2477,15 @@ func (m *Manager) runAdminAPI() {
 		rt.HandleFunc(AdmAPIUserByID, m.admAPIUser).Methods("GET", "POST", "DELETE")
 		rt.HandleFunc(AdmAPIEndpointsPath, m.admAPIEndpoints).Methods("GET", "PUT")
 		rt.HandleFunc(AdmAPIEndpointsByIDPath, m.admAPIEndpoint).Methods("GET", "POST", "DELETE")
-		rt.HandleFunc(AdmAPIEndpointCommandPath, m.admAPIEndpointCommand).Methods("GET", "POST")
+		rt.HandleFunc(AdmAPIEndpointDecommissionPath, m.admAPIEndpointDecommission).Methods("POST")
+		rt.HandleFunc(AdmAPIEndpointsCommandsPath, m.admAPIEndpointsCommands).Methods("GET")
+		rt.HandleFunc(AdmAPIEndpointCommandPath, m.admAPIEndpointCommand).Methods("GET", "POST", "DELETE")
 		rt.HandleFunc(AdmAPIEndpointCommandFieldPath, m.admAPIEndpointCommandField).Methods("GET")
-		rt.HandleFunc(AdmAPIEndpointsReportsPath, m.admAPIEndpointsReports).Methods("GET")
+		rt.HandleFunc(AdmAPIEndpointsReportsPath, m.admAPIEndpointsReports).Methods("GET", "DELETE")
+		rt.HandleFunc(AdmAPIEndpointsFleetReportPath, m.admAPIEndpointsFleetReport).Methods("GET")
 		rt.HandleFunc(AdmAPIEndpointReportPath, m.admAPIEndpointReport).Methods("GET", "DELETE")
 		rt.HandleFunc(AdmAPIEndpointReportArchivePath, m.admAPIEndpointReportArchive).Methods("GET")
+		rt.HandleFunc(AdmAPIEndpointReportHistoryPath, m.admAPIEndpointReportHistory).Methods("GET")
 		rt.HandleFunc(AdmAPIEndpointLogsPath, m.admAPIEndpointLogs).Methods("GET")
 		rt.HandleFunc(AdmAPIEndpointDetectionsPath, m.admAPIEndpointLogs).Methods("GET")
 		rt.HandleFunc(AdmAPIEndpointsArtifactsPath, m.admAPIArtifacts).Methods("GET")
@@ -1418,8 +2493,15 @@ func (m *Manager) runAdminAPI() {
 		rt.HandleFunc(AdmAPIEndpointArtifact, m.admAPIEndpointArtifact).Methods("GET")
 		rt.HandleFunc(AdmAPIEndpointsSysmonConfig, m.admAPIEndpointSysmonConfig).Methods("GET", "POST", "DELETE")
 		rt.HandleFunc(AdmAPIIocsPath, m.admAPIIocs).Methods("GET", "POST", "DELETE")
+		rt.HandleFunc(AdmAPIRulesTestPath, m.admAPIRulesTest).Methods("POST")
+		rt.HandleFunc(AdmAPIShadowRulesPath, m.admAPIShadowRules).Methods("GET", "POST", "DELETE")
 		rt.HandleFunc(AdmAPIRulesPath, m.admAPIRules).Methods("GET", "POST", "DELETE")
+		rt.HandleFunc(AdmAPIContainersPath, m.admAPIContainers).Methods("GET")
+		rt.HandleFunc(AdmAPIContainerPath, m.admAPIContainer).Methods("GET", "POST", "DELETE")
+		rt.HandleFunc(AdmAPISuppressionsPath, m.admAPISuppressions).Methods("GET", "PUT")
+		rt.HandleFunc(AdmAPISuppressionByIDPath, m.admAPISuppression).Methods("GET", "DELETE")
 		rt.HandleFunc(AdmAPIStatsPath, m.admAPIStats).Methods("GET")
+		rt.HandleFunc(AdmAPIConfigPath, m.admAPIConfig).Methods("GET", "POST")
 		// WebSocket handlers
 		rt.HandleFunc(AdmAPIStreamEvents, m.admAPIStreamEvents)
 		rt.HandleFunc(AdmAPIStreamDetections, m.admAPIStreamDetections)
@@ -1439,9 +2521,26 @@ func (m *Manager) runAdminAPI() {
 				log.Panic(err)
 			}
 		} else {
+			tlsConf, err := m.Config.TLS.ServerConfig()
+			if err != nil {
+				log.Panic(err)
+			}
+
+			if m.Config.AdminAPI.MutualTLS.Enabled() {
+				mtlsConf, err := m.Config.AdminAPI.MutualTLS.TLSConfig()
+				if err != nil {
+					log.Panic(err)
+				}
+				tlsConf.ClientCAs = mtlsConf.ClientCAs
+				tlsConf.ClientAuth = mtlsConf.ClientAuth
+				log.Infof("Admin API client certificate authentication enabled (required: %t)", m.Config.AdminAPI.MutualTLS.Require)
+			}
+			m.adminAPI.TLSConfig = tlsConf
+
 			// Bind to a port and pass our router in
 			log.Infof("Running admin HTTPS API server on: %s", uri)
-			if err := m.adminAPI.ListenAndServeTLS(m.Config.TLS.Cert, m.Config.TLS.Key); err != http.ErrServerClosed {
+			// certificate is already loaded in TLS config
+			if err := m.adminAPI.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
 				log.Panic(err)
 			}
 		}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/0xrawsec/whids/event"
@@ -51,12 +52,19 @@ func (m *Manager) endpointAuthorizationMiddleware(next http.Handler) http.Handle
 			return
 		}
 
-		if endpt.Uuid != uuid || endpt.Key != key {
+		// previous key is accepted during key rotation overlap window
+		rotated := endpt.IsPreviousKey(key)
+		if endpt.Uuid != uuid || (endpt.Key != key && !rotated) {
 			http.Error(wt, "Not Authorized", http.StatusForbidden)
 			// we have to return not to reach ServeHTTP
 			return
 		}
 
+		if rotated {
+			// endpoint is expected to fetch its new key
+			wt.Header().Set(EndpointKeyRotatedHeader, strconv.FormatBool(true))
+		}
+
 		endpt.IP = ip
 
 		switch {
@@ -69,6 +77,16 @@ func (m *Manager) endpointAuthorizationMiddleware(next http.Handler) http.Handle
 			return
 		}
 
+		// update actions suppression state
+		endpt.Suppression = ActionsSuppression{}
+		endpt.Suppression.Enabled, _ = strconv.ParseBool(rq.Header.Get(EndpointActionsSuppressedHeader))
+		if until := rq.Header.Get(EndpointActionsSuppressedUntilHeader); until != "" {
+			endpt.Suppression.Until, _ = time.Parse(time.RFC3339, until)
+		}
+
+		// hostname and IP may have changed
+		m.autoAssign.Assign(endpt)
+
 		// update last connection timestamp
 		endpt.UpdateLastConnection()
 		if err := m.db.InsertOrUpdate(endpt); err != nil {
@@ -105,6 +123,20 @@ func endptQuietLogHTTPMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// endptLogHTTPMiddleware logs requests according to the verbosity
+// currently configured, as it can be changed at runtime
+func (m *Manager) endptLogHTTPMiddleware(next http.Handler) http.Handler {
+	verbose := endptLogHTTPMiddleware(next)
+	quiet := endptQuietLogHTTPMiddleware(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c := m.config(); c.Logging.VerboseHTTP {
+			verbose.ServeHTTP(w, r)
+		} else {
+			quiet.ServeHTTP(w, r)
+		}
+	})
+}
+
 func (m *Manager) runEndpointAPI() {
 
 	go func() {
@@ -119,11 +151,7 @@ func (m *Manager) runEndpointAPI() {
 		rt := mux.NewRouter()
 		// Middleware initialization
 		// Manages Request Logging
-		if m.Config.Logging.VerboseHTTP {
-			rt.Use(endptLogHTTPMiddleware)
-		} else {
-			rt.Use(endptQuietLogHTTPMiddleware)
-		}
+		rt.Use(m.endptLogHTTPMiddleware)
 
 		// Manages Authorization
 		rt.Use(m.endpointAuthorizationMiddleware)
@@ -135,13 +163,22 @@ func (m *Manager) runEndpointAPI() {
 		rt.HandleFunc(EptAPIPostLogsPath, m.eptAPICollect).Methods("POST")
 		rt.HandleFunc(EptAPIPostDumpPath, m.eptAPIUploadDump).Methods("POST")
 		rt.HandleFunc(EptAPIPostSystemInfo, m.eptAPISystemInfo).Methods("POST")
+		rt.HandleFunc(EptAPICommandAckPath, m.eptAPICommandAck).Methods("POST")
+		rt.HandleFunc(EptAPICommandCancelPath, m.eptAPICommandCancel).Methods("POST")
 
 		// GET based
 		rt.HandleFunc(EptAPIServerKeyPath, m.eptAPIServerKey).Methods("GET")
+		rt.HandleFunc(EptAPIEndpointKeyPath, m.eptAPIEndpointKey).Methods("GET")
 		rt.HandleFunc(EptAPIRulesPath, m.eptAPIRules).Methods("GET")
 		rt.HandleFunc(EptAPIRulesSha256Path, m.eptAPIRulesSha256).Methods("GET")
+		rt.HandleFunc(EptAPIShadowRulesPath, m.eptAPIShadowRules).Methods("GET")
+		rt.HandleFunc(EptAPIShadowRulesSha256Path, m.eptAPIShadowRulesSha256).Methods("GET")
+		rt.HandleFunc(EptAPISuppressionsPath, m.eptAPISuppressions).Methods("GET")
+		rt.HandleFunc(EptAPISuppressionsSha256Path, m.eptAPISuppressionsSha256).Methods("GET")
 		rt.HandleFunc(EptAPIIoCsPath, m.eptAPIIoCs).Methods("GET")
 		rt.HandleFunc(EptAPIIoCsSha256Path, m.eptAPIIoCsSha256).Methods("GET")
+		rt.HandleFunc(EptAPIContainersPath, m.eptAPIContainers).Methods("GET")
+		rt.HandleFunc(EptAPIContainerPath, m.eptAPIContainer).Methods("GET")
 
 		// GET and POST
 		rt.HandleFunc(EptAPICommandPath, m.eptAPICommand).Methods("GET", "POST")
@@ -161,9 +198,16 @@ func (m *Manager) runEndpointAPI() {
 				log.Panic(err)
 			}
 		} else {
+			tlsConf, err := m.Config.TLS.ServerConfig()
+			if err != nil {
+				log.Panic(err)
+			}
+			m.endpointAPI.TLSConfig = tlsConf
+
 			// Bind to a port and pass our router in
 			log.Infof("Running endpoint HTTPS API server on: %s", uri)
-			if err := m.endpointAPI.ListenAndServeTLS(m.Config.TLS.Cert, m.Config.TLS.Key); err != http.ErrServerClosed {
+			// certificate is already loaded in TLS config
+			if err := m.endpointAPI.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
 				log.Panic(err)
 			}
 		}
@@ -175,6 +219,15 @@ func (m *Manager) eptAPIServerKey(wt http.ResponseWriter, rq *http.Request) {
 	wt.Write([]byte(m.Config.EndpointAPI.ServerKey))
 }
 
+// eptAPIEndpointKey HTTP handler used by endpoints to fetch their current key
+func (m *Manager) eptAPIEndpointKey(wt http.ResponseWriter, rq *http.Request) {
+	if endpt := m.eptAPIMutEndpointFromRequest(rq); endpt != nil {
+		wt.Write([]byte(endpt.Key))
+		return
+	}
+	http.Error(wt, "Unknown endpoint", http.StatusNotFound)
+}
+
 // eptAPIRules HTTP handler used to serve the rules
 func (m *Manager) eptAPIRules(wt http.ResponseWriter, rq *http.Request) {
 	m.RLock()
@@ -202,6 +255,38 @@ func (m *Manager) eptAPIIoCsSha256(wt http.ResponseWriter, rq *http.Request) {
 	wt.Write([]byte(m.iocs.Hash()))
 }
 
+// eptAPIContainers serves the sha256 of all the containers managed by the manager
+func (m *Manager) eptAPIContainers(wt http.ResponseWriter, rq *http.Request) {
+	hashes := make(map[string]string)
+
+	if objs, err := m.db.All(&EdrContainer{}); err != nil {
+		m.logAPIErrorf("failed to retrieve containers: %s", err)
+		http.Error(wt, "Failed to retrieve containers", http.StatusInternalServerError)
+		return
+	} else {
+		for _, o := range objs {
+			cont := o.(*EdrContainer)
+			hashes[cont.Name] = cont.Sha256()
+		}
+	}
+
+	wt.Write(utils.Json(hashes))
+}
+
+// eptAPIContainer serves the entries of a container
+func (m *Manager) eptAPIContainer(wt http.ResponseWriter, rq *http.Request) {
+	var cont *EdrContainer
+
+	name, _ := muxGetVar(rq, "name")
+	if err := m.db.Search(&EdrContainer{}, "Name", "=", name).AssignOne(&cont); err != nil {
+		m.logAPIErrorf("failed to retrieve container %s: %s", name, err)
+		http.Error(wt, "Failed to retrieve container", http.StatusNotFound)
+		return
+	}
+
+	wt.Write(utils.Json(cont.Entries))
+}
+
 // eptAPIUploadDump HTTP handler used to upload dump files from client to manager
 func (m *Manager) eptAPIUploadDump(wt http.ResponseWriter, rq *http.Request) {
 	defer rq.Body.Close()
@@ -239,14 +324,36 @@ func (m *Manager) eptAPIUploadDump(wt http.ResponseWriter, rq *http.Request) {
 func (m *Manager) eptAPICollect(wt http.ResponseWriter, rq *http.Request) {
 	defer rq.Body.Close()
 
+	// applying backpressure if we cannot keep up with ingestion
+	ingestion := m.ingestionLimiter()
+	// settings changed at runtime apply to the next requests
+	conf := m.config()
+	if !ingestion.Acquire() {
+		m.logAPIErrorf("events ingestion queue full, asking endpoint UUID=%s to retry later", rq.Header.Get(EndpointUUIDHeader))
+		wt.Header().Set("Retry-After", strconv.Itoa(DefaultBackpressureRetryAfter))
+		http.Error(wt, "events ingestion queue full", http.StatusServiceUnavailable)
+		return
+	}
+	defer ingestion.Release()
+
 	funcName := utils.GetCurFuncName()
 	cnt := 0
 	uuid := rq.Header.Get(EndpointUUIDHeader)
+	batch := rq.Header.Get(EventsBatchIDHeader)
+	failed := false
+
+	// endpoint replayed a batch we already collected
+	if m.batches.Collected(uuid, batch) {
+		log.Infof("Skipping batch of events already collected from endpoint UUID=%s", uuid)
+		return
+	}
 	endpt, _ := m.MutEndpoint(uuid)
 
 	etid := m.eventLogger.InitTransaction()
 	dtid := m.detectionLogger.InitTransaction()
 	s := bufio.NewScanner(rq.Body)
+	// batches may contain events larger than default scanner buffer
+	s.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), MaxCollectedEventSize)
 	for s.Scan() {
 		tok := []byte(s.Text())
 		log.Debugf("%s received Event: %s", funcName, string(tok))
@@ -261,6 +368,27 @@ func (m *Manager) eptAPICollect(wt http.ResponseWriter, rq *http.Request) {
 			edrData.Event.Hash = utils.HashEventBytes(tok)
 			edrData.Event.ReceiptTime = time.Now().UTC()
 
+			// verifying event integrity
+			switch {
+			case e.Event.Signature == "":
+				edrData.Event.Integrity = event.IntegrityUnsigned
+				if conf.EndpointAPI.RejectUnsigned {
+					m.logAPIErrorf("rejecting unsigned event from endpoint UUID=%s", uuid)
+					continue
+				}
+			case endpt != nil && e.VerifySignature(endpt.Key, tok),
+				// events signed before endpoint adopted its new key
+				endpt != nil && endpt.HasPreviousKey() && e.VerifySignature(endpt.PreviousKey, tok):
+				edrData.Event.Integrity = event.IntegrityValid
+			default:
+				edrData.Event.Integrity = event.IntegrityInvalid
+				if conf.EndpointAPI.RejectInvalid {
+					m.logAPIErrorf("rejecting event failing signature verification from endpoint UUID=%s", uuid)
+					continue
+				}
+				log.Warnf("event failing signature verification from endpoint UUID=%s", uuid)
+			}
+
 			edrData.Endpoint.UUID = uuid
 			if endpt != nil {
 				// updating EdrData fields
@@ -268,12 +396,15 @@ func (m *Manager) eptAPICollect(wt http.ResponseWriter, rq *http.Request) {
 				edrData.Endpoint.Hostname = endpt.Hostname
 				edrData.Endpoint.Group = endpt.Group
 
-				// updating reducer
-				m.UpdateReducer(endpt.Uuid, &e)
+				// shadow detections must not affect endpoint score
+				if !e.IsShadow() {
+					// updating reducer
+					m.UpdateReducer(endpt.Uuid, &e)
 
-				// updating last detection
-				if e.IsDetection() {
-					endpt.LastDetection = e.Timestamp()
+					// updating last detection
+					if e.IsDetection() {
+						endpt.LastDetection = e.Timestamp()
+					}
 				}
 			}
 
@@ -282,8 +413,8 @@ func (m *Manager) eptAPICollect(wt http.ResponseWriter, rq *http.Request) {
 			// setting EdrData
 			e.Event.EdrData = &edrData
 
-			// If it is an alert
-			if e.IsDetection() {
+			// If it is an alert, shadow detections being kept with events only
+			if e.IsDetection() && !e.IsShadow() {
 				if _, err := m.detectionLogger.WriteEvent(dtid, uuid, &e); err != nil {
 					m.logAPIErrorf("failed to write detection: %s", err)
 				}
@@ -299,6 +430,11 @@ func (m *Manager) eptAPICollect(wt http.ResponseWriter, rq *http.Request) {
 		cnt++
 	}
 
+	if err := s.Err(); err != nil {
+		m.logAPIErrorf("failed to read events from endpoint UUID=%s: %s", uuid, err)
+		failed = true
+	}
+
 	if endpt != nil {
 		if err := m.db.InsertOrUpdate(endpt); err != nil {
 			m.logAPIErrorf("failed to update endpoint UUID=%s: %s", endpt.Uuid, err)
@@ -307,13 +443,25 @@ func (m *Manager) eptAPICollect(wt http.ResponseWriter, rq *http.Request) {
 
 	if err := m.eventLogger.CommitTransaction(); err != nil {
 		m.logAPIErrorf("failed to commit event logger transaction: %s", err)
+		failed = true
 	}
 
 	if err := m.detectionLogger.CommitTransaction(); err != nil {
 		m.logAPIErrorf("failed to commit detection logger transaction: %s", err)
+		failed = true
 	}
 	log.Debugf("count Event Received: %d", cnt)
 
+	// endpoint replays the batch, events ingested before the failure
+	// might then be ingested twice but none is lost
+	if failed {
+		http.Error(wt, "Failed to collect events", http.StatusInternalServerError)
+		return
+	}
+
+	// batch is remembered only once fully ingested
+	m.batches.MarkCollected(uuid, batch)
+
 }
 
 // eptAPICommand HTTP handler
@@ -324,7 +472,8 @@ func (m *Manager) eptAPICommand(wt http.ResponseWriter, rq *http.Request) {
 		if endpt := m.eptAPIMutEndpointFromRequest(rq); endpt != nil {
 			// we send back the command to execute only if was not already sent
 			if endpt.Command != nil {
-				if !endpt.Command.Sent {
+				// commands cancelled before being sent are completed
+				if !endpt.Command.Sent && !endpt.Command.Completed {
 					jsonCmd, err := json.Marshal(endpt.Command)
 					if err != nil {
 						m.logAPIErrorf("failed at serializing command to JSON: %s", err)
@@ -333,6 +482,7 @@ func (m *Manager) eptAPICommand(wt http.ResponseWriter, rq *http.Request) {
 					}
 					endpt.Command.Sent = true
 					endpt.Command.SentTime = time.Now()
+					endpt.Command.SetStatus(CmdStatusSent)
 					if err := m.db.InsertOrUpdate(endpt); err != nil {
 						m.logAPIErrorf("failed to update endpoint data: %s", err)
 					}
@@ -372,6 +522,53 @@ func (m *Manager) eptAPICommand(wt http.ResponseWriter, rq *http.Request) {
 	}
 }
 
+// Command acknowledgment HTTP handler
+func (m *Manager) eptAPICommandAck(wt http.ResponseWriter, rq *http.Request) {
+	if endpt := m.eptAPIMutEndpointFromRequest(rq); endpt != nil {
+		rcmd := Command{}
+		if err := readPostAsJSON(rq, &rcmd); err != nil {
+			m.logAPIErrorf("failed to unmarshal acknowledged command: %s", err)
+			http.Error(wt, "", http.StatusBadRequest)
+			return
+		}
+
+		if endpt.Command == nil || endpt.Command.UUID != rcmd.UUID {
+			http.Error(wt, "", http.StatusNotFound)
+			return
+		}
+
+		// acknowledgment may arrive after completion
+		if !endpt.Command.Completed {
+			endpt.Command.SetStatus(CmdStatusRunning)
+			if err := m.db.InsertOrUpdate(endpt); err != nil {
+				m.logAPIErrorf("failed to update endpoint data: %s", err)
+			}
+		}
+	}
+}
+
+// Command cancellation HTTP handler, replies with http.StatusOK if the
+// command has to be cancelled and with http.StatusNoContent otherwise
+func (m *Manager) eptAPICommandCancel(wt http.ResponseWriter, rq *http.Request) {
+	if endpt := m.eptAPIMutEndpointFromRequest(rq); endpt != nil {
+		rcmd := Command{}
+		if err := readPostAsJSON(rq, &rcmd); err != nil {
+			m.logAPIErrorf("failed to unmarshal command to check for cancellation: %s", err)
+			http.Error(wt, "", http.StatusBadRequest)
+			return
+		}
+
+		if endpt.Command == nil || endpt.Command.UUID != rcmd.UUID {
+			http.Error(wt, "", http.StatusNotFound)
+			return
+		}
+
+		if !endpt.Command.CancelRequested {
+			http.Error(wt, "", http.StatusNoContent)
+		}
+	}
+}
+
 // Command HTTP handler
 func (m *Manager) eptAPISystemInfo(wt http.ResponseWriter, rq *http.Request) {
 	switch rq.Method {
@@ -383,6 +580,7 @@ func (m *Manager) eptAPISystemInfo(wt http.ResponseWriter, rq *http.Request) {
 				http.Error(wt, "Failed to unmarshal data", http.StatusInternalServerError)
 			} else {
 				endpt.SystemInfo = &info
+				m.autoAssign.Assign(endpt)
 				m.db.InsertOrUpdate(endpt)
 				if err := m.db.InsertOrUpdate(endpt); err != nil {
 					m.logAPIErrorf("to update endpoint data: %s", err)
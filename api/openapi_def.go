@@ -1235,7 +1235,7 @@ var OpenAPIDefinition = `
           {
             "name": "newkey",
             "in": "query",
-            "description": "Generate a new key for endpoint",
+            "description": "Generate a new key for endpoint, previous key is still accepted during the configured key rotation overlap",
             "required": false,
             "allowEmptyValue": true,
             "schema": {
@@ -1743,10 +1743,20 @@ var OpenAPIDefinition = `
         ],
         "summary": "Retrieve the content of an artifact",
         "parameters": [
+          {
+            "name": "json",
+            "in": "query",
+            "description": "Wrap file content into a JSON response (by default file is downloaded with appropriate Content-Type and Content-Disposition)",
+            "required": false,
+            "allowEmptyValue": true,
+            "schema": {
+              "type": "boolean"
+            }
+          },
           {
             "name": "raw",
             "in": "query",
-            "description": "Retrieve raw file content",
+            "description": "Deprecated, raw=false wraps file content into a JSON response if json is not set",
             "required": false,
             "allowEmptyValue": true,
             "schema": {
@@ -1756,7 +1766,7 @@ var OpenAPIDefinition = `
           {
             "name": "gunzip",
             "in": "query",
-            "description": "Serve gunziped file content",
+            "description": "Serve decompressed file content (gzip or zstd compressed artifacts)",
             "required": false,
             "allowEmptyValue": true,
             "schema": {
@@ -2766,7 +2776,18 @@ var OpenAPIDefinition = `
           {
             "name": "limit",
             "in": "query",
-            "description": "Maximum number of reports to return",
+            "description": "Maximum number of reports to return (default: 1000), bounded by manager configuration. Results are paginated if limit or offset is set",
+            "required": false,
+            "allowEmptyValue": true,
+            "schema": {
+              "type": "integer",
+              "format": "int64"
+            }
+          },
+          {
+            "name": "offset",
+            "in": "query",
+            "description": "Paginate results, offset of the first report to return",
             "required": false,
             "allowEmptyValue": true,
             "schema": {
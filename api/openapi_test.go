@@ -226,7 +226,12 @@ func TestOpenApi(t *testing.T) {
 			openapi.Operation{
 				Method:  "GET",
 				Summary: "List all users",
-				Output:  AdminAPIResponse{},
+				Parameters: []*openapi.Parameter{
+					openapi.QueryParameter(qpGroup, "", "Filter users by group").Skip(),
+					openapi.QueryParameter(qpLimit, 10, "Paginate results, maximum number of users to return").Skip(),
+					openapi.QueryParameter(qpOffset, 0, "Paginate results, offset of the first user to return").Skip(),
+				},
+				Output: AdminAPIResponse{},
 			},
 		)
 
@@ -236,6 +241,7 @@ func TestOpenApi(t *testing.T) {
 				Summary: "Create a new user with identifier",
 				Parameters: []*openapi.Parameter{
 					openapi.QueryParameter(qpIdentifier, "TestAdminUser").Require(),
+					openapi.QueryParameter(qpRole, RoleAdmin, "Role of the user (admin or read-only), defaults to admin").Skip(),
 				},
 				Output: AdminAPIResponse{},
 			},
@@ -254,6 +260,7 @@ func TestOpenApi(t *testing.T) {
 						Key:         "ChangeMe",
 						Description: "Second admin user",
 						Group:       "CSIRT",
+						Role:        RoleAdmin,
 					}, true),
 				Output: AdminAPIResponse{},
 			},
@@ -266,6 +273,7 @@ func TestOpenApi(t *testing.T) {
 				Parameters: []*openapi.Parameter{
 					openapi.PathParameter("uuid", guid),
 					openapi.QueryParameter(qpNewKey, true, "Generate a new random key for user").Skip(),
+					openapi.QueryParameter(qpDisabled, false, "Disable (true) or enable (false) user, disabled users cannot access the API").Skip(),
 				},
 				RequestBody: openapi.JsonRequestBody(
 					"Data to update user with",
@@ -273,6 +281,7 @@ func TestOpenApi(t *testing.T) {
 						Key:         "NewWeakKey",
 						Description: "Second admin user changed",
 						Group:       "SOC",
+						Role:        RoleReadOnly,
 					}, true),
 			})
 
@@ -306,6 +315,8 @@ func TestOpenApiEndpointManagement(t *testing.T) {
 				openapi.QueryParameter(qpGroup, "", "Filter by group"),
 				openapi.QueryParameter(qpStatus, "", "Filter by status"),
 				openapi.QueryParameter(qpCriticality, 0, "Filter by criticality"),
+				openapi.QueryParameter(qpLimit, 10, "Paginate results, maximum number of endpoints to return").Skip(),
+				openapi.QueryParameter(qpOffset, 0, "Paginate results, offset of the first endpoint to return").Skip(),
 			},
 			Output: AdminAPIResponse{},
 		})
@@ -331,7 +342,8 @@ func TestOpenApiEndpointManagement(t *testing.T) {
 			Parameters: []*openapi.Parameter{
 				openapi.PathParameter("uuid", cconf.UUID),
 				openapi.QueryParameter(qpShowKey, true, "Show endpoint key in response").Skip(),
-				openapi.QueryParameter(qpNewKey, true, "Generate a new key for endpoint").Skip(),
+				openapi.QueryParameter(qpNewKey, true, "Generate a new key for endpoint, previous key is still accepted during the configured key rotation overlap").Skip(),
+				openapi.QueryParameter(qpAutoGroup, true, "Clear manual group of endpoint and apply auto-assign rules").Skip(),
 			},
 			RequestBody: openapi.JsonRequestBody(
 				"Fields to modify. NB: Not all the fields can be modified",
@@ -357,6 +369,29 @@ func TestOpenApiEndpointManagement(t *testing.T) {
 	runAdminApiTest(t, f)
 }
 
+func TestOpenApiEndpointDecommission(t *testing.T) {
+	f := func(t *testing.T) {
+
+		endpointPath := openapi.PathItem{
+			Summary: "Endpoint Management",
+			Value:   AdmAPIEndpointsPath,
+		}
+
+		openAPI.Do(endpointPath, openapi.Operation{
+			Method: "POST",
+			Summary: `Decommission an endpoint, deleting it and purging its events, detections,
+			archived reports, suppressions and artifacts. Returns a summary of the data purged.`,
+			Parameters: []*openapi.Parameter{
+				openapi.PathParameter("uuid", cconf.UUID).Suffix(AdmAPIDecommissionSuffix),
+				openapi.QueryParameter(qpConfirm, cconf.UUID, "UUID of the endpoint, confirming decommission"),
+			},
+			Output: AdminAPIResponse{},
+		})
+	}
+
+	runAdminApiTest(t, f)
+}
+
 func TestOpenApiEndpointCommands(t *testing.T) {
 	f := func(t *testing.T) {
 
@@ -375,7 +410,12 @@ func TestOpenApiEndpointCommands(t *testing.T) {
 				`Command to be executed. One can also specify files 
 				to drop from the manager to the endpoint prior to command execution 
 				and files to fetch after execution. A timeout for the can also 
-				be specified, if zero there will be no timeout.`,
+				be specified, if zero there will be no timeout. A command type
+				can be set to run structured commands implemented by the endpoint
+				(isolate, unisolate, memdump, sysinfo), in this case the command line is ignored.
+				Memdump command requires a target (PID, process GUID or image) and
+				dumps are made available through the artifacts API. Sysinfo command
+				returns a structured host profile as command JSON output.`,
 				CommandAPI{CommandLine: `printf "Hello World"`},
 				true),
 			Output: AdminAPIResponse{},
@@ -410,6 +450,36 @@ func TestOpenApiEndpointCommands(t *testing.T) {
 			},
 			Output: AdminAPIResponse{},
 		})
+
+		// the command above has completed so a long running one is sent
+		// for the endpoint to have a command to cancel
+		if r := post(format("%s/%s%s", AdmAPIEndpointsPath, cconf.UUID, AdmAPICommandSuffix), JSON(CommandAPI{CommandLine: "sleep 10"})); r.Error != "" {
+			t.Error(r.Error)
+			t.FailNow()
+		}
+
+		openAPI.Do(endpointPath, openapi.Operation{
+			Method: "DELETE",
+			Summary: `Cancel the command of the endpoint. A command not sent yet is cancelled
+			immediately, otherwise the endpoint stops the command line it runs (and its
+			process tree) and reports the command as cancelled.`,
+			Parameters: []*openapi.Parameter{
+				openapi.PathParameter("uuid", cconf.UUID).Suffix(AdmAPICommandSuffix),
+			},
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(openapi.PathItem{
+			Summary: "Endpoints commands",
+			Value:   AdmAPIEndpointsCommandsPath,
+		}, openapi.Operation{
+			Method:  "GET",
+			Summary: "List the commands of all endpoints not completed yet",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(qpAll, false, "List completed commands as well"),
+			},
+			Output: AdminAPIResponse{},
+		})
 	}
 
 	runAdminApiTest(t, f)
@@ -435,6 +505,22 @@ func TestOpenApiReports(t *testing.T) {
 			Output:  AdminAPIResponse{},
 		})
 
+		fleetReportPath := openapi.PathItem{
+			Summary: sum,
+			Value:   AdmAPIEndpointsFleetReportPath,
+		}
+
+		openAPI.Do(fleetReportPath, openapi.Operation{
+			Method: "GET",
+			Summary: `Get a fleet wide summary of detection reports (highest scoring endpoints,
+			signature frequency, score distribution)`,
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(qpGroup, "", "Filter endpoints by group"),
+				openapi.QueryParameter(qpLimit, DefaultFleetReportTop, "Number of highest scoring endpoints to return"),
+			},
+			Output: AdminAPIResponse{},
+		})
+
 		openAPI.Do(endpointsPath, openapi.Operation{
 			Method:  "GET",
 			Summary: "Retrieve report for a single endpoint",
@@ -460,12 +546,40 @@ func TestOpenApiReports(t *testing.T) {
 				openapi.QueryParameter(qpSince, time.Now().Format(time.RFC3339), "Retrieve report since date (RFC3339)"),
 				openapi.QueryParameter(qpUntil, time.Now().Format(time.RFC3339), "Retrieve report until date (RFC3339)"),
 				openapi.QueryParameter(qpLast, "1d", "Return last reports from duration (ex: `1d` for last day)"),
-				openapi.QueryParameter(qpLimit, 42, "Maximum number of reports to return"),
+				openapi.QueryParameter(qpLimit, 42, `Maximum number of reports to return (default: 1000), bounded by
+					manager configuration. Results are paginated if limit or offset is set`).Skip(),
+				openapi.QueryParameter(qpOffset, 0, "Paginate results, offset of the first report to return").Skip(),
 				openapi.PathParameter("uuid",
 					cconf.UUID).Suffix(AdmAPIReportSuffix).Suffix(AdmAPIArchiveSuffix)},
 			Output: AdminAPIResponse{},
 		})
 
+		openAPI.Do(endpointsPath, openapi.Operation{
+			Method: "GET",
+			Summary: `Get the score history of an endpoint, sampled from archived reports,
+			along with the top rules contributing to the score at each sample`,
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(qpSince, time.Now().Format(time.RFC3339), "Retrieve history since date (RFC3339)"),
+				openapi.QueryParameter(qpUntil, time.Now().Format(time.RFC3339), "Retrieve history until date (RFC3339)"),
+				openapi.QueryParameter(qpLast, "1d", "Return history from duration (ex: `1d` for last day)"),
+				openapi.QueryParameter(qpStep, "1h", "Keep only the most recent sample of every step long interval"),
+				openapi.QueryParameter(qpTop, DefaultScoreHistoryTop, "Number of top rules returned at each sample"),
+				openapi.PathParameter("uuid",
+					cconf.UUID).Suffix(AdmAPIReportSuffix).Suffix(AdmAPIHistorySuffix)},
+			Output: AdminAPIResponse{},
+		})
+
+		// reports of all the endpoints are reset last not to reset
+		// the report of the single endpoint deleted above
+		openAPI.Do(reportsPath, openapi.Operation{
+			Method: "DELETE",
+			Summary: `Archive and reset detection reports of all endpoints, or of a group,
+			an archived report is created for every endpoint reset (admin role required)`,
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(qpGroup, "", "Reset reports only of endpoints in group"),
+			},
+			Output: AdminAPIResponse{},
+		})
 	}
 
 	runAdminApiTest(t, f)
@@ -492,6 +606,7 @@ func TestOpenApiLogs(t *testing.T) {
 				openapi.QueryParameter(qpDelta, "5m", "Delta duration used to pivot (ex: `5m` to get logs 5min around pivot) "),
 				openapi.QueryParameter(qpLimit, 2, "Maximum number of reports to return"),
 				openapi.QueryParameter(qpSkip, 0, "Skip number of events").Skip(),
+				openapi.QueryParameter(qpTimeBasis, "event", "Timestamp used to search events: event (generation time on the endpoint) or receipt (time received by the manager)"),
 				openapi.PathParameter("uuid",
 					cconf.UUID).Suffix(AdmAPILogsSuffix)},
 			Output: AdminAPIResponse{},
@@ -499,7 +614,7 @@ func TestOpenApiLogs(t *testing.T) {
 
 		openAPI.Do(logsPath, openapi.Operation{
 			Method:  "GET",
-			Summary: "Retrieve detections logs",
+			Summary: "Retrieve detections logs (each event comes with a flattened detection summary)",
 			Parameters: []*openapi.Parameter{
 				openapi.QueryParameter(qpSince, nowStr, "Retrieve logs since date (RFC3339)").Skip(),
 				openapi.QueryParameter(qpUntil, nowStr, "Retrieve logs until date (RFC3339)").Skip(),
@@ -508,6 +623,7 @@ func TestOpenApiLogs(t *testing.T) {
 				openapi.QueryParameter(qpDelta, "5m", "Delta duration used to pivot (ex: `5m` to get logs 5min around pivot) "),
 				openapi.QueryParameter(qpLimit, 2, "Maximum number of reports to return"),
 				openapi.QueryParameter(qpSkip, 0, "Skip number of events").Skip(),
+				openapi.QueryParameter(qpTimeBasis, "event", "Timestamp used to search events: event (generation time on the endpoint) or receipt (time received by the manager)"),
 				openapi.PathParameter("uuid",
 					cconf.UUID).Suffix(AdmAPIDetectionSuffix),
 			},
@@ -539,7 +655,11 @@ func TestOpenApiArtifacts(t *testing.T) {
 			Method:  "GET",
 			Summary: "Artifacts on all endpoints",
 			Parameters: []*openapi.Parameter{
-				openapi.QueryParameter(qpSince, nowStr, "Retrieve artifacts received since date (RFC3339)").Skip()},
+				openapi.QueryParameter(qpSince, nowStr, "Retrieve artifacts received since date (RFC3339)").Skip(),
+				openapi.QueryParameter(qpLimit, 10, "Paginate results, maximum number of endpoints to return artifacts for").Skip(),
+				openapi.QueryParameter(qpOffset, 0, "Paginate results, offset of the first endpoint to return artifacts for").Skip(),
+				openapi.QueryParameter(qpCursor, true, "Wrap results with a cursor to pass as since parameter of the next query").Skip(),
+			},
 			Output: AdminAPIResponse{},
 		})
 
@@ -548,6 +668,9 @@ func TestOpenApiArtifacts(t *testing.T) {
 			Summary: "Artifacts for a single endpoint",
 			Parameters: []*openapi.Parameter{
 				openapi.QueryParameter(qpSince, nowStr, "Retrieve artifacts received since date (RFC3339)").Skip(),
+				openapi.QueryParameter(qpLimit, 10, "Paginate results, maximum number of artifacts to return").Skip(),
+				openapi.QueryParameter(qpOffset, 0, "Paginate results, offset of the first artifact to return").Skip(),
+				openapi.QueryParameter(qpCursor, true, "Wrap results with a cursor to pass as since parameter of the next query").Skip(),
 				openapi.PathParameter("uuid", cconf.UUID).Suffix(AdmAPIArticfactsSuffix),
 			},
 			Output: AdminAPIResponse{},
@@ -557,8 +680,10 @@ func TestOpenApiArtifacts(t *testing.T) {
 			Method:  "GET",
 			Summary: "Retrieve the content of an artifact",
 			Parameters: []*openapi.Parameter{
-				openapi.QueryParameter(qpRaw, false, "Retrieve raw file content").Skip(),
-				openapi.QueryParameter(qpGunzip, false, "Serve gunziped file content").Skip(),
+				openapi.QueryParameter(qpJSON, true, `Wrap file content into a JSON response
+					(by default file is downloaded with appropriate Content-Type and Content-Disposition)`),
+				openapi.QueryParameter(qpRaw, true, "Deprecated, raw=false wraps file content into a JSON response if json is not set").Skip(),
+				openapi.QueryParameter(qpGunzip, false, "Serve decompressed file content (gzip or zstd compressed artifacts)").Skip(),
 				openapi.PathParameter("uuid", cconf.UUID).Suffix(AdmAPIArticfactsSuffix),
 				openapi.PathParameter("pguid", guid),
 				openapi.PathParameter("ehash", eventHash),
@@ -635,6 +760,57 @@ func TestOpenApiIoCs(t *testing.T) {
 	runAdminApiTest(t, f)
 }
 
+func TestOpenApiContainers(t *testing.T) {
+	f := func(t *testing.T) {
+
+		containersPath := openapi.PathItem{
+			Summary: "Containers Management (Gene containers pushed on Endpoints)",
+			Value:   AdmAPIContainersPath,
+		}
+		name := "blacklist"
+		entries := []string{"some.random.domain", "A35C86CCD3E26316C45A0E63EFA9CDD1E9D3B01B23F7829EAD9106FA5340066D"}
+
+		openAPI.Do(containersPath, openapi.Operation{
+			Method: "POST",
+			Summary: `Add entries to a container (created if needed), containers are pushed
+			on endpoints along with rules`,
+			Parameters: []*openapi.Parameter{
+				openapi.PathParameter("name", name),
+			},
+			RequestBody: openapi.JsonRequestBody("Entries to add to the container", entries, true),
+			Output:      AdminAPIResponse{},
+		})
+
+		openAPI.Do(containersPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "List containers managed by the manager",
+			Output:  AdminAPIResponse{},
+		})
+
+		openAPI.Do(containersPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get a container",
+			Parameters: []*openapi.Parameter{
+				openapi.PathParameter("name", name),
+			},
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(containersPath, openapi.Operation{
+			Method:  "DELETE",
+			Summary: "Delete entries from a container or the whole container if no entry is given",
+			Parameters: []*openapi.Parameter{
+				openapi.PathParameter("name", name),
+			},
+			RequestBody: openapi.JsonRequestBody("Entries to delete from the container", entries[:1], false),
+			Output:      AdminAPIResponse{},
+		})
+
+	}
+
+	runAdminApiTest(t, f)
+}
+
 func TestOpenApiRules(t *testing.T) {
 	f := func(t *testing.T) {
 
@@ -650,6 +826,9 @@ func TestOpenApiRules(t *testing.T) {
 			Summary: "Add or modify a rule",
 			Parameters: []*openapi.Parameter{
 				openapi.QueryParameter(qpUpdate, true, "Update rule if already existing"),
+				openapi.QueryParameter(qpConflict, RuleConflictUpdate, `Behavior when a rule already exists: abort (nothing loaded),
+				update (rule replaced) or reject (only conflicting rules rejected, the others loaded).
+				When set, a summary of the rules added, updated and rejected is returned.`).Skip(),
 			},
 			RequestBody: openapi.JsonRequestBody(
 				"Rule to add to the manager",
@@ -684,12 +863,150 @@ func TestOpenApiRules(t *testing.T) {
 			Output: AdminAPIResponse{},
 		})
 
+		openAPI.Do(openapi.PathItem{
+			Summary: "Test a rule against stored events",
+			Value:   AdmAPIRulesTestPath,
+		}, openapi.Operation{
+			Method: "POST",
+			Summary: `Compile a rule in an engine independent from the live one and run it against
+			the events stored for an endpoint, or the whole fleet. Nothing is persisted.`,
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(qpEndpointUuid, "", "Endpoint to test the rule against (default: all endpoints)").Skip(),
+				openapi.QueryParameter(qpSince, time.Now().Format(time.RFC3339), "Test against events generated since date (RFC3339)").Skip(),
+				openapi.QueryParameter(qpUntil, time.Now().Format(time.RFC3339), "Test against events generated until date (RFC3339)").Skip(),
+				openapi.QueryParameter(qpLast, "1h", "Test against events generated during the last period (default: 1h)"),
+				openapi.QueryParameter(qpLimit, 1000, "Maximum number of matches returned"),
+			},
+			RequestBody: openapi.JsonRequestBody(
+				"Rule to test",
+				engine.Rule{
+					Name: name,
+					Meta: engine.MetaSection{
+						Events:      map[string][]int64{"Microsoft-Windows-Sysmon/Operational": {1}},
+						Criticality: 10,
+						Schema:      engine.ParseVersion("2.0.0"),
+					},
+					Matches:   []string{fmt.Sprintf("$foo: Image ~= '%s'", `C:\\Malware.exe`)},
+					Condition: "$foo",
+				},
+				true),
+			Output: AdminAPIResponse{},
+		})
+
+		suppressionsPath := openapi.PathItem{
+			Summary: "Detections suppression (false positives feedback deployed on endpoints)",
+			Value:   AdmAPISuppressionsPath,
+		}
+
+		// suppression identifier is generated by the manager
+		var suuid string
+		openAPI.Do(suppressionsPath, openapi.Operation{
+			Method: "PUT",
+			Summary: `Suppress detections of a rule for a process image and/or hash, on a single
+			endpoint or on all endpoints if none is given. An existing suppression applying to the
+			same detections is updated.`,
+			RequestBody: openapi.JsonRequestBody("Suppression to record",
+				DetectionSuppression{
+					Rule:    name,
+					Image:   `C:\Windows\System32\benign.exe`,
+					Comment: "false positive",
+				}, true),
+			Output: AdminAPIResponse{},
+			Validate: func(output interface{}) (err error) {
+				var data []byte
+				var resp struct {
+					Data DetectionSuppression `json:"data"`
+				}
+
+				if err = validateOperation(output); err != nil {
+					return
+				}
+				if data, err = json.Marshal(output); err != nil {
+					return
+				}
+				if err = json.Unmarshal(data, &resp); err != nil {
+					return
+				}
+				suuid = resp.Data.Uuid
+				return
+			},
+		})
+
+		openAPI.Do(suppressionsPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "List detection suppressions",
+			Output:  AdminAPIResponse{},
+		})
+
+		// AdmAPISuppressionByIDPath
+		openAPI.Do(suppressionsPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get a detection suppression",
+			Parameters: []*openapi.Parameter{
+				openapi.PathParameter("uuid", suuid),
+			},
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(suppressionsPath, openapi.Operation{
+			Method:  "DELETE",
+			Summary: "Delete a detection suppression",
+			Parameters: []*openapi.Parameter{
+				openapi.PathParameter("uuid", suuid),
+			},
+			Output: AdminAPIResponse{},
+		})
+
+		shadowPath := openapi.PathItem{
+			Summary: sum,
+			Value:   AdmAPIShadowRulesPath,
+		}
+
+		openAPI.Do(shadowPath, openapi.Operation{
+			Method: "POST",
+			Summary: `Replace the shadow rule set, a complete rule set evaluated by endpoints
+			along with the active one. Shadow detections are forwarded tagged as such and
+			never trigger actions.`,
+			RequestBody: openapi.JsonRequestBody(
+				"Shadow rule set",
+				[]engine.Rule{
+					{
+						Name: name,
+						Meta: engine.MetaSection{
+							Events:      map[string][]int64{"Microsoft-Windows-Sysmon/Operational": {1}},
+							Criticality: 8,
+							Schema:      engine.ParseVersion("2.0.0"),
+						},
+						Matches:   []string{fmt.Sprintf("$foo: Image ~= '%s'", `C:\\Malware.exe`)},
+						Condition: "$foo",
+					},
+				},
+				true),
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(shadowPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get shadow rules",
+			Parameters: []*openapi.Parameter{
+				openapi.QueryParameter(qpName, name, "Regex matching the names of the rules to retrieve"),
+			},
+			Output: AdminAPIResponse{},
+		})
+
+		openAPI.Do(shadowPath, openapi.Operation{
+			Method:  "DELETE",
+			Summary: "Delete all shadow rules, stopping shadow evaluation on endpoints",
+			Output:  AdminAPIResponse{},
+		})
+
 		openAPI.Do(rulesPath, openapi.Operation{
 			Method:  "DELETE",
 			Summary: "Delete rules from manager",
 			Parameters: []*openapi.Parameter{
-				openapi.QueryParameter(qpName, name, `Name of the rule to delete. To avoid mistakes, this
-				parameter cannot be a regex.`),
+				openapi.QueryParameter(qpName, name, "Comma separated list of rule names to delete"),
+				openapi.QueryParameter(qpRegex, "", `Regex matching the names of the rules to delete,
+				takes precedence over name parameter`),
 			},
 			Output: AdminAPIResponse{},
 		})
@@ -826,6 +1143,31 @@ func TestOpenApiStatistics(t *testing.T) {
 			Output:  AdminAPIResponse{},
 		})
 
+		configPath := openapi.PathItem{
+			Summary: "Manager configuration",
+			Value:   AdmAPIConfigPath,
+		}
+
+		openAPI.Do(configPath, openapi.Operation{
+			Method:  "GET",
+			Summary: "Get manager configuration, only runtime settings can be changed without restart",
+			Output:  AdminAPIResponse{},
+		})
+
+		verbose := true
+		workers := 16
+		openAPI.Do(configPath, openapi.Operation{
+			Method:  "POST",
+			Summary: "Update manager runtime settings, changes are persisted into the configuration file",
+			RequestBody: openapi.JsonRequestBody(
+				"Runtime settings to update, settings not present are left unchanged",
+				ManagerRuntimeConfig{
+					VerboseHTTP:      &verbose,
+					IngestionWorkers: &workers,
+				}, true),
+			Output: AdminAPIResponse{},
+		})
+
 	}
 
 	runAdminApiTest(t, f)
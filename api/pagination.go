@@ -0,0 +1,79 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Page structure used to return paginated results from the admin API
+type Page struct {
+	Total int `json:"total"`
+	Count int `json:"count"`
+	// NextOffset is the offset to use to retrieve next page, 0 if there is
+	// no more result to retrieve
+	NextOffset int         `json:"next-offset"`
+	Items      interface{} `json:"items"`
+}
+
+type pagination struct {
+	enabled bool
+	offset  int
+	limit   int
+}
+
+// parsePagination parses pagination parameters. Pagination is enabled only
+// if at least one of the limit or offset parameters is present.
+func parsePagination(rq *http.Request) (p pagination, err error) {
+	pLimit := rq.URL.Query().Get(qpLimit)
+	pOffset := rq.URL.Query().Get(qpOffset)
+
+	if pLimit != "" {
+		if p.limit, err = strconv.Atoi(pLimit); err != nil || p.limit < 0 {
+			return p, fmt.Errorf("failed to parse limit parameter, it must be a positive integer")
+		}
+		p.enabled = true
+	}
+
+	if pOffset != "" {
+		if p.offset, err = strconv.Atoi(pOffset); err != nil || p.offset < 0 {
+			return p, fmt.Errorf("failed to parse offset parameter, it must be a positive integer")
+		}
+		p.enabled = true
+	}
+
+	return
+}
+
+// bounds returns the bounds of the page to return given the
+// total number of items
+func (p pagination) bounds(total int) (start, stop int) {
+	start, stop = 0, total
+
+	if !p.enabled {
+		return
+	}
+
+	start = p.offset
+	if start > total {
+		start = total
+	}
+
+	if p.limit > 0 && start+p.limit < total {
+		stop = start + p.limit
+	}
+
+	return
+}
+
+// page builds a Page out of items, items must be the result
+// of slicing the complete result set with bounds
+func (p pagination) page(items interface{}, total, start, stop int) (page Page) {
+	page.Total = total
+	page.Count = stop - start
+	page.Items = items
+	if stop < total {
+		page.NextOffset = stop
+	}
+	return
+}
@@ -6,6 +6,7 @@ const (
 	qpStatus      = "status"
 	qpShowKey     = "showkey"
 	qpNewKey      = "newkey"
+	qpRole        = "role"
 	qpCriticality = "criticality"
 	qpWait        = "wait"
 	qpSince       = "since"
@@ -15,16 +16,32 @@ const (
 	qpPivot       = "pivot"
 	qpDelta       = "delta"
 	qpSkip        = "skip"
+	qpOffset      = "offset"
 	qpSource      = "source"
 	qpValue       = "value"
 	qpType        = "type"
 	qpName        = "name"
+	qpRegex       = "regex"
 	qpFilters     = "filters"
 	qpUpdate      = "update"
+	qpConflict    = "conflict"
 	qpRaw         = "raw"
 	qpGunzip      = "gunzip"
+	qpJSON        = "json"
 	qpUuid        = "uuid"
 	qpGroupUuid   = "guuid"
 	qpFormat      = "format"
 	qpVersion     = "version"
+	qpCursor      = "cursor"
+	qpStep        = "step"
+	qpTop         = "top"
+	qpDisabled    = "disabled"
+	qpTimeBasis   = "time-basis"
+	qpAutoGroup   = "autogroup"
+	qpAll         = "all"
+	qpConfirm     = "confirm"
+	// stream filters
+	qpEndpointUuid   = "euuid"
+	qpRule           = "rule"
+	qpMinCriticality = "min-criticality"
 )
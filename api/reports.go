@@ -1,14 +1,188 @@
 package api
 
 import (
+	"sort"
 	"time"
 
+	"github.com/0xrawsec/gene/v2/engine"
 	"github.com/0xrawsec/gene/v2/reducer"
 	"github.com/0xrawsec/sod"
 )
 
+const (
+	// DefaultFleetReportTop default number of top endpoints in fleet report
+	DefaultFleetReportTop = 10
+	// width of the bounded score buckets in fleet report
+	fleetScoreBucketWidth = 10
+	// DefaultScoreHistoryTop default number of top rules reported at each score history sample
+	DefaultScoreHistoryTop = 5
+)
+
 type ArchivedReport struct {
 	sod.Item
 	reducer.ReducedStats
 	ArchivedTimestamp time.Time `json:"archived-time"`
 }
+
+// EndpointScore summarizes the report of an endpoint
+type EndpointScore struct {
+	Uuid         string  `json:"uuid"`
+	Hostname     string  `json:"hostname"`
+	Group        string  `json:"group"`
+	AlertCount   int     `json:"alert-count"`
+	Score        int     `json:"score"`
+	BoundedScore float64 `json:"bounded-score"`
+}
+
+// ScoreBucket counts endpoints having a bounded score in [Min; Max[
+type ScoreBucket struct {
+	Min   int `json:"min"`
+	Max   int `json:"max"`
+	Count int `json:"count"`
+}
+
+// FleetReport aggregates reports of several endpoints
+type FleetReport struct {
+	EndpointCount     int             `json:"endpoint-count"`
+	AlertCount        int             `json:"alert-count"`
+	TopEndpoints      []EndpointScore `json:"top-endpoints"`
+	CntBySig          map[string]int  `json:"count-by-signature"`
+	CntByCriticality  map[int]int     `json:"count-by-criticality"`
+	ScoreDistribution []ScoreBucket   `json:"score-distribution"`
+}
+
+// NewFleetReport creates a new empty FleetReport
+func NewFleetReport() *FleetReport {
+	r := &FleetReport{
+		TopEndpoints:      make([]EndpointScore, 0),
+		CntBySig:          make(map[string]int),
+		CntByCriticality:  make(map[int]int),
+		ScoreDistribution: make([]ScoreBucket, 0, 100/fleetScoreBucketWidth),
+	}
+
+	for min := 0; min < 100; min += fleetScoreBucketWidth {
+		r.ScoreDistribution = append(r.ScoreDistribution, ScoreBucket{Min: min, Max: min + fleetScoreBucketWidth})
+	}
+
+	return r
+}
+
+// Update updates fleet report with the report of an endpoint. Engine is used
+// to retrieve the criticality of the signatures, it can be nil.
+func (r *FleetReport) Update(endpt *Endpoint, rs *reducer.ReducedStats, e *engine.Engine) {
+	r.EndpointCount++
+
+	if rs == nil {
+		rs = &reducer.ReducedStats{}
+	}
+
+	r.AlertCount += rs.CntAlerts
+
+	r.TopEndpoints = append(r.TopEndpoints, EndpointScore{
+		Uuid:         endpt.Uuid,
+		Hostname:     endpt.Hostname,
+		Group:        endpt.Group,
+		AlertCount:   rs.CntAlerts,
+		Score:        rs.Score,
+		BoundedScore: rs.BoundedScore,
+	})
+
+	for sig, cnt := range rs.CntBySig {
+		r.CntBySig[sig] += cnt
+		if e != nil {
+			if cr := e.GetCRuleByName(sig); cr != nil {
+				r.CntByCriticality[cr.Criticality] += cnt
+			}
+		}
+	}
+
+	i := int(rs.BoundedScore) / fleetScoreBucketWidth
+	// bounded score of 100 goes to the last bucket
+	if i >= len(r.ScoreDistribution) {
+		i = len(r.ScoreDistribution) - 1
+	}
+	if i >= 0 {
+		r.ScoreDistribution[i].Count++
+	}
+}
+
+// Top keeps only the n highest scoring endpoints
+func (r *FleetReport) Top(n int) {
+	sort.SliceStable(r.TopEndpoints, func(i, j int) bool {
+		return r.TopEndpoints[i].Score > r.TopEndpoints[j].Score
+	})
+
+	if n >= 0 && n < len(r.TopEndpoints) {
+		r.TopEndpoints = r.TopEndpoints[:n]
+	}
+}
+
+// RuleCount number of times a rule matched
+type RuleCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// ScoreSample score of an endpoint at a given time
+type ScoreSample struct {
+	Timestamp    time.Time   `json:"timestamp"`
+	AlertCount   int         `json:"alert-count"`
+	Score        int         `json:"score"`
+	BoundedScore float64     `json:"bounded-score"`
+	TopRules     []RuleCount `json:"top-rules"`
+}
+
+// ScoreHistory time series of an endpoint score, sorted from the oldest to
+// the most recent sample
+type ScoreHistory struct {
+	Count   int           `json:"count"`
+	Samples []ScoreSample `json:"samples"`
+}
+
+func topRules(cntBySig map[string]int, n int) []RuleCount {
+	rules := make([]RuleCount, 0, len(cntBySig))
+	for name, cnt := range cntBySig {
+		rules = append(rules, RuleCount{name, cnt})
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Count == rules[j].Count {
+			return rules[i].Name < rules[j].Name
+		}
+		return rules[i].Count > rules[j].Count
+	})
+
+	if n >= 0 && n < len(rules) {
+		rules = rules[:n]
+	}
+	return rules
+}
+
+// NewScoreHistory builds a score history out of archived reports. If step is
+// not zero, only the most recent report of every step long interval is kept.
+// At each sample the top rules contributing to the score are reported.
+func NewScoreHistory(reports []*ArchivedReport, step time.Duration, top int) (h ScoreHistory) {
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].ArchivedTimestamp.Before(reports[j].ArchivedTimestamp)
+	})
+
+	h.Samples = make([]ScoreSample, 0, len(reports))
+	for i, r := range reports {
+		// a more recent report exists in the same interval
+		if step > 0 && i+1 < len(reports) &&
+			r.ArchivedTimestamp.Truncate(step).Equal(reports[i+1].ArchivedTimestamp.Truncate(step)) {
+			continue
+		}
+
+		h.Samples = append(h.Samples, ScoreSample{
+			Timestamp:    r.ArchivedTimestamp,
+			AlertCount:   r.CntAlerts,
+			Score:        r.Score,
+			BoundedScore: r.BoundedScore,
+			TopRules:     topRules(r.CntBySig, top),
+		})
+	}
+	h.Count = len(h.Samples)
+
+	return
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/whids/logger"
+)
+
+const (
+	// DefaultRetentionInterval default interval at which retention is enforced
+	DefaultRetentionInterval = time.Hour
+)
+
+// RetentionRule overrides the retention of the endpoints matching it
+type RetentionRule struct {
+	Endpoint string        `toml:"endpoint" comment:"UUID of the endpoint the rule applies to"`
+	Group    string        `toml:"group" comment:"Group of the endpoints the rule applies to, case insensitive"`
+	MaxAge   time.Duration `toml:"max-age" comment:"Events and detections older than max-age are deleted (0: kept forever)"`
+	MaxSize  int64         `toml:"max-size" comment:"Maximum size (in bytes) of the events, and of the detections, stored (0: unlimited)"`
+}
+
+// Match returns true if the rule applies to endpoint
+func (r *RetentionRule) Match(uuid string, endpt *Endpoint) bool {
+	if r.Endpoint != "" && r.Endpoint != uuid {
+		return false
+	}
+	if r.Group != "" && (endpt == nil || !strings.EqualFold(r.Group, endpt.Group)) {
+		return false
+	}
+	return true
+}
+
+// RetentionConfig configures the retention of the events, detections and
+// archived reports stored by the manager
+type RetentionConfig struct {
+	MaxAge        time.Duration   `toml:"max-age" comment:"Events and detections older than max-age are deleted (0: kept forever)"`
+	MaxSize       int64           `toml:"max-size" comment:"Maximum size (in bytes) of the events, and of the detections, stored per endpoint\n the oldest ones being deleted first (0: unlimited)"`
+	ReportsMaxAge time.Duration   `toml:"reports-max-age" comment:"Archived reports older than reports-max-age are deleted (0: kept forever)"`
+	Interval      time.Duration   `toml:"interval" comment:"Interval at which retention is enforced and storage usage computed (default: 1h)"`
+	Endpoints     []RetentionRule `toml:"endpoints" comment:"Retention of specific endpoints, the first rule matching an endpoint applies"`
+}
+
+// Verify validates retention configuration
+func (c *RetentionConfig) Verify() error {
+	if c.MaxAge < 0 || c.MaxSize < 0 || c.ReportsMaxAge < 0 || c.Interval < 0 {
+		return fmt.Errorf("retention settings must be positive")
+	}
+
+	for _, r := range c.Endpoints {
+		if r.Endpoint == "" && r.Group == "" {
+			return fmt.Errorf("retention rule must have an endpoint or a group")
+		}
+		if r.MaxAge < 0 || r.MaxSize < 0 {
+			return fmt.Errorf("retention settings must be positive")
+		}
+	}
+
+	return nil
+}
+
+func (c *RetentionConfig) interval() time.Duration {
+	if c.Interval <= 0 {
+		return DefaultRetentionInterval
+	}
+	return c.Interval
+}
+
+// policy returns the maximum age and size of the events of an endpoint
+func (c *RetentionConfig) policy(uuid string, endpt *Endpoint) (maxAge time.Duration, maxSize int64) {
+	for _, r := range c.Endpoints {
+		if r.Match(uuid, endpt) {
+			return r.MaxAge, r.MaxSize
+		}
+	}
+	return c.MaxAge, c.MaxSize
+}
+
+// StorageStats reports the storage used by the events and detections
+type StorageStats struct {
+	Events       int64            `json:"events"`
+	Detections   int64            `json:"detections"`
+	Endpoints    map[string]int64 `json:"endpoints"`
+	RemovedBytes int64            `json:"removed-bytes"`
+	Updated      time.Time        `json:"updated"`
+}
+
+type storageUsage struct {
+	sync.RWMutex
+	stats StorageStats
+}
+
+func (u *storageUsage) Stats() (s StorageStats) {
+	u.RLock()
+	defer u.RUnlock()
+
+	s = u.stats
+	s.Endpoints = make(map[string]int64, len(u.stats.Endpoints))
+	for k, v := range u.stats.Endpoints {
+		s.Endpoints[k] = v
+	}
+	return
+}
+
+func (u *storageUsage) update(s StorageStats) {
+	u.Lock()
+	defer u.Unlock()
+
+	s.RemovedBytes += u.stats.RemovedBytes
+	u.stats = s
+}
+
+// enforceRetention prunes the events and detections of every endpoint
+// according to its retention policy, deletes expired archived reports
+// and computes storage usage
+func (m *Manager) enforceRetention() {
+	c := m.Config.Retention
+	now := time.Now()
+	s := StorageStats{Endpoints: make(map[string]int64), Updated: now.UTC()}
+
+	stores := []struct {
+		logger *logger.EventLogger
+		size   *int64
+	}{
+		{m.eventLogger, &s.Events},
+		{m.detectionLogger, &s.Detections},
+	}
+
+	for _, store := range stores {
+		keys, err := store.logger.Keys()
+		if err != nil {
+			log.Errorf("Failed to list logfiles: %s", err)
+			continue
+		}
+
+		for _, uuid := range keys {
+			var before time.Time
+
+			endpt, _ := m.MutEndpoint(uuid)
+			maxAge, maxSize := c.policy(uuid, endpt)
+			if maxAge > 0 {
+				before = now.Add(-maxAge)
+			}
+
+			r, err := store.logger.Prune(uuid, before, maxSize)
+			if err != nil {
+				log.Errorf("Failed to enforce retention of endpoint %s: %s", uuid, err)
+				r.Size = store.logger.Size(uuid)
+			}
+
+			if r.RemovedDirs > 0 {
+				log.Infof("Retention removed %d bytes of logfiles of endpoint %s", r.RemovedBytes, uuid)
+			}
+
+			*store.size += r.Size
+			s.Endpoints[uuid] += r.Size
+			s.RemovedBytes += r.RemovedBytes
+		}
+	}
+
+	if c.ReportsMaxAge > 0 {
+		search := m.db.Search(&ArchivedReport{}, "ArchivedTimestamp", "<", now.Add(-c.ReportsMaxAge))
+		if err := search.Delete(); err != nil {
+			log.Errorf("Failed to delete expired archived reports: %s", err)
+		}
+	}
+
+	m.storage.update(s)
+}
+
+// runRetention enforces retention periodically until the manager is done
+func (m *Manager) runRetention() {
+	go func() {
+		for !m.IsDone() {
+			m.enforceRetention()
+
+			for t := time.Now(); time.Since(t) < m.Config.Retention.interval() && !m.IsDone(); {
+				time.Sleep(time.Second)
+			}
+		}
+	}()
+}
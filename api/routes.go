@@ -10,12 +10,28 @@ const (
 	EptAPIRulesPath = "/rules"
 	// EptAPIRulesSha256Path API route used to retrieve sha256 of latest batch of Gene rules
 	EptAPIRulesSha256Path = "/rules/sha256"
+	// EptAPIShadowRulesPath API route used to get the shadow Gene rules
+	EptAPIShadowRulesPath = "/rules/shadow"
+	// EptAPIShadowRulesSha256Path API route used to retrieve sha256 of the shadow Gene rules
+	EptAPIShadowRulesSha256Path = "/rules/shadow/sha256"
+	// EptAPISuppressionsPath API route used to get the suppression rules
+	EptAPISuppressionsPath = "/rules/suppressions"
+	// EptAPISuppressionsSha256Path API route used to retrieve sha256 of the suppression rules
+	EptAPISuppressionsSha256Path = "/rules/suppressions/sha256"
 
 	// EptAPIIoCsPath API route used to serve IOC container
 	EptAPIIoCsPath = "/iocs"
 	// EptAPIIoCsSha256Path API route used to serve sha256 of IOC container
 	EptAPIIoCsSha256Path = "/iocs/sha256"
 
+	// EptAPIContainersPath API route used to serve sha256 of the containers managed by the manager
+	EptAPIContainersPath = "/containers"
+	// EptAPIContainerPath API route used to serve a container
+	EptAPIContainerPath = EptAPIContainersPath + `/{name:\w+}`
+
+	// EptAPIEndpointKeyPath API route used by endpoints to fetch their new key after a key rotation
+	EptAPIEndpointKeyPath = "/endpoint/key"
+
 	// POST based API routes
 
 	// EptAPIPostLogsPath API route used to post logs
@@ -29,14 +45,22 @@ const (
 
 	// EptAPICommandPath used to GET commands and POST results
 	EptAPICommandPath = "/commands"
+	// EptAPICommandAckPath used by endpoints to acknowledge a command is running
+	EptAPICommandAckPath = EptAPICommandPath + "/ack"
+	// EptAPICommandCancelPath used by endpoints to know if a running command has to be cancelled
+	EptAPICommandCancelPath = EptAPICommandPath + "/cancel"
 )
 
 var (
 	eptAPIVerbosePaths = []string{
 		EptAPIServerKeyPath,
 		EptAPICommandPath,
+		EptAPICommandCancelPath,
 		EptAPIRulesSha256Path,
+		EptAPIShadowRulesSha256Path,
+		EptAPISuppressionsSha256Path,
 		EptAPIIoCsSha256Path,
+		EptAPIContainersPath,
 	}
 )
 
@@ -48,13 +72,24 @@ const (
 	AdmAPIUserByID = AdmAPIUsers + "/{uuuid:" + uuidRe + "}"
 
 	AdmAPIStatsPath             = "/stats"
+	AdmAPIConfigPath            = "/config"
 	AdmAPIIocsPath              = "/iocs"
 	AdmAPIRulesPath             = "/rules"
+	AdmAPIRulesTestPath         = AdmAPIRulesPath + "/test"
+	AdmAPIShadowRulesPath       = AdmAPIRulesPath + "/shadow"
+	AdmAPIContainersPath        = "/containers"
+	AdmAPIContainerPath         = AdmAPIContainersPath + `/{name:\w+}`
+	AdmAPISuppressionsPath      = "/suppressions"
+	AdmAPISuppressionByIDPath   = AdmAPISuppressionsPath + "/{suuid:" + uuidRe + "}"
 	AdmAPIEndpointsPath         = "/endpoints"
 	AdmAPIEndpointsSysmonConfig = AdmAPIEndpointsPath + `/{os:\w+}/sysmon/config`
 	AdmAPIEndpointsByIDPath     = AdmAPIEndpointsPath + "/{euuid:" + uuidRe + "}"
+	// Decommission related
+	AdmAPIDecommissionSuffix       = "/decommission"
+	AdmAPIEndpointDecommissionPath = AdmAPIEndpointsByIDPath + AdmAPIDecommissionSuffix
 	// Command related
 	AdmAPICommandSuffix            = "/command"
+	AdmAPIEndpointsCommandsPath    = AdmAPIEndpointsPath + "/commands"
 	AdmAPIEndpointCommandPath      = AdmAPIEndpointsByIDPath + AdmAPICommandSuffix
 	AdmAPIEndpointCommandFieldPath = AdmAPIEndpointCommandPath + "/{field}"
 	// Logs related
@@ -65,9 +100,12 @@ const (
 	// Reports related
 	AdmAPIReportSuffix              = "/report"
 	AdmAPIEndpointsReportsPath      = AdmAPIEndpointsPath + "/reports"
+	AdmAPIEndpointsFleetReportPath  = AdmAPIEndpointsReportsPath + "/fleet"
 	AdmAPIEndpointReportPath        = AdmAPIEndpointsByIDPath + AdmAPIReportSuffix
 	AdmAPIArchiveSuffix             = "/archive"
 	AdmAPIEndpointReportArchivePath = AdmAPIEndpointReportPath + AdmAPIArchiveSuffix
+	AdmAPIHistorySuffix             = "/history"
+	AdmAPIEndpointReportHistoryPath = AdmAPIEndpointReportPath + AdmAPIHistorySuffix
 	// Dumps related
 	AdmAPIArticfactsSuffix       = "/artifacts"
 	AdmAPIEndpointsArtifactsPath = AdmAPIEndpointsPath + AdmAPIArticfactsSuffix
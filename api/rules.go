@@ -1,11 +1,188 @@
 package api
 
 import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
 	"github.com/0xrawsec/gene/v2/engine"
 	"github.com/0xrawsec/sod"
 )
 
+const (
+	// MaxRuleCriticality is the maximum criticality a rule can have
+	MaxRuleCriticality = 10
+
+	// RuleConflictAbort aborts a rules upload if a rule already exists
+	RuleConflictAbort = "abort"
+	// RuleConflictUpdate updates the rules already existing
+	RuleConflictUpdate = "update"
+	// RuleConflictReject rejects only the rules already existing and
+	// loads the others
+	RuleConflictReject = "reject"
+)
+
+// RuleChannels maps the channels rules can apply to with the event IDs known
+// in those channels. Any event ID is valid in a channel without known IDs.
+type RuleChannels map[string][]int64
+
+var (
+	sysmonEventIDs = []int64{
+		1, 2, 3, 4, 5, 6, 7, 8, 9, 10,
+		11, 12, 13, 14, 15, 16, 17, 18, 19, 20,
+		21, 22, 23, 24, 25, 26, 27, 28, 29,
+		// error event
+		255,
+	}
+
+	// channels of the events collected by endpoints
+	builtinRuleChannels = RuleChannels{
+		"Microsoft-Windows-Sysmon/Operational": sysmonEventIDs,
+		"Security":                             nil,
+		"System":                               nil,
+		"Application":                          nil,
+		"Microsoft-Windows-Windows Defender/Operational":                     nil,
+		"Microsoft-Windows-PowerShell/Operational":                           nil,
+		"Windows PowerShell":                                                 nil,
+		"Microsoft-Antimalware-Scan-Interface":                               nil,
+		"Microsoft-Windows-Kernel-File/Analytic":                             nil,
+		"Microsoft-Windows-DNS-Client/Operational":                           nil,
+		"Microsoft-Windows-TaskScheduler/Operational":                        nil,
+		"Microsoft-Windows-WMI-Activity/Operational":                         nil,
+		"Microsoft-Windows-Windows Firewall With Advanced Security/Firewall": nil,
+		"Microsoft-Windows-Bits-Client/Operational":                          nil,
+		"Microsoft-Windows-TerminalServices-LocalSessionManager/Operational": nil,
+		// events generated by the EDR itself
+		"EDR": {1, 2, 3},
+	}
+)
+
+// NewRuleChannels returns the built-in rule channels along with extra
+// channels in which any event ID is valid
+func NewRuleChannels(extra ...string) RuleChannels {
+	c := make(RuleChannels, len(builtinRuleChannels)+len(extra))
+	for channel, eids := range builtinRuleChannels {
+		c[channel] = eids
+	}
+	for _, channel := range extra {
+		if _, ok := c[channel]; !ok {
+			c[channel] = nil
+		}
+	}
+	return c
+}
+
+func (c RuleChannels) knows(channel string) (ok bool) {
+	_, ok = c[channel]
+	return
+}
+
+func (c RuleChannels) knowsEventID(channel string, eid int64) bool {
+	// Windows event ids are 16 bits integers
+	if eid < 0 || eid > math.MaxUint16 {
+		return false
+	}
+	if known := c[channel]; len(known) > 0 {
+		for _, k := range known {
+			if k == eid {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
 type EdrRule struct {
 	sod.Item
 	engine.Rule
 }
+
+// RulesDeletion summarizes a rules deletion
+type RulesDeletion struct {
+	Removed   []string `json:"removed"`
+	Missing   []string `json:"missing"`
+	Remaining int      `json:"remaining"`
+	Message   string   `json:"message"`
+}
+
+// RulesUpload summarizes a rules upload
+type RulesUpload struct {
+	Added    []string              `json:"added"`
+	Updated  []string              `json:"updated"`
+	Rejected []RuleValidationError `json:"rejected"`
+	Message  string                `json:"message"`
+}
+
+func isValidRuleConflict(conflict string) bool {
+	switch conflict {
+	case RuleConflictAbort, RuleConflictUpdate, RuleConflictReject:
+		return true
+	}
+	return false
+}
+
+// RuleValidationError describes a problem found in a given field of a rule
+type RuleValidationError struct {
+	Index int    `json:"index"`
+	Rule  string `json:"rule"`
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+func (r *EdrRule) validationError(field, f string, a ...interface{}) RuleValidationError {
+	return RuleValidationError{Rule: r.Name, Field: field, Error: fmt.Sprintf(f, a...)}
+}
+
+// Check checks rule fields and returns all the problems found. Rules can
+// only apply to channels and event IDs known in channels. It also makes
+// sure the rule compiles.
+func (r *EdrRule) Check(channels RuleChannels) (errs []RuleValidationError) {
+	errs = make([]RuleValidationError, 0)
+
+	if strings.TrimSpace(r.Name) == "" {
+		errs = append(errs, r.validationError("Name", "rule name is required"))
+	}
+
+	if r.Meta.Criticality < 0 || r.Meta.Criticality > MaxRuleCriticality {
+		errs = append(errs, r.validationError("Meta.Criticality", "criticality must be in [0; %d], got %d", MaxRuleCriticality, r.Meta.Criticality))
+	}
+
+	// sorted so that problems are always reported in the same order
+	sorted := make([]string, 0, len(r.Meta.Events))
+	for channel := range r.Meta.Events {
+		sorted = append(sorted, channel)
+	}
+	sort.Strings(sorted)
+
+	for _, channel := range sorted {
+		if strings.TrimSpace(channel) == "" {
+			errs = append(errs, r.validationError("Meta.Events", "empty channel name"))
+			continue
+		}
+		if !channels.knows(channel) {
+			errs = append(errs, r.validationError("Meta.Events", "unknown channel %q", channel))
+			continue
+		}
+		for _, eid := range r.Meta.Events[channel] {
+			if !channels.knowsEventID(channel, eid) {
+				errs = append(errs, r.validationError(fmt.Sprintf("Meta.Events.%s", channel), "unknown event id %d", eid))
+			}
+		}
+	}
+
+	if len(r.Matches) > 0 && strings.TrimSpace(r.Condition) == "" {
+		errs = append(errs, r.validationError("Condition", "condition is required when matches are defined"))
+	}
+
+	// any engine-level error is reported only if no other problem was found
+	// as it is very likely to be a consequence of those
+	if len(errs) == 0 {
+		if _, err := r.Compile(engine.NewEngine()); err != nil {
+			errs = append(errs, r.validationError("Matches/Condition", "%s", err))
+		}
+	}
+
+	return
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"fmt"
+	"time"
+)
+
+// ManagerRuntimeConfig holds the manager settings which can be changed
+// without restarting the manager. Fields left empty in an update are not
+// modified.
+type ManagerRuntimeConfig struct {
+	VerboseHTTP        *bool   `json:"verbose-http,omitempty"`
+	RejectUnsigned     *bool   `json:"reject-unsigned-events,omitempty"`
+	RejectInvalid      *bool   `json:"reject-invalid-signatures,omitempty"`
+	IngestionWorkers   *int    `json:"ingestion-workers,omitempty"`
+	IngestionQueue     *int    `json:"ingestion-queue,omitempty"`
+	KeyRotationOverlap *string `json:"key-rotation-overlap,omitempty"`
+	BatchDedupWindow   *string `json:"batch-dedup-window,omitempty"`
+	ArchiveMaxLimit    *uint64 `json:"archive-max-limit,omitempty"`
+}
+
+// ManagerConfigView is the view of the manager configuration returned by the
+// admin API. Static settings require a manager restart to be changed.
+type ManagerConfigView struct {
+	Runtime ManagerRuntimeConfig `json:"runtime"`
+	Static  ManagerConfig        `json:"static"`
+}
+
+func runtimeConfigFrom(c *ManagerConfig) (r ManagerRuntimeConfig) {
+	verbose := c.Logging.VerboseHTTP
+	unsigned := c.EndpointAPI.RejectUnsigned
+	invalid := c.EndpointAPI.RejectInvalid
+	workers := c.EndpointAPI.IngestionWorkers
+	queue := c.EndpointAPI.IngestionQueue
+	overlap := c.EndpointAPI.KeyRotationOverlap.String()
+	window := c.EndpointAPI.BatchDedupWindow.String()
+	limit := c.AdminAPI.ArchiveMaxLimit
+
+	r.VerboseHTTP = &verbose
+	r.RejectUnsigned = &unsigned
+	r.RejectInvalid = &invalid
+	r.IngestionWorkers = &workers
+	r.IngestionQueue = &queue
+	r.KeyRotationOverlap = &overlap
+	r.BatchDedupWindow = &window
+	r.ArchiveMaxLimit = &limit
+
+	return
+}
+
+func parseRuntimeDuration(name string, s *string) (d time.Duration, err error) {
+	if d, err = time.ParseDuration(*s); err != nil {
+		return 0, fmt.Errorf("bad %s: %w", name, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%s must be positive", name)
+	}
+	return
+}
+
+// apply validates the runtime settings and applies them to c. Nothing is
+// modified if any setting is invalid.
+func (r *ManagerRuntimeConfig) apply(c *ManagerConfig) (err error) {
+	var overlap, window time.Duration
+
+	if r.IngestionWorkers != nil && *r.IngestionWorkers < 0 {
+		return fmt.Errorf("ingestion-workers must be positive")
+	}
+
+	if r.IngestionQueue != nil && *r.IngestionQueue < 0 {
+		return fmt.Errorf("ingestion-queue must be positive")
+	}
+
+	if r.KeyRotationOverlap != nil {
+		if overlap, err = parseRuntimeDuration("key-rotation-overlap", r.KeyRotationOverlap); err != nil {
+			return
+		}
+	}
+
+	if r.BatchDedupWindow != nil {
+		if window, err = parseRuntimeDuration("batch-dedup-window", r.BatchDedupWindow); err != nil {
+			return
+		}
+	}
+
+	if r.VerboseHTTP != nil {
+		c.Logging.VerboseHTTP = *r.VerboseHTTP
+	}
+	if r.RejectUnsigned != nil {
+		c.EndpointAPI.RejectUnsigned = *r.RejectUnsigned
+	}
+	if r.RejectInvalid != nil {
+		c.EndpointAPI.RejectInvalid = *r.RejectInvalid
+	}
+	if r.IngestionWorkers != nil {
+		c.EndpointAPI.IngestionWorkers = *r.IngestionWorkers
+	}
+	if r.IngestionQueue != nil {
+		c.EndpointAPI.IngestionQueue = *r.IngestionQueue
+	}
+	if r.KeyRotationOverlap != nil {
+		c.EndpointAPI.KeyRotationOverlap = overlap
+	}
+	if r.BatchDedupWindow != nil {
+		c.EndpointAPI.BatchDedupWindow = window
+	}
+	if r.ArchiveMaxLimit != nil {
+		c.AdminAPI.ArchiveMaxLimit = *r.ArchiveMaxLimit
+	}
+
+	return
+}
+
+// ConfigView returns the current configuration of the manager
+func (m *Manager) ConfigView() (v ManagerConfigView) {
+	m.runtime.RLock()
+	defer m.runtime.RUnlock()
+
+	v.Runtime = runtimeConfigFrom(m.Config)
+	v.Static = *m.Config
+	// never expose secrets
+	if v.Static.EndpointAPI.ServerKey != "" {
+		v.Static.EndpointAPI.ServerKey = "<hidden>"
+	}
+	return
+}
+
+// UpdateRuntimeConfig updates the settings of the manager which can be
+// changed at runtime. Changes are persisted into the configuration file
+// the manager has been started with.
+func (m *Manager) UpdateRuntimeConfig(r ManagerRuntimeConfig) (err error) {
+	m.runtime.Lock()
+	defer m.runtime.Unlock()
+
+	workers, queue := m.Config.EndpointAPI.IngestionWorkers, m.Config.EndpointAPI.IngestionQueue
+
+	if err = r.apply(m.Config); err != nil {
+		return
+	}
+
+	// in flight requests keep using the previous limiter
+	if workers != m.Config.EndpointAPI.IngestionWorkers || queue != m.Config.EndpointAPI.IngestionQueue {
+		m.ingestion = newIngestionLimiter(m.Config.EndpointAPI.IngestionWorkers, m.Config.EndpointAPI.IngestionQueue)
+	}
+
+	m.batches.SetWindow(m.Config.EndpointAPI.BatchDedupWindow)
+
+	if m.Config.path != "" {
+		if err = m.Config.Save(); err != nil {
+			return fmt.Errorf("configuration updated but failed to persist: %w", err)
+		}
+	}
+
+	return
+}
+
+// config returns a copy of the configuration of the manager, to be used to
+// read settings which can be changed at runtime
+func (m *Manager) config() ManagerConfig {
+	m.runtime.RLock()
+	defer m.runtime.RUnlock()
+	return *m.Config
+}
+
+// ingestionLimiter returns the limiter currently used for events ingestion
+func (m *Manager) ingestionLimiter() *ingestionLimiter {
+	m.runtime.RLock()
+	defer m.runtime.RUnlock()
+	return m.ingestion
+}
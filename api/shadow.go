@@ -0,0 +1,144 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/sod"
+)
+
+// EdrShadowRule is a rule of the shadow rule set. The shadow rule set is a
+// complete rule set deployed on endpoints along with the active one,
+// endpoints evaluating events against both and reporting how detections
+// differ, without taking any action on shadow detections.
+type EdrShadowRule struct {
+	sod.Item
+	engine.Rule
+}
+
+// Check checks rule fields and returns all the problems found
+func (r *EdrShadowRule) Check(channels RuleChannels) []RuleValidationError {
+	rule := EdrRule{Rule: r.Rule}
+	return rule.Check(channels)
+}
+
+// shadowEngineFromDB builds an engine from the shadow rules, using the same
+// containers as the active engine
+func (m *Manager) shadowEngineFromDB() (*engine.Engine, error) {
+	e := engine.NewEngine()
+	e.SetDumpRaw(true)
+
+	if objs, err := m.db.All(&EdrContainer{}); err != nil {
+		return nil, err
+	} else {
+		for _, o := range objs {
+			cont := o.(*EdrContainer)
+			for _, entry := range cont.Entries {
+				e.AddToContainer(cont.Name, entry)
+			}
+		}
+	}
+
+	if objs, err := m.db.All(&EdrShadowRule{}); err != nil {
+		return nil, err
+	} else {
+		for _, o := range objs {
+			rule := o.(*EdrShadowRule)
+			if err := e.LoadRule(&rule.Rule); err != nil {
+				return nil, fmt.Errorf("fail to load shadow rule %s: %s", rule.Name, err)
+			}
+		}
+	}
+
+	return e, nil
+}
+
+func (m *Manager) admAPIShadowRules(wt http.ResponseWriter, rq *http.Request) {
+	switch rq.Method {
+	case "GET":
+		name := rq.URL.Query().Get(qpName)
+		if name == "" {
+			name = ".*"
+		}
+
+		if objs, err := m.db.Search(&EdrShadowRule{}, "Name", "~=", name).Collect(); err != nil && !sod.IsNoObjectFound(err) {
+			wt.Write(admErr(err))
+		} else {
+			rules := make([]*EdrShadowRule, 0, len(objs))
+			for _, o := range objs {
+				rules = append(rules, o.(*EdrShadowRule))
+			}
+			wt.Write(admJSONResp(rules))
+		}
+
+	case "POST":
+		defer rq.Body.Close()
+
+		var rules []*EdrShadowRule
+
+		if err := json.NewDecoder(rq.Body).Decode(&rules); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		// the shadow rule set is replaced as a whole so it must be valid
+		problems := make([]RuleValidationError, 0)
+		names := make(map[string]bool)
+		for i, rule := range rules {
+			for _, p := range rule.Check(m.ruleChannels) {
+				p.Index = i
+				problems = append(problems, p)
+			}
+			if names[rule.Name] {
+				problems = append(problems, RuleValidationError{i, rule.Name, "Name", "duplicate rule name"})
+			}
+			names[rule.Name] = true
+		}
+
+		if len(problems) > 0 {
+			resp := NewAdminAPIRespErrorString(format("%d validation error(s) found in rules, nothing loaded", len(problems)))
+			resp.Data = problems
+			wt.Write(resp.ToJSON())
+			return
+		}
+
+		if err := m.db.DeleteAll(&EdrShadowRule{}); err != nil {
+			wt.Write(admErr(err))
+			return
+		}
+
+		if err := m.db.InsertOrUpdateMany(sod.ToObjectSlice(rules)...); err != nil {
+			wt.Write(admErr(fmt.Errorf("partial insert due to error: %s", err)))
+		} else if err := m.initializeGeneFromDB(); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(rules))
+		}
+
+	case "DELETE":
+		// deleting all shadow rules stops shadow evaluation on endpoints
+		if err := m.db.DeleteAll(&EdrShadowRule{}); err != nil {
+			wt.Write(admErr(err))
+		} else if err := m.initializeGeneFromDB(); err != nil {
+			wt.Write(admErr(err))
+		} else {
+			wt.Write(admJSONResp(nil))
+		}
+	}
+}
+
+// eptAPIShadowRules HTTP handler used to serve the shadow rules
+func (m *Manager) eptAPIShadowRules(wt http.ResponseWriter, rq *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+	wt.Write([]byte(m.gene.shadowRules))
+}
+
+// eptAPIShadowRulesSha256 returns the sha256 of the shadow rules
+func (m *Manager) eptAPIShadowRulesSha256(wt http.ResponseWriter, rq *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+	wt.Write([]byte(m.gene.shadowSha256))
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/sod"
+)
+
+const (
+	// SuppressionTag is the tag of the filter rules generated from detection suppressions
+	SuppressionTag = "suppression"
+	// SuppressedRuleTagPrefix prefixes the tag of a suppression rule holding the
+	// name of the rule it suppresses
+	SuppressedRuleTagPrefix = "suppressed:"
+
+	suppressionRulePrefix = "Suppression_"
+)
+
+// DetectionSuppression records a detection marked as false positive by an
+// analyst. It is deployed on endpoints as a filter rule so that the rule
+// stops firing for the given process image and/or hash, either on a single
+// endpoint or on all of them. Suppression rules are served apart from the
+// other rules and are only evaluated against the detections of the rule
+// they suppress.
+type DetectionSuppression struct {
+	sod.Item
+	Uuid     string    `json:"uuid"`
+	Rule     string    `json:"rule"`
+	Image    string    `json:"image,omitempty"`
+	Hash     string    `json:"hash,omitempty"`
+	Endpoint string    `json:"endpoint,omitempty"`
+	Hostname string    `json:"hostname,omitempty"`
+	Comment  string    `json:"comment,omitempty"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+}
+
+// NewDetectionSuppression creates a new suppression
+func NewDetectionSuppression() *DetectionSuppression {
+	s := &DetectionSuppression{Uuid: UUIDGen().String()}
+	s.Initialize(s.Uuid)
+	s.Created = time.Now().UTC()
+	s.Updated = s.Created
+	return s
+}
+
+// Validate overwrite sod.Item function
+func (s *DetectionSuppression) Validate() error {
+	if strings.TrimSpace(s.Rule) == "" {
+		return fmt.Errorf("suppression rule is required")
+	}
+	if s.Image == "" && s.Hash == "" && s.Endpoint == "" {
+		return fmt.Errorf("suppression must apply to an image, a hash or an endpoint")
+	}
+	return nil
+}
+
+// Same returns true if both suppressions apply to the same detections
+func (s *DetectionSuppression) Same(other *DetectionSuppression) bool {
+	return s.Rule == other.Rule &&
+		strings.EqualFold(s.Image, other.Image) &&
+		strings.EqualFold(s.Hash, other.Hash) &&
+		s.Endpoint == other.Endpoint
+}
+
+// RuleName returns the name of the filter rule generated from the suppression
+func (s *DetectionSuppression) RuleName() string {
+	return suppressionRulePrefix + s.Uuid
+}
+
+// FilterRule generates the Gene filter rule matching the events the
+// suppression applies to
+func (s *DetectionSuppression) FilterRule() (r engine.Rule) {
+	r = engine.NewRule()
+	r.Name = s.RuleName()
+	r.Tags = []string{SuppressionTag, SuppressedRuleTagPrefix + s.Rule}
+	r.Meta.Filter = true
+
+	if s.Hostname != "" {
+		r.Meta.Computers = append(r.Meta.Computers, s.Hostname)
+	}
+
+	cond := make([]string, 0, 2)
+	if s.Image != "" {
+		r.Matches = append(r.Matches, fmt.Sprintf("$image: Image ~= '(?i)^%s$'", regexp.QuoteMeta(s.Image)))
+		cond = append(cond, "$image")
+	}
+	if s.Hash != "" {
+		r.Matches = append(r.Matches, fmt.Sprintf("$hash: ImageHashes ~= '(?i)%s'", regexp.QuoteMeta(s.Hash)))
+		cond = append(cond, "$hash")
+	}
+	r.Condition = strings.Join(cond, " and ")
+
+	return
+}
+
+// suppressionEngineFromDB builds an engine holding the filter rules of all
+// the suppressions
+func (m *Manager) suppressionEngineFromDB() (*engine.Engine, error) {
+	e := engine.NewEngine()
+	e.SetDumpRaw(true)
+
+	if objs, err := m.db.All(&DetectionSuppression{}); err != nil {
+		return nil, err
+	} else {
+		for _, o := range objs {
+			s := o.(*DetectionSuppression)
+			rule := s.FilterRule()
+			if err := e.LoadRule(&rule); err != nil {
+				return nil, fmt.Errorf("fail to load suppression rule %s: %s", rule.Name, err)
+			}
+		}
+	}
+
+	return e, nil
+}
+
+// eptAPISuppressions HTTP handler used to serve the suppression rules
+func (m *Manager) eptAPISuppressions(wt http.ResponseWriter, rq *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+	wt.Write([]byte(m.gene.suppressionsRules))
+}
+
+// eptAPISuppressionsSha256 returns the sha256 of the suppression rules
+func (m *Manager) eptAPISuppressionsSha256(wt http.ResponseWriter, rq *http.Request) {
+	m.RLock()
+	defer m.RUnlock()
+	wt.Write([]byte(m.gene.suppressionsSha256))
+}
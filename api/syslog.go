@@ -0,0 +1,387 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// SyslogFormatRFC5424 sends detections as RFC5424 syslog messages,
+	// mapped fields being put in structured data
+	SyslogFormatRFC5424 = "rfc5424"
+	// SyslogFormatCEF sends detections as CEF messages with a RFC5424 header
+	SyslogFormatCEF = "cef"
+
+	SyslogTransportUDP = "udp"
+	SyslogTransportTCP = "tcp"
+	SyslogTransportTLS = "tls"
+
+	// DefaultSyslogFacility is the facility used when none is configured (local0)
+	DefaultSyslogFacility = 16
+	// DefaultSyslogQueueSize is the default number of detections waiting to
+	// be sent to the syslog destination
+	DefaultSyslogQueueSize = 1000
+
+	syslogAppName = "whids"
+	syslogMsgID   = "detection"
+	// private enterprise number used in structured data IDs
+	syslogSDID = "whids@32473"
+
+	// RFC5424 timestamps are limited to microseconds
+	syslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+	syslogTimeout    = 10 * time.Second
+	syslogMaxBackoff = time.Minute
+)
+
+var (
+	// DefaultSyslogFields is the default mapping of event fields to message fields
+	DefaultSyslogFields = []SyslogField{
+		{Name: "suser", Path: "/Event/EventData/User"},
+		{Name: "sproc", Path: "/Event/EventData/Image"},
+		{Name: "spid", Path: "/Event/EventData/ProcessId"},
+		{Name: "sprocguid", Path: "/Event/EventData/ProcessGuid"},
+	}
+)
+
+// SyslogField maps an event field to a field of syslog messages
+type SyslogField struct {
+	Name string `toml:"name" comment:"Name of the field in messages, CEF extension key or RFC5424 structured data parameter"`
+	Path string `toml:"path" comment:"Event field (XPath) the value is taken from (ex: /Event/EventData/User)"`
+}
+
+// SyslogConfig configures the forwarding of detections to a syslog destination
+type SyslogConfig struct {
+	Enable    bool          `toml:"enable" comment:"Forward detections to a syslog destination, in addition to the manager"`
+	Format    string        `toml:"format" comment:"Format of the messages: rfc5424 or cef"`
+	Transport string        `toml:"transport" comment:"Transport used: udp, tcp or tls"`
+	Host      string        `toml:"host" comment:"Hostname or IP of the syslog destination"`
+	Port      int           `toml:"port" comment:"Port of the syslog destination"`
+	CA        string        `toml:"ca" comment:"PEM file of the certificates trusted to verify the destination certificate\n with tls transport (default: system certificates)"`
+	Unsafe    bool          `toml:"unsafe" comment:"Do not verify the destination certificate with tls transport"`
+	Facility  *int          `toml:"facility" comment:"Syslog facility of the messages (default: 16, local0)"`
+	Fields    []SyslogField `toml:"fields" comment:"Event fields mapped into messages, in addition to rule names, criticality,\n host and timestamp"`
+	QueueSize int           `toml:"queue-size" comment:"Maximum number of detections waiting to be sent, detections being\n dropped when the destination is not reachable for too long (default: 1000)"`
+}
+
+// IsEnabled returns true if syslog forwarding is enabled
+func (c *SyslogConfig) IsEnabled() bool {
+	return c != nil && c.Enable
+}
+
+// Verify validates syslog configuration
+func (c *SyslogConfig) Verify() error {
+	if !c.IsEnabled() {
+		return nil
+	}
+
+	switch c.Format {
+	case SyslogFormatRFC5424, SyslogFormatCEF:
+	default:
+		return fmt.Errorf("unknown syslog format: %s", c.Format)
+	}
+
+	switch c.Transport {
+	case SyslogTransportUDP, SyslogTransportTCP, SyslogTransportTLS:
+	default:
+		return fmt.Errorf("unknown syslog transport: %s", c.Transport)
+	}
+
+	if c.Host == "" || c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("syslog destination host and port must be set")
+	}
+
+	if f := c.facility(); f < 0 || f > 23 {
+		return fmt.Errorf("syslog facility must be in [0;23]")
+	}
+
+	if c.QueueSize < 0 {
+		return fmt.Errorf("syslog queue size must be positive")
+	}
+
+	for _, f := range c.Fields {
+		if f.Name == "" || f.Path == "" {
+			return fmt.Errorf("syslog field must have a name and a path")
+		}
+		// names must be valid both as CEF keys and SD-NAME
+		if strings.ContainsAny(f.Name, " =]\"\\|") {
+			return fmt.Errorf("bad syslog field name: %s", f.Name)
+		}
+	}
+
+	return nil
+}
+
+// facility returns the configured facility, kern (0) being a valid one
+func (c *SyslogConfig) facility() int {
+	if c.Facility == nil {
+		return DefaultSyslogFacility
+	}
+	return *c.Facility
+}
+
+func (c *SyslogConfig) queueSize() int {
+	if c.QueueSize <= 0 {
+		return DefaultSyslogQueueSize
+	}
+	return c.QueueSize
+}
+
+func (c *SyslogConfig) fields() []SyslogField {
+	if len(c.Fields) == 0 {
+		return DefaultSyslogFields
+	}
+	return c.Fields
+}
+
+// syslogSeverity maps a detection criticality to a syslog severity
+func syslogSeverity(criticality int) int {
+	switch {
+	case criticality >= 8:
+		// critical
+		return 2
+	case criticality >= 5:
+		// warning
+		return 4
+	default:
+		// notice
+		return 5
+	}
+}
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+	sdParamEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+)
+
+// syslogField is a field of a syslog message
+type syslogField struct {
+	name  string
+	value string
+}
+
+// syslogWriter sends detections to a syslog destination from its own
+// routine, so that a slow or unreachable destination does not hold the
+// forwarder
+type syslogWriter struct {
+	config  *SyslogConfig
+	fields  []SyslogField
+	paths   []engine.XPath
+	queue   chan []byte
+	stop    chan bool
+	done    chan bool
+	conn    net.Conn
+	running bool
+	dropped uint64
+}
+
+func newSyslogWriter(c *SyslogConfig) *syslogWriter {
+	w := &syslogWriter{
+		config: c,
+		fields: c.fields(),
+		queue:  make(chan []byte, c.queueSize()),
+		stop:   make(chan bool),
+		done:   make(chan bool),
+	}
+
+	for _, f := range w.fields {
+		w.paths = append(w.paths, engine.Path(f.Path))
+	}
+
+	return w
+}
+
+// format formats a detection as a syslog message
+func (w *syslogWriter) format(e *event.EdrEvent) []byte {
+	var criticality int
+	var rules []string
+
+	if d := e.GetDetection(); d != nil {
+		criticality = d.Criticality
+		rules = d.Names()
+		sort.Strings(rules)
+	}
+
+	host := e.Computer()
+	if host == "" {
+		host = "-"
+	}
+
+	ts := e.Timestamp().UTC()
+	pri := w.config.facility()*8 + syslogSeverity(criticality)
+	header := fmt.Sprintf("<%d>1 %s %s %s - %s", pri, ts.Format(syslogTimeFormat), host, syslogAppName, syslogMsgID)
+
+	fields := []syslogField{
+		{"rules", strings.Join(rules, ",")},
+		{"criticality", strconv.Itoa(criticality)},
+	}
+	for i, p := range w.paths {
+		if v, ok := e.Get(p); ok {
+			fields = append(fields, syslogField{w.fields[i].Name, fmt.Sprintf("%v", v)})
+		}
+	}
+
+	b := new(strings.Builder)
+	b.WriteString(header)
+
+	switch w.config.Format {
+	case SyslogFormatCEF:
+		fmt.Fprintf(b, " - CEF:0|0xrawsec|whids||%d|%s|%d|rt=%d dvchost=%s",
+			e.EventID(),
+			cefHeaderEscaper.Replace(strings.Join(rules, ", ")),
+			criticality,
+			ts.UnixNano()/int64(time.Millisecond),
+			cefExtensionEscaper.Replace(e.Computer()))
+		// rule names and criticality are already in CEF header
+		for _, f := range fields[2:] {
+			fmt.Fprintf(b, " %s=%s", f.name, cefExtensionEscaper.Replace(f.value))
+		}
+	default:
+		fmt.Fprintf(b, " [%s", syslogSDID)
+		for _, f := range fields {
+			fmt.Fprintf(b, ` %s="%s"`, f.name, sdParamEscaper.Replace(f.value))
+		}
+		fmt.Fprintf(b, "] %s", strings.Join(rules, ", "))
+	}
+
+	return []byte(b.String())
+}
+
+// Send queues a detection to be sent, the detection is dropped if the queue
+// is full
+func (w *syslogWriter) Send(e *event.EdrEvent) {
+	select {
+	case w.queue <- w.format(e):
+	default:
+		if n := atomic.AddUint64(&w.dropped, 1); n == 1 || n%100 == 0 {
+			log.Warnf("Syslog queue full, %d detections dropped so far", n)
+		}
+	}
+}
+
+func (w *syslogWriter) dial() (conn net.Conn, err error) {
+	addr := net.JoinHostPort(w.config.Host, strconv.Itoa(w.config.Port))
+
+	switch w.config.Transport {
+	case SyslogTransportTLS:
+		tc := &tls.Config{
+			ServerName:         w.config.Host,
+			InsecureSkipVerify: w.config.Unsafe,
+			MinVersion:         tls.VersionTLS12,
+		}
+
+		if w.config.CA != "" {
+			var pem []byte
+
+			if pem, err = os.ReadFile(w.config.CA); err != nil {
+				return
+			}
+			tc.RootCAs = x509.NewCertPool()
+			if !tc.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificate found in %s", w.config.CA)
+			}
+		}
+
+		return tls.DialWithDialer(&net.Dialer{Timeout: syslogTimeout}, "tcp", addr, tc)
+	default:
+		return net.DialTimeout(w.config.Transport, addr, syslogTimeout)
+	}
+}
+
+// write writes a message to the destination, connecting to it if needed
+func (w *syslogWriter) write(msg []byte) (err error) {
+	if w.conn == nil {
+		if w.conn, err = w.dial(); err != nil {
+			return
+		}
+	}
+
+	// stream transports use octet counting framing (RFC6587 and RFC5425)
+	if w.config.Transport != SyslogTransportUDP {
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+
+	w.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err = w.conn.Write(msg); err != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	return
+}
+
+// Run starts the routine sending queued detections. A detection failing to
+// be sent is retried until it is sent or the writer is closed.
+func (w *syslogWriter) Run() {
+	w.running = true
+	go func() {
+		defer func() { w.done <- true }()
+
+		for {
+			select {
+			case <-w.stop:
+				w.flush()
+				return
+			case msg := <-w.queue:
+				for backoff := time.Second; ; backoff *= 2 {
+					err := w.write(msg)
+					if err == nil {
+						break
+					}
+
+					if backoff > syslogMaxBackoff {
+						backoff = syslogMaxBackoff
+					}
+					log.Errorf("Failed to send detection to syslog destination, retrying in %s: %s", backoff, err)
+
+					select {
+					case <-w.stop:
+						return
+					case <-time.After(backoff):
+					}
+				}
+			}
+		}
+	}()
+}
+
+// flush makes a single attempt at sending the detections still queued
+func (w *syslogWriter) flush() {
+	for {
+		select {
+		case msg := <-w.queue:
+			if err := w.write(msg); err != nil {
+				log.Errorf("Failed to send queued detections to syslog destination: %s", err)
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Close stops the writer after trying to send queued detections
+func (w *syslogWriter) Close() {
+	if w.running {
+		w.stop <- true
+		<-w.done
+		w.running = false
+	}
+
+	if w.conn != nil {
+		w.conn.Close()
+	}
+}
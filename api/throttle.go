@@ -0,0 +1,108 @@
+package api
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// minimum amount of data read at once by a throttled reader
+	minThrottleChunk = 1024
+)
+
+// bandwidthLimiter is a token bucket limiting the amount of data uploaded
+// per second. Priority transfers (i.e. events) are accounted as any other
+// transfer but low priority ones (i.e. dumps) are paused while a priority
+// transfer is running.
+type bandwidthLimiter struct {
+	sync.Mutex
+	rate      int64
+	available float64
+	last      time.Time
+	priority  int32
+}
+
+func newBandwidthLimiter(rate int64) *bandwidthLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &bandwidthLimiter{rate: rate, available: float64(rate), last: time.Now()}
+}
+
+// chunk returns the maximum amount of data to read at once so that
+// waiting for bandwidth never takes much more than 100ms
+func (l *bandwidthLimiter) chunk() int {
+	if c := int(l.rate / 10); c > minThrottleChunk {
+		return c
+	}
+	return minThrottleChunk
+}
+
+func (l *bandwidthLimiter) wait(n int, priority bool) {
+	if !priority {
+		for atomic.LoadInt32(&l.priority) > 0 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+	l.available += now.Sub(l.last).Seconds() * float64(l.rate)
+	// we don't allow bursts above one second of transfer
+	if l.available > float64(l.rate) {
+		l.available = float64(l.rate)
+	}
+	l.last = now
+
+	l.available -= float64(n)
+	if l.available < 0 {
+		time.Sleep(time.Duration(-l.available / float64(l.rate) * float64(time.Second)))
+	}
+}
+
+// reader wraps r so that reading from it is throttled
+func (l *bandwidthLimiter) reader(r io.ReadCloser, priority bool) io.ReadCloser {
+	if l == nil || r == nil {
+		return r
+	}
+	if priority {
+		atomic.AddInt32(&l.priority, 1)
+	}
+	return &throttledReader{r: r, limiter: l, priority: priority}
+}
+
+type throttledReader struct {
+	r        io.ReadCloser
+	limiter  *bandwidthLimiter
+	priority bool
+	once     sync.Once
+}
+
+func (t *throttledReader) Read(p []byte) (n int, err error) {
+	if c := t.limiter.chunk(); len(p) > c {
+		p = p[:c]
+	}
+	n, err = t.r.Read(p)
+	t.limiter.wait(n, t.priority)
+	if err == io.EOF {
+		t.release()
+	}
+	return
+}
+
+func (t *throttledReader) release() {
+	t.once.Do(func() {
+		if t.priority {
+			atomic.AddInt32(&t.limiter.priority, -1)
+		}
+	})
+}
+
+func (t *throttledReader) Close() error {
+	t.release()
+	return t.r.Close()
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"github.com/0xrawsec/golang-utils/fsutil"
+)
+
+const (
+	// DefaultTLSMinVersion is the minimum TLS version accepted by servers
+	// when none is configured
+	DefaultTLSMinVersion = "1.2"
+
+	// MinRSAKeySize is the minimum size (in bits) of the RSA key of a server certificate
+	MinRSAKeySize = 2048
+	// MinECDSAKeySize is the minimum size (in bits) of the ECDSA key of a server certificate
+	MinECDSAKeySize = 256
+)
+
+var (
+	tlsVersions = map[string]uint16{
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
+	weakSignatureAlgorithms = map[x509.SignatureAlgorithm]bool{
+		x509.MD2WithRSA:    true,
+		x509.MD5WithRSA:    true,
+		x509.SHA1WithRSA:   true,
+		x509.DSAWithSHA1:   true,
+		x509.DSAWithSHA256: true,
+		x509.ECDSAWithSHA1: true,
+	}
+)
+
+//////////////////// TLSConfig
+
+// TLSConfig structure definition
+type TLSConfig struct {
+	Cert         string   `toml:"cert" comment:"Path to the certificate file to use for TLS connections"`
+	Key          string   `toml:"key" comment:"Path to the key to use for TLS connection"`
+	MinVersion   string   `toml:"min-version" comment:"Minimum TLS version accepted: 1.2 or 1.3 (default: 1.2)"`
+	CipherSuites []string `toml:"cipher-suites" comment:"TLS 1.2 cipher suites accepted (ex: TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384)\n Only secure suites are allowed, TLS 1.3 suites are not configurable\n (default: Go secure cipher suites)"`
+}
+
+// Empty returns true if current TLSConfig is empty else false
+func (t *TLSConfig) Empty() bool {
+	return t.Cert == "" && t.Key == ""
+}
+
+// Verify checks whether the files holding cert and key exist, that they
+// match, that the certificate is not weak and that TLS settings are valid
+func (t *TLSConfig) Verify() error {
+	switch {
+	case !fsutil.IsFile(t.Cert):
+		return fmt.Errorf("certificate file (%s) not found", t.Cert)
+	case !fsutil.IsFile(t.Key):
+		return fmt.Errorf("key file (%s) not found", t.Key)
+	}
+
+	if _, err := t.minVersion(); err != nil {
+		return err
+	}
+
+	if _, err := t.cipherSuites(); err != nil {
+		return err
+	}
+
+	if _, err := t.certificate(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *TLSConfig) minVersion() (uint16, error) {
+	v := t.MinVersion
+	if v == "" {
+		v = DefaultTLSMinVersion
+	}
+
+	if version, ok := tlsVersions[strings.TrimPrefix(v, "TLS")]; ok {
+		return version, nil
+	}
+
+	return 0, fmt.Errorf("unsupported TLS minimum version %s, must be 1.2 or 1.3", t.MinVersion)
+}
+
+func (t *TLSConfig) cipherSuites() (ids []uint16, err error) {
+	// nil means Go default secure cipher suites
+	if len(t.CipherSuites) == 0 {
+		return
+	}
+
+	secure := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		secure[cs.Name] = cs.ID
+	}
+
+	insecure := make(map[string]bool)
+	for _, cs := range tls.InsecureCipherSuites() {
+		insecure[cs.Name] = true
+	}
+
+	ids = make([]uint16, 0, len(t.CipherSuites))
+	for _, name := range t.CipherSuites {
+		id, ok := secure[name]
+		switch {
+		case insecure[name]:
+			return nil, fmt.Errorf("insecure TLS cipher suite not allowed: %s", name)
+		case !ok:
+			return nil, fmt.Errorf("unknown TLS cipher suite: %s", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return
+}
+
+// certificate loads the key pair and makes sure it is not weak
+func (t *TLSConfig) certificate() (cert tls.Certificate, err error) {
+	if cert, err = tls.LoadX509KeyPair(t.Cert, t.Key); err != nil {
+		return cert, fmt.Errorf("failed to load TLS key pair: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return cert, fmt.Errorf("failed to parse TLS certificate: %w", err)
+	}
+
+	if weakSignatureAlgorithms[leaf.SignatureAlgorithm] {
+		return cert, fmt.Errorf("weak TLS certificate signature algorithm: %s", leaf.SignatureAlgorithm)
+	}
+
+	switch pub := leaf.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if size := pub.N.BitLen(); size < MinRSAKeySize {
+			return cert, fmt.Errorf("weak TLS certificate RSA key size %d, must be at least %d", size, MinRSAKeySize)
+		}
+	case *ecdsa.PublicKey:
+		if size := pub.Curve.Params().BitSize; size < MinECDSAKeySize {
+			return cert, fmt.Errorf("weak TLS certificate ECDSA key size %d, must be at least %d", size, MinECDSAKeySize)
+		}
+	case ed25519.PublicKey:
+	default:
+		return cert, fmt.Errorf("unsupported TLS certificate key type %T", pub)
+	}
+
+	cert.Leaf = leaf
+
+	return
+}
+
+// ServerConfig returns the tls.Config to use by servers, with certificate
+// loaded, minimum version and cipher suites set
+func (t *TLSConfig) ServerConfig() (conf *tls.Config, err error) {
+	var cert tls.Certificate
+
+	conf = &tls.Config{}
+
+	if conf.MinVersion, err = t.minVersion(); err != nil {
+		return nil, err
+	}
+
+	if conf.CipherSuites, err = t.cipherSuites(); err != nil {
+		return nil, err
+	}
+
+	if cert, err = t.certificate(); err != nil {
+		return nil, err
+	}
+	conf.Certificates = []tls.Certificate{cert}
+
+	return
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert     *x509.Certificate
+	key      crypto.Signer
+	certPath string
+	keyPath  string
+}
+
+// newTestCert generates a certificate signed by parent, self-signed if
+// parent is nil, and writes it along with its key in dir
+func newTestCert(t *testing.T, dir, cn string, key crypto.Signer, parent *testCert) *testCert {
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              []string{"localhost"},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+
+	signer, issuer := key, tmpl
+	if parent != nil {
+		signer, issuer = parent.key, parent.cert
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, key.Public(), signer)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	kder, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	c := &testCert{key: key, certPath: filepath.Join(dir, cn+".crt"), keyPath: filepath.Join(dir, cn+".key")}
+	if c.cert, err = x509.ParseCertificate(der); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if err := ioutil.WriteFile(c.certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if err := ioutil.WriteFile(c.keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: kder}), 0600); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	return c
+}
+
+func newTestKey(t *testing.T) crypto.Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	return key
+}
+
+func TestTLSConfigMinVersion(t *testing.T) {
+	c := newTestCert(t, t.TempDir(), "server", newTestKey(t), nil)
+
+	for _, tc := range []struct {
+		version  string
+		expected uint16
+		valid    bool
+	}{
+		{"", tls.VersionTLS12, true},
+		{"1.2", tls.VersionTLS12, true},
+		{"1.3", tls.VersionTLS13, true},
+		{"TLS1.3", tls.VersionTLS13, true},
+		{"1.1", 0, false},
+		{"1.0", 0, false},
+		{"SSL3", 0, false},
+	} {
+		conf := TLSConfig{Cert: c.certPath, Key: c.keyPath, MinVersion: tc.version}
+
+		if err := conf.Verify(); (err == nil) != tc.valid {
+			t.Errorf("Unexpected verification of min-version=%q: %v", tc.version, err)
+		}
+
+		if sc, err := conf.ServerConfig(); tc.valid && (err != nil || sc.MinVersion != tc.expected) {
+			t.Errorf("Unexpected server config with min-version=%q: %v", tc.version, err)
+		}
+	}
+}
+
+func TestTLSConfigCipherSuites(t *testing.T) {
+	c := newTestCert(t, t.TempDir(), "server", newTestKey(t), nil)
+
+	conf := TLSConfig{Cert: c.certPath, Key: c.keyPath}
+	if sc, err := conf.ServerConfig(); err != nil || sc.CipherSuites != nil {
+		t.Errorf("Go default cipher suites must be used by default: %v", err)
+	}
+
+	conf.CipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}
+	sc, err := conf.ServerConfig()
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if len(sc.CipherSuites) != 2 ||
+		sc.CipherSuites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384 ||
+		sc.CipherSuites[1] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("Unexpected cipher suites: %v", sc.CipherSuites)
+	}
+
+	for _, cs := range []string{
+		// insecure
+		"TLS_RSA_WITH_RC4_128_SHA",
+		"TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA",
+		// unknown
+		"TLS_UNKNOWN_CIPHER_SUITE",
+		"",
+	} {
+		conf.CipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", cs}
+		if err := conf.Verify(); err == nil {
+			t.Errorf("Cipher suite %q must be rejected", cs)
+		}
+	}
+}
+
+func TestTLSConfigCertificate(t *testing.T) {
+	dir := t.TempDir()
+
+	weakRSA, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	weakECDSA, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	for _, c := range []*testCert{
+		newTestCert(t, dir, "weak-rsa", weakRSA, nil),
+		newTestCert(t, dir, "weak-ecdsa", weakECDSA, nil),
+	} {
+		conf := TLSConfig{Cert: c.certPath, Key: c.keyPath}
+		if err := conf.Verify(); err == nil {
+			t.Errorf("Weak certificate %s must be rejected", c.cert.Subject.CommonName)
+		}
+	}
+
+	// key not matching certificate
+	a := newTestCert(t, dir, "a", newTestKey(t), nil)
+	b := newTestCert(t, dir, "b", newTestKey(t), nil)
+	if err := (&TLSConfig{Cert: a.certPath, Key: b.keyPath}).Verify(); err == nil {
+		t.Error("Key not matching certificate must be rejected")
+	}
+
+	if err := (&TLSConfig{Cert: filepath.Join(dir, "missing.crt"), Key: a.keyPath}).Verify(); err == nil {
+		t.Error("Missing certificate must be rejected")
+	}
+}
+
+func TestMutualTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", newTestKey(t), nil)
+
+	if _, err := (&MutualTLSConfig{ClientCA: filepath.Join(dir, "missing.crt")}).TLSConfig(); err == nil {
+		t.Error("Missing client CA must be rejected")
+	}
+
+	// file not holding any PEM certificate
+	if _, err := (&MutualTLSConfig{ClientCA: ca.keyPath}).TLSConfig(); err == nil {
+		t.Error("Client CA without certificate must be rejected")
+	}
+
+	for _, require := range []bool{false, true} {
+		expected := tls.VerifyClientCertIfGiven
+		if require {
+			expected = tls.RequireAndVerifyClientCert
+		}
+
+		conf, err := (&MutualTLSConfig{ClientCA: ca.certPath, Require: require}).TLSConfig()
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if conf.ClientAuth != expected || conf.ClientCAs == nil {
+			t.Errorf("Unexpected client authentication with require=%t: %v", require, conf.ClientAuth)
+		}
+	}
+}
+
+func TestTLSHandshake(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", newTestKey(t), nil)
+	server := newTestCert(t, dir, "server", newTestKey(t), ca)
+	client := newTestCert(t, dir, "client", newTestKey(t), ca)
+	// client certificate not issued by the client CA
+	rogue := newTestCert(t, dir, "rogue", newTestKey(t), nil)
+
+	conf, err := (&TLSConfig{Cert: server.certPath, Key: server.keyPath, MinVersion: "1.3"}).ServerConfig()
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	mtls, err := (&MutualTLSConfig{ClientCA: ca.certPath, Require: true}).TLSConfig()
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	conf.ClientCAs = mtls.ClientCAs
+	conf.ClientAuth = mtls.ClientAuth
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(wt http.ResponseWriter, rq *http.Request) {}))
+	srv.TLS = conf
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	get := func(maxVersion uint16, c *testCert) error {
+		tc := &tls.Config{RootCAs: roots, ServerName: "localhost", MaxVersion: maxVersion}
+		if c != nil {
+			tc.Certificates = []tls.Certificate{{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}}
+		}
+
+		cl := http.Client{Transport: &http.Transport{TLSClientConfig: tc}}
+		resp, err := cl.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := get(0, client); err != nil {
+		t.Errorf("Handshake must succeed: %v", err)
+	}
+
+	if err := get(tls.VersionTLS12, client); err == nil {
+		t.Error("Handshake below minimum version must fail")
+	}
+
+	if err := get(0, nil); err == nil {
+		t.Error("Handshake without client certificate must fail")
+	}
+
+	if err := get(0, rogue); err == nil {
+		t.Error("Handshake with client certificate not issued by client CA must fail")
+	}
+}
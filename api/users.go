@@ -1,9 +1,24 @@
 package api
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
 	"github.com/0xrawsec/sod"
 )
 
+// Roles of admin API users
+const (
+	// RoleAdmin role with full access to admin API
+	RoleAdmin = "admin"
+	// RoleReadOnly role only allowed to issue GET requests
+	RoleReadOnly = "read-only"
+)
+
+type adminAPIUserCtxKey struct{}
+
 // AdminAPIUser structure definition
 type AdminAPIUser struct {
 	sod.Item
@@ -11,5 +26,44 @@ type AdminAPIUser struct {
 	Identifier  string `json:"identifier" sod:"unique"`
 	Key         string `json:"key,omitempty" sod:"unique"`
 	Group       string `json:"group" sod:"index"`
+	Role        string `json:"role"`
 	Description string `json:"description"`
+
+	Created   time.Time `json:"created"`
+	LastLogin time.Time `json:"last-login"`
+	// Disabled users are not allowed to access the admin API
+	Disabled bool `json:"disabled"`
+}
+
+// Validate validates user fields
+func (u *AdminAPIUser) Validate() error {
+	switch u.Role {
+	case "", RoleAdmin, RoleReadOnly:
+		return nil
+	}
+	return fmt.Errorf("unknown role %q, must be one of %s, %s", u.Role, RoleAdmin, RoleReadOnly)
+}
+
+// IsReadOnly returns true if user has a read-only role. Users
+// without role are considered as admins.
+func (u *AdminAPIUser) IsReadOnly() bool {
+	return u != nil && u.Role == RoleReadOnly
+}
+
+// IsDisabled returns true if user has been deactivated
+func (u *AdminAPIUser) IsDisabled() bool {
+	return u != nil && u.Disabled
+}
+
+// adminAPIUserFromRequest returns the user authenticated by the
+// admin API authorization middleware
+func adminAPIUserFromRequest(rq *http.Request) *AdminAPIUser {
+	if u, ok := rq.Context().Value(adminAPIUserCtxKey{}).(*AdminAPIUser); ok {
+		return u
+	}
+	return nil
+}
+
+func withAdminAPIUser(rq *http.Request, u *AdminAPIUser) *http.Request {
+	return rq.WithContext(context.WithValue(rq.Context(), adminAPIUserCtxKey{}, u))
 }
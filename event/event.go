@@ -1,7 +1,12 @@
 package event
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"sort"
 	"strconv"
 	"time"
 
@@ -26,16 +31,70 @@ type EdrData struct {
 		Hash        string
 		Detection   bool
 		ReceiptTime time.Time
+		Integrity   string `json:",omitempty"`
 	}
 }
 
+// Values taken by EdrData.Event.Integrity
+const (
+	IntegrityUnsigned = "unsigned"
+	IntegrityValid    = "valid"
+	IntegrityInvalid  = "invalid"
+)
+
+// Attack holds the ATT&CK techniques and tactics of a detection
+type Attack struct {
+	Techniques []string `json:",omitempty"`
+	Tactics    []string `json:",omitempty"`
+}
+
+func contains(s []string, v string) bool {
+	for _, i := range s {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Add adds a technique and a tactic, empty values and duplicates are ignored
+func (a *Attack) Add(technique, tactic string) {
+	if technique != "" && !contains(a.Techniques, technique) {
+		a.Techniques = append(a.Techniques, technique)
+	}
+	if tactic != "" && !contains(a.Tactics, tactic) {
+		a.Tactics = append(a.Tactics, tactic)
+	}
+}
+
+// IsEmpty returns true if Attack holds neither technique nor tactic
+func (a *Attack) IsEmpty() bool {
+	return len(a.Techniques) == 0 && len(a.Tactics) == 0
+}
+
 type InnerEvent struct {
 	*etw.Event
 	EdrData   *EdrData          `json:",omitempty"`
 	Detection *engine.Detection `json:",omitempty"`
+	Attack    *Attack           `json:",omitempty"`
+	// Raw is set on copies of events taken before enrichment
+	Raw bool `json:",omitempty"`
+	// Shadow is set on copies of events carrying the detection of the
+	// shadow engine
+	Shadow *ShadowDiff `json:",omitempty"`
+	// Signature must remain the last serialized field
+	// as signature verification relies on it
+	Signature string `json:",omitempty"`
 	skip      bool
 }
 
+// ShadowDiff lists the rules by which the detection of the shadow engine
+// differs from the one of the active engine
+type ShadowDiff struct {
+	New        []string `json:"new,omitempty"`
+	Suppressed []string `json:"suppressed,omitempty"`
+}
+
 type EdrEvent struct {
 	Event InnerEvent
 }
@@ -50,11 +109,70 @@ func (e *EdrEvent) InitEdrData() {
 
 func (e *EdrEvent) Hash() string {
 	tmp := *e
-	// null out EdrData as it does not come into hash calculation
+	// null out EdrData and Signature as they do not come into hash calculation
 	tmp.Event.EdrData = nil
+	tmp.Event.Signature = ""
 	return utils.HashEventBytes(utils.Json(tmp))
 }
 
+// HashFields computes a hash of the event over a subset of its fields only.
+// Channel, event ID and detection signatures always come into hash
+// calculation so that events matching different rules hash differently.
+// Missing fields are hashed as empty values.
+func (e *EdrEvent) HashFields(paths []engine.XPath) string {
+	canonical := make([]interface{}, 0, len(paths)+3)
+
+	sigs := make([]string, 0)
+	if d := e.GetDetection(); d != nil && d.Signature != nil {
+		for _, s := range d.Signature.Slice() {
+			sigs = append(sigs, fmt.Sprintf("%v", s))
+		}
+		sort.Strings(sigs)
+	}
+
+	canonical = append(canonical, e.Channel(), e.EventID(), sigs)
+	for _, p := range paths {
+		v, _ := e.Get(p)
+		canonical = append(canonical, v)
+	}
+
+	return utils.HashEventBytes(utils.Json(canonical))
+}
+
+func signature(key string, b []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignedJson returns the JSON encoding of the event with an HMAC-SHA256
+// signature, computed with key over the unsigned JSON encoding of the event
+func (e *EdrEvent) SignedJson(key string) []byte {
+	e.Event.Signature = ""
+	e.Event.Signature = signature(key, utils.Json(e))
+	return utils.Json(e)
+}
+
+// VerifySignature verifies the signature of an event given its raw JSON encoding
+func (e *EdrEvent) VerifySignature(key string, raw []byte) bool {
+	if e.Event.Signature == "" {
+		return false
+	}
+
+	raw = bytes.TrimRight(raw, " \n\r\t")
+	suffix := []byte(fmt.Sprintf(`,"Signature":"%s"}}`, e.Event.Signature))
+	if !bytes.HasSuffix(raw, suffix) {
+		return false
+	}
+
+	// rebuilding unsigned JSON encoding
+	unsigned := make([]byte, 0, len(raw)-len(suffix)+2)
+	unsigned = append(unsigned, raw[:len(raw)-len(suffix)]...)
+	unsigned = append(unsigned, "}}"...)
+
+	return hmac.Equal([]byte(e.Event.Signature), []byte(signature(key, unsigned)))
+}
+
 func (e *EdrEvent) GetStringOr(p engine.XPath, or string) string {
 	if s, ok := e.GetString(p); ok {
 		return s
@@ -206,6 +324,12 @@ func (e *EdrEvent) IsSkipped() bool {
 	return e.Event.skip
 }
 
+// IsShadow returns true if the event is a copy carrying the detection of
+// the shadow engine
+func (e *EdrEvent) IsShadow() bool {
+	return e.Event.Shadow != nil
+}
+
 func (e *EdrEvent) GetDetection() *engine.Detection {
 	return e.Event.Detection
 }
@@ -231,5 +355,6 @@ func (er *EdrEvent) Copy() (new *EdrEvent) {
 	new = NewEdrEvent(&etwEvent)
 	new.Event.EdrData = er.Event.EdrData
 	new.Event.Detection = er.Event.Detection
+	new.Event.Signature = er.Event.Signature
 	return
 }
@@ -76,6 +76,68 @@ func TestEventWithAction(t *testing.T) {
 	}
 }
 
+func TestEventHashFields(t *testing.T) {
+	str := `{"Event":{"EventData":{"CommandLine":"cmd.exe","ProcessGuid":"{515cd0d1-2921-6152-721b-000000008200}","UtcTime":"2021-09-27 20:27:28.768"},"System":{"Channel":"Microsoft-Windows-Sysmon/Operational","EventID":1,"TimeCreated":{"SystemTime":"2021-09-27T20:27:28.7685432Z"}},"Detection":{"Signature":["A","B"],"Criticality":10}}}`
+	paths := []engine.XPath{engine.Path(eventData + "ProcessGuid"), engine.Path(eventData + "CommandLine")}
+
+	e, other := EdrEvent{}, EdrEvent{}
+	if err := json.Unmarshal([]byte(str), &e); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(str), &other); err != nil {
+		t.Fatal(err)
+	}
+
+	// volatile fields must not come into hash calculation
+	other.Event.System.TimeCreated.SystemTime = time.Now()
+	other.Event.EventData["UtcTime"] = time.Now().String()
+	if e.HashFields(paths) != other.HashFields(paths) {
+		t.Errorf("Hash must not depend on fields not selected")
+	}
+	if e.Hash() == other.Hash() {
+		t.Errorf("Whole event hash must depend on timestamps")
+	}
+
+	other.Event.EventData["CommandLine"] = "powershell.exe"
+	if e.HashFields(paths) == other.HashFields(paths) {
+		t.Errorf("Hash must depend on fields selected")
+	}
+}
+
+func TestEventSignature(t *testing.T) {
+	key := "endpoint-key"
+	str := `{"Event":{"EventData":{"CommandLine":"\"C:\\Windows\\System32\\cmd.exe\" /c whoami"},"System":{"Channel":"Microsoft-Windows-Sysmon/Operational","Computer":"DESKTOP-LJRVE06","EventID":1,"TimeCreated":{"SystemTime":"2021-09-27T20:27:28.7685432Z"}},"Detection":{"Signature":["Whoami"],"Criticality":8}}}`
+
+	event := EdrEvent{}
+	if err := json.Unmarshal([]byte(str), &event); err != nil {
+		t.Error(err)
+	}
+	h := event.Hash()
+
+	raw := event.SignedJson(key)
+	signed := EdrEvent{}
+	if err := json.Unmarshal(raw, &signed); err != nil {
+		t.Error(err)
+	}
+
+	if !signed.VerifySignature(key, raw) {
+		t.Errorf("Failed to verify signature: %s", raw)
+	}
+
+	if signed.VerifySignature("wrong-key", raw) {
+		t.Error("Signature verification must fail with wrong key")
+	}
+
+	tampered := bytes.Replace(raw, []byte("whoami"), []byte("net user"), 1)
+	if signed.VerifySignature(key, tampered) {
+		t.Error("Signature verification must fail on tampered event")
+	}
+
+	if h != signed.Hash() {
+		t.Error("Signature must not come into hash calculation")
+	}
+}
+
 func TestEventHashStability(t *testing.T) {
 	for event := range emitEvents(10000, true) {
 		h := event.Hash()
@@ -8,10 +8,12 @@ require (
 	github.com/0xrawsec/golang-win32 v1.0.12
 	github.com/0xrawsec/sod v1.6.8
 	github.com/0xrawsec/toast v1.1.1 // indirect
+	github.com/Microsoft/go-winio v0.4.14
 	github.com/google/shlex v0.0.0-20191202100458-e7afc7fbc510
 	github.com/google/uuid v1.3.0
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/websocket v1.4.2
+	github.com/klauspost/compress v1.15.9
 	github.com/pelletier/go-toml v1.9.3
 	golang.org/x/sys v0.0.0-20190909082730-f460065e899a
 )
@@ -10,6 +10,8 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/0xrawsec/gene/v2/engine"
@@ -20,6 +22,7 @@ import (
 	"github.com/0xrawsec/golang-utils/sync/semaphore"
 	"github.com/0xrawsec/golang-win32/win32/dbghelp"
 	"github.com/0xrawsec/golang-win32/win32/kernel32"
+	"github.com/0xrawsec/whids/api"
 	"github.com/0xrawsec/whids/event"
 	"github.com/0xrawsec/whids/utils"
 )
@@ -33,6 +36,15 @@ const (
 	ActionRegdump   = "regdump"
 	ActionReport    = "report"
 	ActionBrief     = "brief"
+
+	// ActionMemdumpAncestors memdumps the process and its ancestors
+	ActionMemdumpAncestors = "memdump-ancestors"
+	// ActionSockets captures the sockets opened by the process
+	ActionSockets = "sockets"
+
+	// processlessDumpWindow is the period over which dumps of events not
+	// related to any process are bounded by the maximum number of dumps
+	processlessDumpWindow = time.Hour
 )
 
 var (
@@ -40,10 +52,24 @@ var (
 		ActionKill,
 		ActionBlacklist,
 		ActionMemdump,
+		ActionMemdumpAncestors,
 		ActionFiledump,
 		ActionRegdump,
 		ActionReport,
 		ActionBrief,
+		ActionSockets,
+	}
+
+	// DumpActions are the actions producing dumps, an event is dumped only
+	// if at least one of those is set for its criticality
+	DumpActions = []string{
+		ActionMemdump,
+		ActionMemdumpAncestors,
+		ActionFiledump,
+		ActionRegdump,
+		ActionReport,
+		ActionBrief,
+		ActionSockets,
 	}
 
 	filedumpXPaths = []engine.XPath{
@@ -58,19 +84,78 @@ var (
 )
 
 type ActionHandler struct {
+	sync.RWMutex
 	ctx              context.Context
 	hids             *HIDS
 	queue            *datastructs.Fifo
 	compressionQueue *datastructs.Fifo
 	semJobs          semaphore.Semaphore
+	suppression      api.ActionsSuppression
+	// disk space checks and pruning must not run concurrently
+	spaceLock    sync.Mutex
+	skippedDumps uint64
+	// event dump directories being written, never pruned
+	writingLock sync.Mutex
+	writing     map[string]int
+	// dumps of events not related to any process made since processlessStart
+	processlessDumps int
+	processlessStart time.Time
+	// fields event hash is computed over
+	hashPaths []engine.XPath
 }
 
 func NewActionHandler(h *HIDS) *ActionHandler {
-	return &ActionHandler{h.ctx,
-		h,
-		&datastructs.Fifo{},
-		&datastructs.Fifo{},
-		semaphore.New(2)}
+	maxJobs := DefaultMaxConcurrentJobs
+	if h.config.Actions.MaxConcurrentJobs > 0 {
+		maxJobs = h.config.Actions.MaxConcurrentJobs
+	}
+
+	hashPaths := make([]engine.XPath, 0, len(h.config.Dump.HashFields))
+	for _, f := range h.config.Dump.HashFields {
+		hashPaths = append(hashPaths, engine.Path(f))
+	}
+
+	return &ActionHandler{
+		ctx:              h.ctx,
+		hids:             h,
+		queue:            &datastructs.Fifo{},
+		compressionQueue: &datastructs.Fifo{},
+		semJobs:          semaphore.New(uint64(maxJobs)),
+		hashPaths:        hashPaths}
+}
+
+// hash returns the hash of an event used to name its dump directory
+func (m *ActionHandler) hash(e *event.EdrEvent) string {
+	if len(m.hashPaths) > 0 {
+		return e.HashFields(m.hashPaths)
+	}
+	return e.Hash()
+}
+
+// Suppress suppresses actions for a given duration, if duration
+// is zero actions are suppressed until Resume is called
+func (m *ActionHandler) Suppress(d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.suppression = api.ActionsSuppression{Enabled: true}
+	if d > 0 {
+		m.suppression.Until = time.Now().Add(d)
+	}
+}
+
+// Resume resumes actions handling
+func (m *ActionHandler) Resume() {
+	m.Lock()
+	defer m.Unlock()
+	m.suppression = api.ActionsSuppression{}
+}
+
+// Suppression returns the actions suppression state
+func (m *ActionHandler) Suppression() api.ActionsSuppression {
+	m.RLock()
+	defer m.RUnlock()
+	return m.suppression
 }
 
 func (m *ActionHandler) dumpname(src string) string {
@@ -78,22 +163,199 @@ func (m *ActionHandler) dumpname(src string) string {
 	return fmt.Sprintf("%d_%s.bin", time.Now().UnixNano(), base)
 }
 
+// eventDumpDir returns the directory the dumps of e are written to
+func (m *ActionHandler) eventDumpDir(e *event.EdrEvent) string {
+	return filepath.Join(m.hids.config.Dump.Dir, m.hids.processGUID(e), m.hash(e))
+}
+
 func (m *ActionHandler) prepare(e *event.EdrEvent, filename string) string {
-	id := e.Hash()
-	guid := srcGUIDFromEvent(e)
-	dumpDir := filepath.Join(m.hids.config.Dump.Dir, guid, id)
+	dumpDir := m.eventDumpDir(e)
 	utils.HidsMkdirAll(dumpDir)
 	return filepath.Join(dumpDir, filename)
 }
 
+// beginWriting marks an event dump directory as being written so that it
+// is not pruned until endWriting is called
+func (m *ActionHandler) beginWriting(dir string) {
+	m.writingLock.Lock()
+	defer m.writingLock.Unlock()
+
+	if m.writing == nil {
+		m.writing = make(map[string]int)
+	}
+	m.writing[dir]++
+}
+
+func (m *ActionHandler) endWriting(dir string) {
+	m.writingLock.Lock()
+	defer m.writingLock.Unlock()
+
+	if m.writing[dir]--; m.writing[dir] <= 0 {
+		delete(m.writing, dir)
+	}
+}
+
+func (m *ActionHandler) isWriting(dir string) bool {
+	m.writingLock.Lock()
+	defer m.writingLock.Unlock()
+	return m.writing[dir] > 0
+}
+
+// pruneOldestDump deletes the oldest event dump directory not being
+// written, it returns false if there is nothing left to delete
+func (m *ActionHandler) pruneOldestDump() bool {
+	var oldest *ExportedEvent
+	var oldestTime time.Time
+
+	root := m.hids.config.Dump.Dir
+	events, _, err := walkDumps(root)
+	if err != nil {
+		log.Errorf("Failed to list dumps to prune: %s", err)
+		return false
+	}
+
+	for i, e := range events {
+		if m.isWriting(filepath.Join(root, e.ProcessGUID, e.EventHash)) {
+			continue
+		}
+
+		// an event dump is as old as its most recent file
+		var modified time.Time
+		for _, f := range e.Files {
+			if f.Modified.After(modified) {
+				modified = f.Modified
+			}
+		}
+		if oldest == nil || modified.Before(oldestTime) {
+			oldest = &events[i]
+			oldestTime = modified
+		}
+	}
+
+	if oldest == nil {
+		return false
+	}
+
+	guidDir := filepath.Join(root, oldest.ProcessGUID)
+	evtDir := filepath.Join(guidDir, oldest.EventHash)
+	log.Infof("Low disk space, pruning oldest dump: %s", evtDir)
+	if err := os.RemoveAll(evtDir); err != nil {
+		log.Errorf("Failed to prune dump %s: %s", evtDir, err)
+		return false
+	}
+	// fails if not empty which is what we want
+	os.Remove(guidDir)
+
+	return true
+}
+
+// hasFreeSpace returns true if there is enough free space on dump volume
+// to dump. If configured, oldest dumps are pruned to make room.
+func (m *ActionHandler) hasFreeSpace() bool {
+	c := m.hids.config.Dump
+	min := c.minFreeSpace()
+
+	if min == 0 {
+		return true
+	}
+
+	m.spaceLock.Lock()
+	defer m.spaceLock.Unlock()
+
+	for {
+		free, err := utils.DiskFreeSpace(c.Dir)
+		if err != nil {
+			// we don't prevent dumping if we cannot get free space
+			log.Errorf("Failed to get free disk space of dump directory: %s", err)
+			return true
+		}
+
+		if free >= min {
+			return true
+		}
+
+		if !c.PruneOldest || !m.pruneOldestDump() {
+			atomic.AddUint64(&m.skippedDumps, 1)
+			log.Warnf("Free disk space on dump volume (%dMB) below threshold (%dMB), skipping dump", free/utils.Mega, min/utils.Mega)
+			return false
+		}
+	}
+}
+
+// SkippedDumps returns the number of dumps skipped because of low disk space
+func (m *ActionHandler) SkippedDumps() uint64 {
+	return atomic.LoadUint64(&m.skippedDumps)
+}
+
 func (m *ActionHandler) shouldDump(e *event.EdrEvent) bool {
-	guid := srcGUIDFromEvent(e)
+	guid := m.hids.processGUID(e)
+	// events not related to any process only get actions not targeting a process
+	if guid == nullGUID {
+		return m.checkProcesslessDumpOrInc()
+	}
 	return m.hids.tracker.CheckDumpCountOrInc(guid, m.hids.config.Dump.MaxDumps, m.hids.config.Dump.DumpUntracked)
 }
 
-func (m *ActionHandler) writeReader(dst string, reader io.Reader) error {
-	compress := m.hids.config.Dump.Compression
-	return utils.HidsWriteReader(dst, reader, compress)
+// checkProcesslessDumpOrInc returns true if an event not related to any
+// process can be dumped, at most max-dumps of such events being dumped per
+// processlessDumpWindow
+func (m *ActionHandler) checkProcesslessDumpOrInc() bool {
+	m.Lock()
+	defer m.Unlock()
+
+	if now := time.Now(); now.Sub(m.processlessStart) >= processlessDumpWindow {
+		m.processlessStart = now
+		m.processlessDumps = 0
+	}
+
+	if m.processlessDumps < m.hids.config.Dump.MaxDumps {
+		m.processlessDumps++
+		return true
+	}
+
+	return false
+}
+
+// processActions are the actions targeting the process of an event
+var processActions = []string{
+	ActionKill,
+	ActionBlacklist,
+	ActionMemdump,
+	ActionMemdumpAncestors,
+	ActionSockets,
+}
+
+// skipProcessActions returns true if the actions targeting a process cannot
+// be taken on e because it lacks a process GUID
+func (m *ActionHandler) skipProcessActions(e *event.EdrEvent, det *engine.Detection) bool {
+	if m.hids.processGUID(e) != nullGUID {
+		return false
+	}
+
+	for _, a := range processActions {
+		if det.Actions.Contains(a) {
+			log.Debugf("No process GUID available for event=%s, skipping actions targeting a process", m.hash(e))
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeDump writes a dump to disk, creating its directory if needed
+func (m *ActionHandler) writeDump(dst string, reader io.Reader) (err error) {
+	c := m.hids.config.Dump
+	if err = utils.HidsMkdirAll(filepath.Dir(dst)); err != nil {
+		return
+	}
+	return utils.HidsWriteReader(dst, reader, c.compressionFormat(), c.Level)
+}
+
+func (m *ActionHandler) writeReader(dst string, reader io.ReadSeeker) (err error) {
+	if err = m.writeDump(dst, reader); err != nil {
+		err = m.writeFailure(dst, reader, err)
+	}
+	return
 }
 
 func (m *ActionHandler) dumpAsJson(path string, i interface{}) (err error) {
@@ -102,7 +364,7 @@ func (m *ActionHandler) dumpAsJson(path string, i interface{}) (err error) {
 	if b, err = json.Marshal(i); err != nil {
 		return
 	} else {
-		if err = m.writeReader(path, bytes.NewBuffer(b)); err != nil {
+		if err = m.writeReader(path, bytes.NewReader(b)); err != nil {
 			return
 		}
 	}
@@ -120,22 +382,31 @@ func (m *ActionHandler) dumpFile(src, dst string) (err error) {
 		return
 	}
 
-	if err = utils.HidsMkdirAll(filepath.Dir(dst)); err != nil {
-		return err
+	if m.hids.config.Dump.IsExcluded(src) {
+		log.Infof("Skipping dump of excluded file: %s", src)
+		return
 	}
 
 	if sha256, err = file.Sha256(src); err != nil {
 		return err
 	}
 
-	// dump sha256 of file anyway
-	utils.HidsWriteData(fmt.Sprintf("%s.sha256", dst), []byte(sha256))
+	// dump sha256 of file anyway, failing to write it must not prevent the
+	// file from being dumped as write failure fallback may still apply
+	sha256Path := fmt.Sprintf("%s.sha256", dst)
+	if werr := utils.HidsMkdirAll(filepath.Dir(dst)); werr != nil {
+		log.Errorf("Failed to create dump directory of %s: %s", dst, werr)
+	} else if werr = utils.HidsWriteData(sha256Path, []byte(sha256)); werr != nil {
+		log.Errorf("Failed to write dump sha256 %s: %s", sha256Path, werr)
+	}
+
 	if !m.hids.filedumped.Contains(sha256) {
 		var f *os.File
 		log.Debugf("Dumping file: %s->%s", src, dst)
 		if f, err = os.Open(src); err != nil {
 			return
 		}
+		defer f.Close()
 		if err = m.writeReader(dst, f); err != nil {
 			return
 		}
@@ -218,11 +489,18 @@ func (m *ActionHandler) filedumpSet(e *event.EdrEvent) *datastructs.Set {
 		}
 	}
 
+	// files of the agent (dumps, logs ...) are never dumped
+	for _, i := range s.Slice() {
+		if underDirs(i.(string), m.hids.agentDirs) {
+			s.Del(i)
+		}
+	}
+
 	return s
 }
 
 func (m *ActionHandler) filedump(e *event.EdrEvent) {
-	hash := e.Hash()
+	hash := m.hash(e)
 	for _, i := range m.filedumpSet(e).Slice() {
 		filename := i.(string)
 		if err := m.dumpBinFile(e, filename); err != nil {
@@ -231,12 +509,18 @@ func (m *ActionHandler) filedump(e *event.EdrEvent) {
 	}
 }
 
-func (m *ActionHandler) memdump(e *event.EdrEvent) (err error) {
-	hash := e.Hash()
+// memdump dumps the memory of the process of e. A dump completing after
+// ctx is done is incomplete, the process having been killed meanwhile, so
+// it is deleted.
+func (m *ActionHandler) memdump(ctx context.Context, e *event.EdrEvent) (err error) {
+	hash := m.hash(e)
 	if pt := processTrackFromEvent(m.hids, e); !pt.IsZero() {
-		guid := srcGUIDFromEvent(e)
+		guid := pt.ProcessGUID
 		pid := int(pt.PID)
-		if kernel32.IsPIDRunning(pid) && pid != os.Getpid() && !m.hids.memdumped.Contains(guid) && !m.hids.dumping.Contains(guid) {
+		if m.hids.isProtected(pt.PID, "memdump") {
+			return fmt.Errorf("cannot dump protected process event=%s pid=%d", hash, pid)
+		}
+		if kernel32.IsPIDRunning(pid) && !m.hids.memdumped.Contains(guid) && !m.hids.dumping.Contains(guid) {
 			// To avoid dumping the same process twice, possible if two alerts
 			// comes from the same GUID in a short period of time
 			m.hids.dumping.Add(guid)
@@ -245,7 +529,11 @@ func (m *ActionHandler) memdump(e *event.EdrEvent) (err error) {
 			dumpFilename := fmt.Sprintf("%s_%d_%d.dmp", filepath.Base(pt.Image), pid, time.Now().UnixNano())
 			dumpPath := m.prepare(e, dumpFilename)
 			if err = dbghelp.FullMemoryMiniDump(pid, dumpPath); err != nil {
+				os.Remove(dumpPath)
 				return fmt.Errorf("failed to dump process event=%s pid=%d image=%s: %s", hash, pid, pt.Image, err)
+			} else if ctx.Err() != nil {
+				os.Remove(dumpPath)
+				return fmt.Errorf("dump of process event=%s pid=%d completed too late: %s", hash, pid, ctx.Err())
 			} else {
 				// dump was successfull
 				m.hids.memdumped.Add(guid)
@@ -260,6 +548,211 @@ func (m *ActionHandler) memdump(e *event.EdrEvent) (err error) {
 	return
 }
 
+// AncestorDump holds the outcome of the memdump of an ancestor of the
+// detected process, Depth being 1 for the parent, 2 for the grand-parent ...
+type AncestorDump struct {
+	Depth       int    `json:"depth"`
+	ProcessGUID string `json:"process-guid"`
+	PID         int64  `json:"pid"`
+	Image       string `json:"image"`
+	File        string `json:"file,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// memdumpAncestor dumps the memory of an ancestor of the process of e
+func (m *ActionHandler) memdumpAncestor(e *event.EdrEvent, pt *ProcessTrack, depth int) (path string, err error) {
+	guid := pt.ProcessGUID
+	pid := int(pt.PID)
+
+	switch {
+	case m.hids.isProtected(pt.PID, "memdump"):
+		return "", fmt.Errorf("cannot dump protected process pid=%d", pid)
+	case !kernel32.IsPIDRunning(pid):
+		return "", fmt.Errorf("cannot dump process pid=%d, process is already terminated", pid)
+	case m.hids.memdumped.Contains(guid) || m.hids.dumping.Contains(guid):
+		return "", fmt.Errorf("process pid=%d is already dumped", pid)
+	case !m.hids.tracker.CheckDumpCountOrInc(guid, m.hids.config.Dump.MaxDumps, m.hids.config.Dump.DumpUntracked):
+		return "", fmt.Errorf("maximum number of dumps reached for process pid=%d", pid)
+	case !m.hasFreeSpace():
+		return "", fmt.Errorf("not enough free disk space to dump process pid=%d", pid)
+	}
+
+	m.hids.dumping.Add(guid)
+	defer m.hids.dumping.Del(guid)
+
+	path = m.prepare(e, fmt.Sprintf("ancestor-%d_%s_%d_%d.dmp", depth, filepath.Base(pt.Image), pid, time.Now().UnixNano()))
+	if err = dbghelp.FullMemoryMiniDump(pid, path); err != nil {
+		return "", fmt.Errorf("failed to dump process pid=%d image=%s: %s", pid, pt.Image, err)
+	}
+
+	m.hids.memdumped.Add(guid)
+	m.compress(path)
+
+	return
+}
+
+// memdumpAncestors dumps the memory of the ancestors of the process of e,
+// walking up the process tree up to the configured number of ancestors. The
+// outcome of every ancestor dump is dumped along with the other artifacts.
+func (m *ActionHandler) memdumpAncestors(e *event.EdrEvent) {
+	hash := m.hash(e)
+	report := make([]AncestorDump, 0)
+
+	pt := processTrackFromEvent(m.hids, e)
+	if pt.IsZero() {
+		log.Errorf("Cannot dump ancestors of untracked process event=%s", hash)
+		return
+	}
+
+	for depth := 1; depth <= m.hids.config.Actions.memdumpAncestors(); depth++ {
+		if pt = m.hids.tracker.GetByGuid(pt.ParentProcessGUID); pt.IsZero() {
+			break
+		}
+
+		d := AncestorDump{Depth: depth, ProcessGUID: pt.ProcessGUID, PID: pt.PID, Image: pt.Image}
+		if path, err := m.memdumpAncestor(e, pt, depth); err != nil {
+			log.Errorf("Failed to dump ancestor depth=%d of event=%s: %s", depth, hash, err)
+			d.Error = err.Error()
+		} else {
+			d.File = filepath.Base(path)
+		}
+		report = append(report, d)
+	}
+
+	if err := m.dumpAsJson(m.prepare(e, "memdump-ancestors.json"), report); err != nil {
+		log.Errorf("Failed to dump ancestors memdump report for event %s: %s", hash, err)
+	}
+}
+
+// ProcessSocketsReport holds the sockets opened by a process at detection time
+type ProcessSocketsReport struct {
+	PID         int64          `json:"pid"`
+	ProcessGUID string         `json:"process-guid,omitempty"`
+	Image       string         `json:"image,omitempty"`
+	Terminated  bool           `json:"terminated"`
+	Error       string         `json:"error,omitempty"`
+	Timestamp   time.Time      `json:"timestamp"`
+	Sockets     []utils.Socket `json:"sockets"`
+}
+
+// processSockets captures the sockets opened by the process of e. It must be
+// called before the process is killed.
+func (m *ActionHandler) processSockets(e *event.EdrEvent) (r *ProcessSocketsReport) {
+	var err error
+
+	r = &ProcessSocketsReport{Timestamp: time.Now(), Sockets: make([]utils.Socket, 0)}
+
+	pt := processTrackFromEvent(m.hids, e)
+	if pt.IsZero() {
+		r.Error = "untracked process"
+		return
+	}
+
+	r.PID, r.ProcessGUID, r.Image = pt.PID, pt.ProcessGUID, pt.Image
+
+	// sockets of a dead process are gone
+	if !kernel32.IsPIDRunning(int(pt.PID)) {
+		r.Terminated = true
+		return
+	}
+
+	if r.Sockets, err = utils.ProcessSockets(uint32(pt.PID)); err != nil {
+		r.Sockets = make([]utils.Socket, 0)
+		r.Error = err.Error()
+	}
+
+	return
+}
+
+// memdumpOnDemand dumps the memory of a process on operator request. As it
+// is not triggered by a detection the criticality threshold does not apply,
+// only the dump count limit does. Dump is made in a directory named after id.
+func (m *ActionHandler) memdumpOnDemand(pt *ProcessTrack, id string) (path string, err error) {
+	guid := pt.ProcessGUID
+	pid := int(pt.PID)
+
+	switch {
+	case m.hids.isProtected(pt.PID, "memdump"):
+		return "", fmt.Errorf("cannot dump protected process pid=%d", pid)
+	case !kernel32.IsPIDRunning(pid):
+		return "", fmt.Errorf("cannot dump process pid=%d, process is already terminated", pid)
+	case m.hids.dumping.Contains(guid):
+		return "", fmt.Errorf("process pid=%d is already being dumped", pid)
+	case !m.hids.tracker.CheckDumpCountOrInc(guid, m.hids.config.Dump.MaxDumps, m.hids.config.Dump.DumpUntracked):
+		return "", fmt.Errorf("maximum number of dumps reached for process pid=%d", pid)
+	case !m.hasFreeSpace():
+		return "", fmt.Errorf("not enough free disk space to dump process pid=%d", pid)
+	}
+
+	m.hids.dumping.Add(guid)
+	defer m.hids.dumping.Del(guid)
+
+	dumpDir := filepath.Join(m.hids.config.Dump.Dir, guid, id)
+	utils.HidsMkdirAll(dumpDir)
+	path = filepath.Join(dumpDir, fmt.Sprintf("%s_%d_%d.dmp", filepath.Base(pt.Image), pid, time.Now().UnixNano()))
+
+	if err = dbghelp.FullMemoryMiniDump(pid, path); err != nil {
+		return "", fmt.Errorf("failed to dump process pid=%d image=%s: %s", pid, pt.Image, err)
+	}
+
+	m.hids.memdumped.Add(guid)
+	m.compress(path)
+
+	return
+}
+
+// MemdumpReport holds the outcome of a memdump action
+type MemdumpReport struct {
+	Success  bool          `json:"success"`
+	Timeout  bool          `json:"timeout"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// memdumpAndWait dumps process memory. If the process has to be killed
+// afterwards we wait at most the configured memdump timeout. The outcome
+// of the memdump is dumped along with the other artifacts.
+func (m *ActionHandler) memdumpAndWait(e *event.EdrEvent, kill bool) {
+	var r MemdumpReport
+
+	start := time.Now()
+	done := make(chan error, 1)
+
+	// the memdump cannot be interrupted, it is only abandoned when the
+	// context is done
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if kill {
+		ctx, cancel = context.WithTimeout(m.ctx, m.hids.config.Actions.memdumpTimeout())
+	} else {
+		ctx, cancel = context.WithCancel(m.ctx)
+	}
+	defer cancel()
+
+	go func() {
+		done <- m.memdump(ctx, e)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Error(err)
+			r.Error = err.Error()
+		} else {
+			r.Success = true
+		}
+	case <-ctx.Done():
+		r.Timeout = true
+		r.Error = fmt.Sprintf("memdump did not complete within %s", m.hids.config.Actions.memdumpTimeout())
+		log.Errorf("Memdump of event=%s did not complete within %s, killing process anyway", m.hash(e), m.hids.config.Actions.memdumpTimeout())
+	}
+	r.Duration = time.Since(start)
+
+	if err := m.dumpAsJson(m.prepare(e, "memdump.json"), r); err != nil {
+		log.Errorf("Failed to dump memdump report for event %s: %s", m.hash(e), err)
+	}
+}
+
 func (m *ActionHandler) regdump(e *event.EdrEvent) {
 	var err error
 	var content string
@@ -277,7 +770,7 @@ func (m *ActionHandler) regdump(e *event.EdrEvent) {
 							log.Errorf("Failed to run reg query: %s", err)
 							content = fmt.Sprintf("HIDS error dumping %s: %s", targetObject, err)
 						}
-						if err = m.writeReader(dumpPath, bytes.NewBufferString(content)); err != nil {
+						if err = m.writeReader(dumpPath, strings.NewReader(content)); err != nil {
 							log.Errorf("Failed to write registry content to file: %s", err)
 						}
 					}
@@ -291,7 +784,7 @@ func (m *ActionHandler) regdump(e *event.EdrEvent) {
 func (m *ActionHandler) suspend_process(e *event.EdrEvent) {
 	if pt := processTrackFromEvent(m.hids, e); !pt.IsZero() {
 		// additional check not to suspend agent
-		if pt.PID != int64(os.Getpid()) {
+		if !m.hids.isProtected(pt.PID, "suspend") {
 			// before we kill we suspend the process
 			kernel32.SuspendProcess(int(pt.PID))
 		}
@@ -300,10 +793,10 @@ func (m *ActionHandler) suspend_process(e *event.EdrEvent) {
 
 func (m *ActionHandler) kill_process(e *event.EdrEvent) error {
 	if pt := processTrackFromEvent(m.hids, e); !pt.IsZero() {
-		// additional check not to suspend agent
-		if pt.PID != int64(os.Getpid()) {
+		// additional check not to kill agent
+		if !m.hids.isProtected(pt.PID, "kill") {
 			if err := pt.TerminateProcess(); err != nil {
-				return fmt.Errorf("failed to kill process for event=%s image=%s pid=%d guid=%s", e.Hash(), pt.Image, pt.PID, pt.ProcessGUID)
+				return fmt.Errorf("failed to kill process for event=%s image=%s pid=%d guid=%s", m.hash(e), pt.Image, pt.PID, pt.ProcessGUID)
 
 			}
 		}
@@ -311,6 +804,66 @@ func (m *ActionHandler) kill_process(e *event.EdrEvent) error {
 	return nil
 }
 
+// applyRuleActions enforces precedence of actions declared in rules over
+// tier actions. Engine already merges both, so this only needs to act when
+// rule actions override tier ones or when a canary has been touched.
+func (h *HIDS) applyRuleActions(e *event.EdrEvent) {
+	det := e.GetDetection()
+
+	if det == nil {
+		return
+	}
+
+	if h.applyCanaryActions(e, det) {
+		return
+	}
+
+	if !h.config.Actions.overrideTierActions() || det.Actions == nil {
+		return
+	}
+
+	actions := datastructs.NewSet()
+	for _, name := range det.Signature.Slice() {
+		if r := h.Engine.GetCRuleByName(name.(string)); r != nil {
+			actions.Add(datastructs.ToInterfaceSlice(r.Actions)...)
+		}
+	}
+
+	// tier actions apply if no rule declares actions
+	if actions.Len() > 0 {
+		det.Actions = actions
+	}
+}
+
+// applyCanaryActions makes detections of canaries carry the actions
+// configured for canaries, whatever the actions of their criticality tier.
+// Actions declared by other rules matched still apply. It returns true if
+// the detection is a canary detection.
+func (h *HIDS) applyCanaryActions(e *event.EdrEvent, det *engine.Detection) bool {
+	var canary bool
+
+	actions := datastructs.NewSet()
+	for _, name := range det.Signature.Slice() {
+		if isCanaryRule(name.(string)) {
+			canary = true
+			continue
+		}
+		if r := h.Engine.GetCRuleByName(name.(string)); r != nil {
+			actions.Add(datastructs.ToInterfaceSlice(r.Actions)...)
+		}
+	}
+
+	if !canary {
+		return false
+	}
+
+	actions.Add(datastructs.ToInterfaceSlice(h.config.CanariesConfig.Actions)...)
+	det.Actions = actions
+	e.Set(pathActionsOrigin, ActionsOriginCanary)
+
+	return true
+}
+
 func (m *ActionHandler) Queue(e *event.EdrEvent) {
 	if !m.hids.IsHIDSEvent(e) && m.hids.config.Endpoint {
 		if det := e.GetDetection(); det != nil {
@@ -321,75 +874,129 @@ func (m *ActionHandler) Queue(e *event.EdrEvent) {
 	}
 }
 
+// hasDumpAction returns true if one of the actions of the detection produces dumps
+func hasDumpAction(det *engine.Detection) bool {
+	for _, a := range DumpActions {
+		if det.Actions.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *ActionHandler) HandleActions(e *event.EdrEvent) {
 
 	det := e.GetDetection()
 
-	if m.shouldDump(e) && !m.hids.IsHIDSEvent(e) && det != nil {
-		hash := e.Hash()
+	// events are still forwarded but actions are not taken
+	if m.Suppression().Active() {
+		log.Debugf("Actions suppressed, skipping actions for event %s", m.hash(e))
+		return
+	}
+
+	if m.hids.IsHIDSEvent(e) || det == nil {
+		return
+	}
 
-		// Test variables
-		report := det.Actions.Contains(ActionReport)
-		brief := det.Actions.Contains(ActionBrief)
-		kill := det.Actions.Contains(ActionKill)
+	hash := m.hash(e)
 
-		// handling blacklisting action
-		if det.Actions.Contains(ActionBlacklist) {
-			if pt := processTrackFromEvent(m.hids, e); !pt.IsZero() {
-				// additional check not to blacklist agent
-				if int(pt.PID) != os.Getpid() {
-					m.hids.tracker.Blacklist(pt.CommandLine)
-				}
-			}
-		}
+	// dumps of the event must not be pruned while actions are taken
+	dumpDir := m.eventDumpDir(e)
+	m.beginWriting(dumpDir)
+	defer m.endWriting(dumpDir)
 
-		if kill {
-			// we suspend process before to kill it so that we can
-			// memdump it
-			m.suspend_process(e)
-		}
+	// process actions are skipped for events lacking a process GUID, other
+	// actions still apply
+	process := !m.skipProcessActions(e, det)
 
-		// handling report memdumping
-		if det.Actions.Contains(ActionMemdump) {
-			if err := m.memdump(e); err != nil {
-				log.Error(err)
-			}
-		}
+	// Test variables
+	report := det.Actions.Contains(ActionReport)
+	brief := det.Actions.Contains(ActionBrief)
+	kill := process && det.Actions.Contains(ActionKill)
+	// the dump decision derives from the actions configured for the event
+	// criticality, dump limit only applies to events producing dumps
+	dump := hasDumpAction(det) && m.shouldDump(e) && m.hasFreeSpace()
 
-		// we kill the process after we dumped memory
-		if kill {
-			if err := m.kill_process(e); err != nil {
-				log.Error(err)
+	// handling blacklisting action
+	if process && det.Actions.Contains(ActionBlacklist) {
+		if pt := processTrackFromEvent(m.hids, e); !pt.IsZero() {
+			// additional check not to blacklist agent
+			if !m.hids.isProtected(pt.PID, "blacklist") {
+				m.hids.tracker.Blacklist(pt.CommandLine)
 			}
 		}
+	}
 
-		// handling report dumping
-		if (report || brief) && m.hids.config.Report.EnableReporting {
-			if err := m.dumpAsJson(m.prepare(e, "report.json"), m.hids.Report(brief)); err != nil {
-				log.Errorf("Failed to dump report for event %s: %s", hash, err)
-			}
+	// sockets are captured first, they may be ephemeral
+	var sockets *ProcessSocketsReport
+	if dump && process && det.Actions.Contains(ActionSockets) {
+		sockets = m.processSockets(e)
+		if sockets.Error != "" {
+			log.Errorf("Failed to capture sockets of event=%s: %s", hash, sockets.Error)
 		}
+	}
 
-		// handling filedumping
-		if det.Actions.Contains(ActionFiledump) {
-			m.filedump(e)
-		}
+	if kill {
+		// we suspend process before to kill it so that we can
+		// memdump it
+		m.suspend_process(e)
+	}
 
-		// handling regdumping
-		if det.Actions.Contains(ActionRegdump) {
-			m.regdump(e)
+	// handling report memdumping
+	if dump && process && (det.Actions.Contains(ActionMemdump) || det.Actions.Contains(ActionMemdumpAncestors)) {
+		m.memdumpAndWait(e, kill)
+	}
+
+	// we kill the process after we dumped memory
+	if kill {
+		if err := m.kill_process(e); err != nil {
+			log.Error(err)
 		}
+	}
+
+	// ancestors are not killed so they are dumped afterwards
+	if dump && process && det.Actions.Contains(ActionMemdumpAncestors) {
+		m.memdumpAncestors(e)
+	}
+
+	if !dump {
+		return
+	}
 
-		// dumping the event
-		if err := m.dumpAsJson(m.prepare(e, "event.json"), e); err != nil {
-			log.Errorf("Failed to dump event %s: %s", hash, err)
+	// handling report dumping
+	if (report || brief) && m.hids.config.Report.EnableReporting {
+		r := m.hids.Report(brief)
+		r.Sockets = sockets
+		if err := m.dumpAsJson(m.prepare(e, "report.json"), r); err != nil {
+			log.Errorf("Failed to dump report for event %s: %s", hash, err)
+		}
+	} else if sockets != nil {
+		// sockets are dumped on their own when no report is dumped
+		if err := m.dumpAsJson(m.prepare(e, "sockets.json"), sockets); err != nil {
+			log.Errorf("Failed to dump sockets for event %s: %s", hash, err)
 		}
+	}
+
+	// handling filedumping
+	if det.Actions.Contains(ActionFiledump) {
+		m.filedump(e)
+	}
+
+	// handling regdumping
+	if det.Actions.Contains(ActionRegdump) {
+		m.regdump(e)
+	}
 
+	// dumping the event
+	if err := m.dumpAsJson(m.prepare(e, "event.json"), e); err != nil {
+		log.Errorf("Failed to dump event %s: %s", hash, err)
 	}
 }
 
 func (m *ActionHandler) compress(path string) {
 	if m.hids.config.Dump.Compression {
+		// dump is written again when compressed
+		m.beginWriting(filepath.Dir(path))
 		m.compressionQueue.Push(path)
 	}
 }
@@ -400,9 +1007,11 @@ func (m *ActionHandler) compressionRoutine() {
 			for m.compressionQueue.Len() > 0 {
 				if elt := m.compressionQueue.Pop(); elt != nil {
 					path := elt.Value.(string)
-					if err := utils.GzipFileBestSpeed(path); err != nil {
+					c := m.hids.config.Dump
+					if err := utils.CompressFile(path, c.compressionFormat(), c.Level); err != nil {
 						log.Errorf(`Failed to compress %s: %s`, path, err)
 					}
+					m.endWriting(filepath.Dir(path))
 				}
 			}
 			time.Sleep(time.Second)
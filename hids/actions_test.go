@@ -0,0 +1,76 @@
+package hids
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0xrawsec/golang-utils/fsutil"
+)
+
+func TestProcesslessDumpLimit(t *testing.T) {
+	m := &ActionHandler{hids: &HIDS{config: &Config{Dump: &DumpConfig{MaxDumps: 2}}}}
+
+	for i := 0; i < 2; i++ {
+		if !m.checkProcesslessDumpOrInc() {
+			t.Errorf("Dump %d must be allowed", i)
+		}
+	}
+
+	if m.checkProcesslessDumpOrInc() {
+		t.Error("Dumps of events without process must be bounded")
+	}
+
+	// limit applies per window
+	m.processlessStart = time.Now().Add(-processlessDumpWindow)
+	if !m.checkProcesslessDumpOrInc() {
+		t.Error("Dump must be allowed in a new window")
+	}
+}
+
+func TestPruneOldestDumpSkipsWriting(t *testing.T) {
+	root := t.TempDir()
+	m := &ActionHandler{hids: &HIDS{config: &Config{Dump: &DumpConfig{Dir: root}}}}
+
+	guid := "{b54a8ec6-0f5c-4e8b-9e4f-3c1de2f4a7b1}"
+	oldest := filepath.Join(root, guid, "oldest")
+	newest := filepath.Join(root, guid, "newest")
+
+	for i, dir := range []string{oldest, newest} {
+		path := filepath.Join(dir, "event.json")
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if err := os.WriteFile(path, []byte("{}"), 0600); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		modified := time.Now().Add(time.Duration(i-2) * time.Hour)
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+	}
+
+	// oldest dump is being written
+	m.beginWriting(oldest)
+
+	if !m.pruneOldestDump() {
+		t.Error("A dump must be pruned")
+	}
+
+	if !fsutil.IsDir(oldest) || fsutil.Exists(newest) {
+		t.Error("Only dumps not being written must be pruned")
+	}
+
+	if m.pruneOldestDump() {
+		t.Error("Dump being written must never be pruned")
+	}
+
+	m.endWriting(oldest)
+	if !m.pruneOldestDump() || fsutil.Exists(oldest) {
+		t.Error("Dump must be pruned once written")
+	}
+}
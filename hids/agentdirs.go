@@ -0,0 +1,46 @@
+package hids
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// agentDirs returns the absolute paths of the directories the agent writes
+// to or runs from: installation, dumps and logs directories
+func (c *Config) agentDirs() (dirs []string) {
+	dirs = make([]string, 0)
+	seen := make(map[string]bool)
+
+	candidates := []string{c.Dump.Dir, c.FwdConfig.Logging.Dir}
+	if c.Logfile != "" {
+		candidates = append(candidates, filepath.Dir(c.Logfile))
+	}
+	if exe, err := os.Executable(); err == nil {
+		candidates = append(candidates, filepath.Dir(exe))
+	}
+
+	for _, dir := range candidates {
+		if dir == "" {
+			continue
+		}
+		if dir = normalizeDumpPath(dir); !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return
+}
+
+// underDirs returns true if path is one of dirs or is located under one
+// of them, dirs being normalized paths
+func underDirs(path string, dirs []string) bool {
+	path = normalizeDumpPath(path)
+	for _, dir := range dirs {
+		if path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, string(os.PathSeparator))+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package hids
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/0xrawsec/golang-utils/fsutil"
+	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/whids/sysmon"
+)
+
+// sysmonArchiveDirectory returns the absolute path of the archive directory
+// configured in Sysmon. Sysmon archive directory is a directory name
+// relative to the root of the volume.
+func sysmonArchiveDirectory() (dir string, err error) {
+	info := sysmon.NewSysmonInfo()
+	if info.Err != nil {
+		return "", info.Err
+	}
+
+	if dir, err = info.ArchiveDirectory(); err != nil || dir == "" {
+		return
+	}
+
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(os.Getenv("SystemDrive")+`\`, dir)
+	}
+
+	return
+}
+
+// checkSysmonArchive verifies that the archive directory configured is
+// consistent with Sysmon configuration, so that deleted files and clipboard
+// contents archived by Sysmon can be dumped.
+func (h *HIDS) checkSysmonArchive() {
+	c := h.config.Sysmon
+
+	sysmonDir, err := sysmonArchiveDirectory()
+	if err != nil {
+		log.Warnf("Failed to retrieve Sysmon archive directory, cannot check archive configuration: %s", err)
+		return
+	}
+
+	switch {
+	case sysmonDir == "":
+		log.Warnf("Sysmon is not configured to archive files, deleted files and clipboard contents will not be dumped")
+		return
+	case c.ArchiveDirectory == "":
+		if c.followArchive() {
+			log.Infof("Using Sysmon archive directory: %s", sysmonDir)
+			c.ArchiveDirectory = sysmonDir
+			return
+		}
+		log.Warnf("Sysmon archives files to %s but no archive directory is configured, deleted files and clipboard contents will not be dumped", sysmonDir)
+		return
+	case !strings.EqualFold(filepath.Clean(c.ArchiveDirectory), filepath.Clean(sysmonDir)):
+		if c.followArchive() {
+			log.Infof("Configured archive directory %s does not match Sysmon one, using Sysmon archive directory: %s", c.ArchiveDirectory, sysmonDir)
+			c.ArchiveDirectory = sysmonDir
+			return
+		}
+		log.Warnf("Configured archive directory %s does not match Sysmon one %s, deleted files and clipboard contents will not be dumped", c.ArchiveDirectory, sysmonDir)
+		return
+	}
+
+	// directory is created by Sysmon when it archives the first file
+	if !fsutil.IsDir(c.ArchiveDirectory) {
+		log.Infof("Sysmon archive directory %s does not exist yet", c.ArchiveDirectory)
+	}
+}
@@ -0,0 +1,158 @@
+package hids
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// AttackSourceMeta takes ATT&CK information from the ATTACK meta section of rules
+	AttackSourceMeta = "meta"
+	// AttackSourceTags takes ATT&CK information from the tags of rules
+	AttackSourceTags = "tags"
+
+	// DefaultAttackTagPrefix is the default prefix of rule tags carrying ATT&CK information
+	DefaultAttackTagPrefix = "attack."
+)
+
+var (
+	attackTechniqueRe = regexp.MustCompile(`(?i)^t\d{4}(\.\d{3})?$`)
+)
+
+// AttackConfig configures tagging of detections with the ATT&CK
+// techniques and tactics of the rules matched
+type AttackConfig struct {
+	Enable    bool   `toml:"enable" comment:"Enable tagging of detections with ATT&CK techniques and tactics"`
+	Source    string `toml:"source" comment:"Where ATT&CK information is taken from in rules: meta or tags\n meta: ATTACK section of rule meta (ID and Tactic fields)\n tags: rule tags starting with tag-prefix (ex: attack.t1059.001, attack.execution)"`
+	TagPrefix string `toml:"tag-prefix" comment:"Prefix of the rule tags carrying ATT&CK information (default: attack.)"`
+}
+
+// IsEnabled returns true if ATT&CK tagging is enabled
+func (c *AttackConfig) IsEnabled() bool {
+	return c != nil && c.Enable
+}
+
+// Verify validates ATT&CK tagging configuration
+func (c *AttackConfig) Verify() error {
+	if c == nil {
+		return nil
+	}
+
+	switch c.Source {
+	case "", AttackSourceMeta, AttackSourceTags:
+	default:
+		return fmt.Errorf("unknown ATT&CK source: %s", c.Source)
+	}
+
+	return nil
+}
+
+func (c *AttackConfig) tagPrefix() string {
+	if c.TagPrefix == "" {
+		return DefaultAttackTagPrefix
+	}
+	return c.TagPrefix
+}
+
+// attackTagger tags detections with the ATT&CK information of the rules
+// they matched and counts detections per technique
+type attackTagger struct {
+	sync.Mutex
+	enabled    bool
+	source     string
+	prefix     string
+	techniques map[string]int64
+}
+
+func newAttackTagger(c *AttackConfig) *attackTagger {
+	t := &attackTagger{techniques: make(map[string]int64)}
+
+	if c.IsEnabled() {
+		t.enabled = true
+		t.source = c.Source
+		t.prefix = strings.ToLower(c.tagPrefix())
+	}
+
+	return t
+}
+
+func (t *attackTagger) fromMeta(r *engine.CompiledRule, a *event.Attack) {
+	for _, att := range r.Attack {
+		a.Add(strings.ToUpper(att.ID), strings.ToLower(att.Tactic))
+	}
+}
+
+func (t *attackTagger) fromTags(r *engine.CompiledRule, a *event.Attack) {
+	for _, i := range r.Tags.Slice() {
+		tag := strings.ToLower(i.(string))
+		if !strings.HasPrefix(tag, t.prefix) {
+			continue
+		}
+
+		if v := strings.TrimPrefix(tag, t.prefix); attackTechniqueRe.MatchString(v) {
+			a.Add(strings.ToUpper(v), "")
+		} else {
+			a.Add("", strings.ReplaceAll(v, "_", "-"))
+		}
+	}
+}
+
+// Tag attaches to the detection e the ATT&CK techniques and tactics of the
+// rules it matched
+func (t *attackTagger) Tag(eng *engine.Engine, e *event.EdrEvent) {
+	if !t.enabled {
+		return
+	}
+
+	det := e.GetDetection()
+	if det == nil {
+		return
+	}
+
+	a := &event.Attack{}
+	for _, i := range det.Signature.Slice() {
+		r := eng.GetCRuleByName(i.(string))
+		if r == nil {
+			continue
+		}
+
+		switch t.source {
+		case AttackSourceTags:
+			t.fromTags(r, a)
+		default:
+			t.fromMeta(r, a)
+		}
+	}
+
+	if a.IsEmpty() {
+		return
+	}
+
+	sort.Strings(a.Techniques)
+	sort.Strings(a.Tactics)
+	e.Event.Attack = a
+
+	t.Lock()
+	defer t.Unlock()
+	for _, tech := range a.Techniques {
+		t.techniques[tech]++
+	}
+}
+
+// Techniques returns the number of detections tagged with every technique
+func (t *attackTagger) Techniques() map[string]int64 {
+	t.Lock()
+	defer t.Unlock()
+
+	m := make(map[string]int64, len(t.techniques))
+	for k, v := range t.techniques {
+		m[k] = v
+	}
+	return m
+}
@@ -0,0 +1,251 @@
+package hids
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/golang-evtx/evtx"
+	"github.com/0xrawsec/golang-utils/datastructs"
+	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/golang-utils/readers"
+	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/hids/sysinfo"
+	"github.com/0xrawsec/whids/utils"
+)
+
+var (
+	evtxComputerPath  = evtx.Path("/Event/System/Computer")
+	evtxProviderPath  = evtx.Path("/Event/System/Provider/Name")
+	evtxProcessIDPath = evtx.Path("/Event/System/Execution/ProcessID")
+	evtxThreadIDPath  = evtx.Path("/Event/System/Execution/ThreadID")
+	evtxEventDataPath = evtx.Path("/Event/EventData")
+	evtxUserDataPath  = evtx.Path("/Event/UserData")
+)
+
+// BenchmarkResult holds the results of a benchmark run
+type BenchmarkResult struct {
+	Events      int64
+	Detections  int64
+	Filtered    int64
+	Dropped     int64
+	Duration    time.Duration
+	EPS         float64
+	RuleMatches map[string]int64
+	PreHooks    []HookStats
+	PostHooks   []HookStats
+	// slowest rules, only if rule profiling is enabled
+	SlowestRules []RuleProfile
+}
+
+// NewBenchmarkHIDS creates a HIDS meant to replay events offline. Nothing
+// is configured on the system (ETW, canaries, audit policies ...), rules
+// are loaded from local storage only and no action is ever taken.
+func NewBenchmarkHIDS(c *Config) (h *HIDS, err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// rules must only be loaded from local storage
+	c.FwdConfig = &api.ForwarderConfig{Local: true}
+
+	h = &HIDS{
+		ctx:         ctx,
+		cancel:      cancel,
+		stats:       NewEventStats(MaxEPS, MaxEPSDuration),
+		preHooks:    NewHookMan(),
+		postHooks:   NewHookMan(),
+		channels:    datastructs.NewSyncedSet(),
+		config:      c,
+		waitGroup:   sync.WaitGroup{},
+		tracker:     NewActivityTracker(),
+		memdumped:   utils.NewLRUSet(c.Dump.MaxDumpedEntries),
+		dumping:     utils.NewLRUSet(c.Dump.MaxDumpedEntries),
+		filedumped:  utils.NewLRUSet(c.Dump.MaxDumpedEntries),
+		svcResolver: newServiceResolver(),
+		liveTraces:  newLiveTraces(),
+		systemInfo:  &sysinfo.SystemInfo{},
+		protected:   newProtectedPIDs(c.Actions.ProtectChildren),
+		denylist:    newDenylist(c.Denylist),
+		profiler:    newRuleProfiler(c.RuleProfiling),
+		attack:      newAttackTagger(c.Attack),
+	}
+
+	if err = c.Verify(); err != nil {
+		return nil, err
+	}
+
+	h.tracker.SetMaxTracked(c.MaxTracked)
+	h.tracker.SetMaxCommandLine(c.Tracking.CommandLineLimit())
+	h.tracker.SetMaxDrivers(c.Tracking.DriversLimit())
+	h.tracker.SetBlacklistNormalization(c.Actions.NormalizeBlacklist)
+	h.initHooks(c.EnableHooks)
+	h.preHooks.EnableProfiling()
+	h.postHooks.EnableProfiling()
+
+	if err = h.update(true); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+func evtxToEdrEvent(m *evtx.GoEvtxMap) (e *event.EdrEvent, err error) {
+	var eid int64
+	var data *evtx.GoEvtxMap
+
+	if eid, err = m.GetInt(&evtx.EventIDPath); err != nil {
+		if eid, err = m.GetInt(&evtx.EventIDPath2); err != nil {
+			return
+		}
+	}
+
+	// missing optional fields are left empty
+	pid, _ := m.GetUint(&evtxProcessIDPath)
+	tid, _ := m.GetUint(&evtxThreadIDPath)
+
+	etwEvt := &etw.Event{}
+	etwEvt.System.EventID = uint16(eid)
+	etwEvt.System.Channel, _ = m.GetString(&evtx.ChannelPath)
+	etwEvt.System.Computer, _ = m.GetString(&evtxComputerPath)
+	etwEvt.System.Provider.Name, _ = m.GetString(&evtxProviderPath)
+	etwEvt.System.Execution.ProcessID = uint32(pid)
+	etwEvt.System.Execution.ThreadID = uint32(tid)
+	if etwEvt.System.TimeCreated.SystemTime, err = m.GetTime(&evtx.SystemTimePath); err != nil {
+		return
+	}
+
+	if data, err = m.GetMap(&evtxEventDataPath); err == nil {
+		etwEvt.EventData = *data
+	}
+	if data, err = m.GetMap(&evtxUserDataPath); err == nil {
+		etwEvt.UserData = *data
+	}
+
+	return event.NewEdrEvent(etwEvt), nil
+}
+
+// BenchmarkEvents returns a channel of events read from a file. EVTX files
+// (.evtx extension) are supported as well as JSON lines files containing EDR
+// events (as logged by the agent).
+func BenchmarkEvents(path string) (ce chan *event.EdrEvent, err error) {
+	ce = make(chan *event.EdrEvent)
+
+	if strings.ToLower(filepath.Ext(path)) == ".evtx" {
+		var ef evtx.File
+
+		if ef, err = evtx.OpenDirty(path); err != nil {
+			return
+		}
+
+		go func() {
+			defer close(ce)
+			defer ef.Close()
+			for m := range ef.FastEvents() {
+				if e, err := evtxToEdrEvent(m); err != nil {
+					log.Errorf("Failed to convert EVTX event: %s", err)
+				} else {
+					ce <- e
+				}
+			}
+		}()
+		return
+	}
+
+	var fd *os.File
+	if fd, err = os.Open(path); err != nil {
+		return
+	}
+
+	go func() {
+		defer close(ce)
+		defer fd.Close()
+		for line := range readers.Readlines(fd) {
+			e := event.EdrEvent{}
+			if err := json.Unmarshal(line, &e); err != nil {
+				log.Errorf("Failed to decode JSON event: %s", err)
+				continue
+			}
+			ce <- &e
+		}
+	}()
+
+	return
+}
+
+// Benchmark replays events through the same hooks and detection engine as
+// the live agent without taking any action nor forwarding anything
+func (h *HIDS) Benchmark(events chan *event.EdrEvent) (r BenchmarkResult) {
+	r.RuleMatches = make(map[string]int64)
+
+	start := time.Now()
+	for e := range events {
+		r.Events++
+
+		h.truncateEvent(e)
+
+		var names []string
+		var crit int
+		var filtered bool
+
+		if h.denylist.Match(e) {
+			if names, _, filtered = h.Engine.MatchOrFilter(e); len(names) == 0 && !filtered {
+				r.Dropped++
+				continue
+			}
+			e.Event.Detection = nil
+		}
+
+		h.preHooks.RunHooksOn(h, e)
+
+		if h.IsHIDSEvent(e) && !isSysmonProcessTerminate(e) {
+			continue
+		}
+
+		if e.IsSkipped() {
+			continue
+		}
+
+		h.profiler.Profile(h.Engine, e)
+
+		names, crit, filtered = h.Engine.MatchOrFilter(e)
+		// redaction runs once detection is done as on endpoints
+		h.redact(e)
+
+		if len(names) > 0 || filtered {
+			for _, name := range names {
+				r.RuleMatches[name]++
+			}
+
+			if filtered {
+				r.Filtered++
+			}
+
+			if crit >= h.config.CritTresh && !h.suppressions.Suppressed(e, names) {
+				r.Detections++
+				h.postHooks.RunHooksOn(h, e)
+			}
+		}
+
+		if h.PrintAll {
+			fmt.Println(utils.JsonString(e))
+		}
+	}
+
+	r.Duration = time.Since(start)
+	if r.Duration > 0 {
+		r.EPS = float64(r.Events) / r.Duration.Seconds()
+	}
+	r.PreHooks = h.preHooks.Stats()
+	r.PostHooks = h.postHooks.Stats()
+	if h.config.RuleProfiling.IsEnabled() {
+		r.SlowestRules = h.profiler.Top()
+	}
+
+	return
+}
@@ -7,34 +7,219 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/0xrawsec/gene/v2/engine"
 	"github.com/0xrawsec/golang-utils/datastructs"
 	"github.com/0xrawsec/golang-utils/fsutil"
 	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/whids/event"
 	"github.com/0xrawsec/whids/utils"
 )
 
+const (
+	// special token expanding to every user profile directory
+	userProfilesToken = "USERPROFILES"
+	// UserProfilesVar is the variable expanding to every user profile
+	// directory in canary directories and dump exclusions
+	UserProfilesVar = "$" + userProfilesToken
+)
+
+var (
+	// profiles not belonging to an actual user
+	nonUserProfiles = datastructs.NewInitSyncedSet("default", "default user", "all users", "public")
+)
+
+// usersRoot returns the directory containing the user profiles
+func usersRoot() string {
+	if public := os.Getenv("PUBLIC"); public != "" {
+		return filepath.Dir(public)
+	}
+	return filepath.Join(os.Getenv("SYSTEMDRIVE")+string(os.PathSeparator), "Users")
+}
+
+// userProfiles returns the list of the user profile directories
+func userProfiles() (profiles []string) {
+	profiles = make([]string, 0)
+
+	root := usersRoot()
+	if entries, err := os.ReadDir(root); err == nil {
+		for _, e := range entries {
+			if e.IsDir() && !nonUserProfiles.Contains(strings.ToLower(e.Name())) {
+				profiles = append(profiles, filepath.Join(root, e.Name()))
+			}
+		}
+	}
+
+	return
+}
+
+func hasGlob(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// expandGlob expands a path which may contain glob patterns. Only the
+// path components up to the last one containing a pattern are matched
+// against existing directories, the remaining components are appended
+// as is so that directories not existing yet can be created.
+func expandGlob(path string) (out []string) {
+	out = make([]string, 0)
+	parts := strings.Split(path, string(os.PathSeparator))
+
+	last := -1
+	for i, p := range parts {
+		if hasGlob(p) {
+			last = i
+		}
+	}
+
+	if last == -1 {
+		return append(out, path)
+	}
+
+	prefix := strings.Join(parts[:last+1], string(os.PathSeparator))
+	rest := strings.Join(parts[last+1:], string(os.PathSeparator))
+
+	matches, err := filepath.Glob(prefix)
+	if err != nil {
+		log.Errorf("Bad canary directory pattern %s: %s", path, err)
+		return
+	}
+
+	for _, m := range matches {
+		if fsutil.IsDir(m) {
+			out = append(out, filepath.Join(m, rest))
+		}
+	}
+
+	return
+}
+
+// expandCanaryDir expands a canary directory specification into a list of
+// directories. Environment variables are expanded first ($USERPROFILES
+// expanding to every user profile), then glob patterns.
+func expandCanaryDir(dir string) (dirs []string) {
+	dirs = make([]string, 0)
+
+	candidates := utils.ExpandEnvs(dir)
+	if strings.Contains(dir, UserProfilesVar) || strings.Contains(dir, "${"+userProfilesToken+"}") {
+		candidates = make([]string, 0)
+		// other variables are expanded at the same time so that profile
+		// paths are never expanded
+		for _, profile := range userProfiles() {
+			candidates = append(candidates, os.Expand(dir, func(v string) string {
+				if v == userProfilesToken {
+					return profile
+				}
+				return os.Getenv(v)
+			}))
+		}
+	}
+
+	for _, c := range candidates {
+		dirs = append(dirs, expandGlob(c)...)
+	}
+
+	return
+}
+
 // Canary configuration
 type Canary struct {
 	HideFiles       bool     `toml:"hide-files" comment:"Flag to set to hide files"`
 	HideDirectories bool     `toml:"hide-dirs" comment:"Flag to set to hide directories"`
 	SetAuditACL     bool     `toml:"set-audit-acl" comment:"Set Audit ACL to the canary directories, sub-directories and files to generate File System audit events\n https://docs.microsoft.com/en-us/windows/security/threat-protection/auditing/audit-file-system"`
-	Directories     []string `toml:"directories" comment:"Directories where canary files will be created"`
+	Directories     []string `toml:"directories" comment:"Directories where canary files will be created.\n Environment variables of the EDR process are expanded ($SYSTEMDRIVE, $SYSTEMROOT, $WINDIR, $PROGRAMDATA, $PROGRAMFILES, $PUBLIC ...)\n NB: $USERPROFILE is the profile of the account running the EDR, use $USERPROFILES to target every user profile directory\n Glob patterns are supported (ex: $SYSTEMDRIVE\\Users\\*\\Documents\\Canary)"`
 	Files           []string `toml:"files" comment:"Canary files to monitor. Files will be created if not existing"`
 	Delete          bool     `toml:"delete" comment:"Whether to delete or not the canary files when service stops"`
+	Whitelist       []string `toml:"whitelist" comment:"Image path glob patterns, case insensitive, of processes allowed to touch\n the canaries of this group, in addition to the global whitelist (ex: C:\\\\Program Files\\\\Backup\\\\*.exe)"`
+	Signers         []string `toml:"signers" comment:"Signers of the processes allowed to touch the canaries of this group, case\n insensitive (ex: Microsoft Windows), the signature of the image must be valid"`
 	createdDir      *datastructs.SyncedSet
+	// normalized canary directories, used to find the group of a path
+	dirs     []string
+	dirsLock sync.RWMutex
+	// agent directories canaries must not be created in
+	excluded []string
 }
 
-// expands environment variables found in directories
+// excludedDirs returns the canary directories located in agent directories
+func (c *Canary) excludedDirs() (dirs []string) {
+	dirs = make([]string, 0)
+	for _, spec := range c.Directories {
+		for _, dir := range expandCanaryDir(spec) {
+			if underDirs(dir, c.excluded) {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return
+}
+
+// expands environment variables and patterns found in directories,
+// directories located in agent directories are skipped
 func (c *Canary) expandDir() (dirs []string) {
-	return utils.ExpandEnvs(c.Directories...)
+	set := datastructs.NewSet()
+	dirs = make([]string, 0, len(c.Directories))
+	for _, spec := range c.Directories {
+		for _, dir := range expandCanaryDir(spec) {
+			if underDirs(dir, c.excluded) {
+				continue
+			}
+			if !set.Contains(dir) {
+				set.Add(dir)
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return
+}
+
+// contains returns true if path is located in one of the canary directories
+func (c *Canary) contains(path string) bool {
+	c.dirsLock.RLock()
+	defer c.dirsLock.RUnlock()
+	return underDirs(path, c.dirs)
+}
+
+// allows returns true if a process running image, signed by signer, is
+// allowed to touch the canaries of the group. Signer must be empty if the
+// signature of the image is not valid.
+func (c *Canary) allows(image, signer string) bool {
+	for _, p := range c.Whitelist {
+		if ok, _ := filepath.Match(strings.ToLower(p), strings.ToLower(image)); ok {
+			return true
+		}
+	}
+	return signedBy(signer, c.Signers)
+}
+
+func signedBy(signer string, signers []string) bool {
+	if signer == "" {
+		return false
+	}
+	for _, s := range signers {
+		if strings.EqualFold(s, signer) {
+			return true
+		}
+	}
+	return false
 }
 
-// create the canary files and directories
+// create the canary files and directories missing, it can be called
+// several times
 func (c *Canary) create() (err error) {
-	c.createdDir = datastructs.NewSyncedSet()
+	// directories created by a previous call must still be removed at cleanup
+	if c.createdDir == nil {
+		c.createdDir = datastructs.NewSyncedSet()
+	}
+
+	dirs := make([]string, 0)
+	for _, dir := range c.expandDir() {
+		dirs = append(dirs, normalizeDumpPath(dir))
+	}
+	c.dirsLock.Lock()
+	c.dirs = dirs
+	c.dirsLock.Unlock()
 
 	for _, dir := range c.expandDir() {
 		if !fsutil.Exists(dir) {
@@ -116,14 +301,82 @@ func (c *Canary) paths() (files []string) {
 	return
 }
 
+// Names of the rules generated to detect canary files being touched
+const (
+	CanaryAccessedRule  = "Builtin:CanaryAccessed"
+	CanaryModifiedRule  = "Builtin:CanaryModified"
+	CanaryReadWriteRule = "Builtin:CanaryReadWrite"
+)
+
+// ActionsOriginCanary value of the ActionsOrigin field of events whose
+// actions have been triggered by a canary
+const ActionsOriginCanary = "canary"
+
+func isCanaryRule(name string) bool {
+	switch name {
+	case CanaryAccessedRule, CanaryModifiedRule, CanaryReadWriteRule:
+		return true
+	}
+	return false
+}
+
 // CanariesConfig structure holding canary configuration
 type CanariesConfig struct {
 	Enable    bool      `toml:"enable" comment:"Enable canary files management"`
-	Actions   []string  `toml:"actions" comment:"Actions to apply when a canary file is touched"`
+	Actions   []string  `toml:"actions" comment:"Actions to apply when a canary file is touched, they replace\n the actions configured for the criticality of the event"`
 	Whitelist []string  `toml:"whitelist" comment:"Process images being allowed to touch the canaries"`
+	Signers   []string  `toml:"signers" comment:"Signers of the processes allowed to touch any canary, case insensitive\n (ex: Microsoft Windows), the signature of the image must be valid"`
 	Canaries  []*Canary `toml:"group" comment:"Canary files to create at every run"`
 }
 
+// Verify validates canaries configuration
+func (c *CanariesConfig) Verify() error {
+	if c == nil {
+		return nil
+	}
+
+	for _, cf := range c.Canaries {
+		for _, p := range cf.Whitelist {
+			if _, err := filepath.Match(p, ""); err != nil {
+				return fmt.Errorf("bad canary whitelist pattern %s: %w", p, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// allowed returns true if a process running image, signed by signer, is
+// allowed to touch path, either by the global signers or by the allowlists
+// of one of the groups path belongs to
+func (c *CanariesConfig) allowed(path, image, signer string) bool {
+	if signedBy(signer, c.Signers) {
+		return true
+	}
+
+	for _, cf := range c.Canaries {
+		if cf.contains(path) && cf.allows(image, signer) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasAllowlists returns true if canaries are allowed to be touched by
+// processes other than the ones of the global whitelist
+func (c *CanariesConfig) hasAllowlists() bool {
+	if len(c.Signers) > 0 {
+		return true
+	}
+	for _, cf := range c.Canaries {
+		if len(cf.Whitelist) > 0 || len(cf.Signers) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *CanariesConfig) canaryRegexp() string {
 	repaths := make([]string, 0)
 	for _, c := range c.Canaries {
@@ -152,11 +405,23 @@ func (c *CanariesConfig) whitelistRegexp() string {
 	return fmt.Sprintf("(?i:(%s))", strings.Join(wl, "|"))
 }
 
+// ExcludeDirs prevents canaries from being created in dirs (and their
+// sub-directories), dirs must be normalized absolute paths
+func (c *CanariesConfig) ExcludeDirs(dirs ...string) {
+	for _, cf := range c.Canaries {
+		cf.excluded = append(cf.excluded, dirs...)
+	}
+}
+
 // Configure creates canaries and set ACLs if needed
 func (c *CanariesConfig) Configure() {
 	auditDirs := make([]string, 0)
 	if c.Enable {
 		for _, cf := range c.Canaries {
+			for _, dir := range cf.excludedDirs() {
+				log.Warnf("Skipping canary directory located in agent directories: %s", dir)
+			}
+
 			// add the list of directories to audit
 			if cf.SetAuditACL {
 				auditDirs = append(auditDirs, cf.expandDir()...)
@@ -193,7 +458,7 @@ func (c *CanariesConfig) RestoreACLs() {
 // GenRuleFSAudit generate a rule matching FS Audit events for the configured canaries
 func (c *CanariesConfig) GenRuleFSAudit() (r engine.Rule) {
 	r = engine.NewRule()
-	r.Name = "Builtin:CanaryAccessed"
+	r.Name = CanaryAccessedRule
 	r.Meta.Events = map[string][]int64{"Security": {4663}}
 	r.Meta.Criticality = 10
 	r.Matches = []string{
@@ -211,7 +476,7 @@ func (c *CanariesConfig) GenRuleFSAudit() (r engine.Rule) {
 // GenRuleSysmon generate a rule matching sysmon events for the configured canaries
 func (c *CanariesConfig) GenRuleSysmon() (r engine.Rule) {
 	r = engine.NewRule()
-	r.Name = "Builtin:CanaryModified"
+	r.Name = CanaryModifiedRule
 	// FileCreate, FileDeleted and FileDeletedDetected
 	r.Meta.Events = map[string][]int64{"Microsoft-Windows-Sysmon/Operational": {11, 23, 26}}
 	r.Meta.Criticality = 10
@@ -227,7 +492,7 @@ func (c *CanariesConfig) GenRuleSysmon() (r engine.Rule) {
 // GenRuleSysmon generate a rule matching sysmon events for the configured canaries
 func (c *CanariesConfig) GenRuleKernelFile() (r engine.Rule) {
 	r = engine.NewRule()
-	r.Name = "Builtin:CanaryReadWrite"
+	r.Name = CanaryReadWriteRule
 	// FileCreate, FileDeleted and FileDeletedDetected
 	r.Meta.Events = map[string][]int64{"Microsoft-Windows-Kernel-File/Analytic": {15, 16}}
 	r.Meta.Criticality = 10
@@ -240,6 +505,82 @@ func (c *CanariesConfig) GenRuleKernelFile() (r engine.Rule) {
 	return
 }
 
+// canaryAccess returns the path touched and the image of the process
+// touching it for canary events
+func canaryAccess(e *event.EdrEvent) (path, image string) {
+	switch e.Channel() {
+	case securityChannel:
+		return e.GetStringOr(pathFSAuditObjectName, ""), e.GetStringOr(pathFSAuditProcessName, "")
+	case kernelFileChannel:
+		return e.GetStringOr(pathKernelFileFileName, ""), e.GetStringOr(pathSysmonImage, "")
+	}
+	return e.GetStringOr(pathSysmonTargetFilename, ""), e.GetStringOr(pathSysmonImage, "")
+}
+
+// validSigner returns the signer of the process of the event if its
+// signature is valid, an empty string otherwise
+func (h *HIDS) validSigner(e *event.EdrEvent) string {
+	pt := processTrackFromEvent(h, e)
+	if pt.IsZero() || !pt.Signed || !strings.EqualFold(pt.SignatureStatus, "valid") {
+		return ""
+	}
+	return pt.Signature
+}
+
+// allowCanaryAccess removes canary signatures from the detection of e if
+// the process touching the canary is allowed by the group allowlists or
+// the global signers. Other signatures matched are kept. It returns true if
+// the detection has been modified.
+func (h *HIDS) allowCanaryAccess(e *event.EdrEvent) bool {
+	c := h.config.CanariesConfig
+
+	if !c.Enable || !c.hasAllowlists() {
+		return false
+	}
+
+	det := e.GetDetection()
+	if det == nil {
+		return false
+	}
+
+	canary := false
+	for _, name := range det.Signature.Slice() {
+		if isCanaryRule(name.(string)) {
+			canary = true
+			break
+		}
+	}
+
+	if !canary {
+		return false
+	}
+
+	path, image := canaryAccess(e)
+	if !c.allowed(path, image, h.validSigner(e)) {
+		return false
+	}
+
+	log.Debugf("Canary %s touched by allowed process %s", path, image)
+
+	// detection is rebuilt with the other signatures matched
+	d := engine.NewDetection(det.ATTACK != nil, det.Actions != nil)
+	for _, name := range det.Signature.Slice() {
+		if isCanaryRule(name.(string)) {
+			continue
+		}
+		if r := h.Engine.GetCRuleByName(name.(string)); r != nil {
+			d.Update(r)
+		}
+	}
+
+	e.Event.Detection = nil
+	if d.IsAlert() {
+		e.Event.Detection = d
+	}
+
+	return true
+}
+
 // Clean cleans up the canaries
 func (c *CanariesConfig) Clean() {
 	if c.Enable {
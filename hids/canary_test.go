@@ -0,0 +1,36 @@
+package hids
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestExpandCanaryDirUserProfiles(t *testing.T) {
+	users := filepath.Join(t.TempDir(), "Users")
+	for _, name := range []string{"Public", "Default", "alice", "svc$backup"} {
+		if err := os.MkdirAll(filepath.Join(users, name), 0700); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+	}
+
+	// user profiles are found from the public profile
+	t.Setenv("PUBLIC", filepath.Join(users, "Public"))
+	t.Setenv("CANARYDIR", "Canary")
+
+	dirs := expandCanaryDir(filepath.Join(UserProfilesVar, "Documents", "$CANARYDIR"))
+	sort.Strings(dirs)
+
+	expected := []string{
+		filepath.Join(users, "alice", "Documents", "Canary"),
+		// profile paths must not be expanded
+		filepath.Join(users, "svc$backup", "Documents", "Canary"),
+	}
+
+	if !reflect.DeepEqual(dirs, expected) {
+		t.Errorf("Unexpected canary directories: %v", dirs)
+	}
+}
@@ -0,0 +1,89 @@
+package hids
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/0xrawsec/golang-utils/crypto/data"
+	"github.com/0xrawsec/golang-utils/crypto/file"
+	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/utils"
+)
+
+// CommandAllowlistConfig holds the executables manager commands are allowed to run
+type CommandAllowlistConfig struct {
+	Paths  []string `toml:"paths" comment:"Full paths of executables allowed to be run (environment variables are expanded)"`
+	Sha256 []string `toml:"sha256" comment:"SHA256 of executables allowed to be run, dropped executables can be allowed this way"`
+}
+
+// IsEnabled returns true if an allowlist is configured. If not any command
+// is allowed to run.
+func (c *CommandAllowlistConfig) IsEnabled() bool {
+	if c == nil {
+		return false
+	}
+	return len(c.Paths) > 0 || len(c.Sha256) > 0
+}
+
+func (c *CommandAllowlistConfig) allowedPath(path string) bool {
+	for _, p := range utils.ExpandEnvs(c.Paths...) {
+		if abs, err := filepath.Abs(p); err == nil && strings.EqualFold(abs, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CommandAllowlistConfig) allowedSha256(sha256 string) bool {
+	for _, h := range c.Sha256 {
+		if strings.EqualFold(h, sha256) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns an error if the executable of the command is not allowed
+func (c *CommandAllowlistConfig) Check(cmd *api.Command) error {
+	var path, sha256 string
+	var err error
+
+	if !c.IsEnabled() {
+		return nil
+	}
+
+	// executable dropped by the manager can only be allowed by hash
+	for _, ef := range cmd.Drop {
+		if ef.Name == cmd.Name || ef.Name == filepath.Base(cmd.Name) {
+			if c.allowedSha256(data.Sha256(ef.Data)) {
+				return nil
+			}
+			return fmt.Errorf("dropped executable not allowed to run: %s", cmd.Name)
+		}
+	}
+
+	if path, err = exec.LookPath(cmd.Name); err != nil {
+		return fmt.Errorf("cannot resolve executable %s: %w", cmd.Name, err)
+	}
+
+	if path, err = filepath.Abs(path); err != nil {
+		return fmt.Errorf("cannot resolve executable %s: %w", cmd.Name, err)
+	}
+
+	if c.allowedPath(path) {
+		return nil
+	}
+
+	if len(c.Sha256) > 0 {
+		if sha256, err = file.Sha256(path); err != nil {
+			return fmt.Errorf("cannot hash executable %s: %w", path, err)
+		}
+		if c.allowedSha256(sha256) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("executable not allowed to run: %s", path)
+}
@@ -10,12 +10,17 @@ import (
 	"io"
 	"io/fs"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/0xrawsec/golang-utils/fsutil"
 	"github.com/0xrawsec/golang-utils/fsutil/fswalker"
+	"github.com/0xrawsec/whids/hids/sysinfo"
 	"github.com/0xrawsec/whids/utils"
 )
 
@@ -185,3 +190,223 @@ func cmdStat(path string) (nfi FileInfo, err error) {
 	nfi.FromFSFileInfo(fi)
 	return
 }
+
+// MemdumpStatus is the result of an on-demand memdump of a process
+type MemdumpStatus struct {
+	ProcessGUID string `json:"process-guid"`
+	PID         int64  `json:"pid"`
+	Image       string `json:"image"`
+	Dump        string `json:"dump,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// processesFromTarget resolves a command target, either a PID, a process
+// GUID or an image, to the tracked processes it designates
+func (h *HIDS) processesFromTarget(target string) (s []*ProcessTrack) {
+	s = make([]*ProcessTrack, 0)
+
+	if pid, err := strconv.ParseInt(target, 10, 64); err == nil {
+		if pt := h.tracker.GetByPID(pid); !pt.IsZero() {
+			s = append(s, pt)
+		}
+		return
+	}
+
+	if strings.HasPrefix(target, "{") && strings.HasSuffix(target, "}") {
+		if pt := h.tracker.GetByGuid(target); !pt.IsZero() {
+			s = append(s, pt)
+		}
+		return
+	}
+
+	return h.tracker.GetRunningByImage(target)
+}
+
+func (h *HIDS) cmdMemdump(id, target string) (out []MemdumpStatus, err error) {
+	out = make([]MemdumpStatus, 0)
+
+	procs := h.processesFromTarget(target)
+	if len(procs) == 0 {
+		return out, fmt.Errorf("no tracked process matching target: %s", target)
+	}
+
+	for _, pt := range procs {
+		st := MemdumpStatus{ProcessGUID: pt.ProcessGUID, PID: pt.PID, Image: pt.Image}
+		if path, err := h.actionHandler.memdumpOnDemand(pt, id); err != nil {
+			st.Error = err.Error()
+		} else {
+			st.Dump = filepath.Base(path)
+		}
+		out = append(out, st)
+	}
+
+	return
+}
+
+// AuditPolicyState state of an audit policy configured on the endpoint
+type AuditPolicyState struct {
+	Policy  string `json:"policy"`
+	Success bool   `json:"success"`
+	Failure bool   `json:"failure"`
+	Error   string `json:"error,omitempty"`
+}
+
+// HostProfile structured profile of the endpoint returned by sysinfo command
+type HostProfile struct {
+	Hostname string   `json:"hostname"`
+	IPs      []string `json:"ips"`
+	Agent    struct {
+		Version     string `json:"version"`
+		Commit      string `json:"commit"`
+		RulesSha256 string `json:"rules-sha256"`
+	} `json:"agent"`
+	System        *sysinfo.SystemInfo `json:"system"`
+	EtwProviders  []string            `json:"etw-providers"`
+	EtwTraces     []string            `json:"etw-traces"`
+	AuditPolicies []AuditPolicyState  `json:"audit-policies"`
+	Timestamp     time.Time           `json:"timestamp"`
+}
+
+func (h *HIDS) cmdSysinfo() (p HostProfile) {
+	p.Hostname, _ = os.Hostname()
+	p.IPs = make([]string, 0)
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok && !ipn.IP.IsLoopback() {
+				p.IPs = append(p.IPs, ipn.IP.String())
+			}
+		}
+	}
+
+	p.Agent.Version = h.Version
+	p.Agent.Commit = h.CommitID
+	_, rulesSha256Path := h.config.RulesConfig.RulesPaths()
+	p.Agent.RulesSha256, _ = utils.ReadFileString(rulesSha256Path)
+
+	// Sysmon version is part of system information
+	p.System = sysinfo.NewSystemInfo()
+
+	p.EtwProviders = make([]string, 0)
+	p.EtwTraces = make([]string, 0)
+	if h.config.EtwConfig != nil {
+		p.EtwProviders = append(p.EtwProviders, h.config.EtwConfig.Providers...)
+		p.EtwTraces = append(p.EtwTraces, h.config.EtwConfig.Traces...)
+	}
+
+	p.AuditPolicies = make([]AuditPolicyState, 0)
+	if h.config.AuditConfig != nil {
+		for _, ap := range h.config.AuditConfig.AuditPolicies {
+			s := AuditPolicyState{Policy: ap}
+			var err error
+			if s.Success, s.Failure, err = utils.GetAuditPolicy(ap); err != nil {
+				s.Error = err.Error()
+			}
+			p.AuditPolicies = append(p.AuditPolicies, s)
+		}
+	}
+
+	p.Timestamp = time.Now().UTC()
+	return
+}
+
+const (
+	// DefaultMaxLogChunk is the maximum amount of log data returned at once
+	DefaultMaxLogChunk = utils.Mega
+)
+
+// LogChunk is a chunk of an agent log file returned by logs command. Next
+// is the offset to use to follow the log file from where this chunk ends.
+type LogChunk struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Offset int64  `json:"offset"`
+	Next   int64  `json:"next"`
+	Data   string `json:"data"`
+}
+
+// logFiles returns the log files of the agent which can be fetched by logs
+// command, only files within log directory and the bootstrap log are exposed
+func (h *HIDS) logFiles() (files map[string]string) {
+	files = make(map[string]string)
+
+	if h.config.Logfile != "" {
+		dir := filepath.Dir(h.config.Logfile)
+		if ofi, err := ioutil.ReadDir(dir); err == nil {
+			for _, fi := range ofi {
+				if fi.Mode().IsRegular() {
+					files[fi.Name()] = filepath.Join(dir, fi.Name())
+				}
+			}
+		}
+	}
+
+	if h.BootstrapLog != "" && fsutil.IsFile(h.BootstrapLog) {
+		files[filepath.Base(h.BootstrapLog)] = h.BootstrapLog
+	}
+
+	return
+}
+
+func (h *HIDS) cmdLogList() (out []FileInfo) {
+	out = make([]FileInfo, 0)
+	for _, path := range h.logFiles() {
+		if fi, err := cmdStat(path); err == nil {
+			out = append(out, fi)
+		}
+	}
+	return
+}
+
+// cmdLogRead reads at most max bytes of a log file starting at offset. If
+// offset is negative the tail of the file is returned.
+func (h *HIDS) cmdLogRead(name string, offset, max int64) (c LogChunk, err error) {
+	var fd *os.File
+	var fi fs.FileInfo
+
+	// only log files names are accepted, not paths
+	path, ok := h.logFiles()[name]
+	if !ok || filepath.Base(name) != name {
+		err = fmt.Errorf("unknown log file: %s", name)
+		return
+	}
+
+	if max <= 0 || max > DefaultMaxLogChunk {
+		max = DefaultMaxLogChunk
+	}
+
+	if fd, err = os.Open(path); err != nil {
+		return
+	}
+	defer fd.Close()
+
+	if fi, err = fd.Stat(); err != nil {
+		return
+	}
+
+	c.Name = name
+	c.Size = fi.Size()
+
+	switch {
+	case offset < 0:
+		offset = c.Size - max
+		if offset < 0 {
+			offset = 0
+		}
+	case offset > c.Size:
+		// log file got truncated or rotated
+		offset = 0
+	}
+
+	buf := make([]byte, max)
+	n, err := fd.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return
+	}
+	err = nil
+
+	c.Offset = offset
+	c.Next = offset + int64(n)
+	c.Data = string(buf[:n])
+
+	return
+}
@@ -2,6 +2,7 @@ package hids
 
 import (
 	"fmt"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"strings"
@@ -20,22 +21,172 @@ const (
 	actionMediumLow, actionMediumHigh     = 5, 7
 	actionHighLow, actionHighHigh         = 8, 9
 	actionCriticalLow, actionCriticalHigh = 10, 10
+
+	// DefaultMaxConcurrentJobs is the default number of action jobs run concurrently
+	DefaultMaxConcurrentJobs = 2
+	// DefaultMemdumpTimeout is the default maximum time to wait for a memdump before killing a process
+	DefaultMemdumpTimeout = time.Minute
+	// DefaultMemdumpAncestors is the default number of ancestors dumped by memdump-ancestors action
+	DefaultMemdumpAncestors = 1
+	// DefaultClipboardMaxSize is the default maximum size of clipboard data captured
+	DefaultClipboardMaxSize = utils.Mega
+	// RuleActionsMerge adds actions declared in rules to tier actions
+	RuleActionsMerge = "merge"
+	// RuleActionsOverride replaces tier actions with actions declared in rules
+	RuleActionsOverride = "override"
+	// MissingGUIDResolve resolves the process of events lacking a process GUID by PID
+	MissingGUIDResolve = "resolve"
+	// MissingGUIDSkip does not attempt to resolve the process of events lacking a process GUID
+	MissingGUIDSkip = "skip"
+	// LogFormatText logs messages as human readable text
+	LogFormatText = "text"
+	// LogFormatJSON logs messages as JSON lines
+	LogFormatJSON = "json"
+
+	// DefaultAuditVerifyInterval is the default interval at which audit configuration is verified
+	DefaultAuditVerifyInterval = time.Hour
+	// DefaultJitter is the default fraction of the routine intervals randomly
+	// added or removed
+	DefaultJitter = 0.1
+	// DefaultMaxDumpedEntries is the default maximum number of entries of
+	// every set remembering what has been dumped
+	DefaultMaxDumpedEntries = 10000
 )
 
 type ActionsConfig struct {
-	AvailableActions []string `toml:"available-actions" commented:"true" comment:"List of available actions (here as a memo for easier configuration, but it is not used in any way by the engine)"`
-	Low              []string `toml:"low" comment:"Default actions to be taken when event criticality is in [1; 4]"`
-	Medium           []string `toml:"medium" comment:"Default actions to be taken when event criticality is in [5; 7]"`
-	High             []string `toml:"high" comment:"Default actions to be taken when event criticality is in [8; 9]"`
-	Critical         []string `toml:"critical" comment:"Default actions to be taken when event criticality is 10"`
+	AvailableActions   []string      `toml:"available-actions" commented:"true" comment:"List of available actions (here as a memo for easier configuration, but it is not used in any way by the engine)"`
+	Low                []string      `toml:"low" comment:"Default actions to be taken when event criticality is in [1; 4]"`
+	Medium             []string      `toml:"medium" comment:"Default actions to be taken when event criticality is in [5; 7]"`
+	High               []string      `toml:"high" comment:"Default actions to be taken when event criticality is in [8; 9]"`
+	Critical           []string      `toml:"critical" comment:"Default actions to be taken when event criticality is 10"`
+	MaxConcurrentJobs  int           `toml:"max-concurrent-jobs" comment:"Maximum number of action jobs (dumps, reports ...) running concurrently\n NB: memdumps are written uncompressed to disk and wait in the compression queue\n so raising this value increases memory, disk usage and I/O during incidents"`
+	MemdumpTimeout     time.Duration `toml:"memdump-timeout" comment:"Maximum time to wait for a memdump to complete before killing the process\n (when both memdump and kill actions are set)"`
+	MemdumpAncestors   int           `toml:"memdump-ancestors" comment:"Number of ancestors (1 = parent only) to memdump along with the detected process\n when memdump-ancestors action is set"`
+	ProtectChildren    bool          `toml:"protect-children" comment:"Never take actions (kill, suspend, memdump, blacklist) against child processes of the agent\n Agent process itself is always protected"`
+	NormalizeBlacklist bool          `toml:"normalize-blacklist" comment:"Match blacklisted command lines ignoring case and whitespaces differences\n (by default blacklist action matches exact command lines)"`
+	RuleActions        string        `toml:"rule-actions" comment:"Precedence of actions declared in rules over tier actions: merge or override\n merge: rule actions are added to the actions of the event criticality tier\n override: tier actions are ignored if any rule matched declares actions"`
+	MissingGUID        string        `toml:"missing-guid" comment:"Behavior for events lacking a process GUID (Security, PowerShell ...): resolve or skip\n resolve: the process is resolved from the PID of the event, if tracked\n skip: no resolution is attempted\n In any case, actions not targeting a process (report, brief, filedump ...) are taken"`
+}
+
+// resolveMissingGUID returns true if the process of events lacking a
+// process GUID has to be resolved by PID
+func (c *ActionsConfig) resolveMissingGUID() bool {
+	return c.MissingGUID != MissingGUIDSkip
+}
+
+// overrideTierActions returns true if rule actions take precedence over tier actions
+func (c *ActionsConfig) overrideTierActions() bool {
+	return c.RuleActions == RuleActionsOverride
+}
+
+// memdumpTimeout returns the maximum time to wait for a memdump
+func (c *ActionsConfig) memdumpTimeout() time.Duration {
+	if c.MemdumpTimeout <= 0 {
+		return DefaultMemdumpTimeout
+	}
+	return c.MemdumpTimeout
+}
+
+// memdumpAncestors returns the number of ancestors to memdump
+func (c *ActionsConfig) memdumpAncestors() int {
+	if c.MemdumpAncestors <= 0 {
+		return DefaultMemdumpAncestors
+	}
+	return c.MemdumpAncestors
 }
 
 // DumpConfig structure definition
 type DumpConfig struct {
-	Dir           string `toml:"dir" comment:"Directory used to store dumps"`
-	MaxDumps      int    `toml:"max-dumps" comment:"Maximum number of dumps per process"` // maximum number of dump per GUID
-	Compression   bool   `toml:"compression" comment:"Enable dumps compression"`
-	DumpUntracked bool   `toml:"dump-untracked" comment:"Dumps untracked process. Untracked processes are missing\n enrichment information and may generate unwanted dumps"` // whether or not we should dump untracked processes, if true it would create many FPs
+	Dir           string   `toml:"dir" comment:"Directory used to store dumps"`
+	MaxDumps      int      `toml:"max-dumps" comment:"Maximum number of dumps per process"` // maximum number of dump per GUID
+	Compression   bool     `toml:"compression" comment:"Enable dumps compression"`
+	Format        string   `toml:"compression-format" comment:"Compression format of dumps: gzip or zstd (better ratio, useful for large memory dumps)"`
+	Level         int      `toml:"compression-level" comment:"Compression level (gzip: 1-9, zstd: 1-22, 0: fastest level of the format)"`
+	DumpUntracked bool     `toml:"dump-untracked" comment:"Dumps untracked process. Untracked processes are missing\n enrichment information and may generate unwanted dumps"` // whether or not we should dump untracked processes, if true it would create many FPs
+	Exclude       []string `toml:"exclude" comment:"Path patterns (glob) of files never dumped, a pattern matching a directory\n excludes all the files under it. Environment variables can be used\n and $USERPROFILES matches any user profile directory."`
+	MinFreeSpace  int64    `toml:"min-free-space" comment:"Minimum free space (in MB) on dump directory volume required to dump (0: no check)\n Dumps are skipped when free space is below this threshold"`
+	PruneOldest   bool     `toml:"prune-oldest" comment:"Delete oldest dumps to make room before skipping a dump because of low disk space"`
+	HashFields    []string `toml:"hash-fields" comment:"Event fields (XPath) event hash, used to name event dump directories, is computed over.\n Channel, event ID and rules matched are always part of the hash. Excluding volatile\n fields (i.e. UtcTime) makes identical detections land in the same directory.\n If empty the whole event is hashed"`
+	// bounds memory used to remember what has been dumped
+	MaxDumpedEntries int    `toml:"max-dumped-entries" comment:"Maximum number of processes (memdumps) and files (filedumps) remembered as dumped,\n least recently used entries are forgotten first and may be dumped again (0: unlimited)"`
+	WriteFailure     string `toml:"write-failure" comment:"Fallback when writing a dump to disk fails (disk full, permissions): none, retry or stream\n retry: write is retried once\n stream: dump is sent uncompressed to the manager instead of being written to disk,\n write being retried once if not connected to a manager"`
+}
+
+// compressionFormat returns the compression format of dumps, empty if
+// compression is disabled
+func (c *DumpConfig) compressionFormat() string {
+	switch {
+	case !c.Compression:
+		return ""
+	case c.Format == "":
+		return utils.CompressionGzip
+	}
+	return c.Format
+}
+
+// verify validates dump configuration
+func (c *DumpConfig) verify() error {
+	if c.Format != "" && !utils.IsValidCompression(c.Format) {
+		return fmt.Errorf("unknown dump compression format: %s", c.Format)
+	}
+	if c.Level < 0 {
+		return fmt.Errorf("dump compression level must be positive")
+	}
+	if c.MaxDumpedEntries < 0 {
+		return fmt.Errorf("maximum number of dumped entries must be positive")
+	}
+	switch c.WriteFailure {
+	case "", DumpWriteFailureNone, DumpWriteFailureRetry, DumpWriteFailureStream:
+	default:
+		return fmt.Errorf("unknown dump write failure fallback: %s", c.WriteFailure)
+	}
+	return nil
+}
+
+// minFreeSpace returns the minimum free space required in bytes
+func (c *DumpConfig) minFreeSpace() uint64 {
+	if c.MinFreeSpace <= 0 {
+		return 0
+	}
+	return uint64(c.MinFreeSpace) * utils.Mega
+}
+
+// normalizeDumpPath returns the absolute, lower cased path of a file
+// with any alternate data stream stripped out
+func normalizeDumpPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	dir, base := filepath.Split(path)
+	if i := strings.Index(base, ":"); i != -1 {
+		base = base[:i]
+	}
+	return strings.ToLower(filepath.Join(dir, base))
+}
+
+// IsExcluded returns true if path matches a dump exclusion pattern
+func (c *DumpConfig) IsExcluded(path string) bool {
+	path = normalizeDumpPath(path)
+	parts := strings.Split(path, string(os.PathSeparator))
+
+	for _, pattern := range c.Exclude {
+		pattern = os.Expand(pattern, func(v string) string {
+			if v == userProfilesToken {
+				return filepath.Join(usersRoot(), "*")
+			}
+			return os.Getenv(v)
+		})
+		pattern = strings.ToLower(filepath.Clean(pattern))
+
+		// we match the pattern against the path and all its parents
+		for i := len(parts); i > 0; i-- {
+			if ok, _ := filepath.Match(pattern, strings.Join(parts[:i], string(os.PathSeparator))); ok {
+				return true
+			}
+		}
+	}
+
+	return false
 }
 
 // SysmonConfig holds Sysmon related configuration
@@ -43,6 +194,37 @@ type SysmonConfig struct {
 	Bin              string `toml:"bin" comment:"Path to Sysmon binary"`
 	ArchiveDirectory string `toml:"archive-directory" comment:"Path to Sysmon Archive directory"`
 	CleanArchived    bool   `toml:"clean-archived" comment:"Delete files older than 5min archived by Sysmon"`
+	ClipboardMaxSize int64  `toml:"clipboard-max-size" comment:"Maximum size (in bytes) of clipboard data captured from Sysmon archive (default: 1MB)"`
+	DisableClipboard bool   `toml:"disable-clipboard" comment:"Disable clipboard content capture, clipboard events are still reported"`
+	ArchivePolicy    string `toml:"archive-policy" comment:"What to do if archive-directory does not match Sysmon configuration: warn or follow\n warn: log a warning and dump archived files available in archive-directory\n follow: use the archive directory configured in Sysmon"`
+}
+
+const (
+	// ArchivePolicyWarn warns if Sysmon archive directory does not match configuration
+	ArchivePolicyWarn = "warn"
+	// ArchivePolicyFollow uses the archive directory configured in Sysmon
+	ArchivePolicyFollow = "follow"
+)
+
+// followArchive returns true if Sysmon archive directory has to be used
+func (c *SysmonConfig) followArchive() bool {
+	return c.ArchivePolicy == ArchivePolicyFollow
+}
+
+// ClipboardLimit returns the maximum size of clipboard data to capture
+func (c *SysmonConfig) ClipboardLimit() int64 {
+	if c.ClipboardMaxSize <= 0 {
+		return DefaultClipboardMaxSize
+	}
+	return c.ClipboardMaxSize
+}
+
+// jitter returns d randomly increased or decreased by at most Jitter * d
+func (c *Config) jitter(r *rand.Rand, d time.Duration) time.Duration {
+	if c.Jitter <= 0 || d <= 0 {
+		return d
+	}
+	return d + time.Duration((r.Float64()*2-1)*c.Jitter*float64(d))
 }
 
 // RulesConfig holds rules configuration
@@ -50,6 +232,8 @@ type RulesConfig struct {
 	RulesDB        string        `toml:"rules-db" comment:"Path to Gene rules database"`
 	ContainersDB   string        `toml:"containers-db" comment:"Path to Gene rules containers\n (c.f. Gene documentation)"`
 	UpdateInterval time.Duration `toml:"update-interval" comment:"Update interval at which rules should be pulled from manager\n NB: only applies if a manager server is configured"`
+	Shadow         bool          `toml:"shadow" comment:"Pull the shadow rules deployed from the manager and evaluate events against them as well\n Shadow detections differing from active ones are forwarded tagged as such\n and never trigger actions. NB: roughly doubles rules evaluation cost"`
+	ShadowDB       string        `toml:"shadow-db" comment:"Path to shadow Gene rules database, must be outside of rules-db"`
 }
 
 func (c *RulesConfig) RulesPaths() (path, sha256Path string) {
@@ -58,11 +242,100 @@ func (c *RulesConfig) RulesPaths() (path, sha256Path string) {
 	return
 }
 
+// ShadowPaths returns the path to the shadow rules and to their sha256 file
+func (c *RulesConfig) ShadowPaths() (path, sha256Path string) {
+	path = filepath.Join(c.ShadowDB, "shadow.gen")
+	sha256Path = fmt.Sprintf("%s.sha256", path)
+	return
+}
+
+// SuppressionsPaths returns the path to the suppression rules and to their
+// sha256 file. They are stored aside rules database as rules are loaded
+// recursively from it.
+func (c *RulesConfig) SuppressionsPaths() (path, sha256Path string) {
+	path = filepath.Join(filepath.Dir(filepath.Clean(c.RulesDB)), "suppressions.gen")
+	sha256Path = fmt.Sprintf("%s.sha256", path)
+	return
+}
+
+// Verify validates rules configuration
+func (c *RulesConfig) Verify() error {
+	if !c.Shadow {
+		return nil
+	}
+
+	if c.ShadowDB == "" {
+		return fmt.Errorf("shadow rules database must be set")
+	}
+
+	// rules are loaded recursively from rules database
+	if rel, err := filepath.Rel(c.RulesDB, c.ShadowDB); err == nil && !strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("shadow rules database must be outside of rules database")
+	}
+
+	return nil
+}
+
 // AuditConfig holds Windows audit configuration
 type AuditConfig struct {
-	Enable        bool     `toml:"enable" comment:"Enable following Audit Policies or not"`
-	AuditPolicies []string `toml:"audit-policies" comment:"Audit Policies to enable (c.f. auditpol /get /category:* /r)"`
-	AuditDirs     []string `toml:"audit-dirs" comment:"Set Audit ACL to directories, sub-directories and files to generate File System audit events\n https://docs.microsoft.com/en-us/windows/security/threat-protection/auditing/audit-file-system)"`
+	Enable         bool          `toml:"enable" comment:"Enable following Audit Policies or not"`
+	AuditPolicies  []string      `toml:"audit-policies" comment:"Audit Policies to enable (c.f. auditpol /get /category:* /r)"`
+	AuditDirs      []string      `toml:"audit-dirs" comment:"Set Audit ACL to directories, sub-directories and files to generate File System audit events\n https://docs.microsoft.com/en-us/windows/security/threat-protection/auditing/audit-file-system)"`
+	SelfHeal       bool          `toml:"self-heal" comment:"Periodically verify Audit Policies and File System Audit ACLs\n are still in place and re-apply them if they drifted"`
+	VerifyInterval time.Duration `toml:"verify-interval" comment:"Interval at which audit configuration is verified (default: 1h)"`
+}
+
+func (c *AuditConfig) verifyInterval() time.Duration {
+	if c.VerifyInterval <= 0 {
+		return DefaultAuditVerifyInterval
+	}
+	return c.VerifyInterval
+}
+
+// Heal verifies that configured audit policies and File System Audit ACLs
+// are still in place and re-applies the ones which drifted. It returns the
+// number of corrections made.
+func (c *AuditConfig) Heal() (corrected int) {
+
+	if c.Enable {
+		for _, ap := range c.AuditPolicies {
+			enabled, err := utils.IsAuditPolicyEnabled(ap)
+			if err != nil {
+				log.Errorf("Failed to verify audit policy %s: %s", ap, err)
+				continue
+			}
+
+			if !enabled {
+				log.Warnf("Audit policy drift detected: %s", ap)
+				if err := utils.EnableAuditPolicy(ap); err != nil {
+					log.Errorf("Failed to re-enable audit policy %s: %s", ap, err)
+				} else {
+					log.Infof("Re-enabled Audit Policy: %s", ap)
+					corrected++
+				}
+			}
+		}
+	}
+
+	for _, dir := range utils.StdDirs(utils.ExpandEnvs(c.AuditDirs...)...) {
+		ok, err := utils.HasEDRAuditACL(dir)
+		if err != nil {
+			log.Errorf("Failed to verify File System Audit ACL of %s: %s", dir, err)
+			continue
+		}
+
+		if !ok {
+			log.Warnf("File System Audit ACL drift detected: %s", dir)
+			if err := utils.SetEDRAuditACL(dir); err != nil {
+				log.Errorf("Failed to re-apply File System Audit ACL to %s: %s", dir, err)
+			} else {
+				log.Infof("Re-applied File System Audit ACL to directory: %s", dir)
+				corrected++
+			}
+		}
+	}
+
+	return
 }
 
 // Configure configures the desired audit policies
@@ -108,21 +381,40 @@ func (c *AuditConfig) Restore() {
 // Config structure
 type Config struct {
 	//Channels        []string             `toml:"channels" comment:"Windows log channels to listen to. Either channel names\n can be used (i.e. Microsoft-Windows-Sysmon/Operational) or aliases"`
-	CritTresh       int                  `toml:"criticality-treshold" comment:"Dumps/forward only events above criticality threshold\n or filtered events (i.e. Gene filtering rules)"`
-	EnableHooks     bool                 `toml:"en-hooks" comment:"Enable enrichment hooks and dump hooks"`
-	EnableFiltering bool                 `toml:"en-filters" comment:"Enable event filtering (log filtered events, not only alerts)\n See documentation: https://github.com/0xrawsec/gene"`
-	Logfile         string               `toml:"logfile" comment:"Logfile used to log messages generated by the engine"` // for WHIDS log messages (not alerts)
-	LogAll          bool                 `toml:"log-all" comment:"Log any incoming event passing through the engine"`    // log all events to logfile (used for debugging)
-	Endpoint        bool                 `toml:"endpoint" comment:"True if current host is the endpoint on which logs are generated\n Example: turn this off if running on a WEC"`
-	EtwConfig       *EtwConfig           `toml:"etw" comment:"ETW configuration"`
-	FwdConfig       *api.ForwarderConfig `toml:"forwarder" comment:"Forwarder configuration"`
-	Sysmon          *SysmonConfig        `toml:"sysmon" comment:"Sysmon related settings"`
-	Actions         *ActionsConfig       `toml:"actions" comment:"Default actions to apply to events, depending on their criticality"`
-	Dump            *DumpConfig          `toml:"dump" comment:"Dump related settings"`
-	Report          *ReportConfig        `toml:"reporting" comment:"Reporting related settings"`
-	RulesConfig     *RulesConfig         `toml:"rules" comment:"Gene rules related settings\n Gene repo: https://github.com/0xrawsec/gene\n Gene rules repo: https://github.com/0xrawsec/gene-rules"`
-	AuditConfig     *AuditConfig         `toml:"audit" comment:"Windows auditing configuration"`
-	CanariesConfig  *CanariesConfig      `toml:"canaries" comment:"Canary files configuration"`
+	CritTresh        int                     `toml:"criticality-treshold" comment:"Dumps/forward only events above criticality threshold\n or filtered events (i.e. Gene filtering rules)"`
+	EnableHooks      bool                    `toml:"en-hooks" comment:"Enable enrichment hooks and dump hooks"`
+	EnableFiltering  bool                    `toml:"en-filters" comment:"Enable event filtering (log filtered events, not only alerts)\n See documentation: https://github.com/0xrawsec/gene"`
+	Logfile          string                  `toml:"logfile" comment:"Logfile used to log messages generated by the engine"` // for WHIDS log messages (not alerts)
+	LogFormat        string                  `toml:"log-format" comment:"Format of the messages written to logfile: text or json (JSON lines)"`
+	LogAll           bool                    `toml:"log-all" comment:"Log any incoming event passing through the engine"` // log all events to logfile (used for debugging)
+	Endpoint         bool                    `toml:"endpoint" comment:"True if current host is the endpoint on which logs are generated\n Example: turn this off if running on a WEC"`
+	MaxTracked       int                     `toml:"max-tracked-processes" comment:"Maximum number of processes tracked, when reached the oldest\n terminated processes are evicted (0: unlimited)"`
+	Jitter           float64                 `toml:"jitter" comment:"Fraction of the rules update (and heartbeat) and dumps upload intervals randomly\n added or removed to spread requests of endpoints to the manager (0: disabled)"`
+	EtwConfig        *EtwConfig              `toml:"etw" comment:"ETW configuration"`
+	FwdConfig        *api.ForwarderConfig    `toml:"forwarder" comment:"Forwarder configuration"`
+	Sysmon           *SysmonConfig           `toml:"sysmon" comment:"Sysmon related settings"`
+	Actions          *ActionsConfig          `toml:"actions" comment:"Default actions to apply to events, depending on their criticality"`
+	Dump             *DumpConfig             `toml:"dump" comment:"Dump related settings"`
+	Report           *ReportConfig           `toml:"reporting" comment:"Reporting related settings"`
+	RulesConfig      *RulesConfig            `toml:"rules" comment:"Gene rules related settings\n Gene repo: https://github.com/0xrawsec/gene\n Gene rules repo: https://github.com/0xrawsec/gene-rules"`
+	AuditConfig      *AuditConfig            `toml:"audit" comment:"Windows auditing configuration"`
+	CanariesConfig   *CanariesConfig         `toml:"canaries" comment:"Canary files configuration"`
+	Isolation        *IsolationConfig        `toml:"isolation" comment:"Network isolation configuration (isolate / unisolate commands)"`
+	Redaction        *RedactionConfig        `toml:"redaction" comment:"Event fields redaction configuration, used to prevent secrets from leaving the endpoint"`
+	FileAnomaly      *FileAnomalyConfig      `toml:"file-anomaly" comment:"File creation anomaly scoring, enriching Sysmon FileCreate events\n with FileAnomalyScore and FileAnomalyReason fields rules can match on"`
+	Dedup            *DedupConfig            `toml:"dedup" comment:"Detections deduplication configuration. The first detection goes through,\n identical ones occurring within the window are collapsed into a single\n event carrying a DetectionCount field, forwarded at the end of the window"`
+	CommandAllowlist *CommandAllowlistConfig `toml:"command-allowlist" comment:"Executables allowed to be run by manager commands, by path or SHA256\n If empty any command can be run"`
+	Sampling         *SamplingConfig         `toml:"sampling" comment:"Sampling of high volume event types when all events are logged (log-all)\n Only events not matching any rule are sampled, detections are always forwarded"`
+	Denylist         *DenylistConfig         `toml:"denylist" comment:"Event types dropped as early as possible, before any enrichment\n Events matching a rule are never dropped"`
+	Health           *HealthConfig           `toml:"health" comment:"Local health endpoint, exposing agent liveness (/health) and readiness (/ready)"`
+	RecentEvents     *RecentEventsConfig     `toml:"recent-events" comment:"In memory buffer of the last events processed, for debugging"`
+	RawForwarding    *RawForwardingConfig    `toml:"raw-forwarding" comment:"Forwarding of a copy of the events of some channels before any enrichment,\n for tools doing their own enrichment"`
+	Network          *NetworkConfig          `toml:"network" comment:"Networks configuration, used to classify IP addresses"`
+	Tracking         *TrackingConfig         `toml:"tracking" comment:"Process tracking configuration, used to skip tracking of noisy processes"`
+	RuleProfiling    *RuleProfilingConfig    `toml:"rule-profiling" comment:"Rule evaluation time profiling, used to find slow rules\n Slowest rules are reported in heartbeat events"`
+	EventSize        *EventSizeConfig        `toml:"event-size" comment:"Bound the size of events flowing through enrichment, detection,\n dumping and forwarding by truncating oversized fields"`
+	Attack           *AttackConfig           `toml:"attack" comment:"Tagging of detections with the ATT&CK techniques and tactics of the rules matched"`
+	Integrity        *IntegrityConfig        `toml:"integrity" comment:"Process tampering events flagged as detections when process integrity is too low,\n independently of the rules loaded"`
 }
 
 // LoadsHIDSConfig loads a HIDS configuration from a file
@@ -137,6 +429,46 @@ func LoadsHIDSConfig(path string) (c Config, err error) {
 	return
 }
 
+// SaveHIDSConfig saves a HIDS configuration to a file
+func SaveHIDSConfig(path string, c *Config) (err error) {
+	var fd *os.File
+
+	part := fmt.Sprintf("%s.part", path)
+	if fd, err = utils.HidsCreateFile(part); err != nil {
+		return
+	}
+	defer fd.Close()
+
+	enc := toml.NewEncoder(fd)
+	enc.Order(toml.OrderPreserve)
+	if err = enc.Encode(c); err != nil {
+		fd.Close()
+		os.Remove(part)
+		return
+	}
+	fd.Close()
+
+	return os.Rename(part, path)
+}
+
+// SaveHIDSConfigEndpointKey replaces the endpoint key in a HIDS configuration
+// file, the configuration saved being the one of the file and not the
+// running one
+func SaveHIDSConfigEndpointKey(path, key string) (err error) {
+	var c Config
+
+	if c, err = LoadsHIDSConfig(path); err != nil {
+		return
+	}
+
+	if c.FwdConfig == nil {
+		return fmt.Errorf("no forwarder configuration in configuration file %s", path)
+	}
+
+	c.FwdConfig.Client.Key = key
+	return SaveHIDSConfig(path, &c)
+}
+
 // IsForwardingEnabled returns true if a forwarder is actually configured to forward logs
 func (c *Config) IsForwardingEnabled() bool {
 	return *c.FwdConfig != emptyForwarderConfig && !c.FwdConfig.Local
@@ -150,6 +482,9 @@ func (c *Config) Prepare() {
 	if !fsutil.Exists(c.RulesConfig.ContainersDB) {
 		os.MkdirAll(c.RulesConfig.ContainersDB, 0600)
 	}
+	if c.RulesConfig.Shadow && !fsutil.Exists(c.RulesConfig.ShadowDB) {
+		os.MkdirAll(c.RulesConfig.ShadowDB, 0600)
+	}
 	if !fsutil.Exists(c.Dump.Dir) {
 		os.MkdirAll(c.Dump.Dir, 0600)
 	}
@@ -169,5 +504,87 @@ func (c *Config) Verify() error {
 	if !fsutil.IsDir(c.RulesConfig.ContainersDB) {
 		return fmt.Errorf("containers database must be a directory")
 	}
+	if err := c.RulesConfig.Verify(); err != nil {
+		return err
+	}
+	if c.Report != nil {
+		if err := c.Report.Verify(); err != nil {
+			return err
+		}
+	}
+	switch c.LogFormat {
+	case "", LogFormatText, LogFormatJSON:
+	default:
+		return fmt.Errorf("unknown log format: %s", c.LogFormat)
+	}
+	if err := c.Dump.verify(); err != nil {
+		return err
+	}
+	switch c.Actions.RuleActions {
+	case "", RuleActionsMerge, RuleActionsOverride:
+	default:
+		return fmt.Errorf("unknown rule actions precedence: %s", c.Actions.RuleActions)
+	}
+	switch c.Actions.MissingGUID {
+	case "", MissingGUIDResolve, MissingGUIDSkip:
+	default:
+		return fmt.Errorf("unknown missing process GUID behavior: %s", c.Actions.MissingGUID)
+	}
+	switch c.Sysmon.ArchivePolicy {
+	case "", ArchivePolicyWarn, ArchivePolicyFollow:
+	default:
+		return fmt.Errorf("unknown sysmon archive policy: %s", c.Sysmon.ArchivePolicy)
+	}
+	if err := c.Sampling.Verify(); err != nil {
+		return err
+	}
+	if err := c.RuleProfiling.Verify(); err != nil {
+		return err
+	}
+	if err := c.Denylist.Verify(); err != nil {
+		return err
+	}
+	if err := c.Attack.Verify(); err != nil {
+		return err
+	}
+	if err := c.Integrity.Verify(); err != nil {
+		return err
+	}
+	if c.FwdConfig != nil {
+		if err := c.FwdConfig.Syslog.Verify(); err != nil {
+			return err
+		}
+	}
+	if c.Jitter < 0 || c.Jitter >= 1 {
+		return fmt.Errorf("jitter must be in [0;1[")
+	}
+	if err := c.RawForwarding.Verify(); err != nil {
+		return err
+	}
+	if err := c.CanariesConfig.Verify(); err != nil {
+		return err
+	}
+	if err := c.Health.Verify(); err != nil {
+		return err
+	}
+	if c.RecentEvents != nil && c.RecentEvents.Size < 0 {
+		return fmt.Errorf("recent events buffer size must be positive")
+	}
+	if err := c.Network.Compile(); err != nil {
+		return err
+	}
+	if err := c.Tracking.Verify(); err != nil {
+		return err
+	}
+	if c.EventSize != nil {
+		if err := c.EventSize.Verify(); err != nil {
+			return err
+		}
+	}
+	if c.Redaction.IsEnabled() {
+		if err := c.Redaction.Compile(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
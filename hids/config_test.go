@@ -0,0 +1,69 @@
+package hids
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0xrawsec/whids/api"
+)
+
+func TestSaveHIDSConfigEndpointKey(t *testing.T) {
+	dir := t.TempDir()
+
+	// configuration as saved by the agent
+	path := filepath.Join(dir, "config.toml")
+	c := Config{FwdConfig: &api.ForwarderConfig{Client: api.ClientConfig{Key: "old"}}}
+	if err := SaveHIDSConfig(path, &c); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SaveHIDSConfigEndpointKey(path, "new"); err != nil {
+		t.Fatal(err)
+	}
+
+	if c, err := LoadsHIDSConfig(path); err != nil {
+		t.Fatal(err)
+	} else if c.FwdConfig.Client.Key != "new" {
+		t.Errorf("Unexpected endpoint key: %s", c.FwdConfig.Client.Key)
+	}
+
+	// configuration edited by hand, only the key must change
+	edited := strings.Join([]string{
+		"# edited by hand",
+		"[forwarder]",
+		"  local = false",
+		"",
+		"  [forwarder.manager] # manager settings",
+		"    server-key = \"endpoint-key = x\"",
+		"    endpoint-key   = \"old\"",
+		"",
+		"[dump]",
+		"  dir = \"endpoint-key\"",
+		"",
+	}, "\r\n")
+	path = filepath.Join(dir, "edited.toml")
+	if err := os.WriteFile(path, []byte(edited), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SaveHIDSConfigEndpointKey(path, "new"); err != nil {
+		t.Fatal(err)
+	}
+
+	if c, err := LoadsHIDSConfig(path); err != nil {
+		t.Fatal(err)
+	} else if c.FwdConfig.Client.Key != "new" || c.FwdConfig.Client.ServerKey != "endpoint-key = x" || c.Dump.Dir != "endpoint-key" {
+		t.Errorf("Unexpected configuration: %+v %+v", c.FwdConfig.Client, c.Dump)
+	}
+
+	// no forwarder configuration
+	path = filepath.Join(dir, "nokey.toml")
+	if err := os.WriteFile(path, []byte("[dump]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveHIDSConfigEndpointKey(path, "new"); err == nil {
+		t.Error("Missing forwarder configuration must be reported")
+	}
+}
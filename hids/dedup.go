@@ -0,0 +1,152 @@
+package hids
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// DefaultDedupWindow default detection deduplication window
+	DefaultDedupWindow = time.Minute
+)
+
+var (
+	pathDetectionCount = engine.Path("/Event/EventData/DetectionCount")
+)
+
+// DedupConfig holds detections deduplication configuration
+type DedupConfig struct {
+	Enable bool          `toml:"enable" comment:"Collapse identical detections occurring within a time window"`
+	Window time.Duration `toml:"window" comment:"Deduplication time window"`
+	Fields []string      `toml:"fields" comment:"Event fields (XPath) used, along with detection signatures, to build deduplication key\n If empty, the GUID of the process responsible of the detection is used, or its PID and image\n if the event has no GUID"`
+}
+
+// IsEnabled returns true if deduplication is enabled
+func (c *DedupConfig) IsEnabled() bool {
+	return c != nil && c.Enable
+}
+
+type dedupEntry struct {
+	start time.Time
+	count int
+	last  *event.EdrEvent
+}
+
+// deduplicator collapses identical detections. The first detection seen in a
+// window goes through, the following ones are counted and at the end of the
+// window the last one is forwarded carrying the total number of detections.
+type deduplicator struct {
+	sync.Mutex
+	window  time.Duration
+	paths   []engine.XPath
+	entries map[string]*dedupEntry
+}
+
+func newDeduplicator(c *DedupConfig) *deduplicator {
+	d := &deduplicator{
+		window:  DefaultDedupWindow,
+		paths:   make([]engine.XPath, 0),
+		entries: make(map[string]*dedupEntry),
+	}
+
+	if c != nil {
+		if c.Window > 0 {
+			d.window = c.Window
+		}
+		for _, f := range c.Fields {
+			d.paths = append(d.paths, engine.Path(f))
+		}
+	}
+
+	return d
+}
+
+func (d *deduplicator) key(e *event.EdrEvent) string {
+	det := e.GetDetection()
+	if det == nil {
+		return ""
+	}
+
+	sigs := make([]string, 0, det.Signature.Len())
+	for _, s := range det.Signature.Slice() {
+		sigs = append(sigs, s.(string))
+	}
+	sort.Strings(sigs)
+
+	parts := []string{strings.Join(sigs, ",")}
+	if len(d.paths) == 0 {
+		parts = append(parts, defaultDedupKey(e))
+	}
+	for _, p := range d.paths {
+		v, _ := e.Get(p)
+		parts = append(parts, fmt.Sprintf("%v", v))
+	}
+
+	return strings.Join(parts, "|")
+}
+
+// defaultDedupKey identifies the process responsible of a detection by its
+// GUID or, for events lacking one, by its PID and image. Events carrying
+// none of those are only collapsed with identical events.
+func defaultDedupKey(e *event.EdrEvent) string {
+	if guid := srcGUIDFromEvent(e); guid != nullGUID {
+		return guid
+	}
+
+	pid := srcPIDFromEvent(e)
+	image := e.GetStringOr(pathSysmonImage, e.GetStringOr(pathSysmonSourceImage, ""))
+	if pid == -1 && image == "" {
+		return e.Hash()
+	}
+
+	return fmt.Sprintf("%d|%s", pid, image)
+}
+
+// Duplicate returns true if an identical detection has already been
+// seen in the current window, in this case the detection is collapsed
+func (d *deduplicator) Duplicate(e *event.EdrEvent) bool {
+	key := d.key(e)
+	if key == "" {
+		return false
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	if entry, ok := d.entries[key]; ok {
+		entry.count++
+		entry.last = e
+		return true
+	}
+
+	d.entries[key] = &dedupEntry{start: time.Now(), count: 1}
+
+	return false
+}
+
+// Expired returns the collapsed events of the windows expired. Those
+// events carry the number of identical detections seen in the window.
+func (d *deduplicator) Expired(force bool) (out []*event.EdrEvent) {
+	d.Lock()
+	defer d.Unlock()
+
+	out = make([]*event.EdrEvent, 0)
+	now := time.Now()
+	for key, entry := range d.entries {
+		if force || now.Sub(entry.start) >= d.window {
+			if entry.last != nil {
+				entry.last.Set(pathDetectionCount, entry.count)
+				out = append(out, entry.last)
+			}
+			delete(d.entries, key)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,82 @@
+package hids
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/0xrawsec/whids/event"
+)
+
+// DenylistRule configures event types to drop
+type DenylistRule struct {
+	Channel  string  `toml:"channel" comment:"Channel of the events to drop (empty: any channel)"`
+	EventIDs []int64 `toml:"event-ids" comment:"Event IDs to drop (empty: any event ID of the channel)"`
+	Disabled bool    `toml:"disabled" comment:"Disable this rule without removing it"`
+}
+
+// DenylistConfig holds the configuration of event types dropped before any
+// enrichment takes place. Events matching a detection rule are never dropped.
+type DenylistConfig struct {
+	Enable bool           `toml:"enable" comment:"Enable dropping of denylisted events"`
+	Rules  []DenylistRule `toml:"rules" comment:"Denylist rules, an event matching any enabled rule is dropped"`
+}
+
+// IsEnabled returns true if the denylist is enabled
+func (c *DenylistConfig) IsEnabled() bool {
+	return c != nil && c.Enable
+}
+
+// Verify validates denylist configuration
+func (c *DenylistConfig) Verify() error {
+	if c == nil {
+		return nil
+	}
+
+	for i, r := range c.Rules {
+		if r.Channel == "" && len(r.EventIDs) == 0 {
+			return fmt.Errorf("denylist rule %d: either a channel or event IDs must be specified", i)
+		}
+	}
+
+	return nil
+}
+
+// denylist decides whether events have to be dropped before enrichment
+type denylist struct {
+	filters []*Filter
+	dropped uint64
+}
+
+func newDenylist(c *DenylistConfig) *denylist {
+	d := &denylist{filters: make([]*Filter, 0)}
+
+	if c.IsEnabled() {
+		for _, r := range c.Rules {
+			if !r.Disabled {
+				d.filters = append(d.filters, NewFilter(r.EventIDs, r.Channel))
+			}
+		}
+	}
+
+	return d
+}
+
+// Match returns true if the event matches one of the denylist rules
+func (d *denylist) Match(e *event.EdrEvent) bool {
+	for _, f := range d.filters {
+		if f.Match(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// Drop must be called for every event dropped
+func (d *denylist) Drop() {
+	atomic.AddUint64(&d.dropped, 1)
+}
+
+// Dropped returns the number of events dropped
+func (d *denylist) Dropped() uint64 {
+	return atomic.LoadUint64(&d.dropped)
+}
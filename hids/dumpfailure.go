@@ -0,0 +1,139 @@
+package hids
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// DumpWriteFailureNone only logs dump write failures
+	DumpWriteFailureNone = "none"
+	// DumpWriteFailureRetry retries once to write the dump to disk
+	DumpWriteFailureRetry = "retry"
+	// DumpWriteFailureStream sends the dump to the manager instead of
+	// writing it to disk
+	DumpWriteFailureStream = "stream"
+)
+
+// dumpPath returns the path a dump is written to, with the extension of
+// the compression format if any
+func (c *DumpConfig) dumpPath(dst string) string {
+	if format := c.compressionFormat(); format != "" {
+		if ext := utils.CompressionExt(format); !strings.HasSuffix(dst, ext) {
+			return dst + ext
+		}
+	}
+	return dst
+}
+
+// streamDump sends a dump to the manager, chunked as dumps uploaded from
+// disk are, without writing it to disk. The dump is sent uncompressed.
+func (m *ActionHandler) streamDump(dst string, r io.ReadSeeker) (err error) {
+	var size int64
+
+	rel, err := filepath.Rel(m.hids.config.Dump.Dir, dst)
+	if err != nil {
+		return
+	}
+
+	sp := strings.Split(rel, string(os.PathSeparator))
+	if len(sp) != 3 {
+		return fmt.Errorf("unexpected dump path layout: %s", dst)
+	}
+	guid, ehash, name := sp[0], sp[1], sp[2]
+
+	if size, err = r.Seek(0, io.SeekEnd); err != nil {
+		return
+	}
+
+	if size > m.hids.config.FwdConfig.Client.MaxUploadSize {
+		return fmt.Errorf("dump is above allowed upload limit")
+	}
+
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+
+	total := int(size/api.UploadShrinkerBufferSize) + 1
+	buf := make([]byte, api.UploadShrinkerBufferSize)
+	for chunk := 1; chunk <= total; chunk++ {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		fu := &api.FileUpload{
+			Name:      name,
+			GUID:      guid,
+			EventHash: ehash,
+			Content:   buf[:n],
+			Chunk:     chunk,
+			Total:     total,
+		}
+
+		if err := m.hids.forwarder.Client.PostDump(fu); err != nil {
+			return err
+		}
+	}
+
+	return
+}
+
+// writeFailure applies the configured fallback when writing dst failed with
+// werr and records the fallback taken in an EDR event, so that analysts know
+// the dump took an alternate path.
+func (m *ActionHandler) writeFailure(dst string, r io.ReadSeeker, werr error) (err error) {
+	c := m.hids.config.Dump
+	fallback := c.WriteFailure
+
+	if fallback == "" || fallback == DumpWriteFailureNone {
+		return werr
+	}
+
+	// a partially written dump must not be uploaded
+	os.Remove(c.dumpPath(dst))
+
+	if fallback == DumpWriteFailureStream && !m.hids.config.IsForwardingEnabled() {
+		fallback = DumpWriteFailureRetry
+	}
+
+	log.Warnf("Failed to write dump %s, trying fallback %s: %s", dst, fallback, werr)
+
+	if _, err = r.Seek(0, io.SeekStart); err == nil {
+		switch fallback {
+		case DumpWriteFailureRetry:
+			err = m.writeDump(dst, r)
+		case DumpWriteFailureStream:
+			err = m.streamDump(dst, r)
+		}
+	}
+
+	record := map[string]interface{}{
+		"Path":     dst,
+		"Error":    werr.Error(),
+		"Fallback": fallback,
+		"Success":  err == nil,
+	}
+
+	if err != nil {
+		record["FallbackError"] = err.Error()
+		log.Errorf("Fallback %s of dump %s failed: %s", fallback, dst, err)
+		// partially written again
+		if fallback == DumpWriteFailureRetry {
+			os.Remove(c.dumpPath(dst))
+		}
+	} else {
+		log.Infof("Fallback %s of dump %s succeeded", fallback, dst)
+	}
+
+	m.hids.emitEdrEvent(EdrEventDumpWriteFailure, record)
+
+	return
+}
@@ -0,0 +1,241 @@
+package hids
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/0xrawsec/golang-utils/fsutil"
+	"github.com/0xrawsec/whids/api"
+)
+
+var (
+	errTestDumpWrite = errors.New("disk full")
+	testDumpContent  = []byte("dump content")
+)
+
+// testDumpManager is a manager only accepting dump uploads
+type testDumpManager struct {
+	sync.Mutex
+	server  *httptest.Server
+	uploads []api.FileUpload
+}
+
+func newTestDumpManager() *testDumpManager {
+	m := &testDumpManager{}
+	m.server = httptest.NewServer(http.HandlerFunc(func(wt http.ResponseWriter, rq *http.Request) {
+		switch rq.URL.Path {
+		case api.EptAPIServerKeyPath:
+		case api.EptAPIPostDumpPath:
+			fu := api.FileUpload{}
+			if err := json.NewDecoder(rq.Body).Decode(&fu); err != nil {
+				wt.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			m.Lock()
+			m.uploads = append(m.uploads, fu)
+			m.Unlock()
+		default:
+			wt.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return m
+}
+
+func (m *testDumpManager) clientConfig(maxUploadSize int64) api.ClientConfig {
+	host, port, _ := net.SplitHostPort(m.server.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return api.ClientConfig{
+		Proto:         "http",
+		Host:          host,
+		Port:          p,
+		Key:           "endpoint-key",
+		MaxUploadSize: maxUploadSize,
+	}
+}
+
+func testDumpHandler(t *testing.T, fallback string, fc *api.ForwarderConfig) *ActionHandler {
+	fc.Logging.Dir = t.TempDir()
+	f, err := api.NewForwarder(fc)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	return &ActionHandler{hids: &HIDS{
+		config: &Config{
+			FwdConfig: fc,
+			Dump:      &DumpConfig{Dir: t.TempDir(), WriteFailure: fallback},
+		},
+		forwarder: f,
+	}}
+}
+
+func testDumpDst(m *ActionHandler) string {
+	return filepath.Join(m.hids.config.Dump.Dir, "{guid}", "ehash", "dump.bin")
+}
+
+// fallbackRecord returns the dump write failure event emitted if any
+func fallbackRecord(t *testing.T, m *ActionHandler) (record string) {
+	lines := strings.Split(strings.TrimSpace(m.hids.forwarder.Pipe.String()), "\n")
+	for _, l := range lines {
+		if strings.Contains(l, fmt.Sprintf(`"EventID":%d`, EdrEventDumpWriteFailure)) {
+			if record != "" {
+				t.Error("A single write failure event is expected")
+			}
+			record = l
+		}
+	}
+	return
+}
+
+func TestDumpWriteFailureNone(t *testing.T) {
+	m := testDumpHandler(t, DumpWriteFailureNone, &api.ForwarderConfig{Local: true})
+
+	if err := m.writeFailure(testDumpDst(m), bytes.NewReader(testDumpContent), errTestDumpWrite); err != errTestDumpWrite {
+		t.Errorf("Write error must be returned: %v", err)
+	}
+
+	if fallbackRecord(t, m) != "" {
+		t.Error("No fallback must be recorded")
+	}
+}
+
+func TestDumpWriteFailureRetry(t *testing.T) {
+	m := testDumpHandler(t, DumpWriteFailureRetry, &api.ForwarderConfig{Local: true})
+	dst := testDumpDst(m)
+
+	if err := m.writeFailure(dst, bytes.NewReader(testDumpContent), errTestDumpWrite); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if b, err := ioutil.ReadFile(dst); err != nil || !bytes.Equal(b, testDumpContent) {
+		t.Errorf("Unexpected dump written: %q %v", b, err)
+	}
+
+	if r := fallbackRecord(t, m); !strings.Contains(r, `"Fallback":"retry"`) || !strings.Contains(r, `"Success":true`) {
+		t.Errorf("Unexpected fallback record: %s", r)
+	}
+}
+
+func TestDumpWriteFailureRetryFails(t *testing.T) {
+	m := testDumpHandler(t, DumpWriteFailureRetry, &api.ForwarderConfig{Local: true})
+
+	// dump directory cannot be created under a file
+	file := filepath.Join(m.hids.config.Dump.Dir, "{guid}")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if err := m.writeFailure(testDumpDst(m), bytes.NewReader(testDumpContent), errTestDumpWrite); err == nil {
+		t.Error("Retry must fail")
+	}
+
+	if r := fallbackRecord(t, m); !strings.Contains(r, `"Success":false`) || !strings.Contains(r, `"FallbackError"`) {
+		t.Errorf("Unexpected fallback record: %s", r)
+	}
+}
+
+func TestDumpWriteFailureStream(t *testing.T) {
+	mgr := newTestDumpManager()
+	defer mgr.server.Close()
+
+	m := testDumpHandler(t, DumpWriteFailureStream, &api.ForwarderConfig{Client: mgr.clientConfig(1024)})
+	dst := testDumpDst(m)
+
+	if err := m.writeFailure(dst, bytes.NewReader(testDumpContent), errTestDumpWrite); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	if fsutil.Exists(dst) {
+		t.Error("Streamed dump must not be written to disk")
+	}
+
+	mgr.Lock()
+	defer mgr.Unlock()
+	if len(mgr.uploads) != 1 {
+		t.Errorf("Unexpected number of chunks uploaded: %d", len(mgr.uploads))
+		t.FailNow()
+	}
+
+	fu := mgr.uploads[0]
+	if fu.GUID != "{guid}" || fu.EventHash != "ehash" || fu.Name != "dump.bin" ||
+		fu.Chunk != 1 || fu.Total != 1 || !bytes.Equal(fu.Content, testDumpContent) {
+		t.Errorf("Unexpected chunk uploaded: %+v", fu)
+	}
+
+	if r := fallbackRecord(t, m); !strings.Contains(r, `"Fallback":"stream"`) || !strings.Contains(r, `"Success":true`) {
+		t.Errorf("Unexpected fallback record: %s", r)
+	}
+}
+
+func TestDumpWriteFailureStreamNotConnected(t *testing.T) {
+	m := testDumpHandler(t, DumpWriteFailureStream, &api.ForwarderConfig{Local: true})
+	dst := testDumpDst(m)
+
+	if err := m.writeFailure(dst, bytes.NewReader(testDumpContent), errTestDumpWrite); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	// write is retried instead
+	if b, err := ioutil.ReadFile(dst); err != nil || !bytes.Equal(b, testDumpContent) {
+		t.Errorf("Unexpected dump written: %q %v", b, err)
+	}
+
+	if r := fallbackRecord(t, m); !strings.Contains(r, `"Fallback":"retry"`) {
+		t.Errorf("Unexpected fallback record: %s", r)
+	}
+}
+
+func TestDumpWriteFailureStreamOverSize(t *testing.T) {
+	mgr := newTestDumpManager()
+	defer mgr.server.Close()
+
+	m := testDumpHandler(t, DumpWriteFailureStream, &api.ForwarderConfig{Client: mgr.clientConfig(int64(len(testDumpContent) - 1))})
+
+	if err := m.writeFailure(testDumpDst(m), bytes.NewReader(testDumpContent), errTestDumpWrite); err == nil {
+		t.Error("Dump above upload limit must not be streamed")
+	}
+
+	mgr.Lock()
+	defer mgr.Unlock()
+	if len(mgr.uploads) != 0 {
+		t.Error("No chunk must be uploaded")
+	}
+
+	if r := fallbackRecord(t, m); !strings.Contains(r, `"Success":false`) {
+		t.Errorf("Unexpected fallback record: %s", r)
+	}
+}
+
+func TestStreamDumpLayout(t *testing.T) {
+	mgr := newTestDumpManager()
+	defer mgr.server.Close()
+
+	m := testDumpHandler(t, DumpWriteFailureStream, &api.ForwarderConfig{Client: mgr.clientConfig(1024)})
+
+	// dumps must be located in an event directory of a process
+	dst := filepath.Join(m.hids.config.Dump.Dir, "dump.bin")
+	if err := m.streamDump(dst, bytes.NewReader(testDumpContent)); err == nil {
+		t.Error("Dump with unexpected path layout must not be streamed")
+	}
+
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Error("Nothing must be written to disk")
+	}
+}
@@ -0,0 +1,45 @@
+package hids
+
+import (
+	"os"
+	"time"
+
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// EdrChannel channel of the events generated by the EDR itself
+	EdrChannel = "EDR"
+	// EdrProvider provider name of the events generated by the EDR itself
+	EdrProvider = "WHIDS"
+)
+
+// Event IDs of the events generated by the EDR itself
+const (
+	EdrEventRulesReload = 1
+	EdrEventHeartbeat   = 2
+	// EdrEventDumpWriteFailure records the fallback taken when writing a
+	// dump to disk failed
+	EdrEventDumpWriteFailure = 3
+)
+
+// newEdrEvent creates an event generated by the EDR itself
+func newEdrEvent(eid uint16, data map[string]interface{}) *event.EdrEvent {
+	e := &etw.Event{}
+	e.System.EventID = eid
+	e.System.Channel = EdrChannel
+	e.System.Provider.Name = EdrProvider
+	e.System.Computer, _ = os.Hostname()
+	e.System.Execution.ProcessID = uint32(os.Getpid())
+	e.System.TimeCreated.SystemTime = time.Now()
+	e.EventData = data
+	return event.NewEdrEvent(e)
+}
+
+// emitEdrEvent forwards an event generated by the EDR itself
+func (h *HIDS) emitEdrEvent(eid uint16, data map[string]interface{}) {
+	if h.forwarder != nil {
+		h.forwarder.PipeEvent(newEdrEvent(eid, data))
+	}
+}
@@ -2,6 +2,7 @@ package hids
 
 import (
 	"strings"
+	"time"
 
 	"github.com/0xrawsec/golang-etw/etw"
 	"github.com/0xrawsec/golang-utils/datastructs"
@@ -34,6 +35,8 @@ type EtwConfig struct {
 	enTraceFile bool     `toml:"trace-files" comment:"Enable file read/write events via an optimized Microsoft-Windows-Kernel-File provider"`
 	Providers   []string `toml:"providers" comment:"ETW providers to enable in the EDR autologger setting"`
 	Traces      []string `toml:"traces" comment:"Additional ETW traces to retrieve events"`
+	// live traces are started on demand by manager commands
+	MaxLiveTraceDuration time.Duration `toml:"max-live-trace-duration" comment:"Maximum duration of live traces started by manager (etw-collect command)\n to temporarily collect events from additional providers"`
 }
 
 func (c *EtwConfig) ConfigureAutologger() (lastErr error) {
@@ -0,0 +1,211 @@
+package hids
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// DefaultLiveTraceDuration default collection duration of a live trace
+	DefaultLiveTraceDuration = 5 * time.Minute
+	// DefaultMaxLiveTraceDuration default maximum collection duration of a live trace
+	DefaultMaxLiveTraceDuration = time.Hour
+
+	liveTracePrefix = "EdrLiveTrace-"
+)
+
+var (
+	pathLiveTrace = engine.Path("/Event/EventData/LiveTrace")
+)
+
+// LiveTrace holds information about a real-time ETW session
+// started on demand to collect events from a provider
+type LiveTrace struct {
+	Name     string    `json:"name"`
+	Provider string    `json:"provider"`
+	GUID     string    `json:"guid"`
+	Start    time.Time `json:"start"`
+	Stop     time.Time `json:"stop"`
+	Events   uint64    `json:"events"`
+
+	// set to 1 once the trace is stopped
+	stopped  int32
+	cancel   context.CancelFunc
+	producer *etw.RealTimeSession
+	consumer *etw.Consumer
+}
+
+type liveTraces struct {
+	sync.Mutex
+	traces map[string]*LiveTrace
+}
+
+func newLiveTraces() *liveTraces {
+	return &liveTraces{traces: make(map[string]*LiveTrace)}
+}
+
+// List returns the live traces currently running
+func (l *liveTraces) List() (out []LiveTrace) {
+	l.Lock()
+	defer l.Unlock()
+
+	out = make([]LiveTrace, 0, len(l.traces))
+	for _, t := range l.traces {
+		cp := *t
+		cp.Events = atomic.LoadUint64(&t.Events)
+		out = append(out, cp)
+	}
+	return
+}
+
+// maxLiveTraceDuration returns the maximum duration allowed for a live trace
+func (c *EtwConfig) maxLiveTraceDuration() time.Duration {
+	if c.MaxLiveTraceDuration <= 0 {
+		return DefaultMaxLiveTraceDuration
+	}
+	return c.MaxLiveTraceDuration
+}
+
+// startLiveTrace starts collecting events from an additional ETW provider into
+// a dedicated real-time session for a bounded duration. Provider format is
+// the one used in ETW configuration (Name|GUID):EnableLevel:Event IDs:MatchAnyKeyword:MatchAllKeyword
+func (h *HIDS) startLiveTrace(sprov string, d time.Duration) (lt *LiveTrace, err error) {
+	var prov etw.Provider
+
+	if prov, err = etw.ProviderFromString(sprov); err != nil {
+		return nil, fmt.Errorf("invalid provider %s: %w", sprov, err)
+	}
+
+	if d <= 0 {
+		d = DefaultLiveTraceDuration
+	}
+
+	if max := h.config.EtwConfig.maxLiveTraceDuration(); d > max {
+		log.Warnf("Live trace duration capped to %s", max)
+		d = max
+	}
+
+	h.liveTraces.Lock()
+	defer h.liveTraces.Unlock()
+
+	if _, ok := h.liveTraces.traces[prov.GUID]; ok {
+		return nil, fmt.Errorf("a live trace is already running for provider %s", prov.GUID)
+	}
+
+	lt = &LiveTrace{
+		Name:     liveTracePrefix + strings.Trim(prov.GUID, "{}"),
+		Provider: prov.Name,
+		GUID:     prov.GUID,
+		Start:    time.Now(),
+		Stop:     time.Now().Add(d),
+	}
+
+	ctx, cancel := context.WithTimeout(h.ctx, d)
+	lt.cancel = cancel
+
+	lt.producer = etw.NewRealTimeProducer(lt.Name)
+	if err = lt.producer.EnableProvider(prov); err != nil {
+		cancel()
+		lt.producer.Stop()
+		return nil, fmt.Errorf("failed to enable provider %s: %w", prov.GUID, err)
+	}
+
+	lt.consumer = etw.NewRealTimeConsumer(ctx)
+	lt.consumer.InitFilters([]etw.Provider{prov})
+	if err = lt.consumer.OpenTrace(lt.Name); err != nil {
+		cancel()
+		lt.producer.Stop()
+		return nil, fmt.Errorf("failed to open trace %s: %w", lt.Name, err)
+	}
+	lt.consumer.Start()
+
+	h.liveTraces.traces[prov.GUID] = lt
+
+	// forwarding events
+	go func() {
+		for e := range lt.consumer.Events {
+			if e.System.Provider.Name == "" {
+				e.System.Provider.Name = lt.Provider
+			}
+			evt := event.NewEdrEvent(e)
+			evt.Set(pathLiveTrace, lt.Name)
+			h.forwarder.PipeEvent(evt)
+			atomic.AddUint64(&lt.Events, 1)
+		}
+	}()
+
+	// stopping trace when context is done, a trace started later for the
+	// same provider must not be stopped
+	go func() {
+		<-ctx.Done()
+		h.stopTrace(lt)
+	}()
+
+	log.Infof("Started live trace %s for provider %s until %s", lt.Name, sprov, lt.Stop)
+
+	return
+}
+
+// stopLiveTraceByProvider stops a live trace given a provider name or GUID
+func (h *HIDS) stopLiveTraceByProvider(sprov string) error {
+	prov, err := etw.ProviderFromString(sprov)
+	if err != nil {
+		return fmt.Errorf("invalid provider %s: %w", sprov, err)
+	}
+	return h.stopLiveTrace(prov.GUID)
+}
+
+// stopLiveTrace stops a live trace given its provider GUID
+func (h *HIDS) stopLiveTrace(guid string) (err error) {
+	h.liveTraces.Lock()
+	lt, ok := h.liveTraces.traces[guid]
+	h.liveTraces.Unlock()
+
+	if !ok {
+		return fmt.Errorf("no live trace running for provider %s", guid)
+	}
+
+	return h.stopTrace(lt)
+}
+
+// stopTrace stops a live trace once, it is removed from the running traces
+// only if it is still the one of its provider
+func (h *HIDS) stopTrace(lt *LiveTrace) (err error) {
+	h.liveTraces.Lock()
+	if h.liveTraces.traces[lt.GUID] == lt {
+		delete(h.liveTraces.traces, lt.GUID)
+	}
+	h.liveTraces.Unlock()
+
+	if !atomic.CompareAndSwapInt32(&lt.stopped, 0, 1) {
+		return
+	}
+
+	lt.cancel()
+	if err = lt.producer.Stop(); err != nil {
+		log.Errorf("Failed to stop live trace session %s: %s", lt.Name, err)
+	}
+	if err = lt.consumer.Stop(); err != nil {
+		log.Errorf("Failed to stop live trace consumer %s: %s", lt.Name, err)
+	}
+
+	log.Infof("Stopped live trace %s, %d events collected", lt.Name, atomic.LoadUint64(&lt.Events))
+
+	return
+}
+
+// stopLiveTraces stops all the live traces running
+func (h *HIDS) stopLiveTraces() {
+	for _, lt := range h.liveTraces.List() {
+		h.stopLiveTrace(lt.GUID)
+	}
+}
@@ -0,0 +1,28 @@
+package hids
+
+import "testing"
+
+func TestStopTraceInstance(t *testing.T) {
+	guid := "{22FB2CD6-0E7B-422B-A0C7-2FAD1FD0E716}"
+	h := &HIDS{liveTraces: newLiveTraces()}
+
+	// traces are flagged stopped as no ETW session is running
+	previous := &LiveTrace{GUID: guid, stopped: 1}
+	current := &LiveTrace{GUID: guid, stopped: 1}
+	h.liveTraces.traces[guid] = current
+
+	// a previous trace of the same provider timing out
+	h.stopTrace(previous)
+	if h.liveTraces.traces[guid] != current {
+		t.Error("Stopping a previous trace must not stop the current one")
+	}
+
+	h.stopTrace(current)
+	if _, ok := h.liveTraces.traces[guid]; ok {
+		t.Error("Stopped trace must be removed")
+	}
+
+	if err := h.stopLiveTrace(guid); err == nil {
+		t.Error("Stopping a trace not running must fail")
+	}
+}
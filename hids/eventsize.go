@@ -0,0 +1,166 @@
+package hids
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// TruncationMarker string appended to truncated event fields
+	TruncationMarker = "...[truncated]"
+
+	// DefaultPreservedMaxSize is the size preserved fields are truncated to
+	// when truncating other fields is not enough
+	DefaultPreservedMaxSize = 8192
+)
+
+var (
+	// DefaultPreservedFields event fields rules most often match on
+	DefaultPreservedFields = []string{
+		"Image",
+		"ParentImage",
+		"CommandLine",
+		"ParentCommandLine",
+		"TargetFilename",
+		"TargetObject",
+		"QueryName",
+	}
+)
+
+// EventSizeConfig holds the configuration bounding the size of events
+type EventSizeConfig struct {
+	MaxSize          int      `toml:"max-size" comment:"Maximum size in bytes of the data of an event (sum of its field values),\n larger fields of oversized events being truncated before processing (0: unlimited)"`
+	Preserve         []string `toml:"preserve-fields" comment:"Event data fields truncated last as needed for rule matching"`
+	PreservedMaxSize int      `toml:"preserved-max-size" comment:"Size in bytes preserved fields are truncated to, only if truncating other fields\n is not enough (default: 8192)"`
+}
+
+// IsEnabled returns true if event size is bounded
+func (c *EventSizeConfig) IsEnabled() bool {
+	return c != nil && c.MaxSize > 0
+}
+
+// Verify validates event size configuration
+func (c *EventSizeConfig) Verify() error {
+	if c.MaxSize < 0 {
+		return fmt.Errorf("maximum event size must be positive")
+	}
+	if c.PreservedMaxSize < 0 {
+		return fmt.Errorf("maximum size of preserved fields must be positive")
+	}
+	return nil
+}
+
+func (c *EventSizeConfig) preservedMaxSize() int {
+	if c.PreservedMaxSize == 0 {
+		return DefaultPreservedMaxSize
+	}
+	return c.PreservedMaxSize
+}
+
+func (c *EventSizeConfig) preserved(field string) bool {
+	for _, p := range c.Preserve {
+		if p == field {
+			return true
+		}
+	}
+	return false
+}
+
+type sizedField struct {
+	data  map[string]interface{}
+	name  string
+	value string
+	// size under which the field is not truncated
+	floor int
+}
+
+// truncateString truncates s to at most n bytes without splitting a rune
+func truncateString(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if n >= len(s) {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// Truncate truncates the largest string fields of an event until its size is
+// below the configured maximum. Preserved fields are truncated last and never
+// below the preserved fields maximum size. It returns the names of the
+// fields truncated.
+func (c *EventSizeConfig) Truncate(e *event.EdrEvent) (truncated []string) {
+	var size int
+
+	if e.Event.Event == nil {
+		return
+	}
+
+	fields := make([]sizedField, 0)
+	preserved := make([]sizedField, 0)
+	for _, data := range []map[string]interface{}{e.Event.EventData, e.Event.UserData} {
+		for name, v := range data {
+			if s, ok := v.(string); ok {
+				size += len(s)
+				if c.preserved(name) {
+					preserved = append(preserved, sizedField{data, name, s, c.preservedMaxSize()})
+				} else {
+					fields = append(fields, sizedField{data, name, s, 0})
+				}
+			}
+		}
+	}
+
+	if size <= c.MaxSize {
+		return
+	}
+
+	// largest fields are truncated first, preserved ones last
+	for _, fs := range [][]sizedField{fields, preserved} {
+		sort.Slice(fs, func(i, j int) bool { return len(fs[i].value) > len(fs[j].value) })
+	}
+	fields = append(fields, preserved...)
+
+	excess := size - c.MaxSize
+	for _, f := range fields {
+		if excess <= 0 {
+			break
+		}
+
+		// not worth truncating
+		if len(f.value) <= f.floor+len(TruncationMarker) {
+			continue
+		}
+
+		n := len(f.value) - excess - len(TruncationMarker)
+		if n < f.floor {
+			n = f.floor
+		}
+
+		new := truncateString(f.value, n) + TruncationMarker
+		excess -= len(f.value) - len(new)
+		f.data[f.name] = new
+		truncated = append(truncated, f.name)
+	}
+
+	return
+}
+
+// truncateEvent truncates event if it is oversized
+func (h *HIDS) truncateEvent(e *event.EdrEvent) {
+	if !h.config.EventSize.IsEnabled() {
+		return
+	}
+
+	if truncated := h.config.EventSize.Truncate(e); len(truncated) > 0 {
+		log.Debugf("Truncated oversized fields of event %s: %s", e.Hash(), strings.Join(truncated, ", "))
+	}
+}
@@ -0,0 +1,66 @@
+package hids
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/0xrawsec/whids/event"
+)
+
+func sizedEvent(cmdLine, other string) *event.EdrEvent {
+	e := event.EdrEvent{}
+	raw := fmt.Sprintf(`{"Event":{"EventData":{"Image":"cmd.exe","CommandLine":%q,"Other":%q},"System":{"Channel":"Microsoft-Windows-Sysmon/Operational","EventID":1,"Computer":"HOST"}}}`, cmdLine, other)
+	if err := json.Unmarshal([]byte(raw), &e); err != nil {
+		panic(err)
+	}
+	return &e
+}
+
+func TestTruncatePreserved(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		maxSize   int
+		cmdLine   int
+		other     int
+		truncated []string
+		cmdSize   int
+	}{
+		{"not oversized", 1024, 512, 256, nil, 512},
+		// truncating other fields is enough
+		{"other fields first", 1024, 900, 900, []string{"Other"}, 900},
+		// preserved fields only truncated as much as needed
+		{"preserved as needed", 1024, 4096, 0, []string{"CommandLine"}, 1024 - len("cmd.exe")},
+		{"both truncated", 1024, 4096, 4096, []string{"Other", "CommandLine"}, 1024 - len("cmd.exe") - len(TruncationMarker)},
+		// preserved fields never truncated below their maximum size
+		{"preserved capped", 256, 4096, 0, []string{"CommandLine"}, 512 + len(TruncationMarker)},
+		{"preserved not worth", 256, 520, 0, nil, 520},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &EventSizeConfig{
+				MaxSize:          tc.maxSize,
+				Preserve:         DefaultPreservedFields,
+				PreservedMaxSize: 512,
+			}
+			e := sizedEvent(strings.Repeat("A", tc.cmdLine), strings.Repeat("B", tc.other))
+			truncated := c.Truncate(e)
+
+			if fmt.Sprint(truncated) != fmt.Sprint(tc.truncated) {
+				t.Errorf("Unexpected truncated fields: %v", truncated)
+			}
+
+			cmdLine := e.Event.EventData["CommandLine"].(string)
+			if len(cmdLine) != tc.cmdSize {
+				t.Errorf("Unexpected command line size: %d", len(cmdLine))
+			}
+			if len(truncated) > 0 && tc.cmdSize != tc.cmdLine && !strings.HasSuffix(cmdLine, TruncationMarker) {
+				t.Error("Truncated command line must end with truncation marker")
+			}
+		})
+	}
+
+	if err := (&EventSizeConfig{PreservedMaxSize: -1}).Verify(); err == nil {
+		t.Error("Negative preserved fields maximum size must be rejected")
+	}
+}
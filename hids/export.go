@@ -0,0 +1,250 @@
+package hids
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/0xrawsec/golang-utils/crypto/file"
+	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// ExportManifestName name of the manifest file found in dumps export archives
+	ExportManifestName = "manifest.json"
+)
+
+var (
+	guidDirRe = regexp.MustCompile(`^\{[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}\}$`)
+)
+
+// ExportedFile describes a file found in a dumps export archive
+type ExportedFile struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Sha256   string    `json:"sha256"`
+	Modified time.Time `json:"modified"`
+}
+
+// ExportedEvent describes the dumps of an event found in a dumps export archive
+type ExportedEvent struct {
+	ProcessGUID string         `json:"process-guid"`
+	EventHash   string         `json:"event-hash"`
+	Files       []ExportedFile `json:"files"`
+}
+
+// ExportedEndpoint describes the endpoint dumps were exported from
+type ExportedEndpoint struct {
+	UUID     string `json:"uuid"`
+	Hostname string `json:"hostname"`
+}
+
+// DumpsManifest index of a dumps export archive
+type DumpsManifest struct {
+	Endpoint  ExportedEndpoint `json:"endpoint"`
+	Timestamp time.Time        `json:"timestamp"`
+	Events    []ExportedEvent  `json:"events"`
+	Skipped   []string         `json:"skipped"`
+}
+
+// walkDumps walks a dumps directory organized as GUID/event-hash/files and
+// returns the events found. Entries not matching this structure are
+// returned in skipped.
+func walkDumps(root string) (events []ExportedEvent, skipped []string, err error) {
+	var guids, hashes, files []os.DirEntry
+
+	events = make([]ExportedEvent, 0)
+	skipped = make([]string, 0)
+
+	if guids, err = os.ReadDir(root); err != nil {
+		return
+	}
+
+	for _, gfi := range guids {
+		if !gfi.IsDir() || !guidDirRe.MatchString(gfi.Name()) {
+			skipped = append(skipped, gfi.Name())
+			continue
+		}
+
+		guidDir := filepath.Join(root, gfi.Name())
+		if hashes, err = os.ReadDir(guidDir); err != nil {
+			return nil, nil, fmt.Errorf("failed to list (process guid) directory: %s", err)
+		}
+
+		for _, hfi := range hashes {
+			rel := filepath.Join(gfi.Name(), hfi.Name())
+			if !hfi.IsDir() {
+				skipped = append(skipped, rel)
+				continue
+			}
+
+			evtDir := filepath.Join(guidDir, hfi.Name())
+			if files, err = os.ReadDir(evtDir); err != nil {
+				return nil, nil, fmt.Errorf("failed to list (event dump) directory: %s", err)
+			}
+
+			ee := ExportedEvent{ProcessGUID: gfi.Name(), EventHash: hfi.Name(), Files: make([]ExportedFile, 0)}
+			for _, dfi := range files {
+				var info os.FileInfo
+
+				if !dfi.Type().IsRegular() {
+					skipped = append(skipped, filepath.Join(rel, dfi.Name()))
+					continue
+				}
+
+				if info, err = dfi.Info(); err != nil {
+					return nil, nil, fmt.Errorf("failed to read file (%s) info: %s", filepath.Join(evtDir, dfi.Name()), err)
+				}
+
+				ee.Files = append(ee.Files, ExportedFile{
+					Name:     info.Name(),
+					Path:     filepath.ToSlash(filepath.Join(rel, info.Name())),
+					Size:     info.Size(),
+					Modified: info.ModTime().UTC(),
+				})
+			}
+
+			// empty event directories are not worth exporting
+			if len(ee.Files) > 0 {
+				events = append(events, ee)
+			}
+		}
+	}
+
+	return
+}
+
+func zipFile(zw *zip.Writer, name, path string) (err error) {
+	var fd *os.File
+	var w io.Writer
+
+	if fd, err = os.Open(path); err != nil {
+		return
+	}
+	defer fd.Close()
+
+	if w, err = zw.Create(name); err != nil {
+		return
+	}
+
+	_, err = io.Copy(w, fd)
+	return
+}
+
+// ExportDumps packages all the dumps found in the dump directory configured
+// into a single zip archive created in outDir. The archive contains a
+// manifest indexing endpoint, events and files (with their SHA256). If prune
+// is true, exported dumps are deleted once the archive has been written.
+func ExportDumps(c *Config, outDir string, prune bool) (archive string, m DumpsManifest, err error) {
+	var out *os.File
+
+	root := c.Dump.Dir
+
+	m.Timestamp = time.Now().UTC()
+	m.Endpoint.Hostname, _ = os.Hostname()
+	if c.FwdConfig != nil {
+		m.Endpoint.UUID = c.FwdConfig.Client.UUID
+	}
+
+	if m.Events, m.Skipped, err = walkDumps(root); err != nil {
+		return
+	}
+
+	for _, s := range m.Skipped {
+		log.Warnf("Skipping unexpected dump directory entry: %s", filepath.Join(root, s))
+	}
+
+	if err = utils.HidsMkdirAll(outDir); err != nil {
+		return
+	}
+
+	name := fmt.Sprintf("dumps_%s_%s.zip", m.Endpoint.Hostname, m.Timestamp.Format("20060102T150405Z"))
+	archive = filepath.Join(outDir, name)
+	partname := fmt.Sprintf("%s.part", archive)
+
+	if out, err = utils.HidsCreateFile(partname); err != nil {
+		return
+	}
+	defer func() {
+		out.Close()
+		// we don't leave partial archives behind
+		if err != nil {
+			os.Remove(partname)
+		}
+	}()
+
+	zw := zip.NewWriter(out)
+	for i := range m.Events {
+		e := &m.Events[i]
+		for j := range e.Files {
+			f := &e.Files[j]
+			path := filepath.Join(root, filepath.FromSlash(f.Path))
+
+			if f.Sha256, err = file.Sha256(path); err != nil {
+				err = fmt.Errorf("failed to hash file %s: %s", path, err)
+				return
+			}
+
+			if err = zipFile(zw, f.Path, path); err != nil {
+				err = fmt.Errorf("failed to archive file %s: %s", path, err)
+				return
+			}
+		}
+	}
+
+	var w io.Writer
+	if w, err = zw.Create(ExportManifestName); err != nil {
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(&m); err != nil {
+		return
+	}
+
+	if err = zw.Close(); err != nil {
+		return
+	}
+
+	if err = out.Close(); err != nil {
+		return
+	}
+
+	if err = os.Rename(partname, archive); err != nil {
+		return
+	}
+
+	if prune {
+		pruneExported(root, m.Events)
+	}
+
+	return
+}
+
+// pruneExported deletes the dumps exported, process GUID directories are
+// removed only if left empty
+func pruneExported(root string, events []ExportedEvent) {
+	for _, e := range events {
+		guidDir := filepath.Join(root, e.ProcessGUID)
+		evtDir := filepath.Join(guidDir, e.EventHash)
+
+		for _, f := range e.Files {
+			path := filepath.Join(evtDir, f.Name)
+			if err := os.Remove(path); err != nil {
+				log.Errorf("Failed to prune exported dump %s: %s", path, err)
+			}
+		}
+
+		// os.Remove fails if directories are not empty which is what we want
+		os.Remove(evtDir)
+		os.Remove(guidDir)
+	}
+}
@@ -0,0 +1,114 @@
+package hids
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultFileAnomalyWindow default time window used to compute file creation anomalies
+	DefaultFileAnomalyWindow = 30 * time.Second
+	// DefaultFileAnomalyMinFiles default minimum number of files created within window
+	DefaultFileAnomalyMinFiles = 50
+	// DefaultFileAnomalyMinExtensions default minimum number of distinct extensions within window
+	DefaultFileAnomalyMinExtensions = 10
+	// DefaultFileAnomalyMinSameExtension default minimum number of files created with the same uncommon extension
+	DefaultFileAnomalyMinSameExtension = 50
+
+	fileAnomalyDistinctExt  = "distinct-extensions"
+	fileAnomalyUncommonExt  = "uncommon-extension"
+	fileAnomalyReasonsSep   = ","
+	fileAnomalyScoreByCheck = 50
+)
+
+var (
+	// DefaultCommonExtensions extensions never considered as suspicious
+	DefaultCommonExtensions = []string{
+		"", ".tmp", ".log", ".etl", ".dat", ".db", ".pf", ".ini", ".xml",
+		".json", ".txt", ".dll", ".exe", ".sys", ".cab", ".mui", ".lnk",
+	}
+)
+
+// FileAnomalyConfig holds the settings used to score file creation
+// anomalies (i.e. ransomware like behaviour) of a process
+type FileAnomalyConfig struct {
+	Enable           bool          `toml:"enable" comment:"Enable file creation anomaly scoring"`
+	Window           time.Duration `toml:"window" comment:"Time window on which file creations are considered"`
+	MinFiles         int           `toml:"min-files" comment:"Minimum number of files created by a process within window\n to check for distinct extensions anomaly"`
+	MinExtensions    int           `toml:"min-extensions" comment:"Minimum number of distinct extensions created within window\n to flag an anomaly"`
+	MinSameExtension int           `toml:"min-same-extension" comment:"Minimum number of files created within window with\n the same uncommon extension to flag an anomaly"`
+	CommonExtensions []string      `toml:"common-extensions" comment:"Extensions never considered as uncommon (lower case with leading dot)"`
+}
+
+// IsEnabled returns true if file anomaly scoring is enabled
+func (c *FileAnomalyConfig) IsEnabled() bool {
+	return c != nil && c.Enable
+}
+
+func (c *FileAnomalyConfig) isCommon(ext string) bool {
+	for _, common := range c.CommonExtensions {
+		if ext == common {
+			return true
+		}
+	}
+	return false
+}
+
+type fileCreation struct {
+	timestamp time.Time
+	ext       string
+}
+
+// fileWindow keeps track of the files created within a time window
+type fileWindow struct {
+	creations []fileCreation
+	byExt     map[string]int
+}
+
+func (w *fileWindow) add(now time.Time, ext string, window time.Duration) {
+	if w.byExt == nil {
+		w.byExt = make(map[string]int)
+	}
+
+	// removing creations out of window
+	i := 0
+	for ; i < len(w.creations) && now.Sub(w.creations[i].timestamp) > window; i++ {
+		old := w.creations[i].ext
+		if w.byExt[old]--; w.byExt[old] <= 0 {
+			delete(w.byExt, old)
+		}
+	}
+	w.creations = append(w.creations[i:], fileCreation{now, ext})
+	w.byExt[ext]++
+}
+
+// FileAnomaly holds the result of the anomaly scoring
+type FileAnomaly struct {
+	Score   int
+	Reasons []string
+}
+
+// Reason returns the reasons of the anomaly as a string
+func (a FileAnomaly) Reason() string {
+	return strings.Join(a.Reasons, fileAnomalyReasonsSep)
+}
+
+// score updates the file window with a new file creation and scores it
+func (w *fileWindow) score(now time.Time, ext string, c *FileAnomalyConfig) (a FileAnomaly) {
+	ext = strings.ToLower(ext)
+	w.add(now, ext, c.Window)
+
+	// high create frequency across many distinct extensions
+	if len(w.creations) >= c.MinFiles && len(w.byExt) >= c.MinExtensions {
+		a.Score += fileAnomalyScoreByCheck
+		a.Reasons = append(a.Reasons, fileAnomalyDistinctExt)
+	}
+
+	// mass creation (or renames) to a single uncommon extension
+	if !c.isCommon(ext) && w.byExt[ext] >= c.MinSameExtension {
+		a.Score += fileAnomalyScoreByCheck
+		a.Reasons = append(a.Reasons, fileAnomalyUncommonExt)
+	}
+
+	return
+}
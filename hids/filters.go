@@ -18,9 +18,11 @@ var (
 	fltProcessCreate   = NewFilter([]int64{SysmonProcessCreate}, sysmonChannel)
 	fltTrack           = NewFilter([]int64{SysmonProcessCreate, SysmonDriverLoad}, sysmonChannel)
 	fltProcTermination = NewFilter([]int64{SysmonProcessTerminate}, sysmonChannel)
+	fltSelfProtection  = NewFilter([]int64{SysmonProcessCreate, SysmonProcessTerminate}, sysmonChannel)
 	fltImageLoad       = NewFilter([]int64{SysmonImageLoad}, sysmonChannel)
 	fltRegSetValue     = NewFilter([]int64{SysmonRegSetValue}, sysmonChannel)
 	//fltNetwork         = NewFilter([]int64{SysmonNetworkConnect, SysmonDNSQuery}, sysmonChannel)
+	fltNetworkConnect = NewFilter([]int64{SysmonNetworkConnect}, sysmonChannel)
 	//fltDNS             = NewFilter([]int64{SysmonDNSQuery}, sysmonChannel)
 	fltClipboard      = NewFilter([]int64{SysmonClipboardChange}, sysmonChannel)
 	fltImageTampering = NewFilter([]int64{SysmonProcessTampering}, sysmonChannel)
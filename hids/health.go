@@ -0,0 +1,170 @@
+package hids
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// DefaultHealthListen is the default address of the health endpoint
+	DefaultHealthListen = "localhost:4243"
+
+	// HealthPath returns agent liveness
+	HealthPath = "/health"
+	// ReadyPath returns agent readiness
+	ReadyPath = "/ready"
+)
+
+// HealthConfig holds the configuration of the local health endpoint
+type HealthConfig struct {
+	Enable bool   `toml:"enable" comment:"Enable local health endpoint (HTTP)"`
+	Listen string `toml:"listen" comment:"Address the health endpoint listens on, must be a loopback address"`
+}
+
+// IsEnabled returns true if the health endpoint is enabled
+func (c *HealthConfig) IsEnabled() bool {
+	return c != nil && c.Enable
+}
+
+func (c *HealthConfig) listen() string {
+	if c.Listen == "" {
+		return DefaultHealthListen
+	}
+	return c.Listen
+}
+
+// Verify validates health endpoint configuration
+func (c *HealthConfig) Verify() error {
+	if !c.IsEnabled() {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(c.listen())
+	if err != nil {
+		return fmt.Errorf("health endpoint: %w", err)
+	}
+
+	if host == "localhost" {
+		return nil
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("health endpoint must listen on a loopback address: %s", host)
+	}
+
+	return nil
+}
+
+// HealthStatus is the structure returned by the health endpoint
+type HealthStatus struct {
+	Alive        bool      `json:"alive"`
+	Ready        bool      `json:"ready"`
+	EngineLoaded bool      `json:"engine-loaded"`
+	RuleCount    int       `json:"rule-count"`
+	EtwActive    bool      `json:"etw-active"`
+	Events       float64   `json:"events"`
+	Detections   float64   `json:"detections"`
+	EPS          float64   `json:"eps"`
+	LastEvent    time.Time `json:"last-event"`
+	Uptime       string    `json:"uptime"`
+}
+
+func (h *HIDS) etwActive() bool {
+	return atomic.LoadInt32(&h.etwRunning) == 1
+}
+
+func (h *HIDS) markEvent() {
+	atomic.StoreInt64(&h.lastEvent, time.Now().UnixNano())
+}
+
+// Health returns the current health status of the agent
+func (h *HIDS) Health() (s HealthStatus) {
+	h.RLock()
+	if h.Engine != nil {
+		s.EngineLoaded = true
+		s.RuleCount = h.Engine.Count()
+	}
+	h.RUnlock()
+
+	s.EtwActive = h.etwActive()
+	s.Events = h.stats.Events()
+	s.Detections = h.stats.Detections()
+	s.EPS = h.stats.EPS()
+	s.Uptime = h.stats.SinceStart().String()
+	if last := atomic.LoadInt64(&h.lastEvent); last > 0 {
+		s.LastEvent = time.Unix(0, last).UTC()
+	}
+
+	// the agent is alive as long as its ETW session is
+	s.Alive = s.EtwActive
+	s.Ready = s.EngineLoaded && s.EtwActive
+
+	return
+}
+
+func (h *HIDS) healthHandler(ready bool) http.HandlerFunc {
+	return func(wt http.ResponseWriter, rq *http.Request) {
+		if rq.Method != http.MethodGet {
+			http.Error(wt, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		s := h.Health()
+		ok := s.Alive
+		if ready {
+			ok = s.Ready
+		}
+
+		wt.Header().Set("Content-Type", "application/json")
+		if !ok {
+			wt.WriteHeader(http.StatusServiceUnavailable)
+		}
+		wt.Write(utils.Json(s))
+	}
+}
+
+// healthRoutine starts the local health endpoint if enabled
+func (h *HIDS) healthRoutine() bool {
+	if !h.config.Health.IsEnabled() {
+		return false
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(HealthPath, h.healthHandler(false))
+	mux.HandleFunc(ReadyPath, h.healthHandler(true))
+	mux.HandleFunc(RecentEventsPath, h.recentEventsHandler)
+
+	h.healthServer = &http.Server{
+		Addr:         h.config.Health.listen(),
+		Handler:      mux,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		if err := h.healthServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("Health endpoint failed: %s", err)
+		}
+	}()
+
+	return true
+}
+
+func (h *HIDS) stopHealthRoutine() {
+	if h.healthServer == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.healthServer.Shutdown(ctx); err != nil {
+		log.Errorf("Failed to shutdown health endpoint: %s", err)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -12,6 +14,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/0xrawsec/golang-etw/etw"
@@ -20,6 +23,7 @@ import (
 
 	"github.com/0xrawsec/gene/v2/engine"
 	"github.com/0xrawsec/golang-utils/crypto/data"
+	"github.com/0xrawsec/golang-utils/crypto/file"
 	"github.com/0xrawsec/golang-utils/datastructs"
 	"github.com/0xrawsec/golang-utils/fsutil"
 	"github.com/0xrawsec/golang-utils/fsutil/fswalker"
@@ -28,6 +32,7 @@ import (
 	"github.com/0xrawsec/whids/event"
 	"github.com/0xrawsec/whids/hids/sysinfo"
 	"github.com/0xrawsec/whids/utils"
+	"github.com/0xrawsec/whids/utils/osquery"
 )
 
 const (
@@ -36,6 +41,8 @@ const (
 
 	// Container extension
 	containerExt = ".cont.gz"
+	// interval at which manager is asked whether a running command has to be cancelled
+	commandCancelCheckInterval = 5 * time.Second
 )
 
 var (
@@ -50,7 +57,7 @@ var (
 	emptyForwarderConfig = api.ForwarderConfig{}
 
 	// extensions of files to upload to manager
-	uploadExts = datastructs.NewInitSyncedSet(".gz", ".sha256")
+	uploadExts = datastructs.NewInitSyncedSet(utils.GzipExt, utils.ZstdExt, ".sha256")
 
 	archivedRe = regexp.MustCompile(`(CLIP-)??[0-9A-F]{32,}(\..*)?`)
 )
@@ -77,15 +84,44 @@ type HIDS struct {
 	guid          string
 	tracker       *ActivityTracker
 	actionHandler *ActionHandler
-	memdumped     *datastructs.SyncedSet
-	dumping       *datastructs.SyncedSet
-	filedumped    *datastructs.SyncedSet
+	memdumped     *utils.LRUSet
+	dumping       *utils.LRUSet
+	filedumped    *utils.LRUSet
+	svcResolver   *serviceResolver
+	liveTraces    *liveTraces
+	dedup         *deduplicator
+	sampler       *sampler
+	denylist      *denylist
+	profiler      *ruleProfiler
+	attack        *attackTagger
+	recent        *recentEvents
+	raw           *rawForwarder
+	osquery       *osquery.Client
+	suppressions  suppressions
+	protected     *protectedPIDs
+	agentDirs     []string
+	uploadLock    sync.Mutex
+	updateLock    sync.Mutex
+	healthServer  *http.Server
+	// set to 1 while ETW traces are being processed
+	etwRunning int32
+	// unix nano timestamp of the last event received
+	lastEvent int64
 
 	systemInfo *sysinfo.SystemInfo
 
-	Engine   *engine.Engine
+	Engine *engine.Engine
+	// shadow engine, nil if no shadow rule is evaluated
+	shadow   *engine.Engine
 	DryRun   bool
 	PrintAll bool
+	// agent version information reported by sysinfo command
+	Version  string
+	CommitID string
+	// log file written by the agent before configuration is loaded
+	BootstrapLog string
+	// path of the configuration file, used to persist rotated endpoint key
+	ConfigPath string
 }
 
 func newActionnableEngine(c *Config) (e *engine.Engine) {
@@ -123,9 +159,19 @@ func NewHIDS(c *Config) (h *HIDS, err error) {
 		config:          c,
 		waitGroup:       sync.WaitGroup{},
 		tracker:         NewActivityTracker(),
-		memdumped:       datastructs.NewSyncedSet(),
-		dumping:         datastructs.NewSyncedSet(),
-		filedumped:      datastructs.NewSyncedSet(),
+		memdumped:       utils.NewLRUSet(c.Dump.MaxDumpedEntries),
+		dumping:         utils.NewLRUSet(c.Dump.MaxDumpedEntries),
+		filedumped:      utils.NewLRUSet(c.Dump.MaxDumpedEntries),
+		svcResolver:     newServiceResolver(),
+		liveTraces:      newLiveTraces(),
+		dedup:           newDeduplicator(c.Dedup),
+		sampler:         newSampler(c.Sampling),
+		denylist:        newDenylist(c.Denylist),
+		profiler:        newRuleProfiler(c.RuleProfiling),
+		attack:          newAttackTagger(c.Attack),
+		recent:          newRecentEvents(c.RecentEvents),
+		raw:             newRawForwarder(c.RawForwarding),
+		protected:       newProtectedPIDs(c.Actions.ProtectChildren),
 		// has to be empty to post structure the first time
 		systemInfo: &sysinfo.SystemInfo{},
 	}
@@ -133,12 +179,32 @@ func NewHIDS(c *Config) (h *HIDS, err error) {
 	// initializing action manager
 	h.actionHandler = NewActionHandler(h)
 
+	// queries are run through osqueryd if available
+	if c.Report.OSQuery.Socket != "" {
+		h.osquery = osquery.NewClient(c.Report.OSQuery.Socket)
+	}
+
+	// bounding the number of tracked processes
+	h.tracker.SetMaxTracked(c.MaxTracked)
+	h.tracker.SetMaxCommandLine(c.Tracking.CommandLineLimit())
+	h.tracker.SetMaxDrivers(c.Tracking.DriversLimit())
+	h.tracker.SetBlacklistNormalization(c.Actions.NormalizeBlacklist)
+
 	// Creates missing directories
 	c.Prepare()
 
+	// resolved once directories are created
+	h.agentDirs = c.agentDirs()
+
 	// Create logfile asap if needed
 	if c.Logfile != "" {
-		log.SetLogfile(c.Logfile, 0600)
+		if c.LogFormat == LogFormatJSON {
+			if err = utils.SetJSONLogfile(c.Logfile, 0600); err != nil {
+				return nil, fmt.Errorf("failed to open logfile: %w", err)
+			}
+		} else {
+			log.SetLogfile(c.Logfile, 0600)
+		}
 	}
 
 	// Verify configuration
@@ -157,7 +223,9 @@ func NewHIDS(c *Config) (h *HIDS, err error) {
 	// initialization
 	h.initEventProvider()
 	h.initHooks(c.EnableHooks)
+	log.Infof("Agent directories excluded from file dumps and canaries: %s", strings.Join(h.agentDirs, ", "))
 	// initializing canaries
+	h.config.CanariesConfig.ExcludeDirs(h.agentDirs...)
 	h.config.CanariesConfig.Configure()
 	// fixing local audit policies if necessary
 	h.config.AuditConfig.Configure()
@@ -191,39 +259,68 @@ func (h *HIDS) initEventProvider() {
 	}
 }
 
+// RegisterHook registers a hook run on the events matching filter f at a
+// given stage of event processing. Hooks run by increasing priority, hooks
+// of equal priority running in registration order, so that a hook depending
+// on the fields set by another one must have a higher priority. Hooks must
+// be registered before the HIDS runs.
+func (h *HIDS) RegisterHook(stage HookStage, f *Filter, hook Hook, priority int) error {
+	switch stage {
+	case HookPreDetection:
+		h.preHooks.HookWithPriority(hook, f, priority)
+	case HookPostDetection:
+		h.postHooks.HookWithPriority(hook, f, priority)
+	default:
+		return fmt.Errorf("unknown hook stage: %d", stage)
+	}
+	return nil
+}
+
 func (h *HIDS) initHooks(advanced bool) {
 	// We enable those hooks anyway since it is needed to skip
 	// events generated by WHIDS process. These ar very light hooks
-	h.preHooks.Hook(hookSelfGUID, fltImageSize)
-	h.preHooks.Hook(hookProcTerm, fltProcTermination)
-	h.preHooks.Hook(hookStats, fltStats)
-	h.preHooks.Hook(hookTrack, fltTrack)
+	h.RegisterHook(HookPreDetection, fltAnySysmon, hookSelfGUID, HookPriorityTracking)
+	h.RegisterHook(HookPreDetection, fltSelfProtection, hookSelfProtection, HookPriorityTracking)
+	h.RegisterHook(HookPreDetection, fltProcTermination, hookProcTerm, HookPriorityTracking)
+	h.RegisterHook(HookPreDetection, fltStats, hookStats, HookPriorityTracking)
+	h.RegisterHook(HookPreDetection, fltTrack, hookTrack, HookPriorityTracking)
 	if advanced {
 		// Process terminator hook, terminating blacklisted (by action) processes
-		h.preHooks.Hook(hookTerminator, fltProcessCreate)
-		h.preHooks.Hook(hookImageLoad, fltImageLoad)
-		h.preHooks.Hook(hookSetImageSize, fltImageSize)
-		h.preHooks.Hook(hookProcessIntegrityProcTamp, fltImageTampering)
-		h.preHooks.Hook(hookEnrichServices, fltAnySysmon)
-		h.preHooks.Hook(hookClipboardEvents, fltClipboard)
-		h.preHooks.Hook(hookFileSystemAudit, fltFSObjectAccess)
+		h.RegisterHook(HookPreDetection, fltProcessCreate, hookTerminator, HookPriorityEnrichment)
+		h.RegisterHook(HookPreDetection, fltImageLoad, hookImageLoad, HookPriorityEnrichment)
+		h.RegisterHook(HookPreDetection, fltImageSize, hookSetImageSize, HookPriorityEnrichment)
+		h.RegisterHook(HookPreDetection, fltImageTampering, hookProcessIntegrityProcTamp, HookPriorityEnrichment)
+		h.RegisterHook(HookPreDetection, fltAnySysmon, hookEnrichServices, HookPriorityEnrichment)
+		h.RegisterHook(HookPreDetection, fltClipboard, hookClipboardEvents, HookPriorityEnrichment)
+		h.RegisterHook(HookPreDetection, fltFSObjectAccess, hookFileSystemAudit, HookPriorityEnrichment)
+		h.RegisterHook(HookPreDetection, fltNetworkConnect, hookEnrichNetworkConnect, HookPriorityEnrichment)
 		// Must be run the last as it depends on other filters
-		h.preHooks.Hook(hookEnrichAnySysmon, fltAnySysmon)
-		h.preHooks.Hook(hookKernelFiles, fltKernelFile)
+		h.RegisterHook(HookPreDetection, fltAnySysmon, hookEnrichAnySysmon, HookPriorityLateEnrichment)
+		h.RegisterHook(HookPreDetection, fltKernelFile, hookKernelFiles, HookPriorityLateEnrichment)
 
 		// This hook must run before action handling as we want
 		// the gene score to be set before an eventual reporting
-		h.postHooks.Hook(hookUpdateGeneScore, fltAnyEvent)
+		h.RegisterHook(HookPostDetection, fltAnyEvent, hookUpdateGeneScore, HookPriorityEnrichment)
 	}
 }
 
-func (h *HIDS) update(force bool) (last error) {
-	var reloadRules, reloadContainers bool
+func (h *HIDS) update(force bool) error {
+	// rules update and repair must not run concurrently
+	h.updateLock.Lock()
+	defer h.updateLock.Unlock()
+
+	return h.updateEngine(force)
+}
+
+func (h *HIDS) updateEngine(force bool) (last error) {
+	var reloadRules, reloadContainers, reloadShadow, reloadSuppressions bool
 
 	// check that we are connected to any manager
 	if h.config.IsForwardingEnabled() {
 		reloadRules = h.needsRulesUpdate()
 		reloadContainers = h.needsIoCsUpdate()
+		reloadShadow = h.needsShadowRulesUpdate()
+		reloadSuppressions = h.needsSuppressionsUpdate()
 	}
 
 	// check if we need rule update
@@ -235,6 +332,22 @@ func (h *HIDS) update(force bool) (last error) {
 		}
 	}
 
+	if reloadShadow {
+		log.Info("Updating WHIDS shadow rules")
+		if err := h.fetchShadowRulesFromManager(); err != nil {
+			log.Errorf("Failed to fetch shadow rules from manager: %s", err)
+			reloadShadow = false
+		}
+	}
+
+	if reloadSuppressions {
+		log.Info("Updating WHIDS suppressions")
+		if err := h.fetchSuppressionsFromManager(); err != nil {
+			log.Errorf("Failed to fetch suppressions from manager: %s", err)
+			reloadSuppressions = false
+		}
+	}
+
 	if reloadContainers {
 		log.Info("Updating WHIDS containers")
 		if err := h.fetchIoCsFromManager(); err != nil {
@@ -243,8 +356,17 @@ func (h *HIDS) update(force bool) (last error) {
 		}
 	}
 
-	log.Debugf("reloading rules:%t containers:%t forced:%t", reloadRules, reloadContainers, force)
-	if reloadRules || reloadContainers || force {
+	// updating containers managed from the manager
+	if h.config.IsForwardingEnabled() {
+		updated, err := h.updateContainers()
+		if err != nil {
+			log.Errorf("Failed to update containers from manager: %s", err)
+		}
+		reloadContainers = reloadContainers || updated
+	}
+
+	log.Debugf("reloading rules:%t shadow:%t suppressions:%t containers:%t forced:%t", reloadRules, reloadShadow, reloadSuppressions, reloadContainers, force)
+	if reloadRules || reloadShadow || reloadSuppressions || reloadContainers || force {
 		// We need to create a new engine if we received a rule/containers update
 		newEngine := newActionnableEngine(h.config)
 
@@ -295,14 +417,48 @@ func (h *HIDS) update(force bool) (last error) {
 		}
 		log.Infof("Number of rules loaded in engine: %d", newEngine.Count())
 
+		// a failure to load shadow rules must not prevent active rules update
+		shadowEngine, err := h.loadShadowEngine()
+		if err != nil {
+			log.Errorf("Failed to load shadow engine, shadow evaluation disabled: %s", err)
+		} else if shadowEngine != nil {
+			log.Infof("Number of rules loaded in shadow engine: %d", shadowEngine.Count())
+		}
+
+		sups, err := h.loadSuppressions()
+		if err != nil {
+			last = err
+		}
+		log.Infof("Number of suppressions loaded: %d", len(sups))
+
+		rulesPath, _ := h.config.RulesConfig.RulesPaths()
+		rulesSha256, _ := file.Sha256(rulesPath)
+		reload := map[string]interface{}{
+			"Success":     last == nil,
+			"RuleCount":   newEngine.Count(),
+			"RulesSha256": rulesSha256,
+		}
+		if shadowEngine != nil {
+			reload["ShadowRuleCount"] = shadowEngine.Count()
+		}
+
 		// updating engine if no error
 		if last == nil {
-			// we update engine only if there was no error
-			// no need to lock HIDS as newEngine is ready to use at this point
+			// we update engine only if there was no error. Engine is swapped
+			// under lock so that in flight events finish being processed by
+			// the old engine and no event sees a partially loaded engine
+			h.Lock()
 			h.Engine = newEngine
+			h.shadow = shadowEngine
+			h.suppressions = sups
+			h.Unlock()
 		} else {
-			log.Error("EDR engine not updated:", last)
+			// we keep old engine running
+			reload["Error"] = last.Error()
+			log.Errorf("EDR engine not updated, keeping previous rules: %s", last)
 		}
+
+		h.emitEdrEvent(EdrEventRulesReload, reload)
 	} else {
 		log.Debug("Neither rules nor containers need to be updated")
 	}
@@ -416,8 +572,82 @@ func (h *HIDS) fetchIoCsFromManager() (err error) {
 		return fmt.Errorf("failed to verify container \"%s\" integrity", api.IoCContainerName)
 	}
 
-	// we dump the container
-	contPath, contSha256Path := h.containerPaths(api.IoCContainerName)
+	return h.dumpContainer(api.IoCContainerName, iocs, compSha256)
+}
+
+// updateContainers synchronizes the containers managed by the manager
+// and returns true if any container has been updated
+func (h *HIDS) updateContainers() (updated bool, lastErr error) {
+	var remote map[string]string
+	var err error
+
+	cl := h.forwarder.Client
+
+	// if we are not connected to a manager we return
+	if h.config.FwdConfig.Local {
+		return
+	}
+
+	if remote, err = cl.GetContainersSha256(); err != nil {
+		return false, fmt.Errorf("failed to get containers sha256: %s", err)
+	}
+
+	for name, sha256 := range remote {
+		_, locContSha256Path := h.containerPaths(name)
+		if localSha256, _ := utils.ReadFileString(locContSha256Path); localSha256 == sha256 {
+			continue
+		}
+
+		log.Infof("Updating container %s", name)
+		entries, err := cl.GetContainer(name)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		// we compare the integrity of the container received
+		if utils.Sha256StringArray(entries) != sha256 {
+			lastErr = fmt.Errorf("failed to verify container \"%s\" integrity", name)
+			continue
+		}
+
+		if err := h.dumpContainer(name, entries, sha256); err != nil {
+			lastErr = err
+			continue
+		}
+		updated = true
+	}
+
+	// removing containers deleted from manager, only the ones having
+	// a sha256 file have been pushed by the manager
+	for wi := range fswalker.Walk(h.config.RulesConfig.ContainersDB) {
+		for _, fi := range wi.Files {
+			if !strings.HasSuffix(fi.Name(), containerExt) {
+				continue
+			}
+
+			name := strings.SplitN(fi.Name(), ".", 2)[0]
+			contPath, contSha256Path := h.containerPaths(name)
+			if _, ok := remote[name]; ok || name == api.IoCContainerName || !fsutil.Exists(contSha256Path) {
+				continue
+			}
+
+			log.Infof("Removing container %s deleted from manager", name)
+			if err := os.Remove(contPath); err != nil {
+				lastErr = err
+				continue
+			}
+			os.Remove(contSha256Path)
+			updated = true
+		}
+	}
+
+	return
+}
+
+// dumpContainer writes a container and its sha256 to the container database
+func (h *HIDS) dumpContainer(name string, entries []string, sha256 string) (err error) {
+	contPath, contSha256Path := h.containerPaths(name)
 	fd, err := utils.HidsCreateFile(contPath)
 	if err != nil {
 		return err
@@ -428,8 +658,8 @@ func (h *HIDS) fetchIoCsFromManager() (err error) {
 	w := gzip.NewWriter(fd)
 	// closing gzip writer
 	defer w.Close()
-	for _, ioc := range iocs {
-		if _, err = w.Write([]byte(fmt.Sprintln(ioc))); err != nil {
+	for _, e := range entries {
+		if _, err = w.Write([]byte(fmt.Sprintln(e))); err != nil {
 			return
 		}
 	}
@@ -443,7 +673,7 @@ func (h *HIDS) fetchIoCsFromManager() (err error) {
 	}
 
 	// Dump current container sha256 to a file
-	return ioutil.WriteFile(contSha256Path, []byte(compSha256), 0600)
+	return ioutil.WriteFile(contSha256Path, []byte(sha256), 0600)
 }
 
 // loads containers found in container database directory
@@ -529,6 +759,43 @@ func (h *HIDS) cronRoutine() {
 	}()
 }
 
+// routine forwarding collapsed detections at the end of deduplication windows
+func (h *HIDS) dedupRoutine() bool {
+	if h.config.Dedup.IsEnabled() {
+		go func() {
+			for h.ctx.Err() == nil {
+				for _, e := range h.dedup.Expired(false) {
+					h.forwarder.PipeEvent(e)
+				}
+				time.Sleep(time.Second)
+			}
+		}()
+		return true
+	}
+	return false
+}
+
+// routine verifying audit configuration and re-applying it on drift
+func (h *HIDS) auditSelfHealRoutine() bool {
+	if h.config.AuditConfig.SelfHeal {
+		go func() {
+			interval := h.config.AuditConfig.verifyInterval()
+			log.Infof("Starting audit configuration self-healing routine (interval: %s)", interval)
+			for h.ctx.Err() == nil {
+				select {
+				case <-h.ctx.Done():
+				case <-time.After(interval):
+					if n := h.config.AuditConfig.Heal(); n > 0 {
+						log.Infof("Audit configuration self-healing corrected %d drift(s)", n)
+					}
+				}
+			}
+		}()
+		return true
+	}
+	return false
+}
+
 func (h *HIDS) cleanArchivedRoutine() bool {
 	if h.config.Sysmon.CleanArchived {
 		go func() {
@@ -540,13 +807,19 @@ func (h *HIDS) cleanArchivedRoutine() bool {
 				return
 			}
 
-			if fsutil.IsDir(archivePath) {
-				// used to mark files for which we already reported errors
-				reported := datastructs.NewSyncedSet()
-				log.Infof("Starting archive cleanup loop for directory: %s", archivePath)
-				for {
-					// expiration fixed to five minutes
-					expired := time.Now().Add(time.Minute * -5)
+			// Sysmon creates the directory only when it archives the first
+			// file so we do not give up if it does not exist yet
+			if !fsutil.IsDir(archivePath) {
+				log.Warnf("No such Sysmon archive directory yet: %s", archivePath)
+			}
+
+			// used to mark files for which we already reported errors
+			reported := datastructs.NewSyncedSet()
+			log.Infof("Starting archive cleanup loop for directory: %s", archivePath)
+			for {
+				// expiration fixed to five minutes
+				expired := time.Now().Add(time.Minute * -5)
+				if fsutil.IsDir(archivePath) {
 					for wi := range fswalker.Walk(archivePath) {
 						for _, fi := range wi.Files {
 							if archivedRe.MatchString(fi.Name()) {
@@ -561,10 +834,8 @@ func (h *HIDS) cleanArchivedRoutine() bool {
 							}
 						}
 					}
-					time.Sleep(time.Minute * 1)
 				}
-			} else {
-				log.Errorf(fmt.Sprintf("No such Sysmon archive directory: %s", archivePath))
+				time.Sleep(time.Minute * 1)
 			}
 		}()
 		return true
@@ -600,7 +871,9 @@ func (h *HIDS) updateRoutine() bool {
 	if h.config.IsForwardingEnabled() {
 		if d > 0 {
 			go func() {
-				t := time.NewTimer(d)
+				// endpoints started together must not update at the same time
+				r := rand.New(rand.NewSource(time.Now().UnixNano()))
+				t := time.NewTimer(h.config.jitter(r, d))
 				for range t.C {
 					if err := h.update(false); err != nil {
 						log.Error(err)
@@ -608,7 +881,8 @@ func (h *HIDS) updateRoutine() bool {
 					if err := h.updateSystemInfo(); err != nil {
 						log.Error(err)
 					}
-					t.Reset(d)
+					h.heartbeat()
+					t.Reset(h.config.jitter(r, d))
 				}
 			}()
 			return true
@@ -617,65 +891,98 @@ func (h *HIDS) updateRoutine() bool {
 	return false
 }
 
-func (h *HIDS) uploadRoutine() bool {
-	if h.config.IsForwardingEnabled() {
-		// force compression in this case
-		h.config.Dump.Compression = true
-		go func() {
-			for {
-				// Sending dump files over to the manager
-				for wi := range fswalker.Walk(h.config.Dump.Dir) {
-					for _, fi := range wi.Files {
-						sp := strings.Split(wi.Dirpath, string(os.PathSeparator))
-						// upload only file with some extensions
-						if uploadExts.Contains(filepath.Ext(fi.Name())) {
-							if len(sp) >= 2 {
-								var shrink *api.UploadShrinker
-								var err error
-
-								guid := sp[len(sp)-2]
-								ehash := sp[len(sp)-1]
-								fullpath := filepath.Join(wi.Dirpath, fi.Name())
-
-								// we create upload shrinker object
-								if shrink, err = api.NewUploadShrinker(fullpath, guid, ehash); err != nil {
-									log.Errorf("Failed to create upload iterator: %s", err)
-									continue
-								}
+// flush immediately forwards queued and piped events, pending artifacts
+// are sent as well if artifacts is true
+func (h *HIDS) flush(artifacts bool) (s api.FlushStats) {
+	if !h.config.IsForwardingEnabled() {
+		s.Error = "forwarding is not enabled"
+		return
+	}
 
-								if shrink.Size() > h.config.FwdConfig.Client.MaxUploadSize {
-									log.Warnf("Dump file is above allowed upload limit, %s will be deleted without being sent", fullpath)
-									goto CleanShrinker
-								}
+	s = h.forwarder.Flush()
+	if artifacts {
+		s.Artifacts = h.uploadDumps()
+	}
+	log.Infof("Forwarder flushed by manager command: events=%d queued-files=%d artifacts=%d", s.Events, s.QueuedFiles, s.Artifacts)
 
-								// we shrink a file into several chunks to reduce memory impact
-								for fu := shrink.Next(); fu != nil; fu = shrink.Next() {
-									if err = h.forwarder.Client.PostDump(fu); err != nil {
-										log.Error(err)
-										break
-									}
-								}
+	return
+}
 
-							CleanShrinker:
-								// close shrinker otherwise we cannot remove files
-								shrink.Close()
+// uploadDumps sends dump files over to the manager and returns the number
+// of files successfully sent
+func (h *HIDS) uploadDumps() (uploaded int) {
+	// flush command and upload routine must not upload the same files
+	h.uploadLock.Lock()
+	defer h.uploadLock.Unlock()
 
-								if shrink.Err() == nil {
-									log.Infof("Dump file successfully sent to manager, deleting: %s", fullpath)
-									if err := os.Remove(fullpath); err != nil {
-										log.Errorf("Failed to remove file %s: %s", fullpath, err)
-									}
-								} else {
-									log.Errorf("Failed to post dump file: %s", shrink.Err())
-								}
-							} else {
-								log.Errorf("Unexpected directory layout, cannot send dump to manager")
-							}
+	for wi := range fswalker.Walk(h.config.Dump.Dir) {
+		for _, fi := range wi.Files {
+			sp := strings.Split(wi.Dirpath, string(os.PathSeparator))
+			// upload only file with some extensions
+			if uploadExts.Contains(filepath.Ext(fi.Name())) {
+				if len(sp) >= 2 {
+					var shrink *api.UploadShrinker
+					var err error
+
+					guid := sp[len(sp)-2]
+					ehash := sp[len(sp)-1]
+					fullpath := filepath.Join(wi.Dirpath, fi.Name())
+
+					// we create upload shrinker object
+					if shrink, err = api.NewUploadShrinker(fullpath, guid, ehash); err != nil {
+						log.Errorf("Failed to create upload iterator: %s", err)
+						continue
+					}
+
+					if shrink.Size() > h.config.FwdConfig.Client.MaxUploadSize {
+						log.Warnf("Dump file is above allowed upload limit, %s will be deleted without being sent", fullpath)
+						goto CleanShrinker
+					}
+
+					// we shrink a file into several chunks to reduce memory impact
+					for fu := shrink.Next(); fu != nil; fu = shrink.Next() {
+						if err = h.forwarder.Client.PostDump(fu); err != nil {
+							log.Error(err)
+							break
 						}
 					}
 
+					if err == nil && shrink.Err() == nil {
+						uploaded++
+					}
+
+				CleanShrinker:
+					// close shrinker otherwise we cannot remove files
+					shrink.Close()
+
+					if shrink.Err() == nil {
+						log.Infof("Dump file successfully sent to manager, deleting: %s", fullpath)
+						if err := os.Remove(fullpath); err != nil {
+							log.Errorf("Failed to remove file %s: %s", fullpath, err)
+						}
+					} else {
+						log.Errorf("Failed to post dump file: %s", shrink.Err())
+					}
+				} else {
+					log.Errorf("Unexpected directory layout, cannot send dump to manager")
 				}
-				time.Sleep(60 * time.Second)
+			}
+		}
+	}
+
+	return
+}
+
+func (h *HIDS) uploadRoutine() bool {
+	if h.config.IsForwardingEnabled() {
+		// force compression in this case
+		h.config.Dump.Compression = true
+		go func() {
+			r := rand.New(rand.NewSource(time.Now().UnixNano()))
+			for {
+				// Sending dump files over to the manager
+				h.uploadDumps()
+				time.Sleep(h.config.jitter(r, 60*time.Second))
 			}
 		}()
 		return true
@@ -708,14 +1015,55 @@ func (h *HIDS) uncontainCmd() *exec.Cmd {
 
 func (h *HIDS) handleManagerCommand(cmd *api.Command) {
 
+	// structured commands
+	switch cmd.Type {
+	case api.CmdTypeIsolate, api.CmdTypeUnisolate:
+		var err error
+
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		if cmd.Type == api.CmdTypeIsolate {
+			err = h.isolate()
+		} else {
+			err = h.unisolate()
+		}
+		if err != nil {
+			cmd.Error = err.Error()
+		}
+		cmd.Json = IsolationStatus{Isolated: h.isIsolated(), Allowed: h.isolationAllowed()}
+	case api.CmdTypeSysinfo:
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		cmd.Json = h.cmdSysinfo()
+	case api.CmdTypeMemdump:
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		if len(cmd.Args) > 0 {
+			out, err := h.cmdMemdump(cmd.UUID, cmd.Args[0])
+			if err != nil {
+				cmd.Error = err.Error()
+			}
+			cmd.Json = out
+			log.Infof("Memory dump requested by manager command (target: %s)", cmd.Args[0])
+		} else {
+			cmd.Error = "missing memdump target"
+		}
+	}
+
+	// aliases are built by the agent so they do not go through the allowlist
+	aliased := false
+
 	// Switch processing the commands
 	switch cmd.Name {
 	// Aliases
 	case "contain":
+		aliased = true
 		cmd.FromExecCmd(h.containCmd())
 	case "uncontain":
+		aliased = true
 		cmd.FromExecCmd(h.uncontainCmd())
 	case "osquery":
+		aliased = true
 		osquery := h.config.Report.OSQuery.Bin
 		switch {
 		case fsutil.IsFile(h.config.Report.OSQuery.Bin):
@@ -737,6 +1085,8 @@ func (h *HIDS) handleManagerCommand(cmd *api.Command) {
 			spid := cmd.Args[0]
 			if pid, err := strconv.Atoi(spid); err != nil {
 				cmd.Error = fmt.Sprintf("failed to parse pid: %s", err)
+			} else if h.isProtected(int64(pid), "terminate") {
+				cmd.Error = fmt.Sprintf("cannot terminate protected process pid=%d", pid)
 			} else if err := terminate(pid); err != nil {
 				cmd.Error = err.Error()
 			}
@@ -787,6 +1137,110 @@ func (h *HIDS) handleManagerCommand(cmd *api.Command) {
 				cmd.Json = out
 			}
 		}
+	case "suppress-actions":
+		var d time.Duration
+		var err error
+
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		if len(cmd.Args) > 0 {
+			if d, err = time.ParseDuration(cmd.Args[0]); err != nil {
+				cmd.Error = fmt.Sprintf("failed to parse duration: %s", err)
+			}
+		}
+		if err == nil {
+			h.actionHandler.Suppress(d)
+			log.Infof("Actions suppressed by manager command (duration: %s)", d)
+		}
+		h.forwarder.Client.SetActionsSuppression(h.actionHandler.Suppression())
+		cmd.Json = h.actionHandler.Suppression()
+	case "resume-actions":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		h.actionHandler.Resume()
+		log.Info("Actions resumed by manager command")
+		h.forwarder.Client.SetActionsSuppression(h.actionHandler.Suppression())
+		cmd.Json = h.actionHandler.Suppression()
+	case "etw-collect":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		if len(cmd.Args) > 0 {
+			var d time.Duration
+			var err error
+
+			if len(cmd.Args) > 1 {
+				if d, err = time.ParseDuration(cmd.Args[1]); err != nil {
+					cmd.Error = fmt.Sprintf("failed to parse duration: %s", err)
+				}
+			}
+
+			if err == nil {
+				if lt, err := h.startLiveTrace(cmd.Args[0], d); err != nil {
+					cmd.Error = err.Error()
+				} else {
+					cmd.Json = lt
+				}
+			}
+		} else {
+			cmd.Json = h.liveTraces.List()
+		}
+	case "etw-stop":
+		cmd.Unrunnable()
+		if len(cmd.Args) > 0 {
+			if err := h.stopLiveTraceByProvider(cmd.Args[0]); err != nil {
+				cmd.Error = err.Error()
+			}
+		}
+	case "flush":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		cmd.Json = h.flush(len(cmd.Args) > 0 && cmd.Args[0] == "artifacts")
+	case "blacklist":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		cmd.Json = h.tracker.Blacklisted()
+	case "unblacklist":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		if len(cmd.Args) > 0 {
+			// manager sends the command line unchanged as single argument
+			cmdLine := cmd.Args[0]
+			if len(cmd.Args) > 1 {
+				cmdLine = strings.Join(cmd.Args, " ")
+			}
+			if !h.tracker.Unblacklist(cmdLine) {
+				cmd.Error = fmt.Sprintf("command line not blacklisted: %s", cmdLine)
+			} else {
+				log.Infof("Command line unblacklisted by manager command: %s", cmdLine)
+			}
+		}
+		cmd.Json = h.tracker.Blacklisted()
+	case "logs":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		if len(cmd.Args) > 0 {
+			var err error
+			offset, max := int64(-1), int64(DefaultMaxLogChunk)
+			if len(cmd.Args) > 1 {
+				if offset, err = strconv.ParseInt(cmd.Args[1], 10, 64); err != nil {
+					cmd.Error = fmt.Sprintf("failed to parse offset: %s", err)
+				}
+			}
+			if err == nil && len(cmd.Args) > 2 {
+				if max, err = strconv.ParseInt(cmd.Args[2], 10, 64); err != nil {
+					cmd.Error = fmt.Sprintf("failed to parse size: %s", err)
+				}
+			}
+			if err == nil {
+				if chunk, err := h.cmdLogRead(cmd.Args[0], offset, max); err != nil {
+					cmd.Error = err.Error()
+				} else {
+					cmd.Json = chunk
+				}
+			}
+		} else {
+			cmd.Json = h.cmdLogList()
+		}
 	case "report":
 		cmd.Unrunnable()
 		cmd.ExpectJSON = true
@@ -795,8 +1249,12 @@ func (h *HIDS) handleManagerCommand(cmd *api.Command) {
 		h.tracker.RLock()
 		cmd.Unrunnable()
 		cmd.ExpectJSON = true
-		cmd.Json = h.tracker.PS()
+		cmd.Json = h.processTracks()
 		h.tracker.RUnlock()
+	case "ps":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		cmd.Json = h.processList()
 	case "modules":
 		h.tracker.RLock()
 		cmd.Unrunnable()
@@ -804,19 +1262,81 @@ func (h *HIDS) handleManagerCommand(cmd *api.Command) {
 		cmd.Json = h.tracker.Modules()
 		h.tracker.RUnlock()
 	case "drivers":
-		h.tracker.RLock()
 		cmd.Unrunnable()
 		cmd.ExpectJSON = true
-		cmd.Json = h.tracker.Drivers
-		h.tracker.RUnlock()
+		cmd.Json = h.tracker.Drivers()
+	case "repair-rules":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		rr := h.repairRules()
+		if rr.Error != "" {
+			cmd.Error = rr.Error
+		}
+		cmd.Json = rr
+	case "dump-recent-events":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		if path, err := h.dumpRecentEvents(); err != nil {
+			cmd.Error = err.Error()
+		} else if fi, err := cmdStat(path); err != nil {
+			cmd.Error = err.Error()
+		} else {
+			cmd.Json = fi
+		}
+	case "repair-canaries":
+		cmd.Unrunnable()
+		cmd.ExpectJSON = true
+		cr := h.repairCanaries()
+		if cr.Error != "" {
+			cmd.Error = cr.Error
+		}
+		cmd.Json = cr
+	}
+
+	if cmd.IsRunnable() && !aliased {
+		if err := h.config.CommandAllowlist.Check(cmd); err != nil {
+			log.Warnf("Rejected command sent by manager \"%s\": %s", cmd.String(), err)
+			cmd.Unrunnable()
+			cmd.Error = err.Error()
+		}
+	}
+
+	// we finally run the command, it can be cancelled from the manager and
+	// is interrupted if the agent stops
+	ctx, stop := context.WithCancel(h.ctx)
+	defer stop()
+	cancel := make(chan struct{})
+	if cmd.IsRunnable() {
+		go h.watchCommandCancellation(ctx, cancel, cmd)
 	}
 
-	// we finally run the command
-	if err := cmd.Run(); err != nil {
+	if err := cmd.RunContext(h.ctx, cancel); err != nil {
 		log.Errorf("failed to run command sent by manager \"%s\": %s", cmd.String(), err)
 	}
 }
 
+// watchCommandCancellation periodically checks whether the manager requests
+// the cancellation of cmd, closing cancel if it does, until ctx is done
+func (h *HIDS) watchCommandCancellation(ctx context.Context, cancel chan struct{}, cmd *api.Command) {
+	t := time.NewTicker(commandCancelCheckInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if cancelled, err := h.forwarder.Client.IsCommandCancelled(cmd); err != nil {
+				log.Debugf("Failed to check command cancellation: %s", err)
+			} else if cancelled {
+				log.Infof("Command cancelled by manager: %s", cmd.String())
+				close(cancel)
+				return
+			}
+		}
+	}
+}
+
 // routine which manages command to be executed on the endpoint
 // it is made in such a way that we can send burst of commands
 func (h *HIDS) commandRunnerRoutine() bool {
@@ -825,19 +1345,44 @@ func (h *HIDS) commandRunnerRoutine() bool {
 
 			defaultSleep := time.Second * 5
 			sleep := defaultSleep
+			// backoff applied when manager cannot be reached
+			backoff := utils.NewBackoff(defaultSleep, h.config.FwdConfig.Client.MaxRetryBackoff)
+			if backoff.Max <= 0 {
+				backoff.Max = api.DefaultMaxRetryBackoff
+			}
 
 			burstDur := time.Duration(0)
 			tgtBurstDur := time.Second * 30
 			burstSleep := time.Millisecond * 500
 
 			for {
-				if cmd, err := h.forwarder.Client.FetchCommand(); err != nil && err != api.ErrNothingToDo {
-					log.Error(err)
-				} else if err == nil {
+				cmd, err := h.forwarder.Client.FetchCommand()
+
+				// manager accepted our previous key, we have to adopt the new one
+				if h.forwarder.Client.IsKeyRotated() {
+					h.adoptRotatedKey()
+				}
+
+				switch {
+				case err == api.ErrNothingToDo:
+					backoff.Reset()
+				case err != nil:
+					// we are not in burst mode anymore
+					burstDur = 0
+					sleep = backoff.Next()
+					log.Errorf("%s (next attempt in %s)", err, sleep.Round(time.Second))
+					time.Sleep(sleep)
+					sleep = defaultSleep
+					continue
+				default:
+					backoff.Reset()
 					// reduce sleeping time if a command was received
 					sleep = burstSleep
 					burstDur = 0
 					log.Infof("Handling manager command: %s", cmd.String())
+					if err := h.forwarder.Client.AckCommand(cmd); err != nil {
+						log.Error(err)
+					}
 					h.handleManagerCommand(cmd)
 					if err := h.forwarder.Client.PostCommand(cmd); err != nil {
 						log.Error(err)
@@ -861,10 +1406,38 @@ func (h *HIDS) commandRunnerRoutine() bool {
 	return false
 }
 
+// adoptRotatedKey fetches the new endpoint key after a key rotation
+// and saves it to configuration file
+func (h *HIDS) adoptRotatedKey() {
+	key, err := h.forwarder.Client.FetchKey()
+	if err != nil {
+		log.Errorf("Failed to fetch rotated endpoint key: %s", err)
+		return
+	}
+	log.Infof("Endpoint key has been rotated")
+
+	if h.ConfigPath == "" {
+		return
+	}
+
+	// only the key is updated as running configuration may differ
+	if err = SaveHIDSConfigEndpointKey(h.ConfigPath, key); err != nil {
+		log.Errorf("Failed to save rotated key to configuration: %s", err)
+	}
+}
+
 /** Public Methods **/
 
 // IsHIDSEvent returns true if the event is generated by IDS activity
 func (h *HIDS) IsHIDSEvent(e *event.EdrEvent) bool {
+	// secondary check on PIDs in case our GUID is not known yet
+	self := int64(os.Getpid())
+	for _, p := range []engine.XPath{pathSysmonProcessId, pathSysmonParentProcessId, pathSysmonSourceProcessId} {
+		if pid, ok := e.GetInt(p); ok && pid == self {
+			return true
+		}
+	}
+
 	if pguid, ok := e.GetString(pathSysmonParentProcessGUID); ok {
 		if pguid == h.guid {
 			return true
@@ -903,21 +1476,27 @@ func (h *HIDS) Report(light bool) (r Report) {
 
 	// generate a report for running processes or those terminated still having one child or more
 	// do this step first not to polute report with commands to run
-	r.Processes = h.tracker.PS()
+	r.Processes = h.processTracks()
 
 	// Modules ever loaded
 	r.Modules = h.tracker.Modules()
 
 	// Drivers loaded
-	r.Drivers = h.tracker.Drivers
+	r.Drivers = h.tracker.Drivers()
+
+	// Command lines blacklisted
+	r.Blacklist = h.tracker.Blacklisted()
+
+	// Dumps skipped because of low disk space
+	if h.actionHandler != nil {
+		r.SkippedDumps = h.actionHandler.SkippedDumps()
+	}
 
 	// if this is a light report, we don't run the commands
 	if !light {
-		// run all the commands configured to include in the report
-		r.Commands = h.config.Report.PrepareCommands()
-		for i := range r.Commands {
-			r.Commands[i].Run()
-		}
+		// run all the commands and named OSQuery queries configured to
+		// include in the report, within report maximum time
+		r.runCommands(h.config.Report, h.osquery)
 	}
 
 	r.StopTime = time.Now()
@@ -933,6 +1512,9 @@ func (h *HIDS) Run() {
 	// Running action manager
 	h.actionHandler.Run()
 
+	// Check Sysmon archive directory before any routine uses it
+	h.checkSysmonArchive()
+
 	// Start the update routine
 	log.Infof("Update routine running: %t", h.updateRoutine())
 	// starting dump forwarding routine
@@ -941,6 +1523,12 @@ func (h *HIDS) Run() {
 	log.Infof("Command runner routine running: %t", h.commandRunnerRoutine())
 	// start the archive cleanup routine (might create a new thread)
 	log.Infof("Sysmon archived files cleanup routine running: %t", h.cleanArchivedRoutine())
+	// start the detection deduplication routine
+	log.Infof("Detection deduplication routine running: %t", h.dedupRoutine())
+	// start the audit configuration self-healing routine
+	log.Infof("Audit self-healing routine running: %t", h.auditSelfHealRoutine())
+	// start the local health endpoint
+	log.Infof("Health endpoint running: %t", h.healthRoutine())
 
 	// Dry run don't do anything
 	if h.DryRun {
@@ -952,6 +1540,15 @@ func (h *HIDS) Run() {
 
 	// Starting event provider
 	h.eventProvider.Start()
+	atomic.StoreInt32(&h.etwRunning, 1)
+	go func() {
+		// consumer returns only when all traces stopped being processed
+		h.eventProvider.Wait()
+		atomic.StoreInt32(&h.etwRunning, 0)
+		if h.ctx.Err() == nil {
+			log.Critical("ETW session stopped unexpectedly")
+		}
+	}()
 
 	// start stats monitoring
 	h.stats.Start()
@@ -966,7 +1563,28 @@ func (h *HIDS) Run() {
 		}
 
 		for e := range h.eventProvider.Events {
+			// true if event is a collapsed detection
+			var duplicate bool
+			// true if event matched at least one rule
+			var matched bool
+			// true if detection has been suppressed from the manager
+			var suppressed bool
+			// true if event has been piped to the forwarder
+			var forwarded bool
+			// rules matched by the event, its criticality and whether it is filtered
+			var n []string
+			var crit int
+			var filtered bool
+			// detection of the shadow engine, if any is loaded
+			var shadow *engine.Detection
+			var shadowed bool
+			// copy of the event taken before enrichment, if raw forwarded
+			var raw *event.EdrEvent
+			// true once redaction rules applied to the event
+			var redacted bool
 			event := event.NewEdrEvent(e)
+			h.markEvent()
+			h.truncateEvent(event)
 
 			if yes, eps := h.stats.HasPerfIssue(); yes {
 				log.Warnf("Average event rate above limit of %.2f e/s in the last %s: %.2f e/s", h.stats.Threshold(), h.stats.Duration(), eps)
@@ -985,6 +1603,20 @@ func (h *HIDS) Run() {
 
 			h.RLock()
 
+			// denylisted events are dropped before enrichment unless they
+			// match a rule, events kept are matched again once enriched
+			if h.denylist.Match(event) {
+				if n, _, filtered = h.Engine.MatchOrFilter(event); len(n) == 0 && !filtered {
+					h.denylist.Drop()
+					goto Continue
+				}
+				// detection must come from matching the enriched event
+				event.Event.Detection = nil
+			}
+
+			// raw copies must be taken before enrichment
+			raw = h.takeRaw(event)
+
 			// Runs pre detection hooks
 			// putting this before next condition makes the processTracker registering
 			// HIDS events and allows detecting ProcessAccess events from HIDS childs
@@ -1005,12 +1637,50 @@ func (h *HIDS) Run() {
 				goto Continue
 			}
 
+			// raw copies of HIDS and skipped events are not forwarded
+			h.forwardRaw(raw)
+
+			h.profiler.Profile(h.Engine, event)
+
+			// shadow engine sees events as the active engine does
+			shadow, shadowed = h.shadowMatch(event)
+			n, crit, filtered = h.Engine.MatchOrFilter(event)
+			if shadowed {
+				h.forwardShadow(event, event.GetDetection(), shadow)
+			}
+			// canaries touched by allowed processes are not detections
+			if h.allowCanaryAccess(event) {
+				n, crit = nil, 0
+				if det := event.GetDetection(); det != nil {
+					n, crit = det.Names(), det.Criticality
+				}
+			}
+			// process tampering is flagged even if no rule matched
+			if h.flagIntegrity(event) {
+				n, crit = event.GetDetection().Names(), event.GetDetection().Criticality
+			}
+
+			// detection is done, event can be redacted before leaving
+			h.redact(event)
+			redacted = true
+
 			// if the event has matched at least one signature or is filtered
-			if n, crit, filtered := h.Engine.MatchOrFilter(event); len(n) > 0 || filtered {
+			if len(n) > 0 || filtered {
+				matched = true
+				// suppressed detections are processed as filtered events
+				if h.suppressions.Suppressed(event, n) {
+					suppressed = true
+					event.Event.Detection = nil
+				}
+				h.applyRuleActions(event)
+				h.attack.Tag(h.Engine, event)
 				switch {
-				case crit >= h.config.CritTresh:
-					if !h.PrintAll && !h.config.LogAll {
+				case !suppressed && crit >= h.config.CritTresh:
+					// identical detections are collapsed
+					duplicate = h.config.Dedup.IsEnabled() && h.dedup.Duplicate(event)
+					if !h.PrintAll && !h.config.LogAll && !duplicate {
 						h.forwarder.PipeEvent(event)
+						forwarded = true
 					}
 					// Pipe the event to be sent to the forwarder
 					// Run hooks post detection
@@ -1020,25 +1690,36 @@ func (h *HIDS) Run() {
 					//event.Del(&engine.GeneInfoPath)
 					// we pipe filtered event
 					h.forwarder.PipeEvent(event)
+					forwarded = true
 				}
 			}
 
-			// we queue event in action manager
-			h.actionHandler.Queue(event)
+			// we queue event in action manager, actions have
+			// already been taken for duplicate detections
+			if !duplicate {
+				h.actionHandler.Queue(event)
+			}
 
 			// Print everything
 			if h.PrintAll {
 				fmt.Println(utils.JsonString(event))
 			}
 
-			// We log all events
-			if h.config.LogAll {
+			// We log all events, events not matching any rule being sampled
+			if h.config.LogAll && (matched || h.sampler.Keep(event)) {
 				h.forwarder.PipeEvent(event)
+				forwarded = true
 			}
 
 			h.stats.Update(event)
 
 		Continue:
+			// events which did not go through detection are redacted too
+			if !redacted {
+				h.redact(event)
+			}
+			// kept for debugging, including events skipped or dropped
+			h.recent.Add(event, forwarded)
 			h.RUnlock()
 		}
 		log.Infof("HIDS main loop terminated")
@@ -1056,11 +1737,70 @@ func (h *HIDS) LogStats() {
 	log.Infof("Average Event Rate: %.2f EPS", h.stats.EPS())
 	log.Infof("Alerts Reported: %.0f", h.stats.Detections())
 	log.Infof("Count Rules Used (loaded + generated): %d", h.Engine.Count())
+	ts := h.tracker.Stats()
+	log.Infof("Tracked Processes: %d (running: %d terminated: %d pending free: %d evicted: %d)", ts.Processes, ts.Running, ts.Terminated, ts.PendingFree, ts.Evicted)
+	log.Infof("Tracked Drivers: %d Modules: %d Kernel Files: %d", ts.Drivers, ts.Modules, ts.KernelFiles)
+	log.Infof("Blacklisted Command Lines: %d", ts.Blacklisted)
+	log.Infof("Denylisted Events Dropped: %d", h.denylist.Dropped())
+	ds := h.dumpSetsStats()
+	log.Infof("Dumped Processes: %d Files: %d Dumping: %d (evicted: %d)", ds.Memdumped, ds.Filedumped, ds.Dumping, ds.Evicted)
+}
+
+// DumpSetsStats holds the sizes of the sets remembering what has been dumped
+type DumpSetsStats struct {
+	Memdumped  int    `json:"memdumped"`
+	Filedumped int    `json:"filedumped"`
+	Dumping    int    `json:"dumping"`
+	Evicted    uint64 `json:"evicted"`
+}
+
+func (h *HIDS) dumpSetsStats() DumpSetsStats {
+	return DumpSetsStats{
+		Memdumped:  h.memdumped.Len(),
+		Filedumped: h.filedumped.Len(),
+		Dumping:    h.dumping.Len(),
+		Evicted:    h.memdumped.Evicted() + h.filedumped.Evicted() + h.dumping.Evicted(),
+	}
+}
+
+// heartbeat emits an event carrying the agent statistics
+func (h *HIDS) heartbeat() {
+	data := map[string]interface{}{
+		"Uptime":     h.stats.SinceStart().String(),
+		"Events":     int64(h.stats.Events()),
+		"EPS":        h.stats.EPS(),
+		"Alerts":     int64(h.stats.Detections()),
+		"RulesCount": h.Engine.Count(),
+		"Tracker":    h.tracker.Stats(),
+		"Forwarder":  h.forwarder.BatchStats(),
+		"Dropped":    h.denylist.Dropped(),
+		"DumpSets":   h.dumpSetsStats(),
+	}
+
+	if h.config.RuleProfiling.IsEnabled() {
+		data["SlowestRules"] = h.profiler.Top()
+	}
+
+	if h.config.Attack.IsEnabled() {
+		data["AttackTechniques"] = h.attack.Techniques()
+	}
+
+	h.emitEdrEvent(EdrEventHeartbeat, data)
 }
 
 // Stop stops the IDS
 func (h *HIDS) Stop() {
 	log.Infof("Stopping HIDS")
+	// stopping health endpoint
+	h.stopHealthRoutine()
+	// stopping live traces before forwarder is closed
+	h.stopLiveTraces()
+	// forwarding pending collapsed detections
+	if h.config.Dedup.IsEnabled() {
+		for _, e := range h.dedup.Expired(true) {
+			h.forwarder.PipeEvent(e)
+		}
+	}
 	// cancelling parent context
 	h.cancel()
 	// gently close forwarder needs to be done before
@@ -1068,6 +1808,7 @@ func (h *HIDS) Stop() {
 	// because of race condition
 	log.Infof("Closing forwarder")
 	h.forwarder.Close()
+	h.raw.Close()
 
 	// closing event provider
 	log.Infof("Closing event provider")
@@ -1075,6 +1816,11 @@ func (h *HIDS) Stop() {
 		log.Errorf("Error while closing event provider: %s", err)
 	}
 
+	// closing osqueryd connection
+	if h.osquery != nil {
+		h.osquery.Close()
+	}
+
 	// cleaning canary files
 	if h.config.CanariesConfig.Enable {
 		log.Infof("Cleaning canaries")
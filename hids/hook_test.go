@@ -80,3 +80,20 @@ func TestHook(t *testing.T) {
 		}
 	}
 }
+
+func TestHookPriority(t *testing.T) {
+	order := make([]string, 0)
+	hm := NewHookMan()
+	hm.HookWithPriority(func(h *HIDS, e *event.EdrEvent) { order = append(order, "late") }, fltAnyEvent, HookPriorityLateEnrichment)
+	hm.Hook(func(h *HIDS, e *event.EdrEvent) { order = append(order, "default") }, fltAnyEvent)
+	hm.HookWithPriority(func(h *HIDS, e *event.EdrEvent) { order = append(order, "first") }, fltAnyEvent, HookPriorityTracking)
+	hm.HookWithPriority(func(h *HIDS, e *event.EdrEvent) { order = append(order, "second") }, fltAnyEvent, HookPriorityTracking)
+
+	if !hm.RunHooksOn(nil, &event.EdrEvent{}) {
+		t.Error("no hook applied")
+	}
+
+	if strings.Join(order, ",") != "first,second,late,default" {
+		t.Errorf("bad hook order: %v", order)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math"
-	"net"
 	"os"
 	"path/filepath"
 	"strings"
@@ -130,10 +129,18 @@ func hookTrack(h *HIDS, e *event.EdrEvent) {
 												if cd, ok := e.GetString(pathSysmonCurrentDirectory); ok {
 													if hashes, ok := e.GetString(pathSysmonHashes); ok {
 
+														if !h.trackProcess(image, hashes, pid, pguid) {
+															return
+														}
+
 														track := NewProcessTrack(image, pguid, guid, pid)
 														track.ParentImage = pImage
-														track.CommandLine = commandLine
-														track.ParentCommandLine = pCommandLine
+														track.CommandLine = h.tracker.TruncateCommandLine(commandLine)
+														track.ParentCommandLine = h.tracker.TruncateCommandLine(pCommandLine)
+														if h.config.Tracking != nil && h.config.Tracking.TruncateEvents {
+															e.Set(pathSysmonCommandLine, track.CommandLine)
+															e.Set(pathSysmonParentCommandLine, track.ParentCommandLine)
+														}
 														track.CurrentDirectory = cd
 														track.User = user
 														track.IntegrityLevel = il
@@ -183,9 +190,28 @@ func hookTrack(h *HIDS, e *event.EdrEvent) {
 			}
 		}
 	case SysmonDriverLoad:
-		d := DriverInfoFromEvent(e)
-		h.tracker.Drivers = append(h.tracker.Drivers, *d)
+		h.tracker.AddDriver(DriverInfoFromEvent(e))
+	}
+}
+
+// trackProcess returns true if a newly created process has to be tracked
+func (h *HIDS) trackProcess(image, hashes string, pid int64, pguid string) bool {
+	if !h.config.Tracking.IsEnabled() {
+		return true
+	}
+
+	// agent, its parent and its children are always tracked
+	if (image == selfPath && pid == int64(os.Getpid())) || pid == int64(os.Getppid()) ||
+		(h.guid != "" && pguid == h.guid) {
+		return true
+	}
+
+	signer := ""
+	if i, ok := h.tracker.GetModule(hashes); ok {
+		signer = i.Signature
 	}
+
+	return h.config.Tracking.Track(image, signer)
 }
 
 // hook managing statistics about some events
@@ -202,6 +228,10 @@ func hookStats(h *HIDS, e *event.EdrEvent) {
 					if ip, ok := e.GetString(pathSysmonDestIP); ok {
 						if port, ok := e.GetInt(pathSysmonDestPort); ok {
 							if ts, ok := e.GetString(pathSysmonUtcTime); ok {
+								// IPv4-mapped IPv6 addresses are accounted as IPv4
+								if addr := utils.ParseIP(ip); addr != nil {
+									ip = addr.String()
+								}
 								pt.Stats.UpdateCon(ts, ip, uint16(port))
 							}
 						}
@@ -213,9 +243,9 @@ func hookStats(h *HIDS, e *event.EdrEvent) {
 								if qresults != "" && qresults != "-" {
 									records := strings.Split(qresults, ";")
 									for _, r := range records {
-										// check if it is a valid IP
-										if net.ParseIP(r) != nil {
-											pt.Stats.UpdateNetResolve(ts, r, qvalue)
+										// check if it is a valid IP (IPv4 or IPv6)
+										if addr := utils.ParseIP(r); addr != nil {
+											pt.Stats.UpdateNetResolve(ts, addr.String(), qvalue)
 										}
 									}
 								}
@@ -241,6 +271,12 @@ func hookStats(h *HIDS, e *event.EdrEvent) {
 						e.Set(pathFileCountByExt, toString(pt.Stats.Files.CountFilesCreatedByExt[ext]))
 						// Setting file extension
 						e.Set(pathFileExtension, ext)
+						// Setting file creation anomaly score
+						if h.config.FileAnomaly.IsEnabled() {
+							a := pt.Stats.Files.window.score(now, ext, h.config.FileAnomaly)
+							e.Set(pathFileAnomalyScore, toString(a.Score))
+							e.Set(pathFileAnomalyReason, a.Reason())
+						}
 					}
 					pt.Stats.Files.CountFilesCreated++
 					// Setting total file count
@@ -314,7 +350,7 @@ func hookTerminator(h *HIDS, e *event.EdrEvent) {
 	if e.EventID() == SysmonProcessCreate {
 		if commandLine, ok := e.GetString(pathSysmonCommandLine); ok {
 			if pid, ok := e.GetInt(pathSysmonProcessId); ok {
-				if h.tracker.IsBlacklisted(commandLine) {
+				if h.tracker.IsBlacklisted(commandLine) && !h.isProtected(pid, "terminate") {
 					log.Warnf("Terminating blacklisted  process PID=%d CommandLine=\"%s\"", pid, commandLine)
 					if err := terminate(int(pid)); err != nil {
 						log.Errorf("Failed to terminate process PID=%d: %s", pid, err)
@@ -366,6 +402,16 @@ func hookSelfGUID(h *HIDS, e *event.EdrEvent) {
 				}
 			}
 		}
+
+		// Fallback when the process creation event has been missed (i.e. agent
+		// restarted after Sysmon emitted it). Our PID cannot be reused while we
+		// are running so any Sysmon event carrying it comes from us.
+		if pid, ok := e.GetInt(pathSysmonProcessId); ok && pid == int64(os.Getpid()) {
+			if guid, ok := e.GetString(pathSysmonProcessGUID); ok && guid != "" && guid != nullGUID {
+				h.guid = guid
+				log.Infof("Found self GUID from PID: %s", h.guid)
+			}
+		}
 	}
 }
 
@@ -477,10 +523,9 @@ func hookEnrichServices(h *HIDS, e *event.EdrEvent) {
 				} else {
 					// If it fails we resolve the services by PID
 					if spid, ok := e.GetInt(pathSysmonSourceProcessId); ok {
-						if svcs, err := advapi32.ServiceWin32NamesByPid(uint32(spid)); err == nil {
+						if svcs, err := h.svcResolver.resolve(uint32(spid)); err == nil {
 							e.Set(pathSourceServices, svcs)
 						} else {
-							log.Errorf("Failed to resolve service from PID=%d: %s", spid, err)
 							e.Set(pathSourceServices, errServiceResolution.Error())
 						}
 					}
@@ -494,11 +539,10 @@ func hookEnrichServices(h *HIDS, e *event.EdrEvent) {
 				} else {
 					// If it fails we resolve the services by PID
 					if tpid, ok := e.GetInt(pathSysmonTargetProcessId); ok {
-						if svcs, err := advapi32.ServiceWin32NamesByPid(uint32(tpid)); err == nil {
+						if svcs, err := h.svcResolver.resolve(uint32(tpid)); err == nil {
 							e.Set(pathTargetServices, svcs)
 						} else {
-							log.Errorf("Failed to resolve service from PID=%d: %s", tpid, err)
-							e.Set(pathTargetServices, errServiceResolution)
+							e.Set(pathTargetServices, errServiceResolution.Error())
 						}
 					}
 				}
@@ -510,17 +554,15 @@ func hookEnrichServices(h *HIDS, e *event.EdrEvent) {
 				if pid, ok := e.GetInt(pathSysmonProcessId); ok {
 					if track := h.tracker.GetByGuid(guid); !track.IsZero() {
 						if track.Services == "" {
-							track.Services, err = advapi32.ServiceWin32NamesByPid(uint32(pid))
+							track.Services, err = h.svcResolver.resolve(uint32(pid))
 							if err != nil {
-								log.Errorf("Failed to resolve service from PID=%d: %s", pid, ok)
 								track.Services = errServiceResolution.Error()
 							}
 						}
 						e.Set(pathServices, track.Services)
 					} else {
-						services, err := advapi32.ServiceWin32NamesByPid(uint32(pid))
+						services, err := h.svcResolver.resolve(uint32(pid))
 						if err != nil {
-							log.Errorf("Failed to resolve service from PID=%d: %s", pid, err)
 							services = errServiceResolution.Error()
 						}
 						e.Set(pathServices, services)
@@ -552,6 +594,58 @@ func hookSetValueSize(h *HIDS, e *event.EdrEvent) {
 	}
 }*/
 
+// hookEnrichNetworkConnect enriches network connections with the ancestry of
+// the initiating process. User, integrity level and gene score are set by
+// hookEnrichAnySysmon which must run after this hook.
+func hookEnrichNetworkConnect(h *HIDS, e *event.EdrEvent) {
+	track := EmptyProcessTrack()
+	if guid, ok := e.GetString(pathSysmonProcessGUID); ok {
+		track = h.tracker.GetByGuid(guid)
+	}
+
+	// same semantic as Ancestors field of ProcessCreate events
+	e.Set(pathAncestors, "?")
+	if !track.IsZero() {
+		e.Set(pathAncestors, strings.Join(track.Ancestors, "|"))
+	}
+
+	// we never overwrite fields already present in the event
+	enrich := []struct {
+		path  engine.XPath
+		value string
+	}{
+		{pathSysmonParentImage, track.ParentImage},
+		{pathSysmonParentCommandLine, track.ParentCommandLine},
+		{pathParentUser, track.ParentUser},
+		{pathParentIntegrityLevel, track.ParentIntegrityLevel},
+	}
+
+	for _, f := range enrich {
+		if eventHas(e, f.path) {
+			continue
+		}
+		e.Set(f.path, "?")
+		if f.value != "" {
+			e.Set(f.path, f.value)
+		}
+	}
+
+	// classification of the network connection endpoints
+	for _, f := range []struct {
+		ip  engine.XPath
+		net engine.XPath
+	}{
+		{pathSysmonDestIP, pathDestNetwork},
+		{pathSysmonSourceIP, pathSourceNetwork},
+	} {
+		if ip, ok := e.GetString(f.ip); ok {
+			if class := h.config.Network.Classify(ip); class != "" {
+				e.Set(f.net, class)
+			}
+		}
+	}
+}
+
 func hookEnrichAnySysmon(h *HIDS, e *event.EdrEvent) {
 	eventID := e.EventID()
 	switch eventID {
@@ -673,12 +767,18 @@ func hookEnrichAnySysmon(h *HIDS, e *event.EdrEvent) {
 
 func hookClipboardEvents(h *HIDS, e *event.EdrEvent) {
 	e.Set(pathSysmonClipboardData, "?")
+
+	// content capture disabled, we only keep track of the event
+	if h.config.Sysmon.DisableClipboard {
+		return
+	}
+
 	if hashes, ok := e.GetString(pathSysmonHashes); ok {
 		fname := fmt.Sprintf("CLIP-%s", sysmonArcFileRe.ReplaceAllString(hashes, ""))
 		path := filepath.Join(h.config.Sysmon.ArchiveDirectory, fname)
 		if fi, err := os.Stat(path); err == nil {
-			// limit size of ClipboardData to 1 Mega
-			if fi.Mode().IsRegular() && fi.Size() < utils.Mega {
+			// limit size of ClipboardData
+			if fi.Mode().IsRegular() && fi.Size() < h.config.Sysmon.ClipboardLimit() {
 				if data, err := ioutil.ReadFile(path); err == nil {
 					// We try to decode utf16 content because regexp can only match utf8
 					// Thus doing this is needed to apply detection rule on clipboard content
@@ -2,9 +2,13 @@ package hids
 
 import (
 	"fmt"
+	"path"
 	"reflect"
 	"runtime"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/0xrawsec/whids/event"
 )
@@ -15,25 +19,94 @@ import (
 // hooking functions should never panic the program.
 type Hook func(*HIDS, *event.EdrEvent)
 
+// HookStats holds profiling information about a hook
+type HookStats struct {
+	Name  string
+	Calls int64
+	Time  time.Duration
+}
+
+// HookStage is the stage of event processing a hook runs at
+type HookStage int
+
+const (
+	// HookPreDetection hooks run on every event before it is matched
+	// against the rules, they are meant to enrich events
+	HookPreDetection HookStage = iota
+	// HookPostDetection hooks run on detections only
+	HookPostDetection
+)
+
+// Priorities of the builtin hooks. Hooks run by increasing priority, hooks
+// of equal priority running in registration order.
+const (
+	// HookPriorityTracking hooks track processes and skip agent events
+	HookPriorityTracking = 100
+	// HookPriorityEnrichment hooks enrich events from process tracking
+	HookPriorityEnrichment = 200
+	// HookPriorityLateEnrichment hooks depend on other enrichment hooks
+	HookPriorityLateEnrichment = 300
+	// HookPriorityDefault priority of hooks registered with Hook
+	HookPriorityDefault = 500
+)
+
 // HookManager structure definition to easier handle hooks
 type HookManager struct {
 	sync.RWMutex
-	Filters []*Filter
-	Hooks   []Hook
-	memory  map[string][]int // used to memorize hooks given a couple of (channel, eventid)
+	Filters    []*Filter
+	Hooks      []Hook
+	priorities []int
+	memory     map[string][]int // used to memorize hooks given a couple of (channel, eventid)
+	profile    bool
+	stats      []HookStats
 }
 
 // NewHookMan creates a new HookManager structure
 func NewHookMan() *HookManager {
 	return &HookManager{Filters: make([]*Filter, 0),
-		Hooks:  make([]Hook, 0),
-		memory: make(map[string][]int)}
+		Hooks:      make([]Hook, 0),
+		priorities: make([]int, 0),
+		memory:     make(map[string][]int),
+		stats:      make([]HookStats, 0)}
 }
 
-// Hook register a hook for a given filter
+// Hook register a hook for a given filter with the default priority
 func (hm *HookManager) Hook(h Hook, f *Filter) {
+	hm.HookWithPriority(h, f, HookPriorityDefault)
+}
+
+// HookWithPriority register a hook for a given filter, hooks with a lower
+// priority run first
+func (hm *HookManager) HookWithPriority(h Hook, f *Filter, priority int) {
+	hm.Lock()
+	defer hm.Unlock()
+
 	hm.Hooks = append(hm.Hooks, h)
 	hm.Filters = append(hm.Filters, f)
+	hm.priorities = append(hm.priorities, priority)
+	hm.stats = append(hm.stats, HookStats{Name: path.Base(getFunctionName(h))})
+	// hooks applying to events must be computed again
+	hm.memory = make(map[string][]int)
+}
+
+// EnableProfiling enables time measurement of every hook run
+func (hm *HookManager) EnableProfiling() {
+	hm.profile = true
+}
+
+// Stats returns profiling information about the hooks
+func (hm *HookManager) Stats() (stats []HookStats) {
+	hm.RLock()
+	defer hm.RUnlock()
+	stats = make([]HookStats, 0, len(hm.stats))
+	for i := range hm.stats {
+		stats = append(stats, HookStats{
+			Name:  hm.stats[i].Name,
+			Calls: atomic.LoadInt64(&hm.stats[i].Calls),
+			Time:  time.Duration(atomic.LoadInt64((*int64)(&hm.stats[i].Time))),
+		})
+	}
+	return
 }
 
 func eventIdentifier(e *event.EdrEvent) string {
@@ -60,6 +133,9 @@ func (hm *HookManager) RunHooksOn(h *HIDS, e *event.EdrEvent) (ret bool) {
 				hm.memory[key] = append(hm.memory[key], i)
 			}
 		}
+		sort.SliceStable(hm.memory[key], func(i, j int) bool {
+			return hm.priorities[hm.memory[key][i]] < hm.priorities[hm.memory[key][j]]
+		})
 	}
 	hm.Unlock()
 	hm.RLock()
@@ -68,7 +144,14 @@ func (hm *HookManager) RunHooksOn(h *HIDS, e *event.EdrEvent) (ret bool) {
 		hook := hm.Hooks[hi]
 		// debug hooks
 		//log.Infof("Running hook: %s", getFunctionName(hook))
-		hook(h, e)
+		if hm.profile {
+			start := time.Now()
+			hook(h, e)
+			atomic.AddInt64(&hm.stats[hi].Calls, 1)
+			atomic.AddInt64((*int64)(&hm.stats[hi].Time), int64(time.Since(start)))
+		} else {
+			hook(h, e)
+		}
 		// We set return value to true if a hook has been applied
 		ret = true
 	}
@@ -68,8 +68,41 @@ func srcGUIDFromEvent(e *event.EdrEvent) string {
 	return nullGUID
 }
 
+// channels whose events are generated by the process they relate to, so
+// that the PID of the System section identifies it
+var executionPIDChannels = map[string]bool{
+	"Microsoft-Windows-PowerShell/Operational": true,
+	"Windows PowerShell":                       true,
+}
+
+// processGUID returns the process GUID of an event. The process of events
+// lacking a process GUID is resolved by PID, if configured to do so and
+// if it is tracked.
+func (h *HIDS) processGUID(e *event.EdrEvent) string {
+	if guid := srcGUIDFromEvent(e); guid != nullGUID && guid != "" {
+		return guid
+	}
+
+	if !h.config.Actions.resolveMissingGUID() {
+		return nullGUID
+	}
+
+	pid := srcPIDFromEvent(e)
+	if pid <= 0 && executionPIDChannels[e.Channel()] {
+		pid = e.GetIntOr(pathSystemExecutionProcessID, -1)
+	}
+
+	if pid > 0 {
+		if pt := h.tracker.GetByPID(pid); !pt.IsZero() {
+			return pt.ProcessGUID
+		}
+	}
+
+	return nullGUID
+}
+
 func processTrackFromEvent(h *HIDS, e *event.EdrEvent) *ProcessTrack {
-	if uuid := srcGUIDFromEvent(e); uuid != nullGUID {
+	if uuid := h.processGUID(e); uuid != nullGUID {
 		return h.tracker.GetByGuid(uuid)
 	}
 	return EmptyProcessTrack()
@@ -0,0 +1,137 @@
+package hids
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// IntegrityDetectionName is the name of the signature flagging process
+	// tampering events with a low process integrity
+	IntegrityDetectionName = "ProcessIntegrityTampering"
+	// DefaultIntegrityThreshold is the default process integrity difference
+	// (in %) above which process tampering events are flagged
+	DefaultIntegrityThreshold = 10.0
+)
+
+// IntegrityConfig configures flagging of process tampering events, based on
+// the process integrity computed by the agent, regardless of the rules loaded
+type IntegrityConfig struct {
+	Enable      bool     `toml:"enable" comment:"Flag process tampering events whose ProcessIntegrity is above threshold"`
+	Threshold   *float64 `toml:"threshold" comment:"Percentage of the process image differing from its file on disk (ProcessIntegrity field)\n above which the event is flagged (default: 10, 0: any difference)"`
+	Criticality int      `toml:"criticality" comment:"Criticality of the detection, actions of its criticality tier are taken (default: 8)"`
+	Allowlist   []string `toml:"allowlist" comment:"Image path glob patterns, case insensitive, of processes never flagged\n (ex: legitimate self-modifying processes)"`
+}
+
+// IsEnabled returns true if process integrity flagging is enabled
+func (c *IntegrityConfig) IsEnabled() bool {
+	return c != nil && c.Enable
+}
+
+// Verify validates process integrity configuration
+func (c *IntegrityConfig) Verify() error {
+	if c == nil {
+		return nil
+	}
+
+	if t := c.threshold(); t < 0 || t > 100 {
+		return fmt.Errorf("process integrity threshold must be in [0;100]")
+	}
+
+	if c.Criticality < 0 || c.Criticality > 10 {
+		return fmt.Errorf("process integrity criticality must be in [0;10]")
+	}
+
+	for _, p := range c.Allowlist {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("bad process integrity allowlist pattern %s: %w", p, err)
+		}
+	}
+
+	return nil
+}
+
+// threshold returns the configured threshold, 0 being a valid one
+func (c *IntegrityConfig) threshold() float64 {
+	if c.Threshold == nil {
+		return DefaultIntegrityThreshold
+	}
+	return *c.Threshold
+}
+
+func (c *IntegrityConfig) criticality() int {
+	if c.Criticality <= 0 {
+		return actionHighLow
+	}
+	return c.Criticality
+}
+
+func (c *IntegrityConfig) allowed(image string) bool {
+	for _, p := range c.Allowlist {
+		if ok, _ := filepath.Match(strings.ToLower(p), strings.ToLower(image)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tierActions returns the actions configured for an event criticality
+func (c *ActionsConfig) tierActions(crit int) []string {
+	switch {
+	case crit >= actionCriticalLow:
+		return c.Critical
+	case crit >= actionHighLow:
+		return c.High
+	case crit >= actionMediumLow:
+		return c.Medium
+	case crit >= actionLowLow:
+		return c.Low
+	}
+	return nil
+}
+
+// flagIntegrity turns process tampering events with a process integrity
+// difference above threshold into detections. It returns true if the event
+// has been flagged.
+func (h *HIDS) flagIntegrity(e *event.EdrEvent) bool {
+	c := h.config.Integrity
+
+	if !c.IsEnabled() || e.EventID() != SysmonProcessTampering || e.Channel() != sysmonChannel {
+		return false
+	}
+
+	s, ok := e.GetString(pathProcessIntegrity)
+	if !ok {
+		return false
+	}
+
+	// a negative integrity means it could not be computed, events are
+	// flagged only above threshold so that a 0 threshold flags any difference
+	integrity, err := strconv.ParseFloat(s, 64)
+	if err != nil || integrity < 0 || integrity <= c.threshold() {
+		return false
+	}
+
+	if c.allowed(e.GetStringOr(pathSysmonImage, "")) {
+		return false
+	}
+
+	r := engine.NewCompiledRule(engine.EngineMinimalRuleSchemaVersion)
+	r.Name = IntegrityDetectionName
+	r.Criticality = c.criticality()
+	r.Actions = h.config.Actions.tierActions(r.Criticality)
+
+	det := e.GetDetection()
+	if det == nil {
+		det = engine.NewDetection(false, true)
+	}
+	det.Update(&r)
+	e.Event.Detection = det
+
+	return true
+}
@@ -0,0 +1,53 @@
+package hids
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/0xrawsec/whids/event"
+)
+
+func tamperingEvent(integrity string) *event.EdrEvent {
+	e := event.EdrEvent{}
+	raw := fmt.Sprintf(`{"Event":{"EventData":{"Image":"C:\\Windows\\System32\\cmd.exe","ProcessIntegrity":%q},"System":{"Channel":%q,"EventID":%d,"Computer":"HOST"}}}`,
+		integrity, sysmonChannel, SysmonProcessTampering)
+	if err := json.Unmarshal([]byte(raw), &e); err != nil {
+		panic(err)
+	}
+	return &e
+}
+
+func TestFlagIntegrityThreshold(t *testing.T) {
+	zero, negative := 0.0, -1.0
+
+	if err := (&IntegrityConfig{Threshold: &negative}).Verify(); err == nil {
+		t.Error("Negative threshold must be rejected")
+	}
+
+	for _, tc := range []struct {
+		threshold *float64
+		integrity string
+		flagged   bool
+	}{
+		// default threshold
+		{nil, "10", false},
+		{nil, "10.5", true},
+		// explicit zero threshold flags any difference
+		{&zero, "0", false},
+		{&zero, "0.1", true},
+		// integrity could not be computed
+		{&zero, "-1", false},
+	} {
+		c := &IntegrityConfig{Enable: true, Threshold: tc.threshold}
+		if err := c.Verify(); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		h := &HIDS{config: &Config{Integrity: c, Actions: &ActionsConfig{}}}
+		if h.flagIntegrity(tamperingEvent(tc.integrity)) != tc.flagged {
+			t.Errorf("Unexpected flagging of integrity=%s with threshold=%.1f", tc.integrity, c.threshold())
+		}
+	}
+}
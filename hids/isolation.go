@@ -0,0 +1,242 @@
+package hids
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/0xrawsec/golang-utils/fsutil"
+	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// IsolationRuleName name of the firewall rules created to isolate the endpoint
+	IsolationRuleName = "EDR isolation"
+)
+
+var (
+	errAlreadyIsolated = fmt.Errorf("endpoint is already isolated")
+	errNotIsolated     = fmt.Errorf("endpoint is not isolated")
+)
+
+// IsolationConfig holds network isolation configuration
+type IsolationConfig struct {
+	AllowedAddresses []string `toml:"allowed-addresses" comment:"Remote addresses (IPs, ranges or subnets) still reachable when endpoint is isolated\n NB: manager address is always allowed"`
+	StateFile        string   `toml:"state-file" comment:"File where firewall policy is exported before isolation, it is used to restore it exactly"`
+}
+
+// IsolationStatus is the result of an isolation command
+type IsolationStatus struct {
+	Isolated bool     `json:"isolated"`
+	Allowed  []string `json:"allowed"`
+}
+
+func netsh(args ...string) error {
+	if out, err := exec.Command("netsh.exe", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("netsh %s failed: %w: %s", strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// ipRange is an inclusive range of IP addresses of the same family
+type ipRange struct {
+	start net.IP
+	end   net.IP
+}
+
+func (r ipRange) String() string {
+	if r.start.Equal(r.end) {
+		return r.start.String()
+	}
+	return fmt.Sprintf("%s-%s", r.start, r.end)
+}
+
+// parseIPRange parses an IP, a range (ex: 10.0.0.1-10.0.0.42) or a subnet
+func parseIPRange(s string) (r ipRange, err error) {
+	switch {
+	case strings.Contains(s, "/"):
+		var n *net.IPNet
+		if _, n, err = net.ParseCIDR(s); err != nil {
+			return
+		}
+		r.start = utils.ParseIP(n.IP.String())
+		r.end = make(net.IP, len(r.start))
+		for i := range r.start {
+			r.end[i] = r.start[i] | ^n.Mask[len(n.Mask)-len(r.start)+i]
+		}
+	case strings.Contains(s, "-"):
+		bounds := strings.SplitN(s, "-", 2)
+		r.start, r.end = utils.ParseIP(bounds[0]), utils.ParseIP(bounds[1])
+	default:
+		r.start = utils.ParseIP(s)
+		r.end = r.start
+	}
+
+	switch {
+	case r.start == nil || r.end == nil:
+		err = fmt.Errorf("invalid address: %s", s)
+	case len(r.start) != len(r.end) || bytes.Compare(r.start, r.end) > 0:
+		err = fmt.Errorf("invalid address range: %s", s)
+	}
+
+	return
+}
+
+// blockedRanges returns the ranges of addresses, of both IPv4 and IPv6,
+// not covered by allowed addresses
+func blockedRanges(allowed []string) (blocked []string, err error) {
+	families := map[int][]ipRange{net.IPv4len: nil, net.IPv6len: nil}
+
+	for _, a := range allowed {
+		var r ipRange
+		if r, err = parseIPRange(a); err != nil {
+			return
+		}
+		families[len(r.start)] = append(families[len(r.start)], r)
+	}
+
+	blocked = make([]string, 0)
+	for _, size := range []int{net.IPv4len, net.IPv6len} {
+		ranges := families[size]
+		sort.Slice(ranges, func(i, j int) bool { return bytes.Compare(ranges[i].start, ranges[j].start) < 0 })
+
+		// next is the first address not known to be allowed yet
+		next, max := make(net.IP, size), make(net.IP, size)
+		for i := range max {
+			max[i] = 0xff
+		}
+
+		for _, r := range ranges {
+			if bytes.Compare(r.start, next) > 0 {
+				blocked = append(blocked, ipRange{next, utils.PrevIP(r.start)}.String())
+			}
+			if bytes.Compare(r.end, next) >= 0 {
+				if r.end.Equal(max) {
+					// everything left is allowed
+					next = nil
+					break
+				}
+				next = utils.NextIP(r.end)
+			}
+		}
+
+		if next != nil {
+			blocked = append(blocked, ipRange{next, max}.String())
+		}
+	}
+
+	return
+}
+
+// isolationSteps returns the netsh commands isolating the endpoint. Block
+// rules are needed as firewall rules allowing traffic, already existing on
+// the endpoint, take precedence over the block by default policy.
+func isolationSteps(allowed []string) (steps [][]string, err error) {
+	var blocked []string
+
+	if blocked, err = blockedRanges(allowed); err != nil {
+		return
+	}
+
+	name := fmt.Sprintf("name=%s", IsolationRuleName)
+	allowip := fmt.Sprintf("remoteip=%s", strings.Join(allowed, ","))
+	blockip := fmt.Sprintf("remoteip=%s", strings.Join(blocked, ","))
+
+	steps = [][]string{
+		{"advfirewall", "set", "allprofiles", "state", "on"},
+		{"advfirewall", "firewall", "add", "rule", name, "dir=out", "action=allow", allowip},
+		{"advfirewall", "firewall", "add", "rule", name, "dir=in", "action=allow", allowip},
+		{"advfirewall", "firewall", "add", "rule", name, "dir=out", "action=block", blockip},
+		{"advfirewall", "firewall", "add", "rule", name, "dir=in", "action=block", blockip},
+		{"advfirewall", "set", "allprofiles", "firewallpolicy", "blockinbound,blockoutbound"},
+	}
+
+	return
+}
+
+// isolationAllowed returns the list of remote addresses allowed during isolation
+func (h *HIDS) isolationAllowed() (allowed []string) {
+	allowed = make([]string, 0)
+
+	if h.forwarder != nil && h.forwarder.Client.ManagerIP != nil {
+		allowed = append(allowed, h.forwarder.Client.ManagerIP.String())
+	}
+
+	if h.config.Isolation != nil {
+		allowed = append(allowed, h.config.Isolation.AllowedAddresses...)
+	}
+
+	return
+}
+
+// isIsolated returns true if the endpoint is currently isolated
+func (h *HIDS) isIsolated() bool {
+	return h.config.Isolation != nil && fsutil.IsFile(h.config.Isolation.StateFile)
+}
+
+// isolate blocks any network traffic except to the manager and the
+// allowed addresses. Firewall policy is exported prior to any modification
+// so that it can be restored exactly.
+func (h *HIDS) isolate() (err error) {
+
+	if h.config.Isolation == nil || h.config.Isolation.StateFile == "" {
+		return fmt.Errorf("isolation state file is not configured")
+	}
+
+	if h.isIsolated() {
+		return errAlreadyIsolated
+	}
+
+	allowed := h.isolationAllowed()
+	if len(allowed) == 0 {
+		return fmt.Errorf("no address allowed, endpoint would not be manageable anymore")
+	}
+
+	steps, err := isolationSteps(allowed)
+	if err != nil {
+		return fmt.Errorf("bad isolation allowed addresses: %w", err)
+	}
+
+	state := h.config.Isolation.StateFile
+	if err = netsh("advfirewall", "export", state); err != nil {
+		return fmt.Errorf("failed to save firewall policy: %w", err)
+	}
+
+	for _, args := range steps {
+		if err = netsh(args...); err != nil {
+			// we rollback what has already been done
+			if rerr := h.unisolate(); rerr != nil {
+				log.Errorf("Failed to rollback endpoint isolation: %s", rerr)
+			}
+			return
+		}
+	}
+
+	log.Infof("Endpoint isolated, allowed addresses: %s", strings.Join(allowed, ", "))
+	return
+}
+
+// unisolate restores the firewall policy saved before endpoint isolation
+func (h *HIDS) unisolate() (err error) {
+
+	if !h.isIsolated() {
+		return errNotIsolated
+	}
+
+	state := h.config.Isolation.StateFile
+	if err = netsh("advfirewall", "import", state); err != nil {
+		return fmt.Errorf("failed to restore firewall policy: %w", err)
+	}
+
+	if err = os.Remove(state); err != nil {
+		return fmt.Errorf("failed to remove isolation state file: %w", err)
+	}
+
+	log.Info("Endpoint network connectivity restored")
+	return
+}
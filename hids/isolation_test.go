@@ -0,0 +1,68 @@
+package hids
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBlockedRanges(t *testing.T) {
+	for _, tc := range []struct {
+		allowed []string
+		blocked []string
+	}{
+		{
+			[]string{"10.0.0.1"},
+			[]string{"0.0.0.0-10.0.0.0", "10.0.0.2-255.255.255.255", "::-ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+		},
+		{
+			// overlapping and unordered addresses
+			[]string{"192.168.1.0/24", "10.0.0.1-10.0.0.42", "10.0.0.10", "0.0.0.0"},
+			[]string{"0.0.0.1-10.0.0.0", "10.0.0.43-192.168.0.255", "192.168.2.0-255.255.255.255", "::-ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+		},
+		{
+			[]string{"255.255.255.255", "fe80::/64"},
+			[]string{"0.0.0.0-255.255.255.254", "::-fe7f:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "fe80:0:0:1::-ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+		},
+	} {
+		blocked, err := blockedRanges(tc.allowed)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if !reflect.DeepEqual(blocked, tc.blocked) {
+			t.Errorf("Unexpected blocked ranges for %v: %v", tc.allowed, blocked)
+		}
+	}
+
+	for _, bad := range []string{"not an ip", "10.0.0.42-10.0.0.1", "10.0.0.1-fe80::1", "10.0.0.0/33"} {
+		if _, err := blockedRanges([]string{bad}); err == nil {
+			t.Errorf("Address %q must be rejected", bad)
+		}
+	}
+}
+
+func TestIsolationSteps(t *testing.T) {
+	steps, err := isolationSteps([]string{"10.0.0.1", "192.168.1.0/24"})
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	name := "name=" + IsolationRuleName
+	allowip := "remoteip=10.0.0.1,192.168.1.0/24"
+	blockip := "remoteip=0.0.0.0-10.0.0.0,10.0.0.2-192.168.0.255,192.168.2.0-255.255.255.255,::-ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"
+
+	expected := [][]string{
+		{"advfirewall", "set", "allprofiles", "state", "on"},
+		{"advfirewall", "firewall", "add", "rule", name, "dir=out", "action=allow", allowip},
+		{"advfirewall", "firewall", "add", "rule", name, "dir=in", "action=allow", allowip},
+		// existing allow rules must not let traffic pass
+		{"advfirewall", "firewall", "add", "rule", name, "dir=out", "action=block", blockip},
+		{"advfirewall", "firewall", "add", "rule", name, "dir=in", "action=block", blockip},
+		{"advfirewall", "set", "allprofiles", "firewallpolicy", "blockinbound,blockoutbound"},
+	}
+
+	if !reflect.DeepEqual(steps, expected) {
+		t.Errorf("Unexpected isolation steps: %v", steps)
+	}
+}
@@ -0,0 +1,65 @@
+package hids
+
+import (
+	"fmt"
+
+	"github.com/0xrawsec/whids/utils"
+)
+
+// Values taken by network classification fields
+const (
+	NetworkInternal = "internal"
+	NetworkExternal = "external"
+)
+
+var (
+	// DefaultInternalNetworks private, loopback and link-local networks
+	DefaultInternalNetworks = []string{
+		// RFC1918
+		"10.0.0.0/8",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		// unique local, link-local and loopback IPv6 addresses
+		"fc00::/7",
+		"fe80::/10",
+		"::1/128",
+	}
+)
+
+// NetworkConfig holds the configuration used to classify IP addresses
+type NetworkConfig struct {
+	Internal []string `toml:"internal" comment:"CIDR ranges (IPv4 and IPv6) of internal networks, used to enrich network\n connections with DestinationNetwork and SourceNetwork fields (internal or external)"`
+
+	internal utils.CIDRList
+}
+
+// Compile parses the CIDR ranges of the configuration
+func (c *NetworkConfig) Compile() (err error) {
+	if c == nil {
+		return
+	}
+
+	if c.internal, err = utils.ParseCIDRList(c.Internal); err != nil {
+		return fmt.Errorf("bad internal network: %w", err)
+	}
+
+	return
+}
+
+// Classify returns whether an IP address is internal or external, an empty
+// string is returned if the address cannot be parsed or if no network is
+// configured
+func (c *NetworkConfig) Classify(ip string) string {
+	addr := utils.ParseIP(ip)
+
+	switch {
+	case c == nil || addr == nil:
+		return ""
+	case c.internal.Contains(addr):
+		return NetworkInternal
+	}
+
+	return NetworkExternal
+}
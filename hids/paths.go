@@ -16,9 +16,13 @@ var (
 	pathDNSQueryType    = engine.Path("/Event/EventData/QueryType")
 	pathDNSQueryResults = engine.Path("/Event/EventData/QueryResults")
 
+	// System section
+	pathSystemExecutionProcessID = engine.Path("/Event/System/Execution/ProcessID")
+
 	// FileSystemAudit
-	pathFSAuditProcessId  = pathSysmonProcessId
-	pathFSAuditObjectName = engine.Path("/Event/EventData/ObjectName")
+	pathFSAuditProcessId   = pathSysmonProcessId
+	pathFSAuditObjectName  = engine.Path("/Event/EventData/ObjectName")
+	pathFSAuditProcessName = engine.Path("/Event/EventData/ProcessName")
 
 	// Sysmon related paths
 	// Common to several events
@@ -41,6 +45,7 @@ var (
 
 	// EventID 3: NetworkConnect
 	pathSysmonDestIP       = engine.Path("/Event/EventData/DestinationIp")
+	pathSysmonSourceIP     = engine.Path("/Event/EventData/SourceIp")
 	pathSysmonDestPort     = engine.Path("/Event/EventData/DestinationPort")
 	pathSysmonDestHostname = engine.Path("/Event/EventData/DestinationHostname")
 
@@ -147,4 +152,14 @@ var (
 	pathFileCountByExt = engine.Path("/Event/EventData/CountByExt")
 	pathFileExtension  = engine.Path("/Event/EventData/Extension")
 	pathFileFrequency  = engine.Path("/Event/EventData/FrequencyEps")
+
+	pathFileAnomalyScore  = engine.Path("/Event/EventData/FileAnomalyScore")
+	pathFileAnomalyReason = engine.Path("/Event/EventData/FileAnomalyReason")
+
+	// Used to classify network connections (internal / external)
+	pathDestNetwork   = engine.Path("/Event/EventData/DestinationNetwork")
+	pathSourceNetwork = engine.Path("/Event/EventData/SourceNetwork")
+
+	// Used to attribute actions taken to their origin
+	pathActionsOrigin = engine.Path("/Event/EventData/ActionsOrigin")
 )
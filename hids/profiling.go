@@ -0,0 +1,154 @@
+package hids
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// DefaultProfilingSampleRate is the default number of events out of which
+	// one is profiled
+	DefaultProfilingSampleRate = 100
+	// DefaultProfilingTop is the default number of slowest rules reported
+	DefaultProfilingTop = 10
+)
+
+// RuleProfilingConfig holds rule profiling configuration
+type RuleProfilingConfig struct {
+	Enable     bool `toml:"enable" comment:"Enable rule profiling"`
+	SampleRate int  `toml:"sample-rate" comment:"Profile one event out of sample-rate (default: 100)\n every rule is evaluated a second time on profiled events"`
+	Top        int  `toml:"top" comment:"Number of slowest rules to report (default: 10)"`
+}
+
+// IsEnabled returns true if rule profiling is enabled
+func (c *RuleProfilingConfig) IsEnabled() bool {
+	return c != nil && c.Enable
+}
+
+// Verify validates rule profiling configuration
+func (c *RuleProfilingConfig) Verify() error {
+	if c == nil {
+		return nil
+	}
+
+	if c.SampleRate < 0 {
+		return fmt.Errorf("rule profiling sample rate must be positive")
+	}
+
+	if c.Top < 0 {
+		return fmt.Errorf("rule profiling top must be positive")
+	}
+
+	return nil
+}
+
+func (c *RuleProfilingConfig) sampleRate() uint64 {
+	if c.SampleRate <= 0 {
+		return DefaultProfilingSampleRate
+	}
+	return uint64(c.SampleRate)
+}
+
+func (c *RuleProfilingConfig) top() int {
+	if c.Top <= 0 {
+		return DefaultProfilingTop
+	}
+	return c.Top
+}
+
+// RuleProfile holds evaluation time statistics of a rule
+type RuleProfile struct {
+	Name        string        `json:"name"`
+	Evaluations int64         `json:"evaluations"`
+	Total       time.Duration `json:"total"`
+	Average     time.Duration `json:"average"`
+}
+
+// ruleProfiler measures rule evaluation time, only a sample of the
+// events are profiled to limit the overhead
+type ruleProfiler struct {
+	sync.Mutex
+	enabled bool
+	rate    uint64
+	top     int
+	count   uint64
+	rules   map[string]*RuleProfile
+}
+
+func newRuleProfiler(c *RuleProfilingConfig) *ruleProfiler {
+	p := &ruleProfiler{rules: make(map[string]*RuleProfile)}
+
+	if c.IsEnabled() {
+		p.enabled = true
+		p.rate = c.sampleRate()
+		p.top = c.top()
+	}
+
+	return p
+}
+
+// Profile evaluates every rule of the engine on e, measuring evaluation
+// time, if e is part of the sample
+func (p *ruleProfiler) Profile(eng *engine.Engine, e *event.EdrEvent) {
+	if !p.enabled {
+		return
+	}
+
+	if atomic.AddUint64(&p.count, 1)%p.rate != 0 {
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	for _, name := range eng.GetRuleNames() {
+		r := eng.GetCRuleByName(name)
+		if r == nil {
+			continue
+		}
+
+		start := time.Now()
+		r.Match(e)
+		elapsed := time.Since(start)
+
+		rp, ok := p.rules[name]
+		if !ok {
+			rp = &RuleProfile{Name: name}
+			p.rules[name] = rp
+		}
+		rp.Evaluations++
+		rp.Total += elapsed
+		rp.Average = rp.Total / time.Duration(rp.Evaluations)
+	}
+}
+
+// Top returns the profiles of the slowest rules, sorted by decreasing
+// average evaluation time
+func (p *ruleProfiler) Top() (top []RuleProfile) {
+	p.Lock()
+	defer p.Unlock()
+
+	top = make([]RuleProfile, 0, len(p.rules))
+	for _, rp := range p.rules {
+		top = append(top, *rp)
+	}
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Average == top[j].Average {
+			return top[i].Total > top[j].Total
+		}
+		return top[i].Average > top[j].Average
+	})
+
+	if len(top) > p.top {
+		top = top[:p.top]
+	}
+
+	return
+}
@@ -0,0 +1,195 @@
+package hids
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// ProcessSummary is the view the agent has of a running process
+type ProcessSummary struct {
+	PID               int64           `json:"pid"`
+	ParentPID         int64           `json:"ppid,omitempty"`
+	ProcessGUID       string          `json:"process-guid,omitempty"`
+	ParentProcessGUID string          `json:"parent-process-guid,omitempty"`
+	Image             string          `json:"image"`
+	ParentImage       string          `json:"parent-image,omitempty"`
+	CommandLine       string          `json:"command-line,omitempty"`
+	User              string          `json:"user,omitempty"`
+	IntegrityLevel    string          `json:"integrity-lvl,omitempty"`
+	Services          string          `json:"services,omitempty"`
+	Ancestors         []string        `json:"ancestors"`
+	Signed            bool            `json:"signed"`
+	Signature         string          `json:"signature,omitempty"`
+	Score             int64           `json:"score"`
+	Signatures        map[string]uint `json:"signatures"`
+	Tracked           bool            `json:"tracked"`
+	// Limited is true when the agent lacks some information about the
+	// process, listed in Missing
+	Limited bool     `json:"limited"`
+	Missing []string `json:"missing,omitempty"`
+}
+
+// ProcessList is the output of the ps command
+type ProcessList struct {
+	Processes []ProcessSummary `json:"processes"`
+	Tracked   int              `json:"tracked"`
+	Untracked int              `json:"untracked"`
+	// Limited is the number of tracked processes lacking some information
+	Limited int `json:"limited"`
+	// Error is set if processes could not be listed from the system, in
+	// which case only tracked processes are returned
+	Error string `json:"error,omitempty"`
+}
+
+func summarizeTrack(t *ProcessTrack) (s ProcessSummary) {
+	s = ProcessSummary{
+		PID:               t.PID,
+		ProcessGUID:       t.ProcessGUID,
+		ParentProcessGUID: t.ParentProcessGUID,
+		Image:             t.Image,
+		ParentImage:       t.ParentImage,
+		CommandLine:       t.CommandLine,
+		User:              t.User,
+		IntegrityLevel:    t.IntegrityLevel,
+		Services:          t.Services,
+		Ancestors:         t.Ancestors,
+		Signed:            t.Signed,
+		Score:             t.ThreatScore.Score,
+		Signatures:        t.ThreatScore.Signatures,
+		Tracked:           true,
+	}
+
+	if s.Signature = t.Signature; s.Signature == "?" {
+		s.Signature = ""
+	}
+
+	// processes created before the agent started or whose parent is not
+	// tracked lack part of the information
+	for _, f := range []struct {
+		name  string
+		empty bool
+	}{
+		{"parent-image", t.ParentImage == ""},
+		{"command-line", t.CommandLine == ""},
+		{"user", t.User == ""},
+		{"integrity-lvl", t.IntegrityLevel == ""},
+		{"ancestors", len(t.Ancestors) == 0},
+	} {
+		if f.empty {
+			s.Missing = append(s.Missing, f.name)
+		}
+	}
+	s.Limited = len(s.Missing) > 0
+
+	if s.Ancestors == nil {
+		s.Ancestors = make([]string, 0)
+	}
+	if s.Signatures == nil {
+		s.Signatures = make(map[string]uint)
+	}
+
+	return
+}
+
+// samePIDImage returns true if a system process entry is the one of a tracked
+// process. Process entries only carry the executable name so PID reuse is
+// detected when it differs from the image of the track.
+func samePIDImage(t *ProcessTrack, pe *windows.ProcessEntry32) bool {
+	if t.Image == "" {
+		return true
+	}
+	return strings.EqualFold(filepath.Base(t.Image), windows.UTF16ToString(pe.ExeFile[:]))
+}
+
+// systemProcesses returns the processes running on the system, by PID
+func systemProcesses() (ps map[int64]windows.ProcessEntry32, err error) {
+	var snap windows.Handle
+
+	ps = make(map[int64]windows.ProcessEntry32)
+	if snap, err = windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0); err != nil {
+		return
+	}
+	defer windows.CloseHandle(snap)
+
+	pe := windows.ProcessEntry32{Size: uint32(unsafe.Sizeof(windows.ProcessEntry32{}))}
+	for err = windows.Process32First(snap, &pe); err == nil; err = windows.Process32Next(snap, &pe) {
+		ps[int64(pe.ProcessID)] = pe
+	}
+
+	if err == windows.ERROR_NO_MORE_FILES {
+		err = nil
+	}
+
+	return
+}
+
+// processList returns the running processes as seen by the agent. Running
+// processes not tracked (i.e. filtered out by tracking configuration) are
+// listed with the only information available from the system.
+func (h *HIDS) processList() (l ProcessList) {
+	l.Processes = make([]ProcessSummary, 0)
+
+	sys, err := systemProcesses()
+	if err != nil {
+		l.Error = err.Error()
+	}
+
+	tracked := make(map[int64]bool)
+	for _, t := range h.tracker.Running() {
+		h.redactTrack(&t)
+		s := summarizeTrack(&t)
+		if pe, ok := sys[t.PID]; ok {
+			if samePIDImage(&t, &pe) {
+				s.ParentPID = int64(pe.ParentProcessID)
+				tracked[t.PID] = true
+			} else {
+				// the tracked process terminated without the agent knowing
+				// and its PID has been reused, the system process is
+				// listed on its own
+				s.Limited = true
+				s.Missing = append(s.Missing, "pid-reused")
+			}
+		}
+		l.Processes = append(l.Processes, s)
+		l.Tracked++
+	}
+
+	for pid, pe := range sys {
+		// System Idle Process
+		if pid == 0 || tracked[pid] {
+			continue
+		}
+		l.Processes = append(l.Processes, ProcessSummary{
+			PID:        pid,
+			ParentPID:  int64(pe.ParentProcessID),
+			Image:      windows.UTF16ToString(pe.ExeFile[:]),
+			Ancestors:  make([]string, 0),
+			Signatures: make(map[string]uint),
+			Limited:    true,
+			Missing:    []string{"tracking"},
+		})
+	}
+
+	for _, s := range l.Processes {
+		switch {
+		case !s.Tracked:
+			l.Untracked++
+		case s.Limited:
+			l.Limited++
+		}
+	}
+
+	// highest scores first
+	sort.Slice(l.Processes, func(i, j int) bool {
+		if l.Processes[i].Score != l.Processes[j].Score {
+			return l.Processes[i].Score > l.Processes[j].Score
+		}
+		return l.Processes[i].PID < l.Processes[j].PID
+	})
+
+	return
+}
@@ -1,6 +1,9 @@
 package hids
 
 import (
+	"container/list"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -29,6 +32,8 @@ type FileStats struct {
 	CountFilesDeletedByExt map[string]int64     `json:"file-delete-count-by-ext"`
 	TimeFirstFileDeleted   time.Time            `json:"first-file-delete"`
 	TimeLastFileDeleted    time.Time            `json:"last-file-delete"`
+	// files created within the anomaly scoring window
+	window fileWindow
 }
 
 type ProcStats struct {
@@ -128,6 +133,10 @@ type ProcessTrack struct {
 	/* Private */
 	hashes string
 	empty  bool
+	// element of the list of terminated processes
+	terminated *list.Element
+	// set when the track has to be freed once its last child is
+	parked bool
 
 	/* Public */
 	Image                  string            `json:"image"`
@@ -265,6 +274,10 @@ type DriverInfo struct {
 	Signed          bool              `json:"signed"`
 	Signature       string            `json:"signature"`
 	SignatureStatus string            `json:"signature-status"`
+	// Statistics
+	LoadCount int64     `json:"load-count"`
+	FirstLoad time.Time `json:"first-load"`
+	LastLoad  time.Time `json:"last-load"`
 }
 
 func DriverInfoFromEvent(e *event.EdrEvent) (i *DriverInfo) {
@@ -279,10 +292,27 @@ func DriverInfoFromEvent(e *event.EdrEvent) (i *DriverInfo) {
 	i.Signature = e.GetStringOr(pathSysmonSignature, "?")
 	i.SignatureStatus = e.GetStringOr(pathSysmonSignatureStatus, "?")
 	i.Signed, _ = e.GetBool(pathSysmonSigned)
+	i.LoadCount = 1
+	i.FirstLoad = e.Timestamp()
+	i.LastLoad = e.Timestamp()
 
 	return
 }
 
+// Id returns the identifier used to deduplicate drivers, made of the image
+// and sha256 of the driver (all hashes if sha256 is unknown)
+func (i *DriverInfo) Id() string {
+	if sha256, ok := i.HashesMap["sha256"]; ok {
+		return strings.ToLower(i.Image) + "|" + sha256
+	}
+	return strings.ToLower(i.Image) + "|" + i.hashes
+}
+
+func (i *DriverInfo) UpdateStatistics(other *DriverInfo) {
+	i.LastLoad = other.LastLoad
+	i.LoadCount++
+}
+
 type KernelFile struct {
 	FileName   string
 	FileObject uint64
@@ -310,13 +340,39 @@ type ActivityTracker struct {
 	rpids       map[int64]*ProcessTrack // for running processes
 	tpids       map[int64]*ProcessTrack // for terminated processes
 	blacklisted *datastructs.SyncedSet
-	free        *datastructs.Fifo
+	// blacklist matching is made on normalized command lines
+	normalizeBlacklist bool
+	free               *datastructs.Fifo
+	// terminated processes, oldest terminated first
+	terminated *list.List
+	// number of terminated processes kept until their last child is freed
+	parked int
 	// Kernel-Files
 	files map[uint64]*KernelFile
 	// modules loaded
 	modules map[string]*ModuleInfo
 	// driver loaded
-	Drivers []DriverInfo
+	drivers map[string]*DriverInfo
+	// maximum number of drivers tracked (0 means unlimited)
+	maxDrivers int
+	// maximum number of processes tracked (0 means unlimited)
+	maxTracked int
+	evicted    int
+	// maximum length of command lines stored (0 means unlimited)
+	maxCommandLine int
+}
+
+// TrackerStats holds statistics about the activity tracker
+type TrackerStats struct {
+	Processes   int `json:"processes"`
+	Running     int `json:"running"`
+	Terminated  int `json:"terminated"`
+	PendingFree int `json:"pending-free"`
+	Drivers     int `json:"drivers"`
+	Modules     int `json:"modules"`
+	KernelFiles int `json:"kernel-files"`
+	Blacklisted int `json:"blacklisted"`
+	Evicted     int `json:"evicted"`
 }
 
 func NewActivityTracker() *ActivityTracker {
@@ -327,26 +383,66 @@ func NewActivityTracker() *ActivityTracker {
 		tpids:       make(map[int64]*ProcessTrack),
 		blacklisted: datastructs.NewSyncedSet(),
 		free:        &datastructs.Fifo{},
+		terminated:  list.New(),
 		files:       make(map[uint64]*KernelFile),
 		modules:     make(map[string]*ModuleInfo),
-		Drivers:     make([]DriverInfo, 0),
+		drivers:     make(map[string]*DriverInfo),
 	}
 	// startup the routine to free resources
 	pt.freeRtn()
 	return pt
 }
 
-func (pt *ActivityTracker) delete(t *ProcessTrack) {
-	pt.Lock()
-	defer pt.Unlock()
-
-	if t := pt.guids[t.ParentProcessGUID]; t != nil {
-		t.ChildCount--
+func (pt *ActivityTracker) unsafeDelete(t *ProcessTrack) {
+	// track might have already been evicted
+	if pt.guids[t.ProcessGUID] != t {
+		return
 	}
 
 	delete(pt.guids, t.ProcessGUID)
 	// delete from terminated processes
 	delete(pt.tpids, t.PID)
+	if t.terminated != nil {
+		pt.terminated.Remove(t.terminated)
+		t.terminated = nil
+	}
+	if t.parked {
+		t.parked = false
+		pt.parked--
+	}
+
+	if p := pt.guids[t.ParentProcessGUID]; p != nil {
+		p.ChildCount--
+		// parent was only kept for its last child
+		if p.parked && p.ChildCount <= 0 {
+			pt.unsafeDelete(p)
+		}
+	}
+}
+
+// release frees a terminated process, processes having children being
+// parked until their last child is freed
+func (pt *ActivityTracker) release(t *ProcessTrack) {
+	pt.Lock()
+	defer pt.Unlock()
+
+	// track might have already been evicted
+	if pt.guids[t.ProcessGUID] != t {
+		return
+	}
+
+	// we don't free the process structure if it still has a child
+	// this is mostly to keep track of parent processes when generating
+	// a report
+	if t.ChildCount > 0 {
+		if !t.parked {
+			t.parked = true
+			pt.parked++
+		}
+		return
+	}
+
+	pt.unsafeDelete(t)
 }
 
 func (pt *ActivityTracker) freeRtn() {
@@ -363,19 +459,7 @@ func (pt *ActivityTracker) freeRtn() {
 					delta := timeToDel.Sub(now)
 					time.Sleep(delta)
 				}
-				// we don't free the process structure if it still has a child
-				// this is mostly to keep track of parent processes when generating
-				// a report
-				if t.ChildCount > 0 {
-					pt.free.Push(t)
-					// we need to sleep there because we
-					// can end up reprocessing the same
-					// track over and over
-					time.Sleep(1 * time.Second)
-					continue
-				}
-				// delete ProcessTrack from ProcessTracker
-				pt.delete(t)
+				pt.release(t)
 			}
 			// we have to wait here not to go in an
 			// empty endless loop (if nothing in free list)
@@ -410,6 +494,69 @@ func (pt *ActivityTracker) Add(t *ProcessTrack) {
 	}
 	pt.guids[t.ProcessGUID] = t
 	pt.rpids[t.PID] = t
+
+	if pt.maxTracked > 0 && len(pt.guids) > pt.maxTracked {
+		pt.evict(len(pt.guids) - pt.maxTracked)
+	}
+}
+
+// SetMaxTracked sets the maximum number of processes to track, when
+// reached the oldest terminated processes are evicted. A value of 0
+// means no limit.
+func (pt *ActivityTracker) SetMaxTracked(max int) {
+	pt.Lock()
+	defer pt.Unlock()
+	pt.maxTracked = max
+}
+
+// SetMaxDrivers sets the maximum number of drivers to track, when reached
+// the drivers not seen loading for the longest time are evicted. A value
+// of 0 means no limit.
+func (pt *ActivityTracker) SetMaxDrivers(max int) {
+	pt.Lock()
+	defer pt.Unlock()
+	pt.maxDrivers = max
+}
+
+// SetMaxCommandLine sets the maximum length of the command lines stored by
+// the tracker, longer ones being truncated. A value of 0 means no limit. It
+// must be called before any command line is blacklisted.
+func (pt *ActivityTracker) SetMaxCommandLine(max int) {
+	pt.maxCommandLine = max
+}
+
+// TruncateCommandLine truncates a command line to the maximum length of
+// command lines stored, truncated command lines end with TruncationMarker
+func (pt *ActivityTracker) TruncateCommandLine(cmdLine string) string {
+	if pt.maxCommandLine > 0 && len(cmdLine) > pt.maxCommandLine {
+		return truncateString(cmdLine, pt.maxCommandLine) + TruncationMarker
+	}
+	return cmdLine
+}
+
+// evict removes n terminated processes from the tracker, oldest terminated
+// first. It must be called with the lock held.
+func (pt *ActivityTracker) evict(n int) {
+	for ; n > 0 && pt.terminated.Len() > 0; n-- {
+		pt.unsafeDelete(pt.terminated.Front().Value.(*ProcessTrack))
+		pt.evicted++
+	}
+}
+
+// Stats returns statistics about the tracker
+func (pt *ActivityTracker) Stats() (s TrackerStats) {
+	pt.RLock()
+	defer pt.RUnlock()
+	s.Processes = len(pt.guids)
+	s.Running = len(pt.rpids)
+	s.Terminated = len(pt.tpids)
+	s.PendingFree = pt.free.Len() + pt.parked
+	s.Drivers = len(pt.drivers)
+	s.Modules = len(pt.modules)
+	s.KernelFiles = len(pt.files)
+	s.Blacklisted = pt.blacklisted.Len()
+	s.Evicted = pt.evicted
+	return
 }
 
 func (pt *ActivityTracker) PS() map[string]ProcessTrack {
@@ -423,12 +570,77 @@ func (pt *ActivityTracker) PS() map[string]ProcessTrack {
 	return ps
 }
 
+// Running returns a copy of the tracks of the running processes. Ancestors,
+// modules and signatures are copied so that they can be read once the
+// tracker is unlocked, statistics are not.
+func (pt *ActivityTracker) Running() []ProcessTrack {
+	pt.RLock()
+	defer pt.RUnlock()
+	ps := make([]ProcessTrack, 0, len(pt.rpids))
+	for _, t := range pt.rpids {
+		c := *t
+		c.Ancestors = append([]string(nil), t.Ancestors...)
+		c.Modules = append([]*ModuleInfo(nil), t.Modules...)
+		c.ThreatScore.Signatures = make(map[string]uint, len(t.ThreatScore.Signatures))
+		for sig, n := range t.ThreatScore.Signatures {
+			c.ThreatScore.Signatures[sig] = n
+		}
+		ps = append(ps, c)
+	}
+	return ps
+}
+
+// normalizeCommandLine lower cases a command line and collapses whitespaces
+func normalizeCommandLine(cmdLine string) string {
+	return strings.ToLower(strings.Join(strings.Fields(cmdLine), " "))
+}
+
+// SetBlacklistNormalization enables or disables matching blacklisted command
+// lines after normalization (case and whitespaces insensitive). It must be
+// called before any command line is blacklisted.
+func (pt *ActivityTracker) SetBlacklistNormalization(enable bool) {
+	pt.normalizeBlacklist = enable
+}
+
+func (pt *ActivityTracker) blacklistKey(cmdLine string) string {
+	// blacklisted command lines come from the tracker so they might
+	// have been truncated
+	cmdLine = pt.TruncateCommandLine(cmdLine)
+	if pt.normalizeBlacklist {
+		return normalizeCommandLine(cmdLine)
+	}
+	return cmdLine
+}
+
+// Blacklist blacklists a command line, matching is made on exact command
+// line unless blacklist normalization is enabled
 func (pt *ActivityTracker) Blacklist(cmdLine string) {
-	pt.blacklisted.Add(cmdLine)
+	pt.blacklisted.Add(pt.blacklistKey(cmdLine))
+}
+
+// Unblacklist removes a command line from the blacklist, it returns
+// false if the command line was not blacklisted
+func (pt *ActivityTracker) Unblacklist(cmdLine string) bool {
+	key := pt.blacklistKey(cmdLine)
+	if !pt.blacklisted.Contains(key) {
+		return false
+	}
+	pt.blacklisted.Del(key)
+	return true
+}
+
+// Blacklisted returns the sorted list of blacklisted command lines
+func (pt *ActivityTracker) Blacklisted() (s []string) {
+	s = make([]string, 0, pt.blacklisted.Len())
+	for _, i := range pt.blacklisted.Slice() {
+		s = append(s, i.(string))
+	}
+	sort.Strings(s)
+	return
 }
 
 func (pt *ActivityTracker) IsBlacklisted(cmdLine string) bool {
-	return pt.blacklisted.Contains(cmdLine)
+	return pt.blacklisted.Contains(pt.blacklistKey(cmdLine))
 }
 
 func (pt *ActivityTracker) GetParentByGuid(guid string) *ProcessTrack {
@@ -468,6 +680,20 @@ func (pt *ActivityTracker) GetByPID(pid int64) *ProcessTrack {
 	return EmptyProcessTrack()
 }
 
+// GetRunningByImage returns the running processes which image matches
+// image, either its full path or its base name (case insensitive)
+func (pt *ActivityTracker) GetRunningByImage(image string) (s []*ProcessTrack) {
+	pt.RLock()
+	defer pt.RUnlock()
+	s = make([]*ProcessTrack, 0)
+	for _, t := range pt.rpids {
+		if strings.EqualFold(t.Image, image) || strings.EqualFold(filepath.Base(t.Image), image) {
+			s = append(s, t)
+		}
+	}
+	return
+}
+
 func (pt *ActivityTracker) ContainsGuid(guid string) bool {
 	pt.RLock()
 	defer pt.RUnlock()
@@ -490,6 +716,47 @@ func (pt *ActivityTracker) Modules() (s []ModuleInfo) {
 	return
 }
 
+// AddDriver tracks a loaded driver, a driver already known only has its
+// statistics updated
+func (pt *ActivityTracker) AddDriver(i *DriverInfo) {
+	pt.Lock()
+	defer pt.Unlock()
+
+	if old, ok := pt.drivers[i.Id()]; ok {
+		old.UpdateStatistics(i)
+		return
+	}
+
+	if pt.maxDrivers > 0 && len(pt.drivers) >= pt.maxDrivers {
+		var oldest *DriverInfo
+		for _, d := range pt.drivers {
+			if oldest == nil || d.LastLoad.Before(oldest.LastLoad) {
+				oldest = d
+			}
+		}
+		delete(pt.drivers, oldest.Id())
+	}
+
+	pt.drivers[i.Id()] = i
+}
+
+// Drivers returns the drivers tracked, sorted by first load time
+func (pt *ActivityTracker) Drivers() (s []DriverInfo) {
+	pt.RLock()
+	defer pt.RUnlock()
+
+	s = make([]DriverInfo, 0, len(pt.drivers))
+	for _, d := range pt.drivers {
+		s = append(s, *d)
+	}
+
+	sort.Slice(s, func(i, j int) bool {
+		return s[i].FirstLoad.Before(s[j].FirstLoad)
+	})
+
+	return
+}
+
 func (pt *ActivityTracker) AddKernelFile(f *KernelFile) {
 	pt.Lock()
 	defer pt.Unlock()
@@ -509,6 +776,14 @@ func (pt *ActivityTracker) DelKernelFile(key uint64) {
 	delete(pt.files, key)
 }
 
+// GetModule retrieves an already existing ModuleInfo from its hashes
+func (pt *ActivityTracker) GetModule(hashes string) (i *ModuleInfo, ok bool) {
+	pt.RLock()
+	defer pt.RUnlock()
+	i, ok = pt.modules[hashes]
+	return
+}
+
 // GetModuleOrUpdate retrieves an already existing ModuleInfo or updates
 // the map of known ModuleInfo and returns the ModuleInfo updated
 func (pt *ActivityTracker) GetModuleOrUpdate(i *ModuleInfo) *ModuleInfo {
@@ -530,14 +805,20 @@ func (pt *ActivityTracker) IsTerminated(guid string) bool {
 }
 
 func (pt *ActivityTracker) Terminate(guid string) error {
-	if t := pt.GetByGuid(guid); !t.IsZero() {
+	pt.Lock()
+	defer pt.Unlock()
+
+	if t := pt.guids[guid]; t != nil && !t.Terminated {
 		t.Terminated = true
 		t.TimeTerminated = time.Now()
 		// PID entry must be cleared as soon as possible
-		// to avoid issues like deleting a re-used PID in delete method
-		delete(pt.rpids, t.PID)
+		// to avoid issues like deleting a re-used PID in delete method
+		if pt.rpids[t.PID] == t {
+			delete(pt.rpids, t.PID)
+		}
 		// we put it in the map of terminated processes
 		pt.tpids[t.PID] = t
+		t.terminated = pt.terminated.PushBack(t)
 		pt.free.Push(t)
 	}
 	return nil
@@ -0,0 +1,118 @@
+package hids
+
+import (
+	"testing"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/golang-utils/datastructs"
+	"golang.org/x/sys/windows"
+)
+
+func TestTrackerEvict(t *testing.T) {
+	pt := NewActivityTracker()
+	pt.SetMaxTracked(3)
+
+	for i, guid := range []string{"A", "B", "C"} {
+		pt.Add(NewProcessTrack("image.exe", "", guid, int64(i)))
+	}
+
+	pt.Terminate("B")
+	pt.Terminate("A")
+	// B is the oldest terminated process
+	pt.Add(NewProcessTrack("image.exe", "", "D", 3))
+
+	if !pt.GetByGuid("B").IsZero() {
+		t.Error("Oldest terminated process must be evicted")
+	}
+
+	for _, guid := range []string{"A", "C", "D"} {
+		if pt.GetByGuid(guid).IsZero() {
+			t.Errorf("Process %s must still be tracked", guid)
+		}
+	}
+
+	if s := pt.Stats(); s.Evicted != 1 || s.Processes != 3 {
+		t.Errorf("Unexpected tracker stats: %+v", s)
+	}
+}
+
+func TestTrackerParkedParent(t *testing.T) {
+	pt := NewActivityTracker()
+
+	pt.Add(NewProcessTrack("parent.exe", "", "P", 1))
+	pt.Add(NewProcessTrack("child.exe", "P", "C", 2))
+
+	pt.Terminate("P")
+	pt.release(pt.GetByGuid("P"))
+
+	// parent is kept as long as its child is
+	if pt.GetByGuid("P").IsZero() {
+		t.Error("Parent of a tracked process must be kept")
+	}
+
+	if pt.parked != 1 {
+		t.Errorf("Unexpected number of parked processes: %d", pt.parked)
+	}
+
+	pt.Terminate("C")
+	pt.release(pt.GetByGuid("C"))
+
+	// parent is freed with its last child
+	for _, guid := range []string{"P", "C"} {
+		if !pt.GetByGuid(guid).IsZero() {
+			t.Errorf("Process %s must have been freed", guid)
+		}
+	}
+
+	if s := pt.Stats(); s.Processes != 0 || s.Terminated != 0 {
+		t.Errorf("Unexpected tracker stats: %+v", s)
+	}
+}
+
+func TestTrackerRunningCopy(t *testing.T) {
+	pt := NewActivityTracker()
+
+	pt.Add(NewProcessTrack("image.exe", "", "A", 1))
+	track := pt.GetByGuid("A")
+	track.Ancestors = []string{"parent.exe"}
+	track.ThreatScore.Update(&engine.Detection{Signature: datastructs.NewInitSet("sig"), Criticality: 5})
+
+	running := pt.Running()
+	if len(running) != 1 {
+		t.Errorf("Unexpected number of running processes: %d", len(running))
+		t.FailNow()
+	}
+
+	// tracker updating the track must not modify the copy
+	track.Ancestors[0] = "other.exe"
+	track.ThreatScore.Signatures["sig"]++
+	track.ThreatScore.Signatures["other"] = 1
+
+	c := running[0]
+	if c.Ancestors[0] != "parent.exe" {
+		t.Error("Ancestors must be copied")
+	}
+	if len(c.ThreatScore.Signatures) != 1 || c.ThreatScore.Signatures["sig"] != 1 {
+		t.Errorf("Signatures must be copied: %v", c.ThreatScore.Signatures)
+	}
+}
+
+func TestSamePIDImage(t *testing.T) {
+	pe := windows.ProcessEntry32{}
+	copy(pe.ExeFile[:], windows.StringToUTF16("cmd.exe"))
+
+	for _, tc := range []struct {
+		image string
+		same  bool
+	}{
+		{`C:\Windows\System32\cmd.exe`, true},
+		{`C:\Windows\System32\CMD.EXE`, true},
+		// not enough information to tell
+		{"", true},
+		{`C:\Windows\System32\notepad.exe`, false},
+	} {
+		if samePIDImage(NewProcessTrack(tc.image, "", "A", 1), &pe) != tc.same {
+			t.Errorf("Unexpected result for image %s", tc.image)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package hids
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/0xrawsec/golang-etw/etw"
+	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// RawDestinationForwarder forwards raw events along with other events
+	RawDestinationForwarder = "forwarder"
+	// RawDestinationFile writes raw events to a local file
+	RawDestinationFile = "file"
+
+	// DefaultRawMaxFileSize is the default size above which the raw events
+	// file is rotated
+	DefaultRawMaxFileSize = 100 * utils.Mega
+)
+
+// RawForwardingConfig configures forwarding of a copy of the events of some
+// channels as they are received, before any enrichment
+type RawForwardingConfig struct {
+	Channels    []string `toml:"channels" comment:"Channels whose events are also forwarded as received, before any enrichment\n Detection still runs on enriched events"`
+	Destination string   `toml:"destination" comment:"Where raw events go: forwarder or file\n forwarder: forwarded along with other events, carrying a Raw field set to true\n file: written to file, one JSON event per line"`
+	File        string   `toml:"file" comment:"File raw events are written to when destination is file"`
+	MaxFileSize int64    `toml:"max-file-size" comment:"Size (in bytes) above which the raw events file is rotated,\n the previous file being kept with a .1 extension (default: 100MB)"`
+}
+
+// IsEnabled returns true if raw forwarding is enabled
+func (c *RawForwardingConfig) IsEnabled() bool {
+	return c != nil && len(c.Channels) > 0
+}
+
+// Verify validates raw forwarding configuration
+func (c *RawForwardingConfig) Verify() error {
+	if !c.IsEnabled() {
+		return nil
+	}
+
+	switch c.Destination {
+	case "", RawDestinationForwarder:
+	case RawDestinationFile:
+		if c.File == "" {
+			return fmt.Errorf("raw forwarding file must be set")
+		}
+	default:
+		return fmt.Errorf("unknown raw forwarding destination: %s", c.Destination)
+	}
+
+	if c.MaxFileSize < 0 {
+		return fmt.Errorf("raw forwarding maximum file size must be positive")
+	}
+
+	return nil
+}
+
+func (c *RawForwardingConfig) maxFileSize() int64 {
+	if c.MaxFileSize <= 0 {
+		return DefaultRawMaxFileSize
+	}
+	return c.MaxFileSize
+}
+
+// rawForwarder sends copies of events taken before enrichment
+type rawForwarder struct {
+	sync.Mutex
+	config   *RawForwardingConfig
+	channels map[string]bool
+	fd       *os.File
+	size     int64
+}
+
+func newRawForwarder(c *RawForwardingConfig) *rawForwarder {
+	f := &rawForwarder{config: c, channels: make(map[string]bool)}
+
+	if c.IsEnabled() {
+		for _, channel := range c.Channels {
+			f.channels[channel] = true
+		}
+	}
+
+	return f
+}
+
+// Match returns true if a raw copy of event must be forwarded
+func (f *rawForwarder) Match(e *event.EdrEvent) bool {
+	return f.channels[e.Channel()]
+}
+
+// copyEvent returns a deep copy of the ETW event of e
+func copyEvent(e *event.EdrEvent) (c *event.EdrEvent, err error) {
+	etwEvent := etw.Event{}
+
+	if err = json.Unmarshal(utils.Json(e.Event.Event), &etwEvent); err != nil {
+		return
+	}
+
+	return event.NewEdrEvent(&etwEvent), nil
+}
+
+// rawCopy returns a deep copy of e, flagged as raw
+func rawCopy(e *event.EdrEvent) (raw *event.EdrEvent, err error) {
+	if raw, err = copyEvent(e); err != nil {
+		return
+	}
+
+	raw.Event.Raw = true
+
+	return
+}
+
+func (f *rawForwarder) open() (err error) {
+	var fi os.FileInfo
+
+	if f.fd != nil {
+		return
+	}
+
+	if f.fd, err = os.OpenFile(f.config.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, utils.DefaultPerms); err != nil {
+		return
+	}
+
+	if fi, err = f.fd.Stat(); err != nil {
+		return
+	}
+	f.size = fi.Size()
+
+	return
+}
+
+func (f *rawForwarder) rotate() (err error) {
+	f.fd.Close()
+	f.fd = nil
+
+	if err = os.Rename(f.config.File, f.config.File+".1"); err != nil {
+		return
+	}
+
+	return f.open()
+}
+
+// write writes a raw event to the raw events file
+func (f *rawForwarder) write(e *event.EdrEvent) (err error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if err = f.open(); err != nil {
+		return
+	}
+
+	if f.size > f.config.maxFileSize() {
+		if err = f.rotate(); err != nil {
+			return
+		}
+	}
+
+	b := append(utils.Json(e), '\n')
+	n, err := f.fd.Write(b)
+	f.size += int64(n)
+
+	return
+}
+
+// Close closes the raw events file
+func (f *rawForwarder) Close() {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.fd != nil {
+		f.fd.Close()
+		f.fd = nil
+	}
+}
+
+// takeRaw returns a copy of e if e belongs to a raw channel, nil otherwise.
+// It must be called before enrichment hooks run.
+func (h *HIDS) takeRaw(e *event.EdrEvent) *event.EdrEvent {
+	if !h.raw.Match(e) {
+		return nil
+	}
+
+	raw, err := rawCopy(e)
+	if err != nil {
+		log.Errorf("Failed to copy raw event: %s", err)
+		return nil
+	}
+
+	return raw
+}
+
+// forwardRaw forwards a raw copy taken by takeRaw, nothing is done if raw is
+// nil. Redaction applies to raw copies as well.
+func (h *HIDS) forwardRaw(raw *event.EdrEvent) {
+	if raw == nil {
+		return
+	}
+
+	h.redact(raw)
+
+	switch h.config.RawForwarding.Destination {
+	case RawDestinationFile:
+		if err := h.raw.write(raw); err != nil {
+			log.Errorf("Failed to write raw event: %s", err)
+		}
+	default:
+		h.forwarder.PipeEvent(raw)
+	}
+}
@@ -0,0 +1,79 @@
+package hids
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/whids/event"
+)
+
+func channelEvent(channel string) *event.EdrEvent {
+	e := event.EdrEvent{}
+	raw := `{"Event":{"EventData":{"Image":"C:\\Windows\\System32\\cmd.exe"},"System":{"Channel":"` + channel + `","EventID":1,"Computer":"HOST"}}}`
+	if err := json.Unmarshal([]byte(raw), &e); err != nil {
+		panic(err)
+	}
+	return &e
+}
+
+func TestForwardRaw(t *testing.T) {
+	sysmon := "Microsoft-Windows-Sysmon/Operational"
+	file := filepath.Join(t.TempDir(), "raw.json")
+	enriched := engine.Path("/Event/EventData/Enriched")
+
+	c := &RawForwardingConfig{
+		Channels:    []string{sysmon},
+		Destination: RawDestinationFile,
+		File:        file,
+	}
+	h := &HIDS{config: &Config{RawForwarding: c}, raw: newRawForwarder(c)}
+	defer h.raw.Close()
+
+	if raw := h.takeRaw(channelEvent("Security")); raw != nil {
+		t.Error("Events of other channels must not be copied")
+	}
+	// nothing to forward
+	h.forwardRaw(nil)
+
+	e := channelEvent(sysmon)
+	raw := h.takeRaw(e)
+	if raw == nil {
+		t.Error("Raw copy expected")
+		t.FailNow()
+	}
+
+	// enrichment happening after the copy must not alter it
+	e.Set(enriched, "yes")
+	h.forwardRaw(raw)
+	h.raw.Close()
+
+	fd, err := os.Open(file)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	defer fd.Close()
+
+	lines := 0
+	for s := bufio.NewScanner(fd); s.Scan(); lines++ {
+		written := event.EdrEvent{}
+		if err := json.Unmarshal(s.Bytes(), &written); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if !written.Event.Raw {
+			t.Error("Raw copy must be flagged as raw")
+		}
+		if _, ok := written.GetString(enriched); ok {
+			t.Error("Raw copy must not be enriched")
+		}
+	}
+
+	if lines != 1 {
+		t.Errorf("Unexpected number of raw events written: %d", lines)
+	}
+}
@@ -0,0 +1,153 @@
+package hids
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/utils"
+)
+
+const (
+	// RecentEventsPath returns (GET) or dumps (POST) the recent events
+	// processed by the agent
+	RecentEventsPath = "/recent-events"
+
+	recentEventsPrefix = "recent-events-"
+)
+
+// RecentEventsConfig configures the in memory buffer of the last events
+// processed by the agent, used for debugging
+type RecentEventsConfig struct {
+	Size int    `toml:"size" comment:"Number of events processed most recently kept in memory (0: disabled)\n The buffer is dumped with the dump-recent-events manager command or with\n a POST request to the /recent-events route of the health endpoint"`
+	Dir  string `toml:"dir" comment:"Directory recent events are dumped to (default: directory of logfile)"`
+}
+
+// IsEnabled returns true if recent events are kept
+func (c *RecentEventsConfig) IsEnabled() bool {
+	return c != nil && c.Size > 0
+}
+
+// RecentEvent is an event kept in the recent events buffer
+type RecentEvent struct {
+	Processed time.Time       `json:"processed"`
+	Forwarded bool            `json:"forwarded"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// recentEvents is a ring buffer holding the last events processed. Events
+// are serialized when added as they are modified after being processed.
+type recentEvents struct {
+	sync.Mutex
+	events []RecentEvent
+	next   int
+	full   bool
+}
+
+func newRecentEvents(c *RecentEventsConfig) *recentEvents {
+	size := 0
+	if c.IsEnabled() {
+		size = c.Size
+	}
+	return &recentEvents{events: make([]RecentEvent, size)}
+}
+
+// Add adds an event to the buffer, overwriting the oldest one when full
+func (r *recentEvents) Add(e *event.EdrEvent, forwarded bool) {
+	if r == nil || len(r.events) == 0 {
+		return
+	}
+
+	re := RecentEvent{
+		Processed: time.Now().UTC(),
+		Forwarded: forwarded,
+		Event:     utils.Json(e),
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.events[r.next] = re
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Events returns the events of the buffer, the oldest first
+func (r *recentEvents) Events() (events []RecentEvent) {
+	r.Lock()
+	defer r.Unlock()
+
+	events = make([]RecentEvent, 0, len(r.events))
+	if r.full {
+		events = append(events, r.events[r.next:]...)
+	}
+	events = append(events, r.events[:r.next]...)
+
+	return
+}
+
+func (h *HIDS) recentEventsDir() string {
+	if dir := h.config.RecentEvents.Dir; dir != "" {
+		return dir
+	}
+	return filepath.Dir(h.config.Logfile)
+}
+
+// dumpRecentEvents writes recent events, one JSON event per line, to a new
+// file of the recent events directory and returns its path
+func (h *HIDS) dumpRecentEvents() (path string, err error) {
+	if !h.config.RecentEvents.IsEnabled() {
+		return "", fmt.Errorf("recent events are not enabled")
+	}
+
+	dir := h.recentEventsDir()
+	if err = utils.HidsMkdirAll(dir); err != nil {
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	for _, re := range h.recent.Events() {
+		buf.Write(utils.Json(re))
+		buf.WriteByte('\n')
+	}
+
+	path = filepath.Join(dir, fmt.Sprintf("%s%d.json", recentEventsPrefix, time.Now().UnixNano()))
+	if err = utils.HidsWriteData(path, buf.Bytes()); err != nil {
+		return
+	}
+
+	log.Infof("Recent events dumped to %s", path)
+	return
+}
+
+func (h *HIDS) recentEventsHandler(wt http.ResponseWriter, rq *http.Request) {
+	if !h.config.RecentEvents.IsEnabled() {
+		http.Error(wt, "Recent events not enabled", http.StatusNotFound)
+		return
+	}
+
+	wt.Header().Set("Content-Type", "application/json")
+
+	switch rq.Method {
+	case http.MethodGet:
+		wt.Write(utils.Json(h.recent.Events()))
+	case http.MethodPost:
+		path, err := h.dumpRecentEvents()
+		if err != nil {
+			log.Errorf("Failed to dump recent events: %s", err)
+			http.Error(wt, "Failed to dump recent events", http.StatusInternalServerError)
+			return
+		}
+		wt.Write(utils.Json(map[string]string{"path": path}))
+	default:
+		http.Error(wt, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
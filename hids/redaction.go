@@ -0,0 +1,151 @@
+package hids
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/whids/event"
+)
+
+const (
+	// DefaultRedactionMask default string replacing redacted data
+	DefaultRedactionMask = "***"
+)
+
+// RedactionRule defines what to redact in event fields
+type RedactionRule struct {
+	Fields     []string `toml:"fields" comment:"Event fields (XPath) the rule applies to (ex: /Event/EventData/CommandLine)"`
+	Pattern    string   `toml:"pattern" comment:"Regular expression matching data to redact, if empty the whole field is redacted"`
+	WholeField bool     `toml:"whole-field" comment:"Redact the whole field when pattern matches instead of the matching substrings only"`
+
+	paths []engine.XPath
+	re    *regexp.Regexp
+}
+
+func (r *RedactionRule) compile() (err error) {
+	r.paths = make([]engine.XPath, 0, len(r.Fields))
+	for _, f := range r.Fields {
+		r.paths = append(r.paths, engine.Path(f))
+	}
+
+	if r.Pattern != "" {
+		if r.re, err = regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("bad redaction pattern %s: %w", r.Pattern, err)
+		}
+	}
+
+	return
+}
+
+// redact redacts a value and returns the number of redactions made
+func (r *RedactionRule) redact(value, mask string) (string, int) {
+	if r.re == nil {
+		return mask, 1
+	}
+
+	if r.WholeField {
+		if r.re.MatchString(value) {
+			return mask, 1
+		}
+		return value, 0
+	}
+
+	if n := len(r.re.FindAllStringIndex(value, -1)); n > 0 {
+		return r.re.ReplaceAllLiteralString(value, mask), n
+	}
+
+	return value, 0
+}
+
+// RedactionConfig holds the configuration of event field redaction
+type RedactionConfig struct {
+	Enable bool             `toml:"enable" comment:"Enable redaction of event fields before events are forwarded or dumped"`
+	Mask   string           `toml:"mask" comment:"String replacing redacted data"`
+	Rules  []*RedactionRule `toml:"rules" comment:"Redaction rules"`
+}
+
+// IsEnabled returns true if redaction is enabled
+func (c *RedactionConfig) IsEnabled() bool {
+	return c != nil && c.Enable && len(c.Rules) > 0
+}
+
+// Compile compiles redaction rules
+func (c *RedactionConfig) Compile() error {
+	if c.Mask == "" {
+		c.Mask = DefaultRedactionMask
+	}
+
+	for _, r := range c.Rules {
+		if err := r.compile(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// redact redacts an event once it went through detection, so that rules see
+// the original values, and before it is forwarded or queued for actions
+func (h *HIDS) redact(e *event.EdrEvent) {
+	if !h.config.Redaction.IsEnabled() {
+		return
+	}
+
+	if n := h.config.Redaction.Redact(e); n > 0 {
+		log.Debugf("Redacted %d occurrence(s) in event %d from %s", n, e.EventID(), e.Channel())
+	}
+}
+
+// Redact applies redaction rules to event and returns the number of redactions made
+func (c *RedactionConfig) Redact(e *event.EdrEvent) (count int) {
+	for _, r := range c.Rules {
+		for _, p := range r.paths {
+			if value, ok := e.GetString(p); ok {
+				if redacted, n := r.redact(value, c.Mask); n > 0 {
+					e.Set(p, redacted)
+					count += n
+				}
+			}
+		}
+	}
+	return
+}
+
+// RedactValue applies the redaction rules of field p to value and returns the
+// value redacted along with the number of redactions made
+func (c *RedactionConfig) RedactValue(p engine.XPath, value string) (string, int) {
+	var count, n int
+	for _, r := range c.Rules {
+		for _, rp := range r.paths {
+			if rp.Equal(p) {
+				value, n = r.redact(value, c.Mask)
+				count += n
+			}
+		}
+	}
+	return value, count
+}
+
+// redactTrack redacts the command lines of a process track as the command
+// line fields of events are, so that they do not leave the endpoint through
+// process listings and reports. It must be called on a copy of the track.
+func (h *HIDS) redactTrack(t *ProcessTrack) {
+	if !h.config.Redaction.IsEnabled() {
+		return
+	}
+
+	t.CommandLine, _ = h.config.Redaction.RedactValue(pathSysmonCommandLine, t.CommandLine)
+	t.ParentCommandLine, _ = h.config.Redaction.RedactValue(pathSysmonParentCommandLine, t.ParentCommandLine)
+}
+
+// processTracks returns a copy of the tracker content with redacted command lines
+func (h *HIDS) processTracks() map[string]ProcessTrack {
+	ps := h.tracker.PS()
+	for guid, t := range ps {
+		h.redactTrack(&t)
+		ps[guid] = t
+	}
+	return ps
+}
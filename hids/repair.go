@@ -0,0 +1,211 @@
+package hids
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/0xrawsec/golang-utils/datastructs"
+	"github.com/0xrawsec/golang-utils/fsutil"
+	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/whids/utils"
+)
+
+// RulesState describes the rules loaded in the engine
+type RulesState struct {
+	RuleCount   int    `json:"rule-count"`
+	RulesSha256 string `json:"rules-sha256"`
+}
+
+// RulesRepair is the result of a rules repair
+type RulesRepair struct {
+	Before            RulesState `json:"before"`
+	After             RulesState `json:"after"`
+	RemovedContainers []string   `json:"removed-containers"`
+	Error             string     `json:"error,omitempty"`
+}
+
+func (h *HIDS) rulesState() (s RulesState) {
+	_, sha256Path := h.config.RulesConfig.RulesPaths()
+
+	h.RLock()
+	s.RuleCount = h.Engine.Count()
+	h.RUnlock()
+	s.RulesSha256, _ = utils.ReadFileString(sha256Path)
+
+	return
+}
+
+// discardRules removes the rules and the containers pushed by the manager
+// from the local databases. Containers not having a sha256 file have not
+// been pushed by the manager and are kept.
+func (h *HIDS) discardRules() (removed []string, err error) {
+	removed = make([]string, 0)
+	rulesPath, rulesSha256Path := h.config.RulesConfig.RulesPaths()
+
+	for _, path := range []string{rulesPath, rulesSha256Path} {
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+
+	fis, err := os.ReadDir(h.config.RulesConfig.ContainersDB)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	for _, fi := range fis {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), containerExt) {
+			continue
+		}
+
+		name := strings.SplitN(fi.Name(), ".", 2)[0]
+		contPath, contSha256Path := h.containerPaths(name)
+		if !fsutil.Exists(contSha256Path) {
+			continue
+		}
+
+		if err = os.Remove(contPath); err != nil {
+			return removed, fmt.Errorf("failed to remove container %s: %w", name, err)
+		}
+		os.Remove(contSha256Path)
+		removed = append(removed, name)
+	}
+
+	return
+}
+
+// repairRules discards the local rules and containers, fetches them all
+// again from the manager (verifying their sha256) and reloads the engine.
+// In case of failure the engine running keeps its rules.
+func (h *HIDS) repairRules() (r RulesRepair) {
+	var err error
+
+	h.updateLock.Lock()
+	defer h.updateLock.Unlock()
+
+	r.Before = h.rulesState()
+	defer func() {
+		r.After = h.rulesState()
+		if err != nil {
+			r.Error = err.Error()
+			log.Errorf("Failed to repair rules: %s", err)
+		} else {
+			log.Infof("Rules repaired: rules=%d sha256=%s", r.After.RuleCount, r.After.RulesSha256)
+		}
+	}()
+
+	if !h.config.IsForwardingEnabled() {
+		err = fmt.Errorf("forwarding is not enabled")
+		return
+	}
+
+	log.Info("Repairing rules database on manager request")
+	if r.RemovedContainers, err = h.discardRules(); err != nil {
+		return
+	}
+
+	if err = h.fetchRulesFromManager(); err != nil {
+		err = fmt.Errorf("failed to fetch rules from manager: %w", err)
+		return
+	}
+
+	if err = h.fetchIoCsFromManager(); err != nil {
+		err = fmt.Errorf("failed to fetch IoCs from manager: %w", err)
+		return
+	}
+
+	if _, err = h.updateContainers(); err != nil {
+		err = fmt.Errorf("failed to fetch containers from manager: %w", err)
+		return
+	}
+
+	err = h.updateEngine(true)
+
+	return
+}
+
+// CanaryFileState is the state of a canary file after a canaries repair
+type CanaryFileState struct {
+	Path     string `json:"path"`
+	Present  bool   `json:"present"`
+	Restored bool   `json:"restored"`
+}
+
+// CanariesRepair is the result of a canaries repair
+type CanariesRepair struct {
+	RestoredDirs  []string          `json:"restored-dirs"`
+	RestoredFiles []string          `json:"restored-files"`
+	AuditDirs     []string          `json:"audit-dirs"`
+	Inventory     []CanaryFileState `json:"inventory"`
+	Error         string            `json:"error,omitempty"`
+}
+
+// repairCanaries re-creates the canary directories and files missing and
+// re-applies the audit ACLs of the canary directories. Existing canaries are
+// left untouched so that it is safe to repair canaries repeatedly.
+func (h *HIDS) repairCanaries() (r CanariesRepair) {
+	c := h.config.CanariesConfig
+
+	r.RestoredDirs = make([]string, 0)
+	r.RestoredFiles = make([]string, 0)
+	r.AuditDirs = make([]string, 0)
+	r.Inventory = make([]CanaryFileState, 0)
+
+	if !c.Enable {
+		r.Error = "canaries are not enabled"
+		return
+	}
+
+	errs := make([]string, 0)
+	for _, cf := range c.Canaries {
+		missingDirs := make([]string, 0)
+		for _, dir := range cf.expandDir() {
+			if !fsutil.Exists(dir) {
+				missingDirs = append(missingDirs, dir)
+			}
+		}
+
+		missing := datastructs.NewSet()
+		for _, fp := range cf.paths() {
+			if !fsutil.Exists(fp) {
+				missing.Add(fp)
+			}
+		}
+
+		if err := cf.create(); err != nil {
+			errs = append(errs, fmt.Sprintf("failed to create canary: %s", err))
+		}
+
+		for _, dir := range missingDirs {
+			if fsutil.IsDir(dir) {
+				r.RestoredDirs = append(r.RestoredDirs, dir)
+			}
+		}
+
+		for _, fp := range cf.paths() {
+			s := CanaryFileState{Path: fp, Present: fsutil.IsFile(fp)}
+			if s.Present && missing.Contains(fp) {
+				s.Restored = true
+				r.RestoredFiles = append(r.RestoredFiles, fp)
+			}
+			r.Inventory = append(r.Inventory, s)
+		}
+
+		if cf.SetAuditACL {
+			r.AuditDirs = append(r.AuditDirs, cf.expandDir()...)
+		}
+	}
+
+	if err := utils.SetEDRAuditACL(r.AuditDirs...); err != nil {
+		errs = append(errs, fmt.Sprintf("failed to set canaries audit ACLs: %s", err))
+	}
+
+	log.Infof("Canaries repaired: %d directories and %d files restored", len(r.RestoredDirs), len(r.RestoredFiles))
+	r.Error = strings.Join(errs, ", ")
+
+	return
+}
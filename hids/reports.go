@@ -3,19 +3,29 @@ package hids
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os/exec"
+	"sort"
 	"time"
+
+	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/whids/utils/osquery"
 )
 
 // Report structure
 type Report struct {
-	Processes map[string]ProcessTrack `json:"processes"`
-	Modules   []ModuleInfo            `json:"modules"`
-	Drivers   []DriverInfo            `json:"drivers"`
-	Commands  []ReportCommand         `json:"commands"`
-	StartTime time.Time               `json:"start-timestamp"` // time at which report generation started
-	StopTime  time.Time               `json:"stop-timestamp"`  // time at which report generation stopped
+	Processes    map[string]ProcessTrack  `json:"processes"`
+	Modules      []ModuleInfo             `json:"modules"`
+	Drivers      []DriverInfo             `json:"drivers"`
+	Blacklist    []string                 `json:"blacklist"`
+	SkippedDumps uint64                   `json:"skipped-dumps"` // dumps skipped because of low disk space
+	Commands     []ReportCommand          `json:"commands"`
+	Queries      map[string]ReportCommand `json:"queries,omitempty"`
+	Sockets      *ProcessSocketsReport    `json:"sockets,omitempty"`
+	StartTime    time.Time                `json:"start-timestamp"` // time at which report generation started
+	StopTime     time.Time                `json:"stop-timestamp"`  // time at which report generation stopped
+	TimedOut     bool                     `json:"timed-out"`       // report maximum time reached before all commands completed
 }
 
 // ReportCommand is a structure both to configure commands to run in a report
@@ -30,18 +40,72 @@ type ReportCommand struct {
 	Error       string        `json:"error" toml:",omitempty"`
 	Timestamp   time.Time     `json:"timestamp" toml:",omitempty"`
 	Timeout     time.Duration `json:"timeout" toml:"timeout" comment:"Timeout to apply to the command (if > 0 this takes precedence over the global report timeout setting)"`
+	TimedOut    bool          `json:"timed-out" toml:",omitempty"`
+
+	// SQL query to run through osqueryd, for OSQuery commands only
+	sql string
+}
+
+// RunWithOSQuery runs the command through osqueryd if it is an OSQuery
+// command and the daemon is available, it falls back to running the command
+// otherwise.
+func (c *ReportCommand) RunWithOSQuery(client *osquery.Client) {
+	c.runWithOSQuery(context.Background(), client)
+}
+
+// runWithOSQuery is RunWithOSQuery with the command being stopped when ctx
+// is done
+func (c *ReportCommand) runWithOSQuery(ctx context.Context, client *osquery.Client) {
+	var se *osquery.StatusError
+
+	if client == nil || c.sql == "" {
+		c.run(ctx)
+		return
+	}
+
+	// query must not outlive ctx
+	timeout := c.Timeout
+	if d, ok := ctx.Deadline(); ok && (timeout <= 0 || time.Until(d) < timeout) {
+		if timeout = time.Until(d); timeout <= 0 {
+			c.Error = context.DeadlineExceeded.Error()
+			return
+		}
+	}
+
+	c.Timestamp = time.Now()
+	rows, err := client.Query(c.sql, timeout)
+	switch {
+	case err == nil:
+		c.Name = client.Path()
+		c.Args = []string{c.sql}
+		c.Stdout = rows
+	case errors.As(err, &se):
+		// osqueryd is available but query failed, osqueryi would fail too
+		c.Name = client.Path()
+		c.Args = []string{c.sql}
+		c.Error = err.Error()
+	default:
+		if !errors.Is(err, osquery.ErrUnavailable) {
+			log.Warnf("Failed to run OSQuery query through osqueryd, falling back to osqueryi: %s", err)
+		}
+		c.run(ctx)
+	}
 }
 
 // Run the desired command
 func (c *ReportCommand) Run() {
+	c.run(context.Background())
+}
+
+// run runs the command, killing it when ctx is done
+func (c *ReportCommand) run(ctx context.Context) {
 	var cmd *exec.Cmd
 	var err error
 	var stdout []byte
 	var cancel context.CancelFunc
 
-	ctx := context.Background()
 	if c.Timeout > 0 {
-		ctx, cancel = context.WithTimeout(context.Background(), c.Timeout)
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
 		defer cancel()
 	}
 
@@ -69,10 +133,52 @@ var (
 	osqueryiArgs = []string{"--json", "-A"}
 )
 
+// OSQueryQuery is a named OSQuery SQL query
+type OSQueryQuery struct {
+	Name  string `toml:"name" comment:"Name of the query, results are found under this name in the report"`
+	Query string `toml:"query" comment:"SQL query to run (ex: SELECT * FROM processes WHERE path LIKE '%temp%')"`
+}
+
 // OSQueryConfig holds configuration about OSQuery tool
 type OSQueryConfig struct {
-	Bin    string   `toml:"bin" comment:"Path to osqueryi binary"`
-	Tables []string `toml:"tables" comment:"OSQuery tables to add to the report"`
+	Bin     string         `toml:"bin" comment:"Path to osqueryi binary"`
+	Socket  string         `toml:"socket" comment:"Path to the extensions socket of a running osqueryd (ex: \\\\.\\pipe\\shell.em)\n If set, queries are run through osqueryd and osqueryi is used only if it is not available"`
+	Tables  []string       `toml:"tables" comment:"OSQuery tables to add to the report"`
+	Queries []OSQueryQuery `toml:"queries" comment:"Named OSQuery queries to add to the report"`
+}
+
+// Verify checks that queries are named uniquely
+func (c *OSQueryConfig) Verify() error {
+	names := make(map[string]bool)
+	for _, q := range c.Queries {
+		switch {
+		case q.Name == "":
+			return fmt.Errorf("osquery query must have a name: %s", q.Query)
+		case q.Query == "":
+			return fmt.Errorf("osquery query %s is empty", q.Name)
+		case names[q.Name]:
+			return fmt.Errorf("duplicate osquery query name: %s", q.Name)
+		}
+		names[q.Name] = true
+	}
+	return nil
+}
+
+// PrepareQueries builds up osquery commands running the configured queries
+func (c *OSQueryConfig) PrepareQueries() (cmds map[string]ReportCommand) {
+	cmds = make(map[string]ReportCommand)
+
+	for _, q := range c.Queries {
+		cmds[q.Name] = ReportCommand{
+			Description: fmt.Sprintf("OSQuery %s query", q.Name),
+			Name:        c.Bin,
+			Args:        []string{"--json", q.Query},
+			ExpectJSON:  true,
+			sql:         q.Query,
+		}
+	}
+
+	return
 }
 
 // PrepareCommands builds up osquery commands
@@ -86,6 +192,7 @@ func (c *OSQueryConfig) PrepareCommands() (cmds []ReportCommand) {
 		cmds[i].Args = append(cmds[i].Args, t)
 
 		cmds[i].ExpectJSON = true
+		cmds[i].sql = fmt.Sprintf("SELECT * FROM %s", t)
 	}
 
 	return
@@ -97,6 +204,23 @@ type ReportConfig struct {
 	OSQuery         OSQueryConfig   `toml:"osquery" comment:"OSQuery configuration"`
 	Commands        []ReportCommand `toml:"commands" comment:"Commands to execute in addition to the OSQuery ones" commented:"true"`
 	CommandTimeout  time.Duration   `toml:"timeout" comment:"Timeout after which every command expires (to prevent too long commands)"`
+	MaxTime         time.Duration   `toml:"max-time" comment:"Maximum time taken by a report, commands not completed by then being marked as timed out\n in the report (default: twice the command timeout)"`
+}
+
+// Verify validates report configuration
+func (c *ReportConfig) Verify() error {
+	if c.MaxTime < 0 {
+		return fmt.Errorf("report maximum time must be positive")
+	}
+	return c.OSQuery.Verify()
+}
+
+// maxTime returns the maximum time taken by a report, 0 meaning unlimited
+func (c *ReportConfig) maxTime() time.Duration {
+	if c.MaxTime > 0 {
+		return c.MaxTime
+	}
+	return 2 * c.CommandTimeout
 }
 
 // PrepareCommands builds up all commands to run
@@ -118,3 +242,107 @@ func (c *ReportConfig) PrepareCommands() (cmds []ReportCommand) {
 	}
 	return
 }
+
+// PrepareQueries builds up OSQuery queries to run, keyed by query name
+func (c *ReportConfig) PrepareQueries() (cmds map[string]ReportCommand) {
+	cmds = c.OSQuery.PrepareQueries()
+	for name, rc := range cmds {
+		rc.Timeout = c.CommandTimeout
+		cmds[name] = rc
+	}
+	return
+}
+
+// runReportCommands runs commands one after the other until all completed or
+// deadline (if not zero) is reached. Commands not completed by the deadline
+// are marked as timed out and false is returned. Commands run on copies made
+// before starting so cmds is never accessed by the running commands, which
+// are stopped once the deadline is reached.
+func runReportCommands(cmds []*ReportCommand, client *osquery.Client, deadline time.Time) (completed bool) {
+	var timeout <-chan struct{}
+
+	type result struct {
+		i   int
+		cmd ReportCommand
+	}
+
+	ctx := context.Background()
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+		timeout = ctx.Done()
+	}
+
+	todo := make([]ReportCommand, len(cmds))
+	for i, c := range cmds {
+		todo[i] = *c
+	}
+
+	results := make(chan result, len(cmds))
+	done := make([]bool, len(cmds))
+
+	go func() {
+		for i := range todo {
+			if ctx.Err() != nil {
+				return
+			}
+			todo[i].runWithOSQuery(ctx, client)
+			results <- result{i, todo[i]}
+		}
+	}()
+
+	for n := 0; n < len(cmds); n++ {
+		select {
+		case r := <-results:
+			*cmds[r.i] = r.cmd
+			done[r.i] = true
+		case <-timeout:
+			for i, c := range cmds {
+				if !done[i] {
+					c.TimedOut = true
+					c.Error = "report maximum time reached"
+				}
+			}
+			log.Warnf("Report maximum time reached, %d/%d commands completed", n, len(cmds))
+			return false
+		}
+	}
+
+	return true
+}
+
+// runCommands runs report commands and queries within report maximum time
+func (r *Report) runCommands(c *ReportConfig, client *osquery.Client) {
+	var deadline time.Time
+
+	if max := c.maxTime(); max > 0 {
+		deadline = r.StartTime.Add(max)
+	}
+
+	r.Commands = c.PrepareCommands()
+	r.Queries = c.PrepareQueries()
+
+	cmds := make([]*ReportCommand, 0, len(r.Commands)+len(r.Queries))
+	for i := range r.Commands {
+		cmds = append(cmds, &r.Commands[i])
+	}
+
+	names := make([]string, 0, len(r.Queries))
+	for name := range r.Queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	queries := make([]ReportCommand, len(names))
+	for i, name := range names {
+		queries[i] = r.Queries[name]
+		cmds = append(cmds, &queries[i])
+	}
+
+	r.TimedOut = !runReportCommands(cmds, client, deadline)
+
+	for i, name := range names {
+		r.Queries[name] = queries[i]
+	}
+}
@@ -0,0 +1,41 @@
+package hids
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRunReportCommandsPartial(t *testing.T) {
+	cmds := []*ReportCommand{
+		// completes right away as the binary does not exist
+		{Name: "whids-no-such-binary.exe"},
+		{Name: "ping.exe", Args: []string{"-n", "30", "127.0.0.1"}},
+		{Name: "whids-no-such-binary.exe"},
+	}
+
+	start := time.Now()
+	if runReportCommands(cmds, nil, start.Add(time.Second)) {
+		t.Error("Report commands must not complete")
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Error("Report commands must stop at deadline")
+	}
+
+	if cmds[0].TimedOut || cmds[0].Error == "" || cmds[0].Timestamp.IsZero() {
+		t.Errorf("Unexpected outcome of completed command: %+v", cmds[0])
+	}
+
+	for _, c := range cmds[1:] {
+		if !c.TimedOut {
+			t.Errorf("Command %s must be marked as timed out", c.Name)
+		}
+	}
+
+	// command still running must not modify report once deadline reached
+	before := *cmds[1]
+	time.Sleep(2 * time.Second)
+	if cmds[1].Timestamp != before.Timestamp || cmds[1].Stdout != nil {
+		t.Error("Timed out command modified after deadline")
+	}
+}
@@ -0,0 +1,93 @@
+package hids
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/0xrawsec/whids/event"
+)
+
+// SamplingRule configures sampling of a given event type
+type SamplingRule struct {
+	Channel  string  `toml:"channel" comment:"Channel of the events to sample (empty: any channel)"`
+	EventIDs []int64 `toml:"event-ids" comment:"Event IDs to sample (empty: any event ID of the channel)"`
+	Rate     int     `toml:"rate" comment:"Forward only one event out of rate (0: drop all)"`
+}
+
+// SamplingConfig holds event sampling configuration. Sampling only applies
+// to events which did not match any rule, detections and filtered events are
+// always forwarded.
+type SamplingConfig struct {
+	Enable bool           `toml:"enable" comment:"Enable sampling of events not matching any rule"`
+	Rules  []SamplingRule `toml:"rules" comment:"Sampling rules, the first rule matching an event applies"`
+}
+
+// IsEnabled returns true if sampling is enabled
+func (c *SamplingConfig) IsEnabled() bool {
+	return c != nil && c.Enable
+}
+
+// Verify validates sampling configuration
+func (c *SamplingConfig) Verify() error {
+	if c == nil {
+		return nil
+	}
+
+	for i, r := range c.Rules {
+		if r.Rate < 0 {
+			return fmt.Errorf("sampling rule %d: rate must be positive", i)
+		}
+		if r.Channel == "" && len(r.EventIDs) == 0 {
+			return fmt.Errorf("sampling rule %d: either a channel or event IDs must be specified", i)
+		}
+	}
+
+	return nil
+}
+
+type samplingFilter struct {
+	filter *Filter
+	rate   uint64
+	count  uint64
+}
+
+// sampler decides whether events not matching any rule have to be forwarded
+type sampler struct {
+	sync.Mutex
+	filters []*samplingFilter
+}
+
+func newSampler(c *SamplingConfig) *sampler {
+	s := &sampler{filters: make([]*samplingFilter, 0)}
+
+	if c.IsEnabled() {
+		for _, r := range c.Rules {
+			s.filters = append(s.filters, &samplingFilter{
+				filter: NewFilter(r.EventIDs, r.Channel),
+				rate:   uint64(r.Rate),
+			})
+		}
+	}
+
+	return s
+}
+
+// Keep returns true if the event must be forwarded. Events not matching any
+// sampling rule are always kept.
+func (s *sampler) Keep(e *event.EdrEvent) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, f := range s.filters {
+		if f.filter.Match(e) {
+			if f.rate == 0 {
+				return false
+			}
+			keep := f.count%f.rate == 0
+			f.count++
+			return keep
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,84 @@
+package hids
+
+import (
+	"os"
+	"sync"
+
+	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/whids/event"
+)
+
+// protectedPIDs holds the PIDs no action (kill, suspend, memdump ...) must
+// ever target. Agent's PID is always part of it and child processes of the
+// agent are added as they are created. As it relies only on PIDs it does not
+// depend on agent's process GUID being resolved.
+type protectedPIDs struct {
+	sync.RWMutex
+	self     int64
+	children bool
+	pids     map[int64]bool
+}
+
+func newProtectedPIDs(children bool) *protectedPIDs {
+	self := int64(os.Getpid())
+	return &protectedPIDs{
+		self:     self,
+		children: children,
+		pids:     map[int64]bool{self: true},
+	}
+}
+
+// Add protects a child process of the agent
+func (p *protectedPIDs) Add(pid int64) {
+	p.Lock()
+	defer p.Unlock()
+	p.pids[pid] = true
+}
+
+// Del removes protection of a terminated process, agent's PID is never removed
+func (p *protectedPIDs) Del(pid int64) {
+	p.Lock()
+	defer p.Unlock()
+	if pid != p.self {
+		delete(p.pids, pid)
+	}
+}
+
+// Contains returns true if the PID is protected
+func (p *protectedPIDs) Contains(pid int64) bool {
+	p.RLock()
+	defer p.RUnlock()
+	return p.pids[pid]
+}
+
+// isProtected returns true if pid must not be the target of action. As it
+// denotes a rule or configuration problem it is logged loudly.
+func (h *HIDS) isProtected(pid int64, action string) bool {
+	if h.protected.Contains(pid) {
+		log.Criticalf("Self protection: refusing to %s protected process PID=%d (agent PID=%d), check rules and configuration", action, pid, os.Getpid())
+		return true
+	}
+	return false
+}
+
+// hook maintaining the set of protected PIDs up to date with agent's
+// child processes
+func hookSelfProtection(h *HIDS, e *event.EdrEvent) {
+	pid, ok := e.GetInt(pathSysmonProcessId)
+	if !ok {
+		return
+	}
+
+	switch e.EventID() {
+	case SysmonProcessCreate:
+		if !h.protected.children {
+			return
+		}
+		if ppid, ok := e.GetInt(pathSysmonParentProcessId); ok && h.protected.Contains(ppid) {
+			log.Debugf("Self protection: protecting agent child process PID=%d", pid)
+			h.protected.Add(pid)
+		}
+	case SysmonProcessTerminate:
+		h.protected.Del(pid)
+	}
+}
@@ -0,0 +1,105 @@
+package hids
+
+import (
+	"sync"
+	"time"
+
+	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/golang-win32/win32/advapi32"
+)
+
+const (
+	// number of consecutive failures after which we stop
+	// trying to resolve services for a PID
+	svcResolutionMaxFailures = 3
+	// window after which failures for a PID are forgotten
+	svcResolutionWindow = 5 * time.Minute
+	// minimum interval between two resolution error messages
+	svcResolutionLogInterval = time.Minute
+)
+
+type resolutionFailure struct {
+	count int
+	first time.Time
+}
+
+// serviceResolver resolves services names by PID and implements a negative
+// cache so that PIDs failing consistently are not resolved over and over
+type serviceResolver struct {
+	sync.Mutex
+	failures   map[uint32]*resolutionFailure
+	lastPurge  time.Time
+	lastLog    time.Time
+	suppressed int
+}
+
+func newServiceResolver() *serviceResolver {
+	return &serviceResolver{
+		failures:  make(map[uint32]*resolutionFailure),
+		lastPurge: time.Now(),
+	}
+}
+
+func (r *serviceResolver) purge(now time.Time) {
+	if now.Sub(r.lastPurge) < svcResolutionWindow {
+		return
+	}
+
+	for pid, f := range r.failures {
+		if now.Sub(f.first) > svcResolutionWindow {
+			delete(r.failures, pid)
+		}
+	}
+	r.lastPurge = now
+}
+
+func (r *serviceResolver) logError(pid uint32, err error, now time.Time) {
+	if now.Sub(r.lastLog) < svcResolutionLogInterval {
+		r.suppressed++
+		return
+	}
+
+	if r.suppressed > 0 {
+		log.Errorf("Failed to resolve service from PID=%d: %s (%d similar errors suppressed)", pid, err, r.suppressed)
+	} else {
+		log.Errorf("Failed to resolve service from PID=%d: %s", pid, err)
+	}
+
+	r.lastLog = now
+	r.suppressed = 0
+}
+
+// resolve returns the services names associated to a PID
+func (r *serviceResolver) resolve(pid uint32) (services string, err error) {
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	r.purge(now)
+
+	f, ok := r.failures[pid]
+	if ok && now.Sub(f.first) > svcResolutionWindow {
+		delete(r.failures, pid)
+		f, ok = nil, false
+	}
+
+	// circuit is open for this PID
+	if ok && f.count >= svcResolutionMaxFailures {
+		return "", errServiceResolution
+	}
+
+	if services, err = advapi32.ServiceWin32NamesByPid(pid); err == nil {
+		delete(r.failures, pid)
+		return
+	}
+
+	if !ok {
+		f = &resolutionFailure{first: now}
+		r.failures[pid] = f
+	}
+	f.count++
+
+	r.logError(pid, err, now)
+
+	return "", errServiceResolution
+}
@@ -0,0 +1,172 @@
+package hids
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/golang-utils/crypto/data"
+	"github.com/0xrawsec/golang-utils/fsutil"
+	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/utils"
+)
+
+// shadow rules needs to be updated with the ones available in manager
+func (h *HIDS) needsShadowRulesUpdate() bool {
+	var err error
+	var oldSha256, sha256 string
+	_, sha256Path := h.config.RulesConfig.ShadowPaths()
+
+	if !h.config.RulesConfig.Shadow || !h.config.IsForwardingEnabled() {
+		return false
+	}
+
+	if sha256, err = h.forwarder.Client.GetShadowRulesSha256(); err != nil {
+		log.Errorf("Failed to fetch shadow rules sha256: %s", err)
+		return false
+	}
+
+	oldSha256, _ = utils.ReadFileString(sha256Path)
+
+	// log message only if we need to update
+	if oldSha256 != sha256 {
+		log.Infof("Shadow rules: remote=%s local=%s", sha256, oldSha256)
+	}
+
+	return oldSha256 != sha256
+}
+
+func (h *HIDS) fetchShadowRulesFromManager() (err error) {
+	var rules, sha256 string
+
+	rulePath, sha256Path := h.config.RulesConfig.ShadowPaths()
+
+	// if we are not connected to a manager we return
+	if h.config.FwdConfig.Local {
+		return
+	}
+
+	log.Infof("Fetching shadow rules available in manager")
+	if sha256, err = h.forwarder.Client.GetShadowRulesSha256(); err != nil {
+		return err
+	}
+
+	if rules, err = h.forwarder.Client.GetShadowRules(); err != nil {
+		return err
+	}
+
+	if sha256 != data.Sha256([]byte(rules)) {
+		return fmt.Errorf("failed to verify shadow rules integrity")
+	}
+
+	// sha256 is written only once rules are, so that rules failing to be
+	// written are fetched again at next update
+	if err = ioutil.WriteFile(rulePath, []byte(rules), 0600); err != nil {
+		return fmt.Errorf("failed to write shadow rules: %w", err)
+	}
+
+	if err = ioutil.WriteFile(sha256Path, []byte(sha256), 0600); err != nil {
+		return fmt.Errorf("failed to write shadow rules sha256: %w", err)
+	}
+
+	return
+}
+
+// loadShadowEngine builds the shadow engine from the shadow rules database.
+// It returns a nil engine if shadow evaluation is disabled or if no shadow
+// rule is deployed, so that no evaluation cost is paid.
+func (h *HIDS) loadShadowEngine() (e *engine.Engine, err error) {
+	path, _ := h.config.RulesConfig.ShadowPaths()
+
+	if !h.config.RulesConfig.Shadow || !fsutil.IsFile(path) {
+		return
+	}
+
+	e = newActionnableEngine(h.config)
+	if err = h.loadContainers(e); err != nil {
+		return nil, fmt.Errorf("failed at loading containers: %s", err)
+	}
+
+	if err = e.LoadFile(path); err != nil {
+		return nil, fmt.Errorf("failed to load shadow rules: %s", err)
+	}
+
+	if e.Count() == 0 {
+		return nil, nil
+	}
+
+	return
+}
+
+// shadowMatch matches an event against the shadow engine and returns the
+// shadow detection, the event being left as it was. It returns false if no
+// shadow engine is loaded.
+func (h *HIDS) shadowMatch(e *event.EdrEvent) (d *engine.Detection, ok bool) {
+	if h.shadow == nil {
+		return nil, false
+	}
+
+	prev := e.Event.Detection
+	e.Event.Detection = nil
+	h.shadow.MatchOrFilter(e)
+	d = e.Event.Detection
+	e.Event.Detection = prev
+
+	return d, true
+}
+
+// alertNames returns the rules of a detection if it is above the
+// criticality threshold
+func (h *HIDS) alertNames(d *engine.Detection) (names []string) {
+	if d != nil && d.IsAlert() && d.Criticality >= h.config.CritTresh {
+		names = d.Names()
+		sort.Strings(names)
+	}
+	return
+}
+
+func diffNames(from, to []string) (diff []string) {
+	set := make(map[string]bool, len(from))
+	for _, n := range from {
+		set[n] = true
+	}
+	for _, n := range to {
+		if !set[n] {
+			diff = append(diff, n)
+		}
+	}
+	return
+}
+
+// forwardShadow forwards a copy of e carrying the shadow detection if it
+// differs from the active one. Shadow copies are never queued for actions.
+func (h *HIDS) forwardShadow(e *event.EdrEvent, active, shadow *engine.Detection) {
+	a, s := h.alertNames(active), h.alertNames(shadow)
+
+	diff := event.ShadowDiff{
+		New:        diffNames(a, s),
+		Suppressed: diffNames(s, a),
+	}
+
+	if len(diff.New) == 0 && len(diff.Suppressed) == 0 {
+		return
+	}
+
+	c, err := copyEvent(e)
+	if err != nil {
+		log.Errorf("Failed to copy shadow event: %s", err)
+		return
+	}
+
+	// copy is taken before the event is redacted
+	h.redact(c)
+
+	if len(s) > 0 {
+		c.Event.Detection = shadow
+	}
+	c.Event.Shadow = &diff
+
+	h.forwarder.PipeEvent(c)
+}
@@ -0,0 +1,123 @@
+package hids
+
+import (
+	"bufio"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/event"
+)
+
+func testDetection(criticality int, names ...string) *engine.Detection {
+	d := engine.NewDetection(false, false)
+	d.Criticality = criticality
+	for _, n := range names {
+		d.Signature.Add(n)
+	}
+	return d
+}
+
+func TestDiffNames(t *testing.T) {
+	for _, tc := range []struct {
+		from, to []string
+		diff     []string
+	}{
+		{nil, nil, nil},
+		{[]string{"A"}, nil, nil},
+		{nil, []string{"A", "B"}, []string{"A", "B"}},
+		{[]string{"A", "B"}, []string{"A", "B"}, nil},
+		{[]string{"A"}, []string{"A", "B"}, []string{"B"}},
+		{[]string{"A", "B"}, []string{"C"}, []string{"C"}},
+	} {
+		if diff := diffNames(tc.from, tc.to); !reflect.DeepEqual(diff, tc.diff) {
+			t.Errorf("Unexpected diff from %v to %v: %v", tc.from, tc.to, diff)
+		}
+	}
+}
+
+func TestForwardShadow(t *testing.T) {
+	f, err := api.NewForwarder(&api.ForwarderConfig{
+		Local:   true,
+		Logging: api.LoggingConfig{Dir: t.TempDir()},
+	})
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	h := &HIDS{config: &Config{CritTresh: 5}, forwarder: f}
+
+	for _, tc := range []struct {
+		name      string
+		active    *engine.Detection
+		shadow    *engine.Detection
+		forwarded bool
+		diff      event.ShadowDiff
+	}{
+		{"identical", testDetection(8, "A"), testDetection(8, "A"), false, event.ShadowDiff{}},
+		{"no detection", nil, nil, false, event.ShadowDiff{}},
+		// below criticality threshold detections are not alerts
+		{"below threshold", nil, testDetection(2, "A"), false, event.ShadowDiff{}},
+		{"new", nil, testDetection(8, "A", "B"), true, event.ShadowDiff{New: []string{"A", "B"}}},
+		{"suppressed", testDetection(8, "A", "B"), nil, true, event.ShadowDiff{Suppressed: []string{"A", "B"}}},
+		{"new and suppressed", testDetection(8, "A", "B"), testDetection(8, "B", "C"), true,
+			event.ShadowDiff{New: []string{"C"}, Suppressed: []string{"A"}}},
+	} {
+		f.Pipe.Reset()
+		f.DetPipe.Reset()
+
+		e := channelEvent("Microsoft-Windows-Sysmon/Operational")
+		e.Event.Detection = tc.active
+		h.forwardShadow(e, tc.active, tc.shadow)
+
+		// original event must be left untouched
+		if e.IsShadow() || e.Event.Detection != tc.active {
+			t.Errorf("%s: original event must not be modified", tc.name)
+		}
+
+		if f.DetPipe.Len() > 0 {
+			t.Errorf("%s: shadow events must not be piped as detections", tc.name)
+		}
+
+		var lines []string
+		for s := bufio.NewScanner(f.Pipe); s.Scan(); {
+			lines = append(lines, s.Text())
+		}
+
+		if !tc.forwarded {
+			if len(lines) != 0 {
+				t.Errorf("%s: no shadow event expected", tc.name)
+			}
+			continue
+		}
+
+		if len(lines) != 1 {
+			t.Errorf("%s: unexpected number of shadow events: %d", tc.name, len(lines))
+			continue
+		}
+
+		c := event.EdrEvent{}
+		if err := json.Unmarshal([]byte(lines[0]), &c); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+
+		if !c.IsShadow() || !reflect.DeepEqual(*c.Event.Shadow, tc.diff) {
+			t.Errorf("%s: unexpected shadow diff: %+v", tc.name, c.Event.Shadow)
+		}
+
+		// copy carries the shadow detection if any
+		var names []string
+		if d := c.GetDetection(); d != nil {
+			names = d.Names()
+			sort.Strings(names)
+		}
+		if want := h.alertNames(tc.shadow); !reflect.DeepEqual(names, want) {
+			t.Errorf("%s: unexpected detection on shadow copy: %v", tc.name, names)
+		}
+	}
+}
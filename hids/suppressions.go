@@ -0,0 +1,139 @@
+package hids
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/golang-utils/crypto/data"
+	"github.com/0xrawsec/golang-utils/fsutil"
+	"github.com/0xrawsec/golang-utils/log"
+	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/event"
+	"github.com/0xrawsec/whids/utils"
+)
+
+// suppression is a filter rule generated by the manager from a detection
+// marked as false positive, it applies to a single rule
+type suppression struct {
+	rule       *engine.CompiledRule
+	suppressed string
+}
+
+// suppressions holds the suppression rules loaded into an engine
+type suppressions []suppression
+
+// suppressions needs to be updated with the ones available in manager
+func (h *HIDS) needsSuppressionsUpdate() bool {
+	var err error
+	var oldSha256, sha256 string
+	_, sha256Path := h.config.RulesConfig.SuppressionsPaths()
+
+	if !h.config.IsForwardingEnabled() {
+		return false
+	}
+
+	if sha256, err = h.forwarder.Client.GetSuppressionsSha256(); err != nil {
+		log.Errorf("Failed to fetch suppressions sha256: %s", err)
+		return false
+	}
+
+	oldSha256, _ = utils.ReadFileString(sha256Path)
+
+	// log message only if we need to update
+	if oldSha256 != sha256 {
+		log.Infof("Suppressions: remote=%s local=%s", sha256, oldSha256)
+	}
+
+	return oldSha256 != sha256
+}
+
+func (h *HIDS) fetchSuppressionsFromManager() (err error) {
+	var rules, sha256 string
+
+	rulePath, sha256Path := h.config.RulesConfig.SuppressionsPaths()
+
+	// if we are not connected to a manager we return
+	if h.config.FwdConfig.Local {
+		return
+	}
+
+	log.Infof("Fetching suppressions available in manager")
+	if sha256, err = h.forwarder.Client.GetSuppressionsSha256(); err != nil {
+		return err
+	}
+
+	if rules, err = h.forwarder.Client.GetSuppressions(); err != nil {
+		return err
+	}
+
+	if sha256 != data.Sha256([]byte(rules)) {
+		return fmt.Errorf("failed to verify suppressions integrity")
+	}
+
+	// sha256 is written last so that a failure is retried at next update
+	if err = ioutil.WriteFile(rulePath, []byte(rules), 0600); err != nil {
+		return
+	}
+	return ioutil.WriteFile(sha256Path, []byte(sha256), 0600)
+}
+
+// loadSuppressions loads the suppression rules into their own engine, so
+// that they never filter events and are only checked against the detections
+// of the rules they suppress
+func (h *HIDS) loadSuppressions() (s suppressions, err error) {
+	path, _ := h.config.RulesConfig.SuppressionsPaths()
+
+	if !fsutil.IsFile(path) {
+		return
+	}
+
+	e := engine.NewEngine()
+	if err = e.LoadFile(path); err != nil {
+		return nil, fmt.Errorf("failed to load suppressions: %s", err)
+	}
+
+	return suppressionsFromEngine(e), nil
+}
+
+// suppressionsFromEngine returns the suppression rules loaded into an engine
+func suppressionsFromEngine(e *engine.Engine) (s suppressions) {
+	s = make(suppressions, 0)
+
+	for _, name := range e.GetRuleNames() {
+		r := e.GetCRuleByName(name)
+		if r == nil || !r.Filter || !r.Tags.Contains(api.SuppressionTag) {
+			continue
+		}
+		for _, t := range r.Tags.Slice() {
+			if tag := t.(string); strings.HasPrefix(tag, api.SuppressedRuleTagPrefix) {
+				s = append(s, suppression{r, strings.TrimPrefix(tag, api.SuppressedRuleTagPrefix)})
+			}
+		}
+	}
+
+	return
+}
+
+// Suppressed returns true if all the rules an event matched are suppressed
+func (s suppressions) Suppressed(e *event.EdrEvent, names []string) bool {
+	if len(s) == 0 || len(names) == 0 {
+		return false
+	}
+
+	for _, name := range names {
+		suppressed := false
+		for _, sup := range s {
+			if sup.suppressed == name && sup.rule.Match(e) {
+				suppressed = true
+				break
+			}
+		}
+		if !suppressed {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,57 @@
+package hids
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/0xrawsec/gene/v2/engine"
+	"github.com/0xrawsec/whids/api"
+	"github.com/0xrawsec/whids/event"
+)
+
+func imageEvent(image string) *event.EdrEvent {
+	e := event.EdrEvent{}
+	raw := fmt.Sprintf(`{"Event":{"EventData":{"Image":%q},"System":{"Channel":"Microsoft-Windows-Sysmon/Operational","EventID":1,"Computer":"HOST"}}}`, image)
+	if err := json.Unmarshal([]byte(raw), &e); err != nil {
+		panic(err)
+	}
+	return &e
+}
+
+func TestSuppressed(t *testing.T) {
+	image := `C:\Windows\System32\benign.exe`
+
+	s := api.NewDetectionSuppression()
+	s.Rule = "SuppressedRule"
+	s.Image = image
+
+	e := engine.NewEngine()
+	rule := s.FilterRule()
+	if err := e.LoadRule(&rule); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	sups := suppressionsFromEngine(e)
+	if len(sups) != 1 {
+		t.Errorf("Unexpected suppressions: %d", len(sups))
+		t.FailNow()
+	}
+
+	for _, tc := range []struct {
+		image      string
+		names      []string
+		suppressed bool
+	}{
+		{image, []string{"SuppressedRule"}, true},
+		{image, []string{"SuppressedRule", "OtherRule"}, false},
+		{image, []string{"OtherRule"}, false},
+		{image, nil, false},
+		{`C:\Windows\System32\other.exe`, []string{"SuppressedRule"}, false},
+	} {
+		if sups.Suppressed(imageEvent(tc.image), tc.names) != tc.suppressed {
+			t.Errorf("Unexpected suppression result for image=%s names=%v", tc.image, tc.names)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package hids
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// DefaultMaxDrivers is the default maximum number of drivers tracked
+	DefaultMaxDrivers = 1000
+)
+
+// TrackingRule matches processes by image path and/or signer. When both are
+// set, both must match.
+type TrackingRule struct {
+	Image  string `toml:"image" comment:"Image path glob pattern, case insensitive (ex: C:\\\\Program Files\\\\*\\\\*.exe)"`
+	Signer string `toml:"signer" comment:"Signer of the image, case insensitive. Signers are known from image load\n events so a process matches only if its image has already been loaded once"`
+}
+
+func (r *TrackingRule) match(image, signer string) bool {
+	if r.Image != "" {
+		if ok, _ := filepath.Match(strings.ToLower(r.Image), strings.ToLower(image)); !ok {
+			return false
+		}
+	}
+	if r.Signer != "" && !strings.EqualFold(r.Signer, signer) {
+		return false
+	}
+	return true
+}
+
+// TrackingConfig controls which processes are tracked. Processes not tracked
+// are still forwarded but are not enriched with tracking information
+// (ancestors, parent user ...). The agent, its parent and its children are
+// always tracked.
+type TrackingConfig struct {
+	Include        []TrackingRule `toml:"include" comment:"If not empty, only processes matching one of these rules are tracked"`
+	Exclude        []TrackingRule `toml:"exclude" comment:"Processes matching one of these rules are not tracked"`
+	MaxCommandLine int            `toml:"max-command-line" comment:"Maximum length (in bytes) of the command lines stored for tracked processes,\n longer ones being truncated (0: unlimited)"`
+	TruncateEvents bool           `toml:"truncate-events" comment:"Also truncate command lines of process creation events, rules\n then match on truncated command lines"`
+	MaxDrivers     int            `toml:"max-drivers" comment:"Maximum number of distinct drivers tracked (by image and sha256), when reached\n the drivers not loaded for the longest time are evicted (0: unlimited)"`
+}
+
+// CommandLineLimit returns the maximum length of command lines stored
+func (c *TrackingConfig) CommandLineLimit() int {
+	if c == nil {
+		return 0
+	}
+	return c.MaxCommandLine
+}
+
+// DriversLimit returns the maximum number of drivers tracked
+func (c *TrackingConfig) DriversLimit() int {
+	if c == nil {
+		return 0
+	}
+	return c.MaxDrivers
+}
+
+// Verify validates tracking configuration
+func (c *TrackingConfig) Verify() error {
+	if c == nil {
+		return nil
+	}
+
+	if c.MaxCommandLine < 0 {
+		return fmt.Errorf("maximum command line length must be positive")
+	}
+
+	if c.MaxDrivers < 0 {
+		return fmt.Errorf("maximum number of drivers must be positive")
+	}
+
+	for _, rules := range [][]TrackingRule{c.Include, c.Exclude} {
+		for _, r := range rules {
+			if r.Image == "" && r.Signer == "" {
+				return fmt.Errorf("tracking rule must have an image or a signer")
+			}
+			if _, err := filepath.Match(r.Image, ""); err != nil {
+				return fmt.Errorf("bad tracking image pattern %s: %w", r.Image, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// IsEnabled returns true if some processes may not be tracked
+func (c *TrackingConfig) IsEnabled() bool {
+	return c != nil && (len(c.Include) > 0 || len(c.Exclude) > 0)
+}
+
+// Track returns true if a process with image and signer has to be tracked
+func (c *TrackingConfig) Track(image, signer string) bool {
+	if !c.IsEnabled() {
+		return true
+	}
+
+	for _, r := range c.Exclude {
+		if r.match(image, signer) {
+			return false
+		}
+	}
+
+	if len(c.Include) == 0 {
+		return true
+	}
+
+	for _, r := range c.Include {
+		if r.match(image, signer) {
+			return true
+		}
+	}
+
+	return false
+}
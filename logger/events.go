@@ -5,20 +5,46 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/0xrawsec/golang-utils/datastructs"
 	"github.com/0xrawsec/whids/event"
 )
 
+// TimeBasis is the timestamp events are searched by
+type TimeBasis string
+
+const (
+	// TimeBasisEvent uses the time the event has been generated on the endpoint
+	TimeBasisEvent = TimeBasis("event")
+	// TimeBasisReceipt uses the time the event has been received by the manager
+	TimeBasisReceipt = TimeBasis("receipt")
+)
+
+// Validate returns an error if the time basis is unknown
+func (b TimeBasis) Validate() error {
+	switch b {
+	case TimeBasisEvent, TimeBasisReceipt:
+		return nil
+	}
+	return fmt.Errorf("unknown time basis: %s", b)
+}
+
 type RawEvent struct {
-	Timestamp time.Time
-	data      []byte
+	Timestamp   time.Time
+	ReceiptTime time.Time
+	data        []byte
+	// timestamp used to sort events
+	basis TimeBasis
 }
 
 func NewRawEvent(e *event.EdrEvent) (r *RawEvent, err error) {
 	r = &RawEvent{}
 	r.Timestamp = e.Timestamp()
+	if e.Event.EdrData != nil {
+		r.ReceiptTime = e.Event.EdrData.Event.ReceiptTime
+	}
 	r.data, err = json.Marshal(e)
 	return
 }
@@ -29,7 +55,16 @@ func DecodeRawEvent(b []byte) (r *RawEvent, err error) {
 
 	r = &RawEvent{}
 	if i = bytes.Index(b, []byte(":")); i != -1 {
-		if intTS, err = strconv.ParseInt(string(b[:i]), 10, 64); err != nil {
+		header := string(b[:i])
+		// receipt time is encoded after event timestamp if known
+		if j := strings.Index(header, ","); j != -1 {
+			if intTS, err = strconv.ParseInt(header[j+1:], 10, 64); err != nil {
+				return
+			}
+			r.ReceiptTime = time.Unix(0, intTS)
+			header = header[:j]
+		}
+		if intTS, err = strconv.ParseInt(header, 10, 64); err != nil {
 			return
 		}
 		r.Timestamp = time.Unix(0, intTS)
@@ -40,12 +75,24 @@ func DecodeRawEvent(b []byte) (r *RawEvent, err error) {
 	return
 }
 
+// Time returns the timestamp of the event according to time basis,
+// event timestamp is returned if receipt time is unknown
+func (e *RawEvent) Time(basis TimeBasis) time.Time {
+	if basis == TimeBasisReceipt && !e.ReceiptTime.IsZero() {
+		return e.ReceiptTime
+	}
+	return e.Timestamp
+}
+
 func (e *RawEvent) Less(other datastructs.Sortable) bool {
-	return e.Timestamp.Before(other.(*RawEvent).Timestamp)
+	return e.Time(e.basis).Before(other.(*RawEvent).Time(e.basis))
 }
 
 func (e *RawEvent) Encode() []byte {
 	header := fmt.Sprintf("%d:", e.Timestamp.UTC().UnixNano())
+	if !e.ReceiptTime.IsZero() {
+		header = fmt.Sprintf("%d,%d:", e.Timestamp.UTC().UnixNano(), e.ReceiptTime.UTC().UnixNano())
+	}
 	b := make([]byte, 0, len(e.data)+len(header))
 	b = append(b, []byte(header)...)
 	b = append(b, e.data...)
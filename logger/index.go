@@ -32,7 +32,7 @@ func OpenIndexFile(path string) (inf *IndexFile, err error) {
 // LogfilePaths returns the name of the IndexedLogFile
 // associated to the IndexFile
 func (inf *IndexFile) LogfilePath() string {
-	return strings.TrimSuffix(inf.path, IndexExt)
+	return strings.TrimSuffix(strings.TrimSuffix(inf.path, IndexExt), ReceiptIndexExt)
 }
 
 // Next returns the next IndexEntry
@@ -23,6 +23,12 @@ func RenameIndexedLogfile(old, new string) (lastErr error) {
 	if err := os.Rename(IndexFileFromPath(old), IndexFileFromPath(new)); err != nil {
 		lastErr = err
 	}
+	// receipt index does not exist for logfiles written by older versions
+	if fsutil.Exists(ReceiptIndexFileFromPath(old)) {
+		if err := os.Rename(ReceiptIndexFileFromPath(old), ReceiptIndexFileFromPath(new)); err != nil {
+			lastErr = err
+		}
+	}
 	return
 }
 
@@ -31,9 +37,14 @@ func RemoveIndexedLogfile(path string) (lastErr error) {
 	if err := os.Remove(path); err != nil {
 		lastErr = err
 	}
-	if err := os.Remove(path); err != nil {
+	if err := os.Remove(IndexFileFromPath(path)); err != nil {
 		lastErr = err
 	}
+	if fsutil.Exists(ReceiptIndexFileFromPath(path)) {
+		if err := os.Remove(ReceiptIndexFileFromPath(path)); err != nil {
+			lastErr = err
+		}
+	}
 	return
 }
 
@@ -53,6 +64,8 @@ type IndexedLogfile struct {
 	fd         *os.File
 	writer     *gzip.Writer
 	indexEntry IndexEntry
+	// index entry by receipt time
+	receiptEntry IndexEntry
 }
 
 // OpenIndexedLogfile opens an IndexedLogfile
@@ -75,17 +88,31 @@ func IndexFileFromPath(path string) string {
 	return fmt.Sprintf("%s%s", path, IndexExt)
 }
 
+// ReceiptIndexFileFromPath returns a standardized receipt time IndexFile
+// name from a path
+func ReceiptIndexFileFromPath(path string) string {
+	return fmt.Sprintf("%s%s", path, ReceiptIndexExt)
+}
+
 func (f *IndexedLogfile) resetIndexEntry() (err error) {
 	f.indexEntry = IndexEntry{}
+	f.receiptEntry = IndexEntry{}
 	if f.indexEntry.Offset, err = f.size(); err != nil {
 		return fmt.Errorf("failed to reset index entry: %w", err)
 	}
+	f.receiptEntry.Offset = f.indexEntry.Offset
 	return
 }
 
 // IndexFile returns the path of the IndexFile associated to the IndexedLogfile
 func (f *IndexedLogfile) IndexFile() string {
-	return fmt.Sprintf("%s%s", f.path, IndexExt)
+	return IndexFileFromPath(f.path)
+}
+
+// ReceiptIndexFile returns the path of the receipt time IndexFile associated
+// to the IndexedLogfile
+func (f *IndexedLogfile) ReceiptIndexFile() string {
+	return ReceiptIndexFileFromPath(f.path)
 }
 
 func (f *IndexedLogfile) size() (size int64, err error) {
@@ -179,9 +206,11 @@ func (f *IndexedLogfile) WriteRawEventWithTimestamp(e *RawEvent, timestamp time.
 	b = append(e.Encode(), '\n')
 	if n, err = f.writer.Write(b); err == nil {
 		f.indexEntry.UpdateTime(timestamp)
+		f.receiptEntry.UpdateTime(stdTime(e.Time(TimeBasisReceipt)))
 
 		// we increment the event counter
 		f.indexEntry.EventCount += 1
+		f.receiptEntry.EventCount += 1
 	}
 
 	return
@@ -192,25 +221,38 @@ func (f *IndexedLogfile) WriteRawEvent(e *RawEvent) (n int, err error) {
 	return f.WriteRawEventWithTimestamp(e, e.Timestamp)
 }
 
-func (f *IndexedLogfile) flush() (err error) {
+// writeIndexEntry appends an IndexEntry to an IndexFile
+func writeIndexEntry(path string, ie *IndexEntry) (err error) {
 	var indexFd *os.File
 
+	header := !fsutil.Exists(path)
+	if indexFd, err = os.OpenFile(path, os.O_APPEND|os.O_RDWR|os.O_CREATE, utils.DefaultPerms); err != nil {
+		return
+	}
+	defer indexFd.Close()
+
+	if header {
+		if _, err = indexFd.WriteString(IndexHeader + "\n"); err != nil {
+			return fmt.Errorf("failed to write index file: %w", err)
+		}
+	}
+
+	if _, err = indexFd.WriteString(ie.ToCSV() + "\n"); err != nil {
+		return fmt.Errorf("failed to write index file: %w", err)
+	}
+
+	return
+}
+
+func (f *IndexedLogfile) flush() (err error) {
 	// we commit to index file only if we wrote at least one event
 	if f.indexEntry.EventCount > 0 {
-		header := !fsutil.Exists(f.IndexFile())
-		if indexFd, err = os.OpenFile(f.IndexFile(), os.O_APPEND|os.O_RDWR|os.O_CREATE, utils.DefaultPerms); err != nil {
+		if err = writeIndexEntry(f.IndexFile(), &f.indexEntry); err != nil {
 			return
 		}
-		defer indexFd.Close()
-
-		if header {
-			if _, err = indexFd.WriteString(IndexHeader + "\n"); err != nil {
-				return fmt.Errorf("failed to write index file: %w", err)
-			}
-		}
 
-		if _, err = indexFd.WriteString(f.indexEntry.ToCSV() + "\n"); err != nil {
-			return fmt.Errorf("failed to write index file: %w", err)
+		if err = writeIndexEntry(f.ReceiptIndexFile(), &f.receiptEntry); err != nil {
+			return
 		}
 
 		if err = f.writer.Flush(); err != nil {
@@ -16,6 +16,8 @@ import (
 const (
 	// IndexExt is the file extension used for index files
 	IndexExt = ".index"
+	// ReceiptIndexExt is the file extension used for index files by receipt time
+	ReceiptIndexExt = ".rindex"
 	// IndexedLogfileExt is the file extension used by logfiles
 	IndexedLogfileExt = ".gz"
 
@@ -179,3 +179,49 @@ func TestEventLogger(t *testing.T) {
 	timeSearch(t, s, now.Add(-1*month), now.Add(1*month), key, 10000, 10000)
 	timeSearch(t, s, now.Add(-1*month), now.Add(1*month), key, 1000, 1000)
 }
+
+func TestSearchByReceiptTime(t *testing.T) {
+	root := "data/receipt"
+	key := "03e31275-2277-d8e0-bb5f-480fac7ee4ef"
+	nevents := 100
+
+	os.RemoveAll(root)
+	defer os.RemoveAll(root)
+
+	l := NewEventLogger(root, "logs.gz", utils.Mega*1)
+	// events generated with a skewed clock, received now
+	now := time.Now()
+	id := l.InitTransaction()
+	for e := range emitEvents(nevents, false) {
+		e.Event.System.TimeCreated.SystemTime = now.Add(-month)
+		e.Event.EdrData = &event.EdrData{}
+		e.Event.EdrData.Event.ReceiptTime = now
+		if _, err := l.WriteEvent(id, key, e); err != nil {
+			t.Errorf("cannot write event: %s", err)
+		}
+	}
+	l.CommitTransaction()
+	l.Close()
+
+	s := NewEventSearcher(root)
+	defer s.Close()
+
+	count := 0
+	for range s.EventsBy(TimeBasisReceipt, now.Add(-time.Hour), now.Add(time.Hour), key, nevents, 0) {
+		count++
+	}
+	if s.Err() != nil {
+		t.Error(s.Err())
+	}
+	if count != nevents {
+		t.Errorf("expected %d events received in the last hour, got %d", nevents, count)
+	}
+
+	count = 0
+	for range s.EventsBy(TimeBasisEvent, now.Add(-time.Hour), now.Add(time.Hour), key, nevents, 0) {
+		count++
+	}
+	if count != 0 {
+		t.Errorf("no event generated in the last hour expected, got %d", count)
+	}
+}
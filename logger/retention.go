@@ -0,0 +1,163 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// hourDir is a directory holding the logfiles of one hour of events
+type hourDir struct {
+	path  string
+	start time.Time
+	size  int64
+}
+
+// PruneResult holds the result of a pruning of the logfiles of a key
+type PruneResult struct {
+	RemovedDirs  int   `json:"removed-dirs"`
+	RemovedBytes int64 `json:"removed-bytes"`
+	Size         int64 `json:"size"`
+}
+
+// Keys returns the keys (i.e. endpoint identifiers) having logfiles
+func (l *EventLogger) Keys() (keys []string, err error) {
+	var entries []os.DirEntry
+
+	keys = make([]string, 0)
+	if entries, err = os.ReadDir(l.root); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			keys = append(keys, e.Name())
+		}
+	}
+
+	return
+}
+
+// DirSize returns the size in bytes of the regular files under dir
+func DirSize(dir string) (size int64) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
+// hourDirs returns the hour directories of a key, the oldest first
+func (l *EventLogger) hourDirs(key string) (dirs []hourDir, err error) {
+	var days []os.DirEntry
+
+	dirs = make([]hourDir, 0)
+	if days, err = os.ReadDir(filepath.Join(l.root, key)); err != nil {
+		return
+	}
+
+	for _, day := range days {
+		if !day.IsDir() {
+			continue
+		}
+
+		dayPath := filepath.Join(l.root, key, day.Name())
+		hours, err := os.ReadDir(dayPath)
+		if err != nil {
+			continue
+		}
+
+		for _, hour := range hours {
+			// directories not created by the logger are ignored
+			start, err := time.Parse("20060102/15", day.Name()+"/"+hour.Name())
+			if !hour.IsDir() || err != nil {
+				continue
+			}
+
+			path := filepath.Join(dayPath, hour.Name())
+			dirs = append(dirs, hourDir{path: path, start: start, size: DirSize(path)})
+		}
+	}
+
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].start.Before(dirs[j].start) })
+
+	return
+}
+
+// Size returns the size in bytes of the logfiles of a key
+func (l *EventLogger) Size(key string) int64 {
+	return DirSize(filepath.Join(l.root, key))
+}
+
+func (l *EventLogger) removeDir(path string) error {
+	// logfiles being written are closed at transaction commit so
+	// waiting for the current transaction is enough
+	l.Lock()
+	defer l.Unlock()
+
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+
+	// removing day directory if empty
+	os.Remove(filepath.Dir(path))
+	return nil
+}
+
+// Prune removes the logfiles of a key holding only events older than
+// before (if not zero) and then the oldest logfiles until the logfiles of
+// the key are not bigger than maxSize (if positive). Logfiles of the
+// last hour of events are never removed because of their size. The logger
+// is locked only while removing logfiles not to hold events logging.
+func (l *EventLogger) Prune(key string, before time.Time, maxSize int64) (r PruneResult, err error) {
+	var dirs []hourDir
+
+	if dirs, err = l.hourDirs(key); err != nil {
+		return
+	}
+
+	for _, d := range dirs {
+		r.Size += d.size
+	}
+
+	for i, d := range dirs {
+		expired := !before.IsZero() && !d.start.Add(tGranularity).After(before)
+		tooBig := maxSize > 0 && r.Size > maxSize && i < len(dirs)-1
+
+		if !expired && !tooBig {
+			break
+		}
+
+		if err = l.removeDir(d.path); err != nil {
+			return
+		}
+
+		r.RemovedDirs++
+		r.RemovedBytes += d.size
+		r.Size -= d.size
+	}
+
+	return
+}
+
+// Remove removes all the logfiles of a key and returns the number of bytes
+// removed
+func (l *EventLogger) Remove(key string) (size int64, err error) {
+	path := filepath.Join(l.root, key)
+	size = DirSize(path)
+
+	l.Lock()
+	defer l.Unlock()
+
+	if err = os.RemoveAll(path); err != nil {
+		size -= DirSize(path)
+	}
+
+	return
+}
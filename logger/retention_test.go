@@ -0,0 +1,90 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// makeHourDirs creates one hour directory per start holding a logfile of size
+// bytes, as the logger would do
+func makeHourDirs(t *testing.T, root, key string, size int, starts ...time.Time) {
+	for _, s := range starts {
+		dir := filepath.Join(root, key, s.Format("20060102"), s.Format("15"))
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if err := os.WriteFile(filepath.Join(dir, "logs.gz"), make([]byte, size), 0600); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	key := "03e31275-2277-d8e0-bb5f-480fac7ee4ef"
+	size := 1024
+	now := time.Now().UTC().Truncate(tGranularity)
+	starts := []time.Time{
+		now.Add(-3 * tGranularity),
+		now.Add(-2 * tGranularity),
+		now.Add(-tGranularity),
+		now,
+	}
+
+	for _, tc := range []struct {
+		name    string
+		before  time.Time
+		maxSize int64
+		removed int
+	}{
+		{"nothing", time.Time{}, 0, 0},
+		// hour ending exactly at before is expired
+		{"max age boundary", now.Add(-tGranularity), 0, 2},
+		// hour ending right after before is kept
+		{"max age inside hour", now.Add(-tGranularity - time.Second), 0, 1},
+		{"max size not reached", time.Time{}, int64(4 * size), 0},
+		{"max size boundary", time.Time{}, int64(3 * size), 1},
+		{"max size exceeded", time.Time{}, int64(2*size + 1), 2},
+		// last hour of events is never removed because of its size
+		{"max size keeps last hour", time.Time{}, 1, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			makeHourDirs(t, root, key, size, starts...)
+
+			l := NewEventLogger(root, "logs.gz", 0)
+			defer l.Close()
+
+			r, err := l.Prune(key, tc.before, tc.maxSize)
+			if err != nil {
+				t.Error(err)
+				t.FailNow()
+			}
+
+			if r.RemovedDirs != tc.removed {
+				t.Errorf("Unexpected number of directories removed: %d", r.RemovedDirs)
+			}
+
+			if r.RemovedBytes != int64(tc.removed*size) || r.Size != int64((len(starts)-tc.removed)*size) {
+				t.Errorf("Unexpected prune result: %+v", r)
+			}
+
+			if s := l.Size(key); s != r.Size {
+				t.Errorf("Size on disk %d does not match prune result %d", s, r.Size)
+			}
+
+			dirs, err := l.hourDirs(key)
+			if err != nil {
+				t.Error(err)
+				t.FailNow()
+			}
+			// the newest hours must be the ones kept
+			if len(dirs) == 0 || !dirs[len(dirs)-1].start.Equal(now) {
+				t.Error("Last hour of events must be kept")
+			}
+		})
+	}
+}
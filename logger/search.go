@@ -84,8 +84,56 @@ func (s *EventSearcher) keys() (keys []string, err error) {
 	return
 }
 
-func (s *EventSearcher) buildIndex(start, stop time.Time, key string) (index *datastructs.SortedSlice, err error) {
+// indexFile appends to index the entries of index file at path relevant
+// to the time range
+func indexFile(index *datastructs.SortedSlice, path string, start, stop time.Time) (err error) {
 	var ifd *IndexFile
+
+	// opening index file
+	if ifd, err = OpenIndexFile(path); err != nil {
+		return
+	}
+	// appending index entries
+	for indexEntry, err := ifd.Next(); indexEntry != nil && err == nil; indexEntry, err = ifd.Next() {
+		// we append to the index only if it is relevant to our search
+		if indexEntry.Overlaps(start, stop) {
+			index.Insert(indexEntry)
+		}
+	}
+	// closing index file
+	ifd.Close()
+	return
+}
+
+// buildReceiptIndex builds an index of events received in the time range.
+// As logfiles are stored by event time, events received in the time range
+// can be in any directory. Index files not modified since start cannot
+// contain any event received in the time range.
+func (s *EventSearcher) buildReceiptIndex(start, stop time.Time, keys []string) (index *datastructs.SortedSlice, err error) {
+	index = datastructs.NewSortedSlice()
+
+	for _, key := range keys {
+		root := filepath.Join(s.root, key)
+		if !fsutil.IsDir(root) {
+			continue
+		}
+		for wi := range fswalker.Walk(root) {
+			for _, fi := range wi.Files {
+				if !strings.HasSuffix(fi.Name(), ReceiptIndexExt) || fi.ModTime().Before(start) {
+					continue
+				}
+				if err = indexFile(index, filepath.Join(wi.Dirpath, fi.Name()), start, stop); err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	reverseIndex(index)
+	return
+}
+
+func (s *EventSearcher) buildIndex(start, stop time.Time, key string, basis TimeBasis) (index *datastructs.SortedSlice, err error) {
 	var keys []string
 
 	start = stdTime(start)
@@ -101,6 +149,10 @@ func (s *EventSearcher) buildIndex(start, stop time.Time, key string) (index *da
 		keys = []string{key}
 	}
 
+	if basis == TimeBasisReceipt {
+		return s.buildReceiptIndex(start, stop, keys)
+	}
+
 	// we add our tGranularity to stop to make sure we cover all events
 	for t := start; t.Before(stop.Add(tGranularity)); t = t.Add(tGranularity) {
 		for _, key := range keys {
@@ -115,20 +167,7 @@ func (s *EventSearcher) buildIndex(start, stop time.Time, key string) (index *da
 								if key != "" && !strings.Contains(path, key) {
 									continue
 								}
-								// opening index file
-								if ifd, err = OpenIndexFile(path); err != nil {
-									return
-								}
-								// appending index entries
-								for indexEntry, err := ifd.Next(); indexEntry != nil && err == nil; indexEntry, err = ifd.Next() {
-									// we append to the index only if it is relevant to our search
-									if indexEntry.Overlaps(start, stop) {
-										index.Insert(indexEntry)
-									}
-								}
-								// closing index file
-								ifd.Close()
-								if err != nil {
+								if err = indexFile(index, path, start, stop); err != nil {
 									return
 								}
 							}
@@ -183,8 +222,14 @@ func (s *EventSearcher) readRawEvents(ie *IndexEntry) (events []*RawEvent, err e
 	return
 }
 
-// Events returns a channel of RawEvents
+// Events returns a channel of RawEvents generated in the time range
 func (s *EventSearcher) Events(start, stop time.Time, key string, count, skip int) (c chan *RawEvent) {
+	return s.EventsBy(TimeBasisEvent, start, stop, key, count, skip)
+}
+
+// EventsBy returns a channel of RawEvents in the time range, the timestamp
+// of the events being chosen by basis (event generation or receipt time)
+func (s *EventSearcher) EventsBy(basis TimeBasis, start, stop time.Time, key string, count, skip int) (c chan *RawEvent) {
 	var index *datastructs.SortedSlice
 	c = make(chan *RawEvent)
 
@@ -193,7 +238,7 @@ func (s *EventSearcher) Events(start, stop time.Time, key string, count, skip in
 	stop = stdTime(stop)
 
 	// if we fail at building the index
-	if index, s.err = s.buildIndex(start, stop, key); s.err != nil {
+	if index, s.err = s.buildIndex(start, stop, key, basis); s.err != nil {
 		close(c)
 		return
 	}
@@ -231,11 +276,13 @@ func (s *EventSearcher) Events(start, stop time.Time, key string, count, skip in
 				//for _, evt := range tmpEvents {
 				for k := 0; k < len(tmpEvents) && countEvents < count; k++ {
 					evt := tmpEvents[k]
+					evt.basis = basis
 					if skip > 0 {
 						skip--
 						continue
 					}
-					if (start.Before(evt.Timestamp) && stop.After(evt.Timestamp)) || evt.Timestamp == start || evt.Timestamp == stop {
+					ts := evt.Time(basis)
+					if (start.Before(ts) && stop.After(ts)) || ts.Equal(start) || ts.Equal(stop) {
 						events.Insert(evt)
 						countEvents++
 					}
@@ -29,6 +29,8 @@ var (
 		`(?s:System\sMonitor\s(?P<version>v\d+\.\d+)\s-[.\s].*` +
 			`<manifest schemaversion="(?P<schemaversion>\d+\.\d+)" binaryversion="(?P<binaryversion>\d+\.\d+)">)`,
 	)
+
+	archiveDirRe = regexp.MustCompile(`(?mi:^\s*-?\s*Archive\s?Directory:\s*(?P<dir>.*?)\s*$)`)
 )
 
 func Install(image string) (err error) {
@@ -162,25 +164,58 @@ func (i *Info) ConfigHash() string {
 	return hash
 }
 
+// output runs Sysmon binary with args and returns its output
+func (i *Info) output(args ...string) (out []byte, err error) {
+	if !fsutil.IsFile(i.Service.Image) {
+		return nil, ErrSysmonNotInstalled
+	}
+
+	c := command.CommandTimeout(
+		time.Second*2,
+		i.Service.Image,
+		args...,
+	)
+	defer c.Terminate()
+
+	if out, err = c.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to run sysmon command: %s", err)
+	}
+
+	if len(out) >= 2 {
+		// check UTF16 BOM
+		if out[1] == '\xfe' && out[0] == '\xff' {
+			out = []byte(win32.UTF16BytesToString(out))
+		}
+	}
+
+	return
+}
+
+// ArchiveDirectory returns the archive directory of the current Sysmon
+// configuration, an empty string is returned if archiving is not configured
+func (i *Info) ArchiveDirectory() (dir string, err error) {
+	var out []byte
+
+	if out, err = i.output("-c"); err != nil {
+		return
+	}
+
+	if m := archiveDirRe.FindSubmatch(out); m != nil {
+		dir = string(m[1])
+		// Sysmon prints a dash when no archive directory is configured
+		if dir == "-" {
+			dir = ""
+		}
+	}
+
+	return
+}
+
 func (i *Info) parseSchema() {
 	sh := submatch.NewHelper(versionRe)
 
 	if fsutil.IsFile(i.Service.Image) {
-
-		c := command.CommandTimeout(
-			time.Second*2,
-			i.Service.Image,
-			"-s",
-		)
-		defer c.Terminate()
-
-		if out, err := c.CombinedOutput(); err == nil {
-			if len(out) >= 2 {
-				// check UTF16 BOM
-				if out[1] == '\xfe' && out[0] == '\xff' {
-					out = []byte(win32.UTF16BytesToString(out))
-				}
-			}
+		if out, err := i.output("-s"); err == nil {
 			sh.Prepare(out)
 			if v, err := sh.GetBytes("version"); err == nil {
 				i.Version = string(v)
@@ -192,7 +227,7 @@ func (i *Info) parseSchema() {
 				i.Config.Version.Binary = string(bv)
 			}
 		} else {
-			i.Err = fmt.Errorf("failed to run sysmon command: %s", err)
+			i.Err = err
 		}
 	}
 }
@@ -43,12 +43,18 @@ var (
 
 	simpleManagerConfig = api.ManagerConfig{
 		AdminAPI: api.AdminAPIConfig{
-			Host: "localhost",
-			Port: api.AdmAPIDefaultPort,
+			Host:              "localhost",
+			Port:              api.AdmAPIDefaultPort,
+			ArchiveMaxLimit:   api.DefaultArchiveMaxLimit,
+			StreamCompression: true,
 		},
 		EndpointAPI: api.EndpointAPIConfig{
-			Host: "0.0.0.0",
-			Port: api.EptAPIDefaultPort,
+			Host:               "0.0.0.0",
+			Port:               api.EptAPIDefaultPort,
+			IngestionWorkers:   api.DefaultIngestionWorkers,
+			IngestionQueue:     api.DefaultIngestionQueue,
+			KeyRotationOverlap: api.DefaultKeyRotationOverlap,
+			BatchDedupWindow:   api.DefaultBatchDedupWindow,
 		},
 		Logging: api.ManagerLogConfig{
 			Root:        "./data/logs",
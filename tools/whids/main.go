@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -50,23 +51,41 @@ var (
 
 	logDir = filepath.Join(abs, "Logs")
 
+	bootstrapLog = filepath.Join(abs, "bootstrap.log")
+
+	defaultSyslogFacility     = api.DefaultSyslogFacility
+	defaultIntegrityThreshold = hids.DefaultIntegrityThreshold
+
 	// DefaultHIDSConfig is the default HIDS configuration
 	DefaultHIDSConfig = hids.Config{
 		RulesConfig: &hids.RulesConfig{
 			RulesDB:        filepath.Join(abs, "Database", "Rules"),
 			ContainersDB:   filepath.Join(abs, "Database", "Containers"),
 			UpdateInterval: 60 * time.Second,
+			ShadowDB:       filepath.Join(abs, "Database", "Shadow"),
 		},
 
 		FwdConfig: &api.ForwarderConfig{
 			Local: true,
 			Client: api.ClientConfig{
-				MaxUploadSize: api.DefaultMaxUploadSize,
+				MaxUploadSize:   api.DefaultMaxUploadSize,
+				MaxRetryBackoff: api.DefaultMaxRetryBackoff,
+				BatchSize:       api.DefaultBatchSize,
+				FlushInterval:   api.DefaultFlushInterval,
 			},
 			Logging: api.LoggingConfig{
 				Dir:              filepath.Join(logDir, "Alerts"),
 				RotationInterval: time.Hour * 5,
 			},
+			Syslog: &api.SyslogConfig{
+				Enable:    false,
+				Format:    api.SyslogFormatRFC5424,
+				Transport: api.SyslogTransportUDP,
+				Port:      514,
+				Facility:  &defaultSyslogFacility,
+				Fields:    api.DefaultSyslogFields,
+				QueueSize: api.DefaultSyslogQueueSize,
+			},
 		},
 		EtwConfig: &hids.EtwConfig{
 			Providers: []string{
@@ -75,31 +94,51 @@ var (
 				"Microsoft-Windows-PowerShell",
 				"Microsoft-Antimalware-Scan-Interface",
 			},
-			Traces: []string{"Eventlog-Security"},
+			Traces:               []string{"Eventlog-Security"},
+			MaxLiveTraceDuration: hids.DefaultMaxLiveTraceDuration,
 		},
 		Sysmon: &hids.SysmonConfig{
 			Bin:              "C:\\Windows\\Sysmon64.exe",
 			ArchiveDirectory: "C:\\Sysmon\\",
 			CleanArchived:    true,
+			ClipboardMaxSize: hids.DefaultClipboardMaxSize,
+			ArchivePolicy:    hids.ArchivePolicyWarn,
 		},
 		Actions: &hids.ActionsConfig{
-			AvailableActions: hids.AvailableActions,
-			Low:              []string{},
-			Medium:           []string{"brief", "filedump", "regdump"},
-			High:             []string{"report", "filedump", "regdump"},
-			Critical:         []string{"report", "filedump", "regdump", "memdump"},
+			AvailableActions:  hids.AvailableActions,
+			Low:               []string{},
+			Medium:            []string{"brief", "filedump", "regdump"},
+			High:              []string{"report", "filedump", "regdump"},
+			Critical:          []string{"report", "filedump", "regdump", "memdump"},
+			MaxConcurrentJobs: hids.DefaultMaxConcurrentJobs,
+			MemdumpTimeout:    hids.DefaultMemdumpTimeout,
+			MemdumpAncestors:  hids.DefaultMemdumpAncestors,
+			ProtectChildren:   true,
+			RuleActions:       hids.RuleActionsMerge,
+			MissingGUID:       hids.MissingGUIDResolve,
 		},
 		Dump: &hids.DumpConfig{
 			Dir:           filepath.Join(abs, "Dumps"),
 			Compression:   true,
+			Format:        utils.CompressionGzip,
+			Level:         0,
 			MaxDumps:      4,
 			DumpUntracked: false,
+			Exclude:       []string{},
+			MinFreeSpace:  0,
+			PruneOldest:   false,
+			HashFields:    []string{},
+
+			MaxDumpedEntries: hids.DefaultMaxDumpedEntries,
+			WriteFailure:     hids.DumpWriteFailureNone,
 		},
 		Report: &hids.ReportConfig{
 			EnableReporting: false,
 			OSQuery: hids.OSQueryConfig{
-				Bin:    "C:\\Program Files\\osquery\\osqueryi.exe",
-				Tables: []string{"processes", "services", "scheduled_tasks", "drivers", "startup_items", "process_open_sockets"}},
+				Bin:     "C:\\Program Files\\osquery\\osqueryi.exe",
+				Socket:  "",
+				Tables:  []string{"processes", "services", "scheduled_tasks", "drivers", "startup_items", "process_open_sockets"},
+				Queries: []hids.OSQueryQuery{}},
 			Commands: []hids.ReportCommand{{
 				Description: "Example command",
 				Name:        "osqueryi.exe",
@@ -107,9 +146,11 @@ var (
 				ExpectJSON:  true,
 			}},
 			CommandTimeout: 60 * time.Second,
+			MaxTime:        120 * time.Second,
 		},
 		AuditConfig: &hids.AuditConfig{
-			AuditPolicies: []string{"File System"},
+			AuditPolicies:  []string{"File System"},
+			VerifyInterval: hids.DefaultAuditVerifyInterval,
 		},
 		CanariesConfig: &hids.CanariesConfig{
 			Enable: false,
@@ -126,11 +167,97 @@ var (
 				"C:\\Windows\\explorer.exe",
 			},
 		},
+		Isolation: &hids.IsolationConfig{
+			AllowedAddresses: []string{},
+			StateFile:        filepath.Join(abs, "Database", "isolation.wfw"),
+		},
+		Redaction: &hids.RedactionConfig{
+			Enable: false,
+			Mask:   hids.DefaultRedactionMask,
+			Rules: []*hids.RedactionRule{
+				{
+					Fields:  []string{"/Event/EventData/CommandLine", "/Event/EventData/ParentCommandLine"},
+					Pattern: `(?i)(password|passwd|pwd)[=:\s]+\S+`,
+				},
+			},
+		},
+		FileAnomaly: &hids.FileAnomalyConfig{
+			Enable:           false,
+			Window:           hids.DefaultFileAnomalyWindow,
+			MinFiles:         hids.DefaultFileAnomalyMinFiles,
+			MinExtensions:    hids.DefaultFileAnomalyMinExtensions,
+			MinSameExtension: hids.DefaultFileAnomalyMinSameExtension,
+			CommonExtensions: hids.DefaultCommonExtensions,
+		},
+		Dedup: &hids.DedupConfig{
+			Enable: false,
+			Window: hids.DefaultDedupWindow,
+			Fields: []string{},
+		},
+		CommandAllowlist: &hids.CommandAllowlistConfig{
+			Paths:  []string{},
+			Sha256: []string{},
+		},
+		Sampling: &hids.SamplingConfig{
+			Enable: false,
+			Rules:  []hids.SamplingRule{},
+		},
+		Denylist: &hids.DenylistConfig{
+			Enable: false,
+			Rules:  []hids.DenylistRule{},
+		},
+		Attack: &hids.AttackConfig{
+			Enable:    false,
+			Source:    hids.AttackSourceMeta,
+			TagPrefix: hids.DefaultAttackTagPrefix,
+		},
+		Integrity: &hids.IntegrityConfig{
+			Enable:      false,
+			Threshold:   &defaultIntegrityThreshold,
+			Criticality: 8,
+			Allowlist:   []string{},
+		},
+		RuleProfiling: &hids.RuleProfilingConfig{
+			Enable:     false,
+			SampleRate: hids.DefaultProfilingSampleRate,
+			Top:        hids.DefaultProfilingTop,
+		},
+		Health: &hids.HealthConfig{
+			Enable: false,
+			Listen: hids.DefaultHealthListen,
+		},
+		RecentEvents: &hids.RecentEventsConfig{
+			Size: 0,
+		},
+		RawForwarding: &hids.RawForwardingConfig{
+			Channels:    []string{},
+			Destination: hids.RawDestinationForwarder,
+			File:        filepath.Join(logDir, "raw.json"),
+			MaxFileSize: hids.DefaultRawMaxFileSize,
+		},
+		Network: &hids.NetworkConfig{
+			Internal: hids.DefaultInternalNetworks,
+		},
+		Tracking: &hids.TrackingConfig{
+			Include:        []hids.TrackingRule{},
+			Exclude:        []hids.TrackingRule{},
+			MaxCommandLine: 0,
+			TruncateEvents: false,
+			MaxDrivers:     hids.DefaultMaxDrivers,
+		},
+		EventSize: &hids.EventSizeConfig{
+			MaxSize:          0,
+			Preserve:         hids.DefaultPreservedFields,
+			PreservedMaxSize: hids.DefaultPreservedMaxSize,
+		},
 		CritTresh:       5,
 		Logfile:         filepath.Join(logDir, "whids.log"),
+		LogFormat:       hids.LogFormatText,
 		EnableHooks:     true,
 		EnableFiltering: true,
 		Endpoint:        true,
+		MaxTracked:      100000,
+		Jitter:          hids.DefaultJitter,
 		LogAll:          false}
 )
 
@@ -146,10 +273,14 @@ var (
 	flagProfile    bool
 	flagRestore    bool
 	flagAutologger bool
+	flagPrune      bool
+	flagJSON       bool
 
 	hostIDS *hids.HIDS
 
 	importRules string
+	benchmark   string
+	exportDumps string
 
 	config = filepath.Join(abs, "config.toml")
 
@@ -222,6 +353,10 @@ func runHids(service bool) {
 
 	hostIDS.DryRun = flagDryRun
 	hostIDS.PrintAll = flagPrintAll
+	hostIDS.Version = version
+	hostIDS.CommitID = commitID
+	hostIDS.BootstrapLog = bootstrapLog
+	hostIDS.ConfigPath = config
 
 	// If not a service we need to be able to stop the HIDS
 	if !service {
@@ -242,6 +377,185 @@ func runHids(service bool) {
 	}
 }
 
+func runBenchmark(c *hids.Config) {
+	// logs must be printed to the console
+	c.Logfile = ""
+
+	bench, err := hids.NewBenchmarkHIDS(c)
+	if err != nil {
+		log.Abort(exitFail, fmt.Errorf("failed to create HIDS: %s", err))
+	}
+	bench.PrintAll = flagPrintAll
+
+	events, err := hids.BenchmarkEvents(benchmark)
+	if err != nil {
+		log.Abort(exitFail, fmt.Errorf("failed to read events: %s", err))
+	}
+
+	r := bench.Benchmark(events)
+
+	log.Infof("Events replayed: %d", r.Events)
+	log.Infof("Detections: %d", r.Detections)
+	log.Infof("Filtered: %d", r.Filtered)
+	log.Infof("Dropped (denylist): %d", r.Dropped)
+	log.Infof("Duration: %s", r.Duration)
+	log.Infof("Average Event Rate: %.2f EPS", r.EPS)
+	for name, count := range r.RuleMatches {
+		log.Infof("Rule %s matched: %d", name, count)
+	}
+	for _, hs := range append(r.PreHooks, r.PostHooks...) {
+		if hs.Calls > 0 {
+			log.Infof("Hook %s calls=%d total=%s average=%s", hs.Name, hs.Calls, hs.Time, hs.Time/time.Duration(hs.Calls))
+		}
+	}
+	for _, rp := range r.SlowestRules {
+		log.Infof("Rule %s evaluations=%d total=%s average=%s", rp.Name, rp.Evaluations, rp.Total, rp.Average)
+	}
+}
+
+// ImportResult is the summary of a rules import printed with -json
+type ImportResult struct {
+	Rules   int      `json:"rules"`
+	Sha256  string   `json:"sha256"`
+	Skipped []string `json:"skipped"`
+	Output  string   `json:"output"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// disabledRules returns the names of the disabled rules found in the rule
+// files under path, those rules being skipped by the engine
+func disabledRules(path string) (names []string, err error) {
+	names = make([]string, 0)
+
+	err = filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || !engine.DefaultRuleExtensions.Contains(filepath.Ext(p)) {
+			return err
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		dec := json.NewDecoder(f)
+		for {
+			var r engine.Rule
+			if err := dec.Decode(&r); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("failed to decode rule file %s: %w", p, err)
+			}
+			if r.IsDisabled() {
+				names = append(names, r.Name)
+			}
+		}
+	})
+
+	return
+}
+
+func importRulesFrom(c *hids.Config, r *ImportResult) (err error) {
+	eng := engine.NewEngine()
+	eng.SetDumpRaw(true)
+
+	if err = eng.LoadDirectory(importRules); err != nil {
+		return
+	}
+
+	if r.Skipped, err = disabledRules(importRules); err != nil {
+		return
+	}
+
+	prules, psha256 := c.RulesConfig.RulesPaths()
+	rules := new(bytes.Buffer)
+	for rule := range eng.GetRawRule(".*") {
+		if _, err = rules.Write([]byte(rule + "\n")); err != nil {
+			return
+		}
+	}
+
+	r.Sha256 = data.Sha256(rules.Bytes())
+
+	if err = ioutil.WriteFile(prules, rules.Bytes(), utils.DefaultPerms); err != nil {
+		return
+	}
+
+	if err = ioutil.WriteFile(psha256, []byte(r.Sha256), utils.DefaultPerms); err != nil {
+		return
+	}
+
+	r.Rules = eng.Count()
+	r.Output = prules
+
+	return
+}
+
+func runImportRules(c *hids.Config) {
+	r := ImportResult{Skipped: make([]string, 0)}
+
+	// in order not to write logs into file
+	// TODO: add a stream handler to log facility
+	c.Logfile = ""
+
+	// only errors are logged not to mix logs with JSON output
+	if flagJSON {
+		log.InitLogger(log.LError)
+	}
+
+	log.Infof("Importing rules from %s", importRules)
+	err := importRulesFrom(c, &r)
+
+	if flagJSON {
+		if err != nil {
+			r.Error = err.Error()
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(r); err != nil {
+			log.Abort(exitFail, fmt.Sprintf("Failed to encode import result: %s", err))
+		}
+		if r.Error != "" {
+			os.Exit(exitFail)
+		}
+		return
+	}
+
+	if err != nil {
+		log.Abort(exitFail, fmt.Sprintf("Failed to import rules: %s", err))
+	}
+
+	for _, name := range r.Skipped {
+		log.Warnf("Rule skipped (disabled): %s", name)
+	}
+	log.Infof("Rules imported: %d", r.Rules)
+	log.Infof("IMPORT SUCCESSFUL: %s", r.Output)
+}
+
+func runExportDumps(c *hids.Config) {
+	log.Infof("Exporting dumps from %s", c.Dump.Dir)
+
+	archive, m, err := hids.ExportDumps(c, exportDumps, flagPrune)
+	if err != nil {
+		log.Abort(exitFail, fmt.Errorf("failed to export dumps: %s", err))
+	}
+
+	files := 0
+	for _, e := range m.Events {
+		files += len(e.Files)
+	}
+
+	log.Infof("Events exported: %d", len(m.Events))
+	log.Infof("Files exported: %d", files)
+	if len(m.Skipped) > 0 {
+		log.Warnf("Entries skipped: %d", len(m.Skipped))
+	}
+	if flagPrune {
+		log.Infof("Exported dumps pruned")
+	}
+	log.Infof("EXPORT SUCCESSFUL: %s", archive)
+}
+
 func proctectDir(dir string) {
 	var out []byte
 	var err error
@@ -279,6 +593,10 @@ func main() {
 	flag.BoolVar(&flagRestore, "restore", flagRestore, "Restore Audit Policies and File System Audit ACLs according to configuration file")
 	flag.StringVar(&config, "c", config, "Configuration file")
 	flag.StringVar(&importRules, "import", importRules, "Import rules")
+	flag.BoolVar(&flagJSON, "json", flagJSON, "Print a JSON summary of -import on stdout, only errors are logged")
+	flag.StringVar(&exportDumps, "export-dumps", exportDumps, "Package local dumps, along with an index manifest, into a zip archive created in the directory given as argument")
+	flag.BoolVar(&flagPrune, "prune", flagPrune, "Delete dumps successfully exported with -export-dumps")
+	flag.StringVar(&benchmark, "benchmark", benchmark, "Replay events from an EVTX or JSON lines file through the engine (no action taken) and report performance statistics")
 
 	flag.Usage = func() {
 		printInfo(os.Stderr)
@@ -386,41 +704,24 @@ func main() {
 
 	// has to be there so that we print logs to stdout
 	if importRules != "" {
-		// in order not to write logs into file
-		// TODO: add a stream handler to log facility
-		hidsConf.Logfile = ""
-		log.Infof("Importing rules from %s", importRules)
-		eng := engine.NewEngine()
-		eng.SetDumpRaw(true)
-
-		if err := eng.LoadDirectory(importRules); err != nil {
-			log.Abort(exitFail, fmt.Sprintf("Failed to import rules: %s", err))
-		}
-
-		prules, psha256 := hidsConf.RulesConfig.RulesPaths()
-		rules := new(bytes.Buffer)
-		for rule := range eng.GetRawRule(".*") {
-			if _, err := rules.Write([]byte(rule + "\n")); err != nil {
-				log.Abort(exitFail, fmt.Sprintf("Failed to import rules: %s", err))
-			}
-		}
-
-		if err := ioutil.WriteFile(prules, rules.Bytes(), utils.DefaultPerms); err != nil {
-			log.Abort(exitFail, fmt.Sprintf("Failed to import rules: %s", err))
-		}
+		runImportRules(&hidsConf)
+		os.Exit(exitSuccess)
+	}
 
-		if err := ioutil.WriteFile(psha256, []byte(data.Sha256(rules.Bytes())), utils.DefaultPerms); err != nil {
-			log.Abort(exitFail, fmt.Sprintf("Failed to import rules: %s", err))
-		}
+	if exportDumps != "" {
+		runExportDumps(&hidsConf)
+		os.Exit(exitSuccess)
+	}
 
-		log.Infof("IMPORT SUCCESSFUL: %s", prules)
-		os.Exit(0)
+	if benchmark != "" {
+		runBenchmark(&hidsConf)
+		os.Exit(exitSuccess)
 	}
 
 	// If it is called by the Windows Service Manager (not interactive)
 	if !isIntSess {
 		// set logfile the time the service starts
-		log.SetLogfile(filepath.Join(abs, "bootstrap.log"))
+		log.SetLogfile(bootstrapLog)
 
 		// if running as service we protect installation directory with appropriate ACLs
 		if fsutil.IsDir(abs) {
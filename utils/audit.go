@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"encoding/csv"
 	"fmt"
 	"os/exec"
+	"strings"
 
 	"github.com/0xrawsec/whids/utils/powershell"
 )
@@ -116,6 +118,49 @@ func SetAuditPolicy(subCatOrGuid string, success, failure bool) error {
 	return exec.Command("auditpol", args...).Run()
 }
 
+// GetAuditPolicy returns the current success and failure auditing settings
+// of an audit policy subcategory
+func GetAuditPolicy(subCatOrGuid string) (success, failure bool, err error) {
+	var guid string
+	var out []byte
+	var records [][]string
+
+	if guid = resolveSubcategory(subCatOrGuid); guid == "" {
+		err = fmt.Errorf("Unknown Audit Policy subcategory: %s", subCatOrGuid)
+		return
+	}
+
+	if out, err = exec.Command("auditpol", "/get", fmt.Sprintf("/subcategory:%s", guid), "/r").Output(); err != nil {
+		return
+	}
+
+	// output is CSV: Machine Name,Policy Target,Subcategory,Subcategory GUID,Inclusion Setting,Exclusion Setting
+	r := csv.NewReader(strings.NewReader(strings.TrimSpace(string(out))))
+	r.FieldsPerRecord = -1
+	if records, err = r.ReadAll(); err != nil {
+		return
+	}
+
+	for _, rec := range records {
+		if len(rec) > 4 && strings.EqualFold(rec[3], guid) {
+			setting := strings.ToLower(rec[4])
+			success = strings.Contains(setting, "success")
+			failure = strings.Contains(setting, "failure")
+			return
+		}
+	}
+
+	err = fmt.Errorf("Audit Policy subcategory not found in auditpol output: %s", subCatOrGuid)
+	return
+}
+
+// IsAuditPolicyEnabled returns true if both success and failure auditing
+// are enabled for an audit policy subcategory
+func IsAuditPolicyEnabled(subCatOrGuid string) (bool, error) {
+	success, failure, err := GetAuditPolicy(subCatOrGuid)
+	return success && failure, err
+}
+
 func EnableAuditPolicy(subCatOrGuid string) error {
 	return SetAuditPolicy(subCatOrGuid, true, true)
 }
@@ -164,6 +209,29 @@ const (
 	}
 	`
 
+	funcHasAuditACL = `Function HasAudit-ACL {
+	[cmdletbinding()]
+	Param (
+	[string]$TargetFolder,
+	[string]$AuditUser,
+	[string]$AuditRules,
+	[string]$InheritType,
+	[string]$AuditType
+	)
+	$AccessRule = New-Object System.Security.AccessControl.FileSystemAuditRule($AuditUser,$AuditRules,$InheritType,"None",$AuditType)
+	$ACL = Get-Acl -Audit $TargetFolder
+	foreach ( $a in $ACL.Audit )
+	{ 
+		if ( $a.FileSystemRights -eq $AccessRule.FileSystemRights -And $a.AuditFlags -eq $AccessRule.AuditFlags -And $a.IdentityReference -eq $AccessRule.IdentityReference)
+		{
+			return $true
+		}
+	}
+	return $false
+	}
+	`
+
+	hasAuditACLFmt    = `HasAudit-ACL -TargetFolder "%s" -AuditUser "Everyone" -AuditRules "Delete,DeleteSubdirectoriesAndFiles,Modify,ChangePermissions,Takeownership" -InheritType "ContainerInherit,ObjectInherit" -AuditType "Success, Failure"`
 	setAuditACLFmt    = `SetAudit-ACL -TargetFolder "%s" -AuditUser "Everyone" -AuditRules "Delete,DeleteSubdirectoriesAndFiles,Modify,ChangePermissions,Takeownership" -InheritType "ContainerInherit,ObjectInherit" -AuditType "Success, Failure"`
 	removeAuditACLFmt = `RemoveAudit-ACL -TargetFolder "%s" -AuditUser "Everyone" -AuditRules "Delete,DeleteSubdirectoriesAndFiles,Modify,ChangePermissions,Takeownership" -InheritType "ContainerInherit,ObjectInherit" -AuditType "Success, Failure"`
 )
@@ -205,3 +273,16 @@ func RemoveEDRAuditACL(directories ...string) (err error) {
 
 	return p.Exit()
 }
+
+// HasEDRAuditACL returns true if the File System Audit ACL set by
+// SetEDRAuditACL is present on directory
+func HasEDRAuditACL(directory string) (ok bool, err error) {
+	var out []byte
+
+	script := funcHasAuditACL + "\n" + fmt.Sprintf(hasAuditACLFmt, StdDir(directory))
+	if out, err = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script).Output(); err != nil {
+		return
+	}
+
+	return strings.EqualFold(strings.TrimSpace(string(out)), "true"), nil
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff computes exponentially growing delays, randomized to prevent
+// many clients from retrying at the same time
+type Backoff struct {
+	Base time.Duration
+	Max  time.Duration
+
+	attempt uint
+}
+
+// NewBackoff creates a new Backoff starting at base and capped to max
+func NewBackoff(base, max time.Duration) *Backoff {
+	return &Backoff{Base: base, Max: max}
+}
+
+// Next returns the delay to wait before the next attempt. The delay is
+// randomly chosen between half and the whole of the exponential delay.
+func (b *Backoff) Next() time.Duration {
+	d := b.Base << b.attempt
+	// we check for overflow as well
+	if d > b.Max || d <= 0 {
+		d = b.Max
+	} else {
+		b.attempt++
+	}
+
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// Reset resets the backoff after a successful attempt
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
@@ -3,6 +3,7 @@ package command
 import (
 	"context"
 	"os/exec"
+	"sync/atomic"
 	"time"
 )
 
@@ -10,22 +11,73 @@ type Cmd struct {
 	*exec.Cmd
 	ctx    context.Context
 	cancel context.CancelFunc
+	// set to 1 when the process has been killed because ctx is done
+	stopped int32
+	// set to 1 when the command has been cancelled with Cancel
+	cancelled int32
 }
 
 func Command(name string, arg ...string) (c *Cmd) {
-	c = &Cmd{}
-	c.ctx, c.cancel = context.WithCancel(context.Background())
-	c.Cmd = exec.CommandContext(c.ctx, name, arg...)
-	return
+	return CommandContext(context.Background(), 0, name, arg...)
 }
 
 func CommandTimeout(timeout time.Duration, name string, arg ...string) (c *Cmd) {
+	return CommandContext(context.Background(), timeout, name, arg...)
+}
+
+// CommandContext creates a command stopped when ctx is done or, if
+// timeout is strictly positive, when it times out. The whole process tree
+// of the command is killed when it is stopped.
+func CommandContext(ctx context.Context, timeout time.Duration, name string, arg ...string) (c *Cmd) {
 	c = &Cmd{}
-	c.ctx, c.cancel = context.WithTimeout(context.Background(), timeout)
+	if timeout > 0 {
+		c.ctx, c.cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		c.ctx, c.cancel = context.WithCancel(ctx)
+	}
 	c.Cmd = exec.CommandContext(c.ctx, name, arg...)
+	// only called if ctx is done before the process exits
+	c.Cmd.Cancel = func() error {
+		atomic.StoreInt32(&c.stopped, 1)
+		return killTree(c.Process)
+	}
 	return
 }
 
+// TimedOut returns true if the command has been stopped because of a timeout
+func (c *Cmd) TimedOut() bool {
+	return c.isStopped() && !c.isCancelled() && c.ctx.Err() == context.DeadlineExceeded
+}
+
+// Cancelled returns true if the command has been stopped by Cancel
+func (c *Cmd) Cancelled() bool {
+	return c.isStopped() && c.isCancelled()
+}
+
+// Interrupted returns true if the command has been stopped because the
+// context it has been created with is done. It must be called before
+// Terminate.
+func (c *Cmd) Interrupted() bool {
+	return c.isStopped() && !c.isCancelled() && c.ctx.Err() == context.Canceled
+}
+
+// isStopped returns true if the process has been killed because ctx is done
+// or has not been started because ctx was already done
+func (c *Cmd) isStopped() bool {
+	return atomic.LoadInt32(&c.stopped) == 1 || (c.Process == nil && c.ctx.Err() != nil)
+}
+
+func (c *Cmd) isCancelled() bool {
+	return atomic.LoadInt32(&c.cancelled) == 1
+}
+
+// Cancel stops the command, it is reported as cancelled and not as
+// interrupted
+func (c *Cmd) Cancel() {
+	atomic.StoreInt32(&c.cancelled, 1)
+	c.Terminate()
+}
+
 func (c *Cmd) Terminate() {
 	if c.cancel != nil {
 		c.cancel()
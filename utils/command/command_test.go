@@ -1,6 +1,7 @@
 package command
 
 import (
+	"context"
 	"os/exec"
 	"testing"
 	"time"
@@ -20,3 +21,51 @@ func TestCommandeTimeout(t *testing.T) {
 	c.Terminate()
 	tt.ExpectErr(c.Wait(), &exec.ExitError{})
 }
+
+func TestCommandCancelled(t *testing.T) {
+	tt := toast.FromT(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := CommandContext(ctx, 0, "sleep", "30")
+	defer c.Terminate()
+	tt.CheckErr(c.Start())
+	cancel()
+	tt.ExpectErr(c.Wait(), &exec.ExitError{})
+	tt.Assert(c.Interrupted())
+	tt.Assert(!c.Cancelled())
+	tt.Assert(!c.TimedOut())
+
+	c = CommandContext(context.Background(), 0, "sleep", "30")
+	defer c.Terminate()
+	tt.CheckErr(c.Start())
+	c.Cancel()
+	tt.ExpectErr(c.Wait(), &exec.ExitError{})
+	tt.Assert(c.Cancelled())
+	tt.Assert(!c.Interrupted())
+	tt.Assert(!c.TimedOut())
+
+	c = CommandTimeout(100*time.Millisecond, "sleep", "30")
+	defer c.Terminate()
+	tt.ExpectErr(c.Run(), &exec.ExitError{})
+	tt.Assert(c.TimedOut())
+	tt.Assert(!c.Cancelled())
+	tt.Assert(!c.Interrupted())
+
+	// command exiting on its own is neither cancelled nor timed out, even
+	// if its context is done afterwards
+	ctx, cancel = context.WithCancel(context.Background())
+	c = CommandContext(ctx, 0, "false")
+	defer c.Terminate()
+	tt.ExpectErr(c.Run(), &exec.ExitError{})
+	cancel()
+	tt.Assert(!c.Cancelled())
+	tt.Assert(!c.Interrupted())
+	tt.Assert(!c.TimedOut())
+
+	// output is still available
+	c = Command("echo", "whids")
+	defer c.Terminate()
+	out, err := c.Output()
+	tt.CheckErr(err)
+	tt.Assert(string(out) == "whids\n")
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package command
+
+import (
+	"os"
+)
+
+// killTree kills process p, children are not tracked on this platform
+func killTree(p *os.Process) error {
+	return p.Kill()
+}
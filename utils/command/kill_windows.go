@@ -0,0 +1,19 @@
+//go:build windows
+// +build windows
+
+package command
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// killTree kills process p and all its children
+func killTree(p *os.Process) error {
+	if err := exec.Command("taskkill.exe", "/F", "/T", "/PID", strconv.Itoa(p.Pid)).Run(); err != nil {
+		// we make sure the process itself is killed
+		return p.Kill()
+	}
+	return nil
+}
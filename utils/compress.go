@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// CompressionGzip gzip compression format
+	CompressionGzip = "gzip"
+	// CompressionZstd zstd compression format
+	CompressionZstd = "zstd"
+
+	// GzipExt extension of gzip compressed files
+	GzipExt = ".gz"
+	// ZstdExt extension of zstd compressed files
+	ZstdExt = ".zst"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// IsValidCompression returns true if format is a supported compression format
+func IsValidCompression(format string) bool {
+	switch format {
+	case CompressionGzip, CompressionZstd:
+		return true
+	}
+	return false
+}
+
+// CompressionExt returns the file extension of a compression format
+func CompressionExt(format string) string {
+	if format == CompressionZstd {
+		return ZstdExt
+	}
+	return GzipExt
+}
+
+// IsCompressedPath returns true if path has the extension of a supported
+// compression format
+func IsCompressedPath(path string) bool {
+	return strings.HasSuffix(path, GzipExt) || strings.HasSuffix(path, ZstdExt)
+}
+
+// TrimCompressionExt removes compression extension from path
+func TrimCompressionExt(path string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(path, GzipExt), ZstdExt)
+}
+
+// NewCompressWriter returns a writer compressing data with the given format.
+// A level of 0 selects the fastest level of the format.
+func NewCompressWriter(w io.Writer, format string, level int) (io.WriteCloser, error) {
+	switch format {
+	case CompressionGzip:
+		if level == 0 {
+			level = gzip.BestSpeed
+		}
+		return gzip.NewWriterLevel(w, level)
+	case CompressionZstd:
+		lvl := zstd.SpeedFastest
+		if level != 0 {
+			lvl = zstd.EncoderLevelFromZstd(level)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(lvl))
+	case "":
+		return nopWriteCloser{w}, nil
+	}
+	return nil, fmt.Errorf("unknown compression format: %s", format)
+}
+
+// NewDecompressReader returns a reader decompressing data according to the
+// extension of path (.gz or .zst)
+func NewDecompressReader(path string, r io.Reader) (io.ReadCloser, error) {
+	switch {
+	case strings.HasSuffix(path, GzipExt):
+		return gzip.NewReader(r)
+	case strings.HasSuffix(path, ZstdExt):
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unknown compression extension: %s", path)
+}
@@ -29,7 +29,15 @@ func CountFiles(directory string) (cnt int) {
 
 // GzipFileBestSpeed compresses a file to gzip and deletes the original file
 func GzipFileBestSpeed(path string) (err error) {
-	fname := fmt.Sprintf("%s.gz", path)
+	return CompressFile(path, CompressionGzip, gzip.BestSpeed)
+}
+
+// CompressFile compresses a file with the given format and level and deletes
+// the original file. Compressed file extension depends on the format.
+func CompressFile(path, format string, level int) (err error) {
+	var w io.WriteCloser
+
+	fname := fmt.Sprintf("%s%s", path, CompressionExt(format))
 	partname := fmt.Sprintf("%s.part", fname)
 
 	f, err := os.Open(path)
@@ -44,16 +52,18 @@ func GzipFileBestSpeed(path string) (err error) {
 	}
 	defer of.Close()
 
-	// if valid level error returned is nil so no need to handle it
-	w, _ := gzip.NewWriterLevel(of, gzip.BestSpeed)
-	defer w.Close()
-	if _, err := io.Copy(w, f); err != nil {
-		return err
+	if w, err = NewCompressWriter(of, format, level); err != nil {
+		return
+	}
+	if _, err = io.Copy(w, f); err != nil {
+		w.Close()
+		return
 	}
 
-	// gzip writer
-	w.Flush()
-	w.Close()
+	// compressed writer
+	if err = w.Close(); err != nil {
+		return
+	}
 	// original file
 	f.Close()
 	// part file
@@ -84,13 +94,16 @@ func HidsWriteData(dest string, data []byte) error {
 }
 
 // HidsWriteReader writes the content of a reader to a destination file. If
-// compress is true .gz extension is added to destination file name.
-func HidsWriteReader(dst string, content io.Reader, compress bool) (err error) {
+// a compression format is given, content is compressed and the extension of
+// the format is added to destination file name.
+func HidsWriteReader(dst string, content io.Reader, format string, level int) (err error) {
 	var out *os.File
 	var w io.WriteCloser
 
-	if compress && !strings.HasSuffix(dst, ".gz") {
-		dst = fmt.Sprintf("%s.gz", dst)
+	if format != "" {
+		if ext := CompressionExt(format); !strings.HasSuffix(dst, ext) {
+			dst = fmt.Sprintf("%s%s", dst, ext)
+		}
 	}
 
 	if out, err = HidsCreateFile(dst); err != nil {
@@ -98,14 +111,12 @@ func HidsWriteReader(dst string, content io.Reader, compress bool) (err error) {
 	}
 	defer out.Close()
 
-	if compress {
-		if w, err = gzip.NewWriterLevel(out, gzip.BestSpeed); err != nil {
-			return
-		}
-		defer w.Close()
+	if w, err = NewCompressWriter(out, format, level); err != nil {
+		return
 	}
 
 	if _, err = io.Copy(w, content); err != nil {
+		w.Close()
 		return
 	}
 
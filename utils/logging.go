@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"encoding/json"
+	"io"
+	stdlog "log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	logSourceRe = regexp.MustCompile(`^(\S+\.go:\d+): `)
+	logLevelRe  = regexp.MustCompile(`^(ABORT|CRITICAL|DEBUG|ERROR|INFO|PANIC|WARNING) - `)
+	logFieldRe  = regexp.MustCompile(`(\w+)=("[^"]*"|\S+)`)
+)
+
+// JSONLogEntry is a log message as written by a JSONLogWriter
+type JSONLogEntry struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Level     string            `json:"level"`
+	Message   string            `json:"message"`
+	Source    string            `json:"source,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+}
+
+// ParseLogMessage parses a message formatted by golang-utils/log package.
+// Date and time flags of the standard logger are expected to be disabled.
+// Key=value pairs found in the message are extracted as fields.
+func ParseLogMessage(msg string) (e JSONLogEntry) {
+	msg = strings.TrimRight(msg, "\r\n")
+
+	e.Timestamp = time.Now().UTC()
+
+	if m := logSourceRe.FindStringSubmatch(msg); m != nil {
+		e.Source = m[1]
+		msg = msg[len(m[0]):]
+	}
+
+	if m := logLevelRe.FindStringSubmatch(msg); m != nil {
+		e.Level = strings.ToLower(m[1])
+		msg = msg[len(m[0]):]
+	}
+
+	e.Message = strings.TrimSpace(msg)
+
+	for _, m := range logFieldRe.FindAllStringSubmatch(e.Message, -1) {
+		if e.Fields == nil {
+			e.Fields = make(map[string]string)
+		}
+		e.Fields[m[1]] = strings.Trim(m[2], `"`)
+	}
+
+	return
+}
+
+// JSONLogWriter converts messages written by the standard logger into JSON lines
+type JSONLogWriter struct {
+	sync.Mutex
+	w io.Writer
+}
+
+// NewJSONLogWriter creates a new JSONLogWriter writing to w
+func NewJSONLogWriter(w io.Writer) *JSONLogWriter {
+	return &JSONLogWriter{w: w}
+}
+
+// Write implements io.Writer, the standard logger calls it once per message
+func (j *JSONLogWriter) Write(p []byte) (n int, err error) {
+	var b []byte
+
+	if b, err = json.Marshal(ParseLogMessage(string(p))); err != nil {
+		return
+	}
+
+	j.Lock()
+	defer j.Unlock()
+	if _, err = j.w.Write(append(b, '\n')); err != nil {
+		return
+	}
+
+	return len(p), nil
+}
+
+// SetJSONLogfile makes the standard logger, used by golang-utils/log package,
+// write JSON lines to logfile
+func SetJSONLogfile(path string, mode os.FileMode) (err error) {
+	var fd *os.File
+
+	if fd, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, mode); err != nil {
+		return
+	}
+
+	// timestamp is part of the JSON entry
+	stdlog.SetFlags(stdlog.Flags() &^ (stdlog.Ldate | stdlog.Ltime | stdlog.Lmicroseconds | stdlog.LUTC))
+	stdlog.SetOutput(NewJSONLogWriter(fd))
+	return
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"container/list"
+	"sync"
+)
+
+// LRUSet is a thread safe set holding at most a given number of elements.
+// When full, the least recently used element is evicted.
+type LRUSet struct {
+	sync.Mutex
+	max     int
+	order   *list.List
+	items   map[interface{}]*list.Element
+	evicted uint64
+}
+
+// NewLRUSet creates a new LRUSet holding at most max elements, a max
+// lower or equal to zero means no limit
+func NewLRUSet(max int) *LRUSet {
+	return &LRUSet{
+		max:   max,
+		order: list.New(),
+		items: make(map[interface{}]*list.Element),
+	}
+}
+
+// Add adds items to the set, evicting least recently used items if needed
+func (s *LRUSet) Add(items ...interface{}) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, i := range items {
+		if e, ok := s.items[i]; ok {
+			s.order.MoveToFront(e)
+			continue
+		}
+
+		s.items[i] = s.order.PushFront(i)
+
+		if s.max > 0 && s.order.Len() > s.max {
+			oldest := s.order.Back()
+			s.order.Remove(oldest)
+			delete(s.items, oldest.Value)
+			s.evicted++
+		}
+	}
+}
+
+// Contains returns true if all items are in the set, items found are
+// marked as recently used
+func (s *LRUSet) Contains(items ...interface{}) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, i := range items {
+		e, ok := s.items[i]
+		if !ok {
+			return false
+		}
+		s.order.MoveToFront(e)
+	}
+
+	return true
+}
+
+// Del deletes items from the set
+func (s *LRUSet) Del(items ...interface{}) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, i := range items {
+		if e, ok := s.items[i]; ok {
+			s.order.Remove(e)
+			delete(s.items, i)
+		}
+	}
+}
+
+// Len returns the number of elements in the set
+func (s *LRUSet) Len() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.order.Len()
+}
+
+// Evicted returns the number of elements evicted so far
+func (s *LRUSet) Evicted() uint64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.evicted
+}
@@ -1,6 +1,10 @@
 package utils
 
-import "net"
+import (
+	"fmt"
+	"net"
+	"strings"
+)
 
 // derived from: https://gist.github.com/kotakanbe/d3059af990252ba89a82
 func NextIP(ip net.IP) net.IP {
@@ -27,3 +31,62 @@ func PrevIP(ip net.IP) net.IP {
 	}
 	return nip
 }
+
+// ParseIP parses an IP address, IPv6 zones (ex: fe80::1%eth0) being
+// ignored. IPv4-mapped IPv6 addresses are converted to IPv4 addresses.
+func ParseIP(s string) net.IP {
+	if i := strings.IndexByte(s, '%'); i >= 0 {
+		s = s[:i]
+	}
+	ip := net.ParseIP(strings.Trim(s, "[]"))
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+// CIDRList is a list of IPv4 and IPv6 networks
+type CIDRList []*net.IPNet
+
+// ParseCIDRList parses a list of CIDR ranges, single IP addresses are
+// accepted and considered as networks with a full mask
+func ParseCIDRList(cidrs []string) (l CIDRList, err error) {
+	l = make(CIDRList, 0, len(cidrs))
+	for _, c := range cidrs {
+		var n *net.IPNet
+
+		if !strings.Contains(c, "/") {
+			ip := ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %s", c)
+			}
+			l = append(l, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+
+		if _, n, err = net.ParseCIDR(c); err != nil {
+			return nil, err
+		}
+		l = append(l, n)
+	}
+	return
+}
+
+// Contains returns true if ip belongs to one of the networks of the list
+func (l CIDRList) Contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range l {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsString returns true if the IP address s belongs to one of the
+// networks of the list
+func (l CIDRList) ContainsString(s string) bool {
+	return l.Contains(ParseIP(s))
+}
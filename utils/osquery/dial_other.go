@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package osquery
+
+import (
+	"net"
+	"time"
+)
+
+// dial connects to osqueryd extensions socket
+func dial(path string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("unix", path, timeout)
+}
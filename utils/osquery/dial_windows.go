@@ -0,0 +1,22 @@
+//go:build windows
+// +build windows
+
+package osquery
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// dial connects to osqueryd extensions socket, a named pipe on Windows.
+// Unlike a file opened on the pipe, the connection supports deadlines and
+// closing it cancels pending I/O.
+func dial(path string, timeout time.Duration) (net.Conn, error) {
+	if strings.HasPrefix(path, `\\`) {
+		return winio.DialPipe(path, &timeout)
+	}
+	return net.DialTimeout("unix", path, timeout)
+}
@@ -0,0 +1,459 @@
+package osquery
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Minimal client of the osquery extension manager Thrift API (osqueryd
+// extensions socket) only implementing the query method. Thrift binary
+// protocol over a buffered transport is used as osqueryd expects.
+
+const (
+	// DefaultRetryInterval is the minimum time between two connection
+	// attempts to an unavailable osqueryd
+	DefaultRetryInterval = 30 * time.Second
+
+	// Thrift binary protocol
+	thriftVersion1    = 0x80010000
+	thriftVersionMask = 0xffff0000
+	thriftTypeMask    = 0x000000ff
+
+	// Thrift message types
+	thriftCall      = 1
+	thriftReply     = 2
+	thriftException = 3
+
+	// Thrift field types
+	thriftStop   = 0
+	thriftBool   = 2
+	thriftByte   = 3
+	thriftDouble = 4
+	thriftI16    = 6
+	thriftI32    = 8
+	thriftI64    = 10
+	thriftString = 11
+	thriftStruct = 12
+	thriftMap    = 13
+	thriftSet    = 14
+	thriftList   = 15
+
+	// maximum size of a string or container we accept to decode
+	maxThriftSize = 256 * 1024 * 1024
+	// maximum number of container elements allocated before being read, as
+	// sizes read from the wire cannot be trusted
+	maxThriftPrealloc = 1024
+)
+
+var (
+	// ErrUnavailable is returned when osqueryd cannot be reached
+	ErrUnavailable = errors.New("osqueryd is not available")
+)
+
+// StatusError is returned when osqueryd ran a query with an error status
+type StatusError struct {
+	Code    int32
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("osquery status code=%d: %s", e.Code, e.Message)
+}
+
+// Client is a client to a running osqueryd. Connection is lazily opened at
+// the first query and re-opened if osqueryd goes away.
+type Client struct {
+	sync.Mutex
+	path      string
+	conn      net.Conn
+	rw        *bufio.ReadWriter
+	seqID     int32
+	lastRetry time.Time
+
+	// RetryInterval is the minimum time between two connection attempts
+	RetryInterval time.Duration
+}
+
+// NewClient creates a new client connecting to osqueryd extensions socket
+// path. On Windows path is a named pipe (ex: \\.\pipe\shell.em).
+func NewClient(path string) *Client {
+	return &Client{path: path, RetryInterval: DefaultRetryInterval}
+}
+
+// Path returns the path of the osqueryd socket
+func (c *Client) Path() string {
+	return c.path
+}
+
+func (c *Client) connect(timeout time.Duration) (err error) {
+	if c.conn != nil {
+		return nil
+	}
+
+	// we do not hammer an unavailable osqueryd
+	if !c.lastRetry.IsZero() && time.Since(c.lastRetry) < c.RetryInterval {
+		return ErrUnavailable
+	}
+
+	if c.conn, err = dial(c.path, timeout); err != nil {
+		c.lastRetry = time.Now()
+		c.conn = nil
+		return fmt.Errorf("%w: %s", ErrUnavailable, err)
+	}
+
+	c.lastRetry = time.Time{}
+	c.rw = bufio.NewReadWriter(bufio.NewReader(c.conn), bufio.NewWriter(c.conn))
+	return
+}
+
+func (c *Client) reset() {
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.conn = nil
+	c.rw = nil
+}
+
+// Query runs a SQL query and returns resulting rows. If timeout is greater
+// than zero and the query does not complete in time, the connection is
+// closed and an error returned. Any error not being a StatusError means the
+// connection to osqueryd has been lost.
+func (c *Client) Query(sql string, timeout time.Duration) (rows []map[string]string, err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if err = c.connect(timeout); err != nil {
+		return
+	}
+
+	// a deadline reached leaves the connection in an unknown state
+	deadline := time.Time{}
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if err = c.conn.SetDeadline(deadline); err != nil {
+		c.reset()
+		return
+	}
+
+	c.seqID++
+	if err = writeQuery(c.rw, c.seqID, sql); err == nil {
+		rows, err = readQueryReply(c.rw.Reader, c.seqID)
+	}
+
+	var se *StatusError
+	var ne net.Error
+	switch {
+	case err == nil, errors.As(err, &se):
+	case errors.As(err, &ne) && ne.Timeout():
+		c.reset()
+		return nil, fmt.Errorf("osquery query timed out after %s", timeout)
+	default:
+		c.reset()
+	}
+
+	return
+}
+
+// Close closes the connection to osqueryd
+func (c *Client) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	c.reset()
+	return nil
+}
+
+/////////////////////////// Thrift binary protocol
+
+type writer struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (w *writer) write(v interface{}) {
+	if w.err == nil {
+		w.err = binary.Write(w.w, binary.BigEndian, v)
+	}
+}
+
+func (w *writer) string(s string) {
+	w.write(int32(len(s)))
+	if w.err == nil {
+		_, w.err = w.w.WriteString(s)
+	}
+}
+
+func (w *writer) field(typ byte, id int16) {
+	w.write(typ)
+	w.write(id)
+}
+
+func writeQuery(rw *bufio.ReadWriter, seqID int32, sql string) error {
+	w := &writer{w: rw.Writer}
+
+	// message header (strict)
+	w.write(uint32(thriftVersion1 | thriftCall))
+	w.string("query")
+	w.write(seqID)
+
+	// query_args struct
+	w.field(thriftString, 1)
+	w.string(sql)
+	w.write(byte(thriftStop))
+
+	if w.err != nil {
+		return w.err
+	}
+	return rw.Flush()
+}
+
+type reader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *reader) read(v interface{}) {
+	if r.err == nil {
+		r.err = binary.Read(r.r, binary.BigEndian, v)
+	}
+}
+
+func (r *reader) size() int32 {
+	var n int32
+	r.read(&n)
+	if r.err == nil && (n < 0 || n > maxThriftSize) {
+		r.err = fmt.Errorf("invalid thrift size: %d", n)
+	}
+	return n
+}
+
+// prealloc returns the capacity to allocate for a container of size n
+func prealloc(n int32) int {
+	if n > maxThriftPrealloc {
+		return maxThriftPrealloc
+	}
+	return int(n)
+}
+
+func (r *reader) string() string {
+	n := r.size()
+	if r.err != nil {
+		return ""
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		r.err = err
+	}
+	return string(b)
+}
+
+func (r *reader) i32() (v int32) {
+	r.read(&v)
+	return
+}
+
+func (r *reader) field() (typ byte, id int16) {
+	r.read(&typ)
+	if typ != thriftStop {
+		r.read(&id)
+	}
+	return
+}
+
+func (r *reader) skip(typ byte) {
+	switch typ {
+	case thriftBool, thriftByte:
+		var v byte
+		r.read(&v)
+	case thriftI16:
+		var v int16
+		r.read(&v)
+	case thriftI32:
+		var v int32
+		r.read(&v)
+	case thriftDouble, thriftI64:
+		var v int64
+		r.read(&v)
+	case thriftString:
+		r.string()
+	case thriftStruct:
+		for r.err == nil {
+			t, _ := r.field()
+			if t == thriftStop {
+				break
+			}
+			r.skip(t)
+		}
+	case thriftMap:
+		var kt, vt byte
+		r.read(&kt)
+		r.read(&vt)
+		n := r.size()
+		for i := int32(0); i < n && r.err == nil; i++ {
+			r.skip(kt)
+			r.skip(vt)
+		}
+	case thriftSet, thriftList:
+		var et byte
+		r.read(&et)
+		n := r.size()
+		for i := int32(0); i < n && r.err == nil; i++ {
+			r.skip(et)
+		}
+	default:
+		r.err = fmt.Errorf("unknown thrift type: %d", typ)
+	}
+}
+
+// exception reads a TApplicationException
+func (r *reader) exception() error {
+	var msg string
+	var code int32
+
+	for r.err == nil {
+		t, id := r.field()
+		switch {
+		case t == thriftStop:
+			return fmt.Errorf("osquery exception type=%d: %s", code, msg)
+		case id == 1 && t == thriftString:
+			msg = r.string()
+		case id == 2 && t == thriftI32:
+			code = r.i32()
+		default:
+			r.skip(t)
+		}
+	}
+	return r.err
+}
+
+// status reads an ExtensionStatus structure
+func (r *reader) status() (s StatusError) {
+	for r.err == nil {
+		t, id := r.field()
+		switch {
+		case t == thriftStop:
+			return
+		case id == 1 && t == thriftI32:
+			s.Code = r.i32()
+		case id == 2 && t == thriftString:
+			s.Message = r.string()
+		default:
+			r.skip(t)
+		}
+	}
+	return
+}
+
+// rows reads an ExtensionPluginResponse (list<map<string, string>>)
+func (r *reader) rows() (rows []map[string]string) {
+	var et, kt, vt byte
+
+	r.read(&et)
+	n := r.size()
+	if r.err == nil && et != thriftMap {
+		r.err = fmt.Errorf("unexpected thrift list element type: %d", et)
+	}
+
+	rows = make([]map[string]string, 0, prealloc(n))
+	for i := int32(0); i < n && r.err == nil; i++ {
+		r.read(&kt)
+		r.read(&vt)
+		m := r.size()
+		if r.err == nil && (kt != thriftString || vt != thriftString) {
+			r.err = fmt.Errorf("unexpected thrift map types: %d %d", kt, vt)
+		}
+		row := make(map[string]string, prealloc(m))
+		for j := int32(0); j < m && r.err == nil; j++ {
+			k := r.string()
+			row[k] = r.string()
+		}
+		rows = append(rows, row)
+	}
+
+	return
+}
+
+func readQueryReply(rd io.Reader, seqID int32) (rows []map[string]string, err error) {
+	var typ int32
+	var status StatusError
+
+	r := &reader{r: rd}
+
+	// message header
+	header := r.i32()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if header < 0 {
+		if uint32(header)&thriftVersionMask != thriftVersion1 {
+			return nil, fmt.Errorf("bad thrift version: 0x%x", uint32(header))
+		}
+		typ = header & thriftTypeMask
+		r.string()
+	} else {
+		// old non strict message header, header is the name length
+		name := make([]byte, header)
+		if _, r.err = io.ReadFull(rd, name); r.err == nil {
+			var b byte
+			r.read(&b)
+			typ = int32(b)
+		}
+	}
+
+	if id := r.i32(); r.err == nil && id != seqID {
+		return nil, fmt.Errorf("unexpected thrift sequence id: %d", id)
+	}
+
+	switch {
+	case r.err != nil:
+		return nil, r.err
+	case typ == thriftException:
+		return nil, r.exception()
+	case typ != thriftReply:
+		return nil, fmt.Errorf("unexpected thrift message type: %d", typ)
+	}
+
+	// query_result struct, field 0 being the ExtensionResponse
+	for r.err == nil {
+		t, id := r.field()
+		if t == thriftStop {
+			break
+		}
+		if id != 0 || t != thriftStruct {
+			r.skip(t)
+			continue
+		}
+
+		// ExtensionResponse struct
+		for r.err == nil {
+			t, id := r.field()
+			if t == thriftStop {
+				break
+			}
+			switch {
+			case id == 1 && t == thriftStruct:
+				status = r.status()
+			case id == 2 && t == thriftList:
+				rows = r.rows()
+			default:
+				r.skip(t)
+			}
+		}
+	}
+
+	switch {
+	case r.err != nil:
+		return nil, r.err
+	case status.Code != 0:
+		return nil, &status
+	}
+
+	return
+}
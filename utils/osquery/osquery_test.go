@@ -0,0 +1,185 @@
+package osquery
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0xrawsec/toast"
+)
+
+// serve answers the query requests made on l with the given status and rows
+func serve(l net.Listener, code int32, rows []map[string]string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+			rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+			r := &reader{r: rw.Reader}
+
+			for {
+				// request header
+				if r.i32(); r.err != nil {
+					return
+				}
+				r.string()
+				seqID := r.i32()
+				r.skip(thriftStruct)
+				if r.err != nil {
+					return
+				}
+
+				w := &writer{w: rw.Writer}
+				w.write(uint32(thriftVersion1 | thriftReply))
+				w.string("query")
+				w.write(seqID)
+
+				// query_result
+				w.field(thriftStruct, 0)
+				// ExtensionStatus
+				w.field(thriftStruct, 1)
+				w.field(thriftI32, 1)
+				w.write(code)
+				w.field(thriftString, 2)
+				w.string("OK")
+				w.field(thriftI64, 3)
+				w.write(int64(42))
+				w.write(byte(thriftStop))
+				// ExtensionPluginResponse
+				w.field(thriftList, 2)
+				w.write(byte(thriftMap))
+				w.write(int32(len(rows)))
+				for _, row := range rows {
+					w.write(byte(thriftString))
+					w.write(byte(thriftString))
+					w.write(int32(len(row)))
+					for k, v := range row {
+						w.string(k)
+						w.string(v)
+					}
+				}
+				w.write(byte(thriftStop))
+				w.write(byte(thriftStop))
+
+				if w.err != nil || rw.Flush() != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func TestClientQuery(t *testing.T) {
+	tt := toast.FromT(t)
+
+	path := filepath.Join(t.TempDir(), "osquery.em")
+	rows := []map[string]string{
+		{"pid": "4", "name": "System"},
+		{"pid": "1337", "name": "whids.exe"},
+	}
+
+	l, err := net.Listen("unix", path)
+	tt.CheckErr(err)
+	go serve(l, 0, rows)
+
+	c := NewClient(path)
+	defer c.Close()
+
+	// several queries are made on the same connection
+	for i := 0; i < 3; i++ {
+		out, err := c.Query("SELECT pid, name FROM processes", time.Second)
+		tt.CheckErr(err)
+		tt.Assert(len(out) == len(rows))
+		tt.Assert(out[1]["name"] == "whids.exe")
+	}
+
+	// osqueryd going away
+	l.Close()
+	c.Lock()
+	c.reset()
+	c.Unlock()
+	_, err = c.Query("SELECT pid, name FROM processes", time.Second)
+	tt.Assert(errors.Is(err, ErrUnavailable))
+	// no reconnection attempt before retry interval
+	_, err = c.Query("SELECT pid, name FROM processes", time.Second)
+	tt.Assert(err == ErrUnavailable)
+}
+
+func TestClientQueryStatus(t *testing.T) {
+	tt := toast.FromT(t)
+
+	path := filepath.Join(t.TempDir(), "osquery.em")
+	l, err := net.Listen("unix", path)
+	tt.CheckErr(err)
+	defer l.Close()
+	go serve(l, 1, nil)
+
+	c := NewClient(path)
+	defer c.Close()
+
+	_, err = c.Query("SELECT * FROM unknown", time.Second)
+	var se *StatusError
+	tt.Assert(errors.As(err, &se))
+	tt.Assert(se.Code == 1)
+	// connection is kept on status errors
+	tt.Assert(c.conn != nil)
+}
+
+func TestClientQueryTimeout(t *testing.T) {
+	tt := toast.FromT(t)
+
+	path := filepath.Join(t.TempDir(), "osquery.em")
+	l, err := net.Listen("unix", path)
+	tt.CheckErr(err)
+	defer l.Close()
+
+	// osqueryd accepting connections but never answering
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	c := NewClient(path)
+	defer c.Close()
+
+	start := time.Now()
+	_, err = c.Query("SELECT pid, name FROM processes", 100*time.Millisecond)
+	tt.Assert(err != nil)
+	tt.Assert(time.Since(start) < time.Second)
+	// connection must be dropped after a timeout
+	tt.Assert(c.conn == nil)
+}
+
+func TestReaderRowsTruncated(t *testing.T) {
+	tt := toast.FromT(t)
+
+	// list announcing far more rows than actually sent
+	var buf bytes.Buffer
+	w := &writer{w: bufio.NewWriter(&buf)}
+	w.write(byte(thriftMap))
+	w.write(int32(maxThriftSize))
+	w.write(byte(thriftString))
+	w.write(byte(thriftString))
+	w.write(int32(maxThriftSize))
+	w.string("pid")
+	w.string("4")
+	tt.CheckErr(w.w.Flush())
+
+	r := &reader{r: &buf}
+	rows := r.rows()
+	tt.Assert(r.err != nil)
+	tt.Assert(cap(rows) <= maxThriftPrealloc)
+}
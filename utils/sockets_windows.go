@@ -0,0 +1,212 @@
+//go:build windows
+// +build windows
+
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	afInet  = 2
+	afInet6 = 23
+
+	tcpTableOwnerPidAll = 5
+	udpTableOwnerPid    = 1
+
+	errorInsufficientBuffer = 122
+
+	// sizes of MIB_*ROW_OWNER_PID structures
+	tcpRowSize  = 24
+	tcp6RowSize = 56
+	udpRowSize  = 12
+	udp6RowSize = 28
+)
+
+var (
+	iphlpapi            = syscall.NewLazyDLL("iphlpapi.dll")
+	getExtendedTcpTable = iphlpapi.NewProc("GetExtendedTcpTable")
+	getExtendedUdpTable = iphlpapi.NewProc("GetExtendedUdpTable")
+
+	tcpStates = map[uint32]string{
+		1:  "CLOSED",
+		2:  "LISTEN",
+		3:  "SYN_SENT",
+		4:  "SYN_RCVD",
+		5:  "ESTABLISHED",
+		6:  "FIN_WAIT1",
+		7:  "FIN_WAIT2",
+		8:  "CLOSE_WAIT",
+		9:  "CLOSING",
+		10: "LAST_ACK",
+		11: "TIME_WAIT",
+		12: "DELETE_TCB",
+	}
+)
+
+// Socket describes a socket opened by a process
+type Socket struct {
+	Protocol   string `json:"protocol"`
+	LocalAddr  string `json:"local-addr"`
+	LocalPort  uint16 `json:"local-port"`
+	RemoteAddr string `json:"remote-addr,omitempty"`
+	RemotePort uint16 `json:"remote-port,omitempty"`
+	State      string `json:"state,omitempty"`
+	PID        uint32 `json:"pid"`
+}
+
+func extendedTable(proc *syscall.LazyProc, af, class uintptr) (table []byte, err error) {
+	size := uint32(0)
+
+	// table size can change between calls
+	for i := 0; i < 5; i++ {
+		var ptr uintptr
+		if size > 0 {
+			table = make([]byte, size)
+			ptr = uintptr(unsafe.Pointer(&table[0]))
+		}
+
+		r, _, _ := proc.Call(ptr, uintptr(unsafe.Pointer(&size)), 0, af, class, 0)
+		switch r {
+		case 0:
+			return table[:size], nil
+		case errorInsufficientBuffer:
+			continue
+		default:
+			return nil, fmt.Errorf("%s failed: %w", proc.Name, syscall.Errno(r))
+		}
+	}
+
+	return nil, fmt.Errorf("%s failed: table keeps growing", proc.Name)
+}
+
+func port(dw uint32) uint16 {
+	// port is stored in network byte order in the low order bytes
+	return uint16(dw&0xff)<<8 | uint16(dw>>8&0xff)
+}
+
+func rows(table []byte, rowSize int) (n int, err error) {
+	if len(table) < 4 {
+		return 0, fmt.Errorf("table too short")
+	}
+	n = int(binary.LittleEndian.Uint32(table))
+	if len(table) < 4+n*rowSize {
+		return 0, fmt.Errorf("table too short for %d entries", n)
+	}
+	return
+}
+
+func parseTCPTable(table []byte, pid uint32, v6 bool) (sockets []Socket, err error) {
+	var n int
+
+	rowSize := tcpRowSize
+	if v6 {
+		rowSize = tcp6RowSize
+	}
+
+	if n, err = rows(table, rowSize); err != nil {
+		return
+	}
+
+	for i := 0; i < n; i++ {
+		var s Socket
+		var state uint32
+
+		row := table[4+i*rowSize : 4+(i+1)*rowSize]
+		if v6 {
+			s.Protocol = "tcp6"
+			s.LocalAddr = net.IP(row[0:16]).String()
+			s.LocalPort = port(binary.LittleEndian.Uint32(row[20:]))
+			s.RemoteAddr = net.IP(row[24:40]).String()
+			s.RemotePort = port(binary.LittleEndian.Uint32(row[44:]))
+			state = binary.LittleEndian.Uint32(row[48:])
+			s.PID = binary.LittleEndian.Uint32(row[52:])
+		} else {
+			s.Protocol = "tcp"
+			state = binary.LittleEndian.Uint32(row[0:])
+			s.LocalAddr = net.IP(row[4:8]).String()
+			s.LocalPort = port(binary.LittleEndian.Uint32(row[8:]))
+			s.RemoteAddr = net.IP(row[12:16]).String()
+			s.RemotePort = port(binary.LittleEndian.Uint32(row[16:]))
+			s.PID = binary.LittleEndian.Uint32(row[20:])
+		}
+		s.State = tcpStates[state]
+
+		if s.PID == pid {
+			sockets = append(sockets, s)
+		}
+	}
+
+	return
+}
+
+func parseUDPTable(table []byte, pid uint32, v6 bool) (sockets []Socket, err error) {
+	var n int
+
+	rowSize := udpRowSize
+	if v6 {
+		rowSize = udp6RowSize
+	}
+
+	if n, err = rows(table, rowSize); err != nil {
+		return
+	}
+
+	for i := 0; i < n; i++ {
+		var s Socket
+
+		row := table[4+i*rowSize : 4+(i+1)*rowSize]
+		if v6 {
+			s.Protocol = "udp6"
+			s.LocalAddr = net.IP(row[0:16]).String()
+			s.LocalPort = port(binary.LittleEndian.Uint32(row[20:]))
+			s.PID = binary.LittleEndian.Uint32(row[24:])
+		} else {
+			s.Protocol = "udp"
+			s.LocalAddr = net.IP(row[0:4]).String()
+			s.LocalPort = port(binary.LittleEndian.Uint32(row[4:]))
+			s.PID = binary.LittleEndian.Uint32(row[8:])
+		}
+
+		if s.PID == pid {
+			sockets = append(sockets, s)
+		}
+	}
+
+	return
+}
+
+// ProcessSockets returns the TCP and UDP sockets (IPv4 and IPv6) opened by
+// a process
+func ProcessSockets(pid uint32) (sockets []Socket, err error) {
+	var table []byte
+	var s []Socket
+
+	sockets = make([]Socket, 0)
+
+	for _, af := range []uintptr{afInet, afInet6} {
+		v6 := af == afInet6
+
+		if table, err = extendedTable(getExtendedTcpTable, af, tcpTableOwnerPidAll); err != nil {
+			return
+		}
+		if s, err = parseTCPTable(table, pid, v6); err != nil {
+			return
+		}
+		sockets = append(sockets, s...)
+
+		if table, err = extendedTable(getExtendedUdpTable, af, udpTableOwnerPid); err != nil {
+			return
+		}
+		if s, err = parseUDPTable(table, pid, v6); err != nil {
+			return
+		}
+		sockets = append(sockets, s...)
+	}
+
+	return
+}
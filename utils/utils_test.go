@@ -1,7 +1,12 @@
 package utils
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 var (
@@ -48,3 +53,135 @@ func TestEnableFSAuditing(t *testing.T) {
 		t.Errorf("Failed at enabling FS Auditing: %s", err)
 	}
 }
+
+func TestParseLogMessage(t *testing.T) {
+	e := ParseLogMessage("hids.go:42: ERROR - Failed to dump file=\"C:\\foo bar.exe\" event=abcdef \n")
+
+	if e.Level != "error" || e.Source != "hids.go:42" {
+		t.Errorf("Unexpected log entry: %+v", e)
+	}
+
+	if e.Fields["file"] != `C:\foo bar.exe` || e.Fields["event"] != "abcdef" {
+		t.Errorf("Unexpected log fields: %+v", e.Fields)
+	}
+
+	if e = ParseLogMessage("INFO - Hello World "); e.Level != "info" || e.Message != "Hello World" || e.Fields != nil {
+		t.Errorf("Unexpected log entry: %+v", e)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base, max := time.Second, time.Minute
+	b := NewBackoff(base, max)
+
+	for i := 0; i < 20; i++ {
+		exp := base << i
+		if exp > max || exp <= 0 {
+			exp = max
+		}
+		if d := b.Next(); d < exp/2 || d > exp {
+			t.Errorf("Unexpected backoff delay at attempt %d: %s", i, d)
+		}
+	}
+
+	b.Reset()
+	if d := b.Next(); d > base {
+		t.Errorf("Backoff not reset: %s", d)
+	}
+}
+
+func TestCompressFile(t *testing.T) {
+	data := bytes.Repeat([]byte("compress me "), 4096)
+	dir := t.TempDir()
+
+	for _, format := range []string{CompressionGzip, CompressionZstd} {
+		path := filepath.Join(dir, "dump.bin")
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := CompressFile(path, format, 0); err != nil {
+			t.Fatalf("Failed to compress with %s: %s", format, err)
+		}
+
+		compressed := path + CompressionExt(format)
+		fd, err := os.Open(compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := NewDecompressReader(compressed, fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		fd.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(got, data) {
+			t.Errorf("Decompressed %s data differs from original", format)
+		}
+	}
+}
+
+func TestCIDRList(t *testing.T) {
+	l, err := ParseCIDRList([]string{"10.0.0.0/8", "192.168.0.0/16", "fc00::/7", "fe80::/10", "8.8.8.8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ip := range []string{"10.1.2.3", "192.168.1.1", "::ffff:10.0.0.1", "fd12:3456::1", "fe80::1%12", "8.8.8.8"} {
+		if !l.ContainsString(ip) {
+			t.Errorf("%s is expected to be in list", ip)
+		}
+	}
+
+	for _, ip := range []string{"172.16.0.1", "8.8.4.4", "2001:4860:4860::8888", "not an ip", ""} {
+		if l.ContainsString(ip) {
+			t.Errorf("%s is not expected to be in list", ip)
+		}
+	}
+
+	if _, err := ParseCIDRList([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("Invalid CIDR must not be parsed")
+	}
+}
+
+func TestLRUSet(t *testing.T) {
+	s := NewLRUSet(3)
+
+	s.Add("a", "b", "c")
+	// a becomes the most recently used
+	if !s.Contains("a") {
+		t.Error("a is expected to be in set")
+	}
+
+	s.Add("d")
+	if s.Contains("b") {
+		t.Error("b is expected to be evicted")
+	}
+	if !s.Contains("a", "c", "d") {
+		t.Error("a, c and d are expected to be in set")
+	}
+	if s.Len() != 3 || s.Evicted() != 1 {
+		t.Errorf("unexpected len=%d evicted=%d", s.Len(), s.Evicted())
+	}
+
+	s.Del("a")
+	if s.Contains("a") || s.Len() != 2 {
+		t.Error("a is expected to be deleted")
+	}
+
+	// no limit
+	s = NewLRUSet(0)
+	for i := 0; i < 1000; i++ {
+		s.Add(i)
+	}
+	if s.Len() != 1000 || s.Evicted() != 0 {
+		t.Errorf("unexpected len=%d evicted=%d", s.Len(), s.Evicted())
+	}
+}
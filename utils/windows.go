@@ -8,6 +8,7 @@ import (
 	"regexp"
 	"strings"
 	"syscall"
+	"unsafe"
 
 	"github.com/0xrawsec/golang-win32/win32/advapi32"
 	"github.com/0xrawsec/golang-win32/win32/kernel32"
@@ -101,3 +102,30 @@ func RegValueToString(elems ...string) string {
 	}
 	return ""
 }
+
+var (
+	getDiskFreeSpaceExW = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+)
+
+// DiskFreeSpace returns the number of bytes available to the caller on the
+// volume the path given as argument is located on
+func DiskFreeSpace(path string) (free uint64, err error) {
+	var total, totalFree uint64
+
+	utf16Path, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return
+	}
+
+	r, _, lastErr := getDiskFreeSpaceExW.Call(
+		uintptr(unsafe.Pointer(utf16Path)),
+		uintptr(unsafe.Pointer(&free)),
+		uintptr(unsafe.Pointer(&total)),
+		uintptr(unsafe.Pointer(&totalFree)))
+
+	if r == 0 {
+		err = fmt.Errorf("GetDiskFreeSpaceExW failed: %w", lastErr)
+	}
+
+	return
+}